@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// addressSourceTailscale yields the node's Tailscale IPs.
+const addressSourceTailscale = "tailscale"
+
+// addressRule is one step of picking the addresses a node is published
+// with. Rules are tried in order for each family and the first address of
+// that family any of them yields wins.
+type addressRule struct {
+	// Source is "tailscale" for the node's Tailscale IPs or
+	// "cap:<capability>" for addresses declared in a node attribute, e.g.
+	// a service VIP. The attribute's values are addresses, or objects with
+	// an "address" field.
+	Source string `yaml:"source"`
+	// Tag limits the rule to nodes carrying it.
+	Tag string `yaml:"tag"`
+}
+
+var defaultAddressRules = []addressRule{{Source: addressSourceTailscale}}
+
+// parseAddressRules parses ADDRESS_RULES, "[tag:x=]source,...".
+func parseAddressRules(v string) []addressRule {
+	var out []addressRule
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		r := addressRule{Source: part}
+		if tag, source, ok := strings.Cut(part, "="); ok {
+			r = addressRule{Source: source, Tag: tag}
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func (r addressRule) validate() error {
+	if r.Source != addressSourceTailscale && (!strings.HasPrefix(r.Source, "cap:") || r.Source == "cap:") {
+		return fmt.Errorf("address_rules: source %q must be tailscale or cap:<capability>", r.Source)
+	}
+	if r.Tag != "" && !strings.HasPrefix(r.Tag, "tag:") {
+		return fmt.Errorf("address_rules: %s: tag %q must start with tag:", r.Source, r.Tag)
+	}
+	return nil
+}
+
+// candidateAddrs returns the addresses the rules yield for ps, in order.
+func candidateAddrs(ps *ipnstate.PeerStatus) []netip.Addr {
+	var out []netip.Addr
+	for _, r := range cfg().AddressRules {
+		if r.Tag != "" && !hasTag(ps, r.Tag) {
+			continue
+		}
+		if r.Source == addressSourceTailscale {
+			out = append(out, ps.TailscaleIPs...)
+			continue
+		}
+		for _, v := range ps.CapMap[tailcfg.NodeCapability(strings.TrimPrefix(r.Source, "cap:"))] {
+			if addr, ok := capAddr(v); ok {
+				out = append(out, addr)
+			}
+		}
+	}
+	return out
+}
+
+// capAddr decodes a node attribute value, "100.100.1.1" or
+// {"address": "100.100.1.1"}.
+func capAddr(v tailcfg.RawMessage) (netip.Addr, bool) {
+	var s string
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		var obj struct{ Address string }
+		if err := json.Unmarshal([]byte(v), &obj); err != nil {
+			return netip.Addr{}, false
+		}
+		s = obj.Address
+	}
+	addr, err := netip.ParseAddr(s)
+	return addr, err == nil
+}
+
+// publishedAddrs returns the addresses in family to publish: the first of
+// addrs, empty when none is, or with ALL_ADDRESSES every one of them.
+func publishedAddrs(addrs []netip.Addr, family func(netip.Addr) bool) []string {
+	if !cfg().AllAddresses {
+		return []string{pickAddr(addrs, family)}
+	}
+	var out []string
+	for _, addr := range addrs {
+		if family(addr) && !slices.Contains(out, addr.String()) {
+			out = append(out, addr.String())
+		}
+	}
+	return out
+}
+
+// pickAddr returns the first of addrs in family, empty when none is.
+func pickAddr(addrs []netip.Addr, family func(netip.Addr) bool) string {
+	if i := slices.IndexFunc(addrs, family); i >= 0 {
+		return addrs[i].String()
+	}
+	return ""
+}
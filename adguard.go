@@ -0,0 +1,207 @@
+//go:build !no_adguard
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// errAdGuardRuleGone is returned when our rule to edit has disappeared.
+var errAdGuardRuleGone = errors.New("rule not found")
+
+// agMu serializes the read-modify-write of the user rules, which every zone
+// on the same AdGuard Home instance shares.
+var agMu sync.Mutex
+
+func init() {
+	registerProvider("adguard", newAdGuardProvider)
+}
+
+// adGuardProvider publishes records as $dnsrewrite rules in the custom
+// filtering rules of an AdGuard Home instance. Unlike the entries of the DNS
+// rewrites page, rules can be preceded by a comment line, which carries the
+// ownership marker: rules without it are never touched. The comment also
+// keeps the TTL we meant, which rewrites don't have.
+//
+//	! _tailscale node=nXXXX ttl=300
+//	|laptop.int.example.com^$dnsrewrite=NOERROR;A;100.64.0.1
+type adGuardProvider struct {
+	url      string
+	user     string
+	password string
+	zone     string
+}
+
+func newAdGuardProvider(ctx context.Context, name string) (provider, error) {
+	base := strings.TrimSuffix(os.Getenv("ADGUARD_URL"), "/")
+	if base == "" {
+		return nil, fmt.Errorf("set ADGUARD_URL, e.g. http://adguard:3000")
+	}
+	p := &adGuardProvider{url: base, user: os.Getenv("ADGUARD_USERNAME"), password: secretEnv("ADGUARD_PASSWORD"), zone: name}
+	// AdGuard Home has no zones, only check that the API answers
+	if _, err := p.rules(ctx); err != nil {
+		return nil, fmt.Errorf("get filtering rules: %w", err)
+	}
+	return p, nil
+}
+
+func (p *adGuardProvider) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.url+"/control"+path, body)
+	if err != nil {
+		return err
+	}
+	if p.user != "" {
+		req.SetBasicAuth(p.user, p.password)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// rules returns the custom filtering rules, one line each.
+func (p *adGuardProvider) rules(ctx context.Context) ([]string, error) {
+	var status struct {
+		UserRules []string `json:"user_rules"`
+	}
+	if err := p.call(ctx, http.MethodGet, "/filtering/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return status.UserRules, nil
+}
+
+func (p *adGuardProvider) setRules(ctx context.Context, rules []string) error {
+	return p.call(ctx, http.MethodPost, "/filtering/set_rules", map[string][]string{"rules": rules}, nil)
+}
+
+// adGuardRule returns the rewrite rule answering name with content.
+func adGuardRule(name, typ, content string) string {
+	return fmt.Sprintf("|%s^$dnsrewrite=NOERROR;%s;%s", name, typ, content)
+}
+
+// parseAdGuardRule parses a rule written by adGuardRule.
+func parseAdGuardRule(rule string) (name, typ, content string, ok bool) {
+	rest, ok := strings.CutPrefix(rule, "|")
+	if !ok {
+		return "", "", "", false
+	}
+	name, rest, ok = strings.Cut(rest, "^$dnsrewrite=NOERROR;")
+	if !ok {
+		return "", "", "", false
+	}
+	typ, content, ok = strings.Cut(rest, ";")
+	return name, typ, content, ok
+}
+
+// adGuardComment returns the comment line preceding the rule of c.
+func adGuardComment(c change) string {
+	return fmt.Sprintf("! %s ttl=%d", c.Comment, c.TTL)
+}
+
+// owned returns our records in rules, keyed by the index of their rule. The
+// record ID is the rule itself.
+func (p *adGuardProvider) owned(rules []string) map[int]record {
+	out := map[int]record{}
+	for i := 1; i < len(rules); i++ {
+		comment, ok := strings.CutPrefix(rules[i-1], "! ")
+		if !ok || !ownedComment(comment) {
+			continue
+		}
+		name, typ, content, ok := parseAdGuardRule(rules[i])
+		if !ok || (name != p.zone && !strings.HasSuffix(name, "."+p.zone)) {
+			continue
+		}
+		ttl := 1
+		if j := strings.LastIndex(comment, " ttl="); j >= 0 {
+			fmt.Sscan(comment[j+len(" ttl="):], &ttl)
+			comment = comment[:j]
+		}
+		out[i] = record{ID: rules[i], Name: name, Type: typ, Content: content, TTL: ttl, Comment: comment}
+	}
+	return out
+}
+
+func (p *adGuardProvider) List(ctx context.Context) ([]record, error) {
+	rules, err := p.rules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get filtering rules: %w", err)
+	}
+	var out []record
+	for _, r := range p.owned(rules) {
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// edit rewrites the rules with fn applied to our record with ID id, or to
+// none when id is empty.
+func (p *adGuardProvider) edit(ctx context.Context, id string, fn func(rules []string, i int) []string) error {
+	agMu.Lock()
+	defer agMu.Unlock()
+	rules, err := p.rules(ctx)
+	if err != nil {
+		return fmt.Errorf("get filtering rules: %w", err)
+	}
+	i := -1
+	for j, r := range p.owned(rules) {
+		if r.ID == id {
+			i = j
+		}
+	}
+	if id != "" && i < 0 {
+		return fmt.Errorf("%w: %s", errAdGuardRuleGone, id)
+	}
+	return p.setRules(ctx, fn(rules, i))
+}
+
+func (p *adGuardProvider) Create(ctx context.Context, c change) (string, error) {
+	rule := adGuardRule(c.fqdn(), c.Type, c.Content)
+	return rule, p.edit(ctx, "", func(rules []string, _ int) []string {
+		return append(rules, adGuardComment(c), rule)
+	})
+}
+
+func (p *adGuardProvider) Update(ctx context.Context, c change) error {
+	return p.edit(ctx, c.RecordID, func(rules []string, i int) []string {
+		rules[i-1], rules[i] = adGuardComment(c), adGuardRule(c.fqdn(), c.Type, c.Content)
+		return rules
+	})
+}
+
+func (p *adGuardProvider) Delete(ctx context.Context, c change) error {
+	err := p.edit(ctx, c.RecordID, func(rules []string, i int) []string {
+		return append(rules[:i-1], rules[i+1:]...)
+	})
+	if errors.Is(err, errAdGuardRuleGone) {
+		// already gone
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// adminRecord is a managed record as listed by GET /records.
+type adminRecord struct {
+	Zone    string `json:"zone"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	// Device is the MagicDNS name of the node the record is published for,
+	// empty for records of other sources.
+	Device string `json:"device,omitempty"`
+}
+
+var (
+	adminMu sync.Mutex
+	// adminRecords and adminStatus are the managed records and the status
+	// as of the last cycle, kept for the endpoints so they don't race the
+	// cycle's state.
+	adminRecords []adminRecord
+	adminStatus  *statusFile
+	// adminPending are the changes the last cycle planned but didn't make,
+	// and adminHistory the latest adminHistorySize changes made, oldest
+	// first.
+	adminPending []change
+	adminHistory []auditEntry
+)
+
+const adminHistorySize = 200
+
+// noteReport keeps what the cycle r left undone: everything it planned in
+// monitor mode or while frozen, its deferred and failed changes otherwise.
+func noteReport(r *runReport) {
+	var pending []change
+	if !applying() {
+		pending = append(pending, r.Planned...)
+	} else {
+		pending = append(pending, r.Deferred...)
+		for _, f := range r.Failed {
+			pending = append(pending, f.change)
+		}
+	}
+	adminMu.Lock()
+	adminPending = pending
+	adminMu.Unlock()
+}
+
+// noteHistory adds the audit entries of a cycle to the recent changes.
+func noteHistory(entries []auditEntry) {
+	adminMu.Lock()
+	defer adminMu.Unlock()
+	adminHistory = append(adminHistory, entries...)
+	if n := len(adminHistory) - adminHistorySize; n > 0 {
+		adminHistory = slices.Clone(adminHistory[n:])
+	}
+}
+
+// noteAdminRecords keeps the records of the state for GET /records, with
+// the device each was wanted for in the cycle with st.
+func noteAdminRecords(st *ipnstate.Status, wants map[string]map[string]host) {
+	devices := map[string]string{string(st.Self.ID): strings.TrimSuffix(st.Self.DNSName, ".")}
+	for _, ps := range st.Peer {
+		devices[string(ps.ID)] = strings.TrimSuffix(ps.DNSName, ".")
+	}
+	var out []adminRecord
+	for zoneName, records := range syncState.Records {
+		for key, r := range records {
+			name, _, _ := strings.Cut(key, " ")
+			rec := adminRecord{Zone: zoneName, Name: name, Type: r.Type, Content: r.Content}
+			if h, ok := wants[zoneName][key]; ok && h.NodeID != "" {
+				rec.Device = devices[h.NodeID]
+			}
+			out = append(out, rec)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if a.Zone != b.Zone {
+			return a.Zone < b.Zone
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Type+a.Content < b.Type+b.Content
+	})
+	adminMu.Lock()
+	adminRecords = out
+	adminMu.Unlock()
+}
+
+func init() {
+	// GET /records lists the managed records, narrowed down with ?zone= or
+	// ?device=.
+	handle("/records", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		zone, device := r.FormValue("zone"), r.FormValue("device")
+		adminMu.Lock()
+		out := make([]adminRecord, 0, len(adminRecords))
+		for _, rec := range adminRecords {
+			if (zone == "" || rec.Zone == zone) && (device == "" || rec.Device == device || getName(rec.Device) == device) {
+				out = append(out, rec)
+			}
+		}
+		adminMu.Unlock()
+		writeJSON(w, out)
+	})
+	// GET /status returns the outcome of the last cycle, its last error and
+	// the freeze in effect, like STATUS_PATH.
+	handle("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		adminMu.Lock()
+		s := adminStatus
+		adminMu.Unlock()
+		if s == nil {
+			http.Error(w, "no sync has run yet", http.StatusServiceUnavailable)
+			return
+		}
+		out := *s
+		out.Frozen = currentFreeze()
+		out.Records = nil
+		writeJSON(w, out)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// annotation is the operator's note on a manually triggered sync, carried
+// into the audit log, the run report and the notifications of that sync.
+type annotation struct {
+	Reason string `json:"reason,omitempty"`
+	Ticket string `json:"ticket,omitempty"`
+	// By is who triggered the sync, as authorized by the listener.
+	By string `json:"by,omitempty"`
+}
+
+func (a *annotation) String() string {
+	var parts []string
+	for _, f := range [][2]string{{"reason", a.Reason}, {"ticket", a.Ticket}, {"by", a.By}} {
+		if f[1] != "" {
+			parts = append(parts, f[0]+": "+f[1])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+type annotationKey struct{}
+
+func withAnnotation(ctx context.Context, a *annotation) context.Context {
+	return context.WithValue(ctx, annotationKey{}, a)
+}
+
+// annotationFrom returns the annotation of the sync running with ctx, or nil.
+func annotationFrom(ctx context.Context) *annotation {
+	a, _ := ctx.Value(annotationKey{}).(*annotation)
+	return a
+}
+
+// manualSyncs queues the syncs requested through POST /sync for the daemon
+// loop; a request while one is queued is refused (see queueSync).
+var manualSyncs = make(chan *annotation, 1)
+
+func init() {
+	handle("/sync", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		a := &annotation{Reason: r.FormValue("reason"), Ticket: r.FormValue("ticket"), By: caller(r)}
+		queued, err := queueSync(r.Context(), a)
+		switch {
+		case err != nil:
+			http.Error(w, fmt.Sprintf("queue the sync: %v", err), http.StatusBadGateway)
+		case !queued:
+			http.Error(w, "a sync is already queued", http.StatusConflict)
+		default:
+			slog.Info("sync requested", "annotation", a)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	})
+	registerCommand("trigger", runTrigger)
+}
+
+// runTrigger implements `tailscale-dns-sync trigger`: it asks the running
+// daemon for a sync right away, annotated with -reason and -ticket.
+func runTrigger(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("trigger", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	reason := fs.String("reason", "", "why the sync is needed, kept in the audit log and notifications")
+	ticket := fs.String("ticket", "", "change ticket ID")
+	target := fs.String("url", "", "daemon URL, default from the listen config")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	if err := callDaemon(ctx, *target, http.MethodPost, "/sync", url.Values{"reason": {*reason}, "ticket": {*ticket}}); err != nil {
+		return err
+	}
+	slog.Info("sync requested")
+	return nil
+}
+
+// callDaemon sends form to path of the running daemon at target, by default
+// the address of the listen config, and fails unless it is accepted.
+func callDaemon(ctx context.Context, target, method, path string, form url.Values) error {
+	if target == "" {
+		host, port, err := net.SplitHostPort(cfg().Listen.Addr)
+		if err != nil {
+			return fmt.Errorf("set -url or LISTEN_ADDR: %w", err)
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		scheme := "http"
+		if cfg().Listen.TLSCert != "" {
+			scheme = "https"
+		}
+		target = scheme + "://" + net.JoinHostPort(host, port)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(target, "/")+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cfg().Listen.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg().Listen.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
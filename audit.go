@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// auditEntry records the outcome of a single provider mutation.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Zone       string    `json:"zone"`
+	Name       string    `json:"name"`
+	FQDN       string    `json:"fqdn"`
+	Type       string    `json:"type"`
+	Content    string    `json:"content,omitempty"`
+	OldContent string    `json:"old_content,omitempty"`
+	RecordID   string    `json:"record_id,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	// Annotation is the operator's note on a manually triggered sync.
+	Annotation *annotation `json:"annotation,omitempty"`
+}
+
+// newAuditEntry records c; errMsg is empty when c was applied.
+func newAuditEntry(c change, errMsg string) auditEntry {
+	e := auditEntry{
+		Time:       time.Now().UTC(),
+		Action:     c.Action,
+		Zone:       c.Zone,
+		Name:       c.Name,
+		FQDN:       c.fqdn(),
+		Type:       c.Type,
+		Content:    c.Content,
+		OldContent: c.OldContent,
+		RecordID:   c.RecordID,
+		Outcome:    "success",
+	}
+	if errMsg != "" {
+		e.Outcome = "error"
+		e.Error = errMsg
+	}
+	return e
+}
+
+// auditSQLite inserts entries into the SQLite database at path, unless
+// built with no_sqlite.
+var auditSQLite func(ctx context.Context, path string, entries []auditEntry) error
+
+// writeAudit appends entries to AUDIT_PATH as JSON lines. Local files are
+// appended to; object storage is immutable, so AUDIT_PATH is used as a
+// prefix and each cycle writes its own object. sqlite:// locations get a
+// row per entry.
+func writeAudit(ctx context.Context, entries []auditEntry) {
+	if cfg().AuditPath == "" || len(entries) == 0 {
+		return
+	}
+	for i := range entries {
+		entries[i].Annotation = annotationFrom(ctx)
+	}
+	if path, ok := strings.CutPrefix(cfg().AuditPath, "sqlite://"); ok {
+		if auditSQLite == nil {
+			slog.Warn("write audit: built without SQLite support")
+			return
+		}
+		if err := auditSQLite(ctx, path, entries); err != nil {
+			slog.Warn("write audit failed", logErr(err))
+		}
+		return
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			slog.Warn("encode audit entry failed", logErr(err))
+			return
+		}
+	}
+	if u, err := url.Parse(cfg().AuditPath); err == nil && (u.Scheme == "s3" || u.Scheme == "gs") {
+		location := cfg().AuditPath + entries[0].Time.Format("20060102T150405.000000000Z") + ".jsonl"
+		if err := writeObject(ctx, location, buf.Bytes()); err != nil {
+			slog.Warn("write audit failed", logErr(err))
+		}
+		return
+	}
+	f, err := os.OpenFile(cfg().AuditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Warn("open audit log failed", "path", cfg().AuditPath, logErr(err))
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		slog.Warn("write audit failed", logErr(err))
+	}
+}
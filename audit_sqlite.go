@@ -0,0 +1,92 @@
+//go:build !no_sqlite
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const auditSchema = `CREATE TABLE IF NOT EXISTS audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	time TEXT NOT NULL,
+	action TEXT NOT NULL,
+	zone TEXT NOT NULL,
+	name TEXT NOT NULL,
+	fqdn TEXT NOT NULL,
+	type TEXT NOT NULL,
+	content TEXT,
+	old_content TEXT,
+	record_id TEXT,
+	outcome TEXT NOT NULL,
+	error TEXT,
+	annotation TEXT
+);
+CREATE INDEX IF NOT EXISTS audit_fqdn ON audit (fqdn, time);
+-- the log is append-only
+CREATE TRIGGER IF NOT EXISTS audit_no_update BEFORE UPDATE ON audit
+BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END;
+CREATE TRIGGER IF NOT EXISTS audit_no_delete BEFORE DELETE ON audit
+BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END;`
+
+var (
+	auditDBOnce sync.Once
+	auditDB     *sql.DB
+	auditDBErr  error
+)
+
+func init() {
+	auditSQLite = insertAudit
+}
+
+// openAuditDB opens the database at path once, creating the table.
+func openAuditDB(ctx context.Context, path string) (*sql.DB, error) {
+	auditDBOnce.Do(func() {
+		auditDB, auditDBErr = sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+		if auditDBErr != nil {
+			return
+		}
+		if _, auditDBErr = auditDB.ExecContext(ctx, auditSchema); auditDBErr != nil {
+			auditDB.Close()
+			return
+		}
+		onShutdown(func() { auditDB.Close() })
+	})
+	return auditDB, auditDBErr
+}
+
+// insertAudit adds entries to the database at path in one transaction.
+func insertAudit(ctx context.Context, path string, entries []auditEntry) error {
+	db, err := openAuditDB(ctx, path)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO audit
+		(time, action, zone, name, fqdn, type, content, old_content, record_id, outcome, error, annotation)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, e := range entries {
+		var annotation sql.NullString
+		if e.Annotation != nil {
+			annotation = sql.NullString{String: e.Annotation.String(), Valid: true}
+		}
+		_, err := stmt.ExecContext(ctx, e.Time.Format(time.RFC3339Nano), e.Action, e.Zone, e.Name, e.FQDN, e.Type,
+			e.Content, e.OldContent, e.RecordID, e.Outcome, e.Error, annotation)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
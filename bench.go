@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/netip"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func init() {
+	registerCommand("bench", runBench)
+}
+
+// runBench implements `tailscale-dns-sync bench`: it reconciles a synthetic
+// tailnet of -peers nodes into in-memory zones for -cycles cycles, replacing
+// -churn of the peers between cycles, and reports time, allocations and
+// provider calls per cycle. Nothing outside the process is touched.
+func runBench(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	peers := fs.Int("peers", 1000, "number of synthetic peers")
+	zoneCount := fs.Int("zones", 1, "number of zones")
+	cycles := fs.Int("cycles", 5, "number of sync cycles")
+	churn := fs.Float64("churn", 0.05, "fraction of peers replaced between cycles")
+	fs.Parse(args)
+
+	c := defaultConfig()
+	liveConfig.Store(&c)
+	providers := make([]*memoryProvider, *zoneCount)
+	zones = nil
+	for i := range providers {
+		providers[i] = newMemoryProvider()
+		name := fmt.Sprintf("bench%d.example", i)
+		zones = append(zones, &zone{Name: name, Suffix: domainSuffix(name), provider: providers[i]})
+	}
+	tailnet := newSyntheticTailnet(*peers)
+	syntheticStatus = tailnet.status
+
+	// per-record logging would dominate the measurements; the log package
+	// writes through the slog handler too, so a handler taking no level
+	// silences both
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1})))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "cycle\tpeers\tchanges\ttime\tchanges/s\tallocs\tbytes\tapi calls\t")
+	for cycle := 1; cycle <= *cycles; cycle++ {
+		if cycle > 1 {
+			tailnet.replace(int(float64(*peers) * *churn))
+		}
+		var calls int64
+		for _, p := range providers {
+			calls -= p.calls.Load()
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		report := reconcile(ctx)
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+		for _, p := range providers {
+			calls += p.calls.Load()
+		}
+		if report.Result != "success" {
+			return fmt.Errorf("cycle %d: %s %s", cycle, report.Result, report.Error)
+		}
+		n := len(report.Applied)
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%.0f\t%d\t%d\t%d\t\n", cycle, len(tailnet.peers), n,
+			elapsed.Round(time.Microsecond), float64(n)/elapsed.Seconds(),
+			after.Mallocs-before.Mallocs, after.TotalAlloc-before.TotalAlloc, calls)
+	}
+	return w.Flush()
+}
+
+// syntheticTailnet is a fake tailnet for benchmarks.
+type syntheticTailnet struct {
+	peers map[key.NodePublic]*ipnstate.PeerStatus
+	next  int
+}
+
+func newSyntheticTailnet(n int) *syntheticTailnet {
+	t := &syntheticTailnet{peers: map[key.NodePublic]*ipnstate.PeerStatus{}}
+	for i := 0; i < n; i++ {
+		t.add()
+	}
+	return t
+}
+
+func (t *syntheticTailnet) add() {
+	t.next++
+	ip := netip.AddrFrom4([4]byte{100, 64 + byte(t.next>>16&0x3f), byte(t.next >> 8), byte(t.next)})
+	t.peers[key.NewNode().Public()] = &ipnstate.PeerStatus{
+		DNSName:      fmt.Sprintf("node-%d.bench.ts.net.", t.next),
+		TailscaleIPs: []netip.Addr{ip},
+		Online:       true,
+	}
+}
+
+// replace removes n random peers and adds n new ones.
+func (t *syntheticTailnet) replace(n int) {
+	for k := range t.peers {
+		if n == 0 {
+			break
+		}
+		if rand.Intn(2) == 0 {
+			continue
+		}
+		delete(t.peers, k)
+		t.add()
+		n--
+	}
+}
+
+func (t *syntheticTailnet) status() *ipnstate.Status {
+	return &ipnstate.Status{
+		Self:           &ipnstate.PeerStatus{},
+		Peer:           t.peers,
+		CurrentTailnet: &ipnstate.TailnetStatus{MagicDNSSuffix: "bench.ts.net"},
+	}
+}
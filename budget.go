@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+)
+
+// Kinds of provider API calls.
+const (
+	apiCallList   = "list"
+	apiCallMutate = "mutate"
+	apiCallRetry  = "retry"
+	apiCallOther  = "other"
+)
+
+var (
+	metricAPICalls      = newMetric("counter", "provider_api_calls_total", "Provider API calls, by kind: list, mutate, retry or other.")
+	metricCycleAPICalls = newMetric("gauge", "cycle_provider_api_calls", "Provider API calls made by the last sync cycle, by kind.")
+	metricAPIErrors     = newMetric("counter", "provider_api_errors_total", "Provider API calls that failed, by zone and kind.")
+
+	apiMu sync.Mutex
+	// apiCalls counts the calls of the running cycle by kind; apiReserved
+	// holds calls granted to changes that weren't made yet.
+	apiCalls    = map[string]int{}
+	apiReserved int
+)
+
+// beginAPICycle starts counting the calls of a new cycle.
+func beginAPICycle() {
+	apiMu.Lock()
+	defer apiMu.Unlock()
+	apiCalls = map[string]int{}
+	apiReserved = 0
+	rateLimited.Store(false)
+}
+
+// countAPICall records a provider call of kind.
+func countAPICall(kind string) {
+	metricAPICalls.Inc("kind", kind)
+	apiMu.Lock()
+	apiCalls[kind]++
+	apiMu.Unlock()
+}
+
+// countAPIError records a failed provider call of kind against z.
+func countAPIError(z *zone, kind string) {
+	metricAPIErrors.Inc("zone", z.Name, "kind", kind)
+}
+
+// cycleAPICalls returns the calls of the running cycle by kind and exports
+// them.
+func cycleAPICalls() map[string]int {
+	apiMu.Lock()
+	defer apiMu.Unlock()
+	out := make(map[string]int, len(apiCalls))
+	for _, kind := range []string{apiCallList, apiCallMutate, apiCallRetry, apiCallOther} {
+		metricCycleAPICalls.Set(float64(apiCalls[kind]), "kind", kind)
+		if apiCalls[kind] > 0 {
+			out[kind] = apiCalls[kind]
+		}
+	}
+	return out
+}
+
+// apiBudgetLeft returns how many calls API_BUDGET_PER_CYCLE still allows.
+func apiBudgetLeft() int {
+	apiMu.Lock()
+	defer apiMu.Unlock()
+	return budgetLeft()
+}
+
+func budgetLeft() int {
+	if cfg().APIBudget <= 0 {
+		return math.MaxInt
+	}
+	spent := apiReserved
+	for _, n := range apiCalls {
+		spent += n
+	}
+	return max(cfg().APIBudget-spent, 0)
+}
+
+// budgetChanges keeps as many of changes as the rest of the cycle's budget
+// pays for, counting one call per chunk when they are sent in batches, and
+// defers the others to the next cycle. The calls are held for the kept
+// changes until release is called, after they were made, so zones applied
+// concurrently don't spend the same budget twice.
+func budgetChanges(z *zone, changes []change) (kept, deferred []change, release func()) {
+	perCall := 1
+	if _, ok := z.provider.(batcher); ok && cfg().BatchSize > 0 && z.registry == nil {
+		perCall = cfg().BatchSize
+	}
+	apiMu.Lock()
+	left := budgetLeft()
+	n := len(changes)
+	if left < math.MaxInt/perCall {
+		n = min(n, left*perCall)
+	}
+	calls := (n + perCall - 1) / perCall
+	if cfg().APIBudget > 0 {
+		apiReserved += calls
+	}
+	apiMu.Unlock()
+	if n < len(changes) {
+		slog.Warn("API budget per cycle reached, deferring changes to the next cycle", "zone", z.Name, "budget", cfg().APIBudget, "deferred", len(changes)-n)
+	}
+	return changes[:n], changes[n:], func() {
+		if cfg().APIBudget <= 0 {
+			return
+		}
+		apiMu.Lock()
+		apiReserved -= calls
+		apiMu.Unlock()
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// capacityReporter is implemented by providers whose zones have a record
+// limit. Capacity returns the number of records in the whole zone and the
+// limit, 0 meaning none is known.
+type capacityReporter interface {
+	Capacity(ctx context.Context) (used, limit int, err error)
+}
+
+var (
+	metricZoneRecords     = newMetric("gauge", "zone_records", "Records in the zone, including ones not managed by the sync.")
+	metricZoneRecordLimit = newMetric("gauge", "zone_record_limit", "Record limit of the zone at the provider.")
+
+	capacityMu sync.Mutex
+	// capacityChecked and capacityWarned are per zone.
+	capacityChecked = map[string]time.Time{}
+	capacityWarned  = map[string]bool{}
+)
+
+// checkCapacity warns once when z is about to run out of records, counting
+// the creates about to be applied, every CAPACITY_CHECK_INTERVAL.
+func checkCapacity(ctx context.Context, z *zone, pending []change) {
+	cr, ok := z.provider.(capacityReporter)
+	if !ok || cfg().CapacityCheckInterval <= 0 || apiBudgetLeft() == 0 {
+		// out of budget, checked in a later cycle
+		return
+	}
+	capacityMu.Lock()
+	due := time.Since(capacityChecked[z.Name]) >= cfg().CapacityCheckInterval
+	if due {
+		capacityChecked[z.Name] = time.Now()
+	}
+	capacityMu.Unlock()
+	if !due {
+		return
+	}
+	countAPICall(apiCallOther)
+	used, limit, err := cr.Capacity(ctx)
+	if err != nil {
+		slog.Warn("capacity check failed", "zone", z.Name, logErr(err))
+		return
+	}
+	metricZoneRecords.Set(float64(used), "zone", z.Name)
+	if limit <= 0 {
+		return
+	}
+	metricZoneRecordLimit.Set(float64(limit), "zone", z.Name)
+	for _, c := range pending {
+		if c.Action == actionCreate {
+			used++
+		}
+	}
+	near := float64(used) >= cfg().CapacityWarnRatio*float64(limit)
+	capacityMu.Lock()
+	warned := capacityWarned[z.Name]
+	capacityWarned[z.Name] = near
+	capacityMu.Unlock()
+	if near && !warned {
+		msg := fmt.Sprintf("%s: %d of %d records used, creates will start failing at the limit", z.Name, used, limit)
+		slog.Warn("zone near its record limit", "zone", z.Name, "used", used, "limit", limit)
+		notify(ctx, event{Type: "capacity", Message: msg})
+	}
+}
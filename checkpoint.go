@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// checkpointMaxAge is how long an interrupted run may be resumed. Older
+// checkpoints are dropped and the zone is planned from scratch.
+const checkpointMaxAge = time.Hour
+
+// checkpoint holds the changes of a zone still to be applied by an
+// interrupted run.
+type checkpoint struct {
+	Changes []change  `json:"changes"`
+	Created time.Time `json:"created"`
+}
+
+// checkpointMu guards syncState.Checkpoints, which zones update concurrently.
+var checkpointMu sync.Mutex
+
+// resumeCheckpoint returns the changes an interrupted run left for z.
+func resumeCheckpoint(z *zone) (checkpoint, bool) {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	cp, ok := syncState.Checkpoints[z.Name]
+	if ok && time.Since(cp.Created) > checkpointMaxAge {
+		slog.Info("dropping the checkpoint, replanning", "zone", z.Name, "checkpoint", cp.Created.Format(time.RFC3339))
+		delete(syncState.Checkpoints, z.Name)
+		return cp, false
+	}
+	return cp, ok && len(cp.Changes) > 0
+}
+
+// setCheckpoint records that changes remain to be applied in z and saves
+// the state, so a restart resumes from here. No changes clear it.
+func setCheckpoint(ctx context.Context, z *zone, created time.Time, changes []change) {
+	checkpointMu.Lock()
+	defer checkpointMu.Unlock()
+	if len(changes) == 0 {
+		delete(syncState.Checkpoints, z.Name)
+	} else {
+		if syncState.Checkpoints == nil {
+			syncState.Checkpoints = map[string]checkpoint{}
+		}
+		syncState.Checkpoints[z.Name] = checkpoint{Changes: changes, Created: created}
+	}
+	// the zone's deadline may be what interrupted the run
+	saveState(context.WithoutCancel(ctx))
+}
+
+// applyCheckpointed applies changes in chunks of CHECKPOINT_SIZE, saving
+// the remaining ones after every chunk. Changes failing within a chunk are
+// left to the next full plan.
+func applyCheckpointed(ctx context.Context, z *zone, changes []change, created time.Time) (applied []change, failed []failedChange) {
+	size := cfg().CheckpointSize
+	if size <= 0 || len(changes) <= size {
+		applied, failed = applyChanges(ctx, z, changes)
+		if created.IsZero() {
+			return applied, failed
+		}
+		setCheckpoint(ctx, z, created, nil)
+		return applied, failed
+	}
+	if created.IsZero() {
+		created = time.Now()
+	}
+	for len(changes) > 0 && ctx.Err() == nil {
+		n := min(size, len(changes))
+		setCheckpoint(ctx, z, created, changes)
+		a, f := applyChanges(ctx, z, changes[:n])
+		applied, failed = append(applied, a...), append(failed, f...)
+		changes = changes[n:]
+	}
+	setCheckpoint(ctx, z, created, changes)
+	if len(changes) > 0 {
+		slog.Warn("interrupted, resuming next cycle", "zone", z.Name, "changes_left", len(changes))
+	}
+	return applied, failed
+}
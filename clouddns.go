@@ -0,0 +1,261 @@
+//go:build !no_clouddns
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const cloudDNSEndpoint = "https://dns.googleapis.com/dns/v1/projects/"
+
+var (
+	cdnsOnce    sync.Once
+	cdnsClient  *http.Client
+	cdnsProject string
+	cdnsErr     error
+)
+
+func init() {
+	registerProvider("clouddns", newCloudDNSProvider)
+}
+
+// cloudDNSProvider manages the records in one Google Cloud DNS managed zone.
+// Like Route53, Cloud DNS records carry no comments, so every A, AAAA, CNAME
+// and SRV record under the managed suffix is considered ours, and records
+// sharing a name and type are rewritten as one set.
+type cloudDNSProvider struct {
+	client  *http.Client
+	project string
+	zone    string
+	// managedZone is the Cloud DNS name of the zone, not its DNS name.
+	managedZone string
+	mu          sync.Mutex
+}
+
+// cloudDNSRecordSet is a resource record set of the Cloud DNS API.
+type cloudDNSRecordSet struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	TTL     int      `json:"ttl"`
+	Rrdatas []string `json:"rrdatas"`
+}
+
+func newCloudDNSProvider(ctx context.Context, name string) (provider, error) {
+	cdnsOnce.Do(func() {
+		// service account keys from GOOGLE_APPLICATION_CREDENTIALS, workload
+		// identity or the metadata server
+		creds, err := google.FindDefaultCredentials(context.Background(), "https://www.googleapis.com/auth/ndev.clouddns.readwrite")
+		if err != nil {
+			cdnsErr = err
+			return
+		}
+		cdnsProject = os.Getenv("GCP_PROJECT")
+		if cdnsProject == "" {
+			cdnsProject = creds.ProjectID
+		}
+		if cdnsProject == "" {
+			cdnsErr = fmt.Errorf("set GCP_PROJECT, the credentials don't name a project")
+			return
+		}
+		cdnsClient = oauth2.NewClient(context.Background(), creds.TokenSource)
+	})
+	if cdnsErr != nil {
+		return nil, cdnsErr
+	}
+	p := &cloudDNSProvider{client: cdnsClient, project: cdnsProject, zone: name}
+	var res struct {
+		ManagedZones []struct {
+			Name string `json:"name"`
+		} `json:"managedZones"`
+	}
+	if err := p.call(ctx, http.MethodGet, "/managedZones?dnsName="+url.QueryEscape(name+"."), nil, &res); err != nil {
+		return nil, fmt.Errorf("list managed zones %s: %w", name, err)
+	}
+	var names []string
+	for _, z := range res.ManagedZones {
+		names = append(names, z.Name)
+	}
+	if want := os.Getenv("CLOUDDNS_MANAGED_ZONE"); want != "" {
+		if !slices.Contains(names, want) {
+			return nil, fmt.Errorf("%w: %s as managed zone %s", errZoneNotFound, name, want)
+		}
+		names = []string{want}
+	}
+	switch len(names) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", errZoneNotFound, name)
+	case 1:
+		p.managedZone = names[0]
+		return p, nil
+	}
+	return nil, fmt.Errorf("several managed zones serve %s, set CLOUDDNS_MANAGED_ZONE to one of: %s", name, strings.Join(names, ", "))
+}
+
+// call sends a request to the project's Cloud DNS API and decodes the
+// response into out.
+func (p *cloudDNSProvider) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, cloudDNSEndpoint+p.project+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *cloudDNSProvider) StoredTTL(ttl int) int {
+	if ttl <= 1 {
+		// Cloud DNS has no automatic TTL
+		return 300
+	}
+	return ttl
+}
+
+func (p *cloudDNSProvider) List(ctx context.Context) ([]record, error) {
+	var out []record
+	err := p.ListPages(ctx, p.zone, func(page []record) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// ListPages lists the zone a page at a time and keeps the names under
+// suffix; the API only filters by exact name.
+func (p *cloudDNSProvider) ListPages(ctx context.Context, suffix string, fn func([]record) error) error {
+	if suffix == "" {
+		suffix = p.zone
+	}
+	token := ""
+	for {
+		var res struct {
+			Rrsets        []cloudDNSRecordSet `json:"rrsets"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		path := "/managedZones/" + p.managedZone + "/rrsets"
+		if token != "" {
+			path += "?pageToken=" + url.QueryEscape(token)
+		}
+		if err := p.call(ctx, http.MethodGet, path, nil, &res); err != nil {
+			return fmt.Errorf("list record sets: %w", err)
+		}
+		var page []record
+		for _, rs := range res.Rrsets {
+			name := strings.TrimSuffix(rs.Name, ".")
+			if name != suffix && !strings.HasSuffix(name, "."+suffix) {
+				continue
+			}
+			if rs.Type != "A" && rs.Type != "AAAA" && rs.Type != "CNAME" && rs.Type != "SRV" {
+				continue
+			}
+			for _, v := range rs.Rrdatas {
+				page = append(page, record{ID: name + " " + rs.Type, Name: name, Type: rs.Type, Content: strings.TrimSuffix(v, "."), TTL: rs.TTL})
+			}
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if res.NextPageToken == "" {
+			return nil
+		}
+		token = res.NextPageToken
+	}
+}
+
+// recordSet returns the record set c belongs to, nil when there is none.
+func (p *cloudDNSProvider) recordSet(ctx context.Context, c change) (*cloudDNSRecordSet, error) {
+	var res struct {
+		Rrsets []cloudDNSRecordSet `json:"rrsets"`
+	}
+	q := url.Values{"name": {c.fqdn() + "."}, "type": {c.Type}}
+	if err := p.call(ctx, http.MethodGet, "/managedZones/"+p.managedZone+"/rrsets?"+q.Encode(), nil, &res); err != nil {
+		return nil, fmt.Errorf("get record set %s %s: %w", c.fqdn(), c.Type, err)
+	}
+	if len(res.Rrsets) == 0 {
+		return nil, nil
+	}
+	return &res.Rrsets[0], nil
+}
+
+func (p *cloudDNSProvider) Create(ctx context.Context, c change) (string, error) {
+	return c.fqdn() + " " + c.Type, p.edit(ctx, c)
+}
+
+func (p *cloudDNSProvider) Update(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+func (p *cloudDNSProvider) Delete(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+// edit applies c to its record set by swapping the old set for the new one
+// in a single change.
+func (p *cloudDNSProvider) edit(ctx context.Context, c change) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old, err := p.recordSet(ctx, c)
+	if err != nil {
+		return err
+	}
+	var values []string
+	if old != nil {
+		for _, v := range old.Rrdatas {
+			values = append(values, strings.TrimSuffix(v, "."))
+		}
+	}
+	if c.Action == actionDelete && !slices.Contains(values, c.Content) {
+		// already gone
+		return nil
+	}
+	var req struct {
+		Additions []cloudDNSRecordSet `json:"additions,omitempty"`
+		Deletions []cloudDNSRecordSet `json:"deletions,omitempty"`
+	}
+	if old != nil {
+		req.Deletions = []cloudDNSRecordSet{*old}
+	}
+	if values = editRecordSet(values, c); len(values) > 0 {
+		rs := cloudDNSRecordSet{Name: c.fqdn() + ".", Type: c.Type, TTL: p.StoredTTL(c.TTL)}
+		for _, v := range values {
+			if c.Type == "CNAME" || c.Type == "SRV" {
+				v += "."
+			}
+			rs.Rrdatas = append(rs.Rrdatas, v)
+		}
+		req.Additions = []cloudDNSRecordSet{rs}
+	}
+	return p.call(ctx, http.MethodPost, "/managedZones/"+p.managedZone+"/changes", req, nil)
+}
@@ -0,0 +1,251 @@
+//go:build !no_cloudflare
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// cloudflarePageSize is the most records Cloudflare returns per page.
+const cloudflarePageSize = 1000
+
+var (
+	cfOnce sync.Once
+	cfAPI  *cloudflare.API
+	cfErr  error
+)
+
+func init() {
+	registerProvider("cloudflare", newCloudflareProvider)
+	secretResets = append(secretResets, func() { cfOnce = sync.Once{} })
+}
+
+// cloudflareProvider manages the records in one Cloudflare zone, marking the
+// ones we own with OWNERSHIP_MARKER in their comment.
+type cloudflareProvider struct {
+	api    *cloudflare.API
+	zone   string
+	zoneID string
+}
+
+func newCloudflareProvider(ctx context.Context, name string) (provider, error) {
+	cfOnce.Do(func() {
+		// retries and rate limits are handled by providerClient
+		opts := []cloudflare.Option{cloudflare.HTTPClient(providerClient), cloudflare.UsingRetryPolicy(0, 1, 1)}
+		if u := os.Getenv("CLOUDFLARE_API_URL"); u != "" {
+			opts = append(opts, cloudflare.BaseURL(strings.TrimSuffix(u, "/")))
+		}
+		cfAPI, cfErr = cloudflare.NewWithAPIToken(secretEnv("CLOUDFLARE_TOKEN"), opts...)
+	})
+	if cfErr != nil {
+		return nil, cfErr
+	}
+	id, err := resolveZoneID(ctx, cfAPI, name, os.Getenv("CLOUDFLARE_ACCOUNT_ID"))
+	if err != nil {
+		return nil, err
+	}
+	return &cloudflareProvider{api: cfAPI, zone: name, zoneID: id}, nil
+}
+
+// resolveZoneID looks up the ID of the zone called name. When accountID is
+// set, only zones owned by that account are considered, which is required
+// when the token can see the same zone name in several accounts.
+func resolveZoneID(ctx context.Context, api *cloudflare.API, name, accountID string) (string, error) {
+	res, err := api.ListZonesContext(ctx, cloudflare.WithZoneFilters(name, accountID, ""))
+	if err != nil {
+		return "", fmt.Errorf("list zones %s: %w", name, err)
+	}
+	switch len(res.Result) {
+	case 0:
+		if accountID != "" {
+			return "", fmt.Errorf("%w: %s in account %s", errZoneNotFound, name, accountID)
+		}
+		return "", fmt.Errorf("%w: %s", errZoneNotFound, name)
+	case 1:
+		return res.Result[0].ID, nil
+	default:
+		accounts := make([]string, 0, len(res.Result))
+		for _, z := range res.Result {
+			accounts = append(accounts, fmt.Sprintf("%s (%s)", z.Account.ID, z.Account.Name))
+		}
+		return "", fmt.Errorf("zone %s exists in several accounts, set CLOUDFLARE_ACCOUNT_ID to one of: %s",
+			name, strings.Join(accounts, ", "))
+	}
+}
+
+// recordFor returns the record created for c.
+func (p *cloudflareProvider) recordFor(c change) cloudflare.DNSRecord {
+	r := cloudflare.DNSRecord{
+		Type:    c.Type,
+		Name:    c.fqdn(),
+		Content: c.Content,
+		Comment: cfg().OwnershipMarker,
+		TTL:     max(c.TTL, 1),
+		Proxied: &c.Proxied,
+	}
+	if c.Comment != "" {
+		r.Comment = c.Comment
+	}
+	if c.Type == "SRV" {
+		// Cloudflare takes SRV records as structured data
+		var priority, weight, port int
+		var target string
+		fmt.Sscan(c.Content, &priority, &weight, &port, &target)
+		r.Content = ""
+		r.Data = map[string]any{"priority": priority, "weight": weight, "port": port, "target": target}
+	}
+	return r
+}
+
+// srvContent returns the content of an SRV record as "priority weight port
+// target"; Cloudflare reports the priority separately.
+func srvContent(r cloudflare.DNSRecord) string {
+	if r.Type != "SRV" || r.Priority == nil || len(strings.Fields(r.Content)) != 3 {
+		return r.Content
+	}
+	return fmt.Sprintf("%d %s", *r.Priority, r.Content)
+}
+
+func (p *cloudflareProvider) List(ctx context.Context) ([]record, error) {
+	var out []record
+	err := p.ListPages(ctx, "", func(page []record) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// ListPages lists our records page by page, narrowed on the server to names
+// ending in suffix, so zones with many unrelated records are never fetched
+// whole.
+func (p *cloudflareProvider) ListPages(ctx context.Context, suffix string, fn func([]record) error) error {
+	q := url.Values{"per_page": {strconv.Itoa(cloudflarePageSize)}}
+	if len(cfg().LegacyMarkers) == 0 {
+		// tag policies append to the marker; the filter can't match
+		// several, so with legacy markers they're only checked below
+		q.Set("comment.startswith", cfg().OwnershipMarker)
+	}
+	if suffix != "" {
+		q.Set("name.endswith", "."+suffix)
+	}
+	return p.listQuery(ctx, q, func(r cloudflare.DNSRecord) bool { return ownedComment(r.Comment) }, fn)
+}
+
+// ListRegistry returns the TXT records under suffix without our marker,
+// which ListPages leaves out: the heritage records of external-dns and
+// other owners REGISTRY=txt must see to keep off their names.
+func (p *cloudflareProvider) ListRegistry(ctx context.Context, suffix string) ([]record, error) {
+	q := url.Values{"per_page": {strconv.Itoa(cloudflarePageSize)}, "type": {"TXT"}}
+	if suffix != "" {
+		q.Set("name.endswith", "."+suffix)
+	}
+	var out []record
+	err := p.listQuery(ctx, q, func(r cloudflare.DNSRecord) bool { return !ownedComment(r.Comment) }, func(page []record) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// listQuery passes the records of the listing q that keep accepts to fn,
+// page by page.
+func (p *cloudflareProvider) listQuery(ctx context.Context, q url.Values, keep func(cloudflare.DNSRecord) bool, fn func([]record) error) error {
+	for page := 1; ; page++ {
+		q.Set("page", strconv.Itoa(page))
+		resp, err := p.api.Raw(ctx, http.MethodGet, fmt.Sprintf("/zones/%s/dns_records?%s", p.zoneID, q.Encode()), nil, nil)
+		if err != nil {
+			return fmt.Errorf("list DNS records page %d: %w", page, err)
+		}
+		var records []cloudflare.DNSRecord
+		if err := json.Unmarshal(resp.Result, &records); err != nil {
+			return fmt.Errorf("decode DNS records page %d: %w", page, err)
+		}
+		out := make([]record, 0, len(records))
+		for _, r := range records {
+			if !keep(r) {
+				continue
+			}
+			out = append(out, record{ID: r.ID, Name: r.Name, Type: r.Type, Content: srvContent(r), TTL: r.TTL,
+				Comment: r.Comment, Proxied: r.Proxied != nil && *r.Proxied, ModifiedOn: r.ModifiedOn})
+		}
+		if err := fn(out); err != nil {
+			return err
+		}
+		if len(records) < cloudflarePageSize {
+			return nil
+		}
+	}
+}
+
+func (p *cloudflareProvider) Create(ctx context.Context, c change) (string, error) {
+	r, err := p.api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(p.zoneID), cloudflare.CreateDNSRecordParams(p.recordFor(c)))
+	if err != nil {
+		return "", fmt.Errorf("CreateDNSRecord: %w", err)
+	}
+	return r.ID, nil
+}
+
+func (p *cloudflareProvider) Update(ctx context.Context, c change) error {
+	r := p.recordFor(c)
+	_, err := p.api.UpdateDNSRecord(ctx, cloudflare.ZoneIdentifier(p.zoneID), cloudflare.UpdateDNSRecordParams{
+		ID:      c.RecordID,
+		Type:    r.Type,
+		Name:    r.Name,
+		Content: r.Content,
+		Data:    r.Data,
+		TTL:     r.TTL,
+		Proxied: r.Proxied,
+		Comment: &r.Comment,
+	})
+	if err != nil {
+		return fmt.Errorf("UpdateDNSRecord: %w", err)
+	}
+	return nil
+}
+
+// Adopt takes over an unmarked record in the place of the one c creates,
+// rewriting it to c, and returns its ID, "" when there is none.
+func (p *cloudflareProvider) Adopt(ctx context.Context, c change) (string, error) {
+	records, _, err := p.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(p.zoneID), cloudflare.ListDNSRecordsParams{Name: c.fqdn(), Type: c.Type})
+	if err != nil {
+		return "", fmt.Errorf("ListDNSRecords: %w", err)
+	}
+	for _, r := range records {
+		if r.Comment != "" || recordKey(r.Type, c.Name, srvContent(r)) != c.key() {
+			continue
+		}
+		c.RecordID = r.ID
+		return r.ID, p.Update(ctx, c)
+	}
+	return "", nil
+}
+
+func (p *cloudflareProvider) Delete(ctx context.Context, c change) error {
+	if err := p.api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(p.zoneID), c.RecordID); err != nil {
+		return fmt.Errorf("DeleteDNSRecord: %w", err)
+	}
+	return nil
+}
+
+// CheckCredentials verifies CLOUDFLARE_TOKEN.
+func (p *cloudflareProvider) CheckCredentials(ctx context.Context) (time.Time, error) {
+	res, err := p.api.VerifyAPIToken(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if res.Status != "active" {
+		return res.ExpiresOn, fmt.Errorf("token is %s", res.Status)
+	}
+	return res.ExpiresOn, nil
+}
@@ -0,0 +1,88 @@
+//go:build !no_cloudflare
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+type cfBatchID struct {
+	ID string `json:"id"`
+}
+
+type cfBatchRecord struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content,omitempty"`
+	Data    any    `json:"data,omitempty"`
+	TTL     int    `json:"ttl"`
+	Comment string `json:"comment,omitempty"`
+	Proxied *bool  `json:"proxied,omitempty"`
+}
+
+type cfBatchPatch struct {
+	ID string `json:"id"`
+	cfBatchRecord
+}
+
+type cfBatchRequest struct {
+	Deletes []cfBatchID     `json:"deletes,omitempty"`
+	Patches []cfBatchPatch  `json:"patches,omitempty"`
+	Posts   []cfBatchRecord `json:"posts,omitempty"`
+}
+
+type cfBatchResult struct {
+	Deletes []cloudflare.DNSRecord `json:"deletes"`
+	Patches []cloudflare.DNSRecord `json:"patches"`
+	Posts   []cloudflare.DNSRecord `json:"posts"`
+}
+
+// ApplyBatch sends chunk through the Cloudflare batch endpoint, which applies
+// it atomically.
+func (p *cloudflareProvider) ApplyBatch(ctx context.Context, chunk []change) ([]change, error) {
+	var (
+		req     cfBatchRequest
+		creates []change
+		updates []change
+		deletes []change
+	)
+	for _, c := range chunk {
+		r := p.recordFor(c)
+		rec := cfBatchRecord{Type: r.Type, Name: r.Name, Content: r.Content, Data: r.Data, TTL: r.TTL, Comment: r.Comment, Proxied: r.Proxied}
+		switch c.Action {
+		case actionCreate:
+			req.Posts = append(req.Posts, rec)
+			creates = append(creates, c)
+		case actionUpdate:
+			req.Patches = append(req.Patches, cfBatchPatch{ID: c.RecordID, cfBatchRecord: rec})
+			updates = append(updates, c)
+		case actionDelete:
+			req.Deletes = append(req.Deletes, cfBatchID{ID: c.RecordID})
+			deletes = append(deletes, c)
+		}
+	}
+	resp, err := p.api.Raw(ctx, http.MethodPost, fmt.Sprintf("/zones/%s/dns_records/batch", p.zoneID), req, nil)
+	if err != nil {
+		return nil, fmt.Errorf("batch DNS records: %w", err)
+	}
+	var result cfBatchResult
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("decode batch result: %w", err)
+	}
+	// deletes are executed before patches and posts, results keep the request order
+	done := make([]change, 0, len(chunk))
+	done = append(done, deletes...)
+	done = append(done, updates...)
+	for i, c := range creates {
+		if i < len(result.Posts) {
+			c.RecordID = result.Posts[i].ID
+		}
+		done = append(done, c)
+	}
+	return done, nil
+}
@@ -0,0 +1,43 @@
+//go:build !no_cloudflare
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+// Capacity returns the record count of the zone and the record quota of its
+// plan, which CLOUDFLARE_RECORD_LIMIT overrides.
+func (p *cloudflareProvider) Capacity(ctx context.Context) (used, limit int, err error) {
+	_, info, err := p.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(p.zoneID), cloudflare.ListDNSRecordsParams{
+		ResultInfo: cloudflare.ResultInfo{PerPage: 1},
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("ListDNSRecords: %w", err)
+	}
+	if v := os.Getenv("CLOUDFLARE_RECORD_LIMIT"); v != "" {
+		limit, err = strconv.Atoi(v)
+		return info.Total, limit, err
+	}
+	z, err := p.api.ZoneDetails(ctx, p.zoneID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ZoneDetails: %w", err)
+	}
+	switch z.Plan.LegacyID {
+	case "free":
+		// free zones added since September 2024 get 200 records
+		limit = 200
+		if z.CreatedOn.Before(time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)) {
+			limit = 1000
+		}
+	case "pro", "business":
+		limit = 3500
+	}
+	return info.Total, limit, nil
+}
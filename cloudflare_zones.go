@@ -0,0 +1,91 @@
+//go:build !no_cloudflare
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func init() {
+	registerZoneCreator("cloudflare", createCloudflareZone)
+}
+
+func createCloudflareZone(ctx context.Context, name string) error {
+	z, err := addCloudflareZone(ctx, cfAPI, name)
+	if err != nil {
+		return err
+	}
+	slog.Info("created zone, point its delegation at its name servers", "zone", name, "nameservers", strings.Join(z.NameServers, ","))
+	return nil
+}
+
+// addCloudflareZone adds name as a full zone to CLOUDFLARE_ACCOUNT_ID.
+func addCloudflareZone(ctx context.Context, api *cloudflare.API, name string) (cloudflare.Zone, error) {
+	accountID := os.Getenv("CLOUDFLARE_ACCOUNT_ID")
+	if accountID == "" {
+		return cloudflare.Zone{}, errors.New("creating a zone requires CLOUDFLARE_ACCOUNT_ID")
+	}
+	z, err := api.CreateZone(ctx, name, false, cloudflare.Account{ID: accountID}, "full")
+	if err != nil {
+		return cloudflare.Zone{}, fmt.Errorf("CreateZone %s: %w", name, err)
+	}
+	return z, nil
+}
+
+// Delegate creates the zone child in the same account unless it exists and
+// publishes its Cloudflare nameservers as NS records in this zone.
+func (p *cloudflareProvider) Delegate(ctx context.Context, child string) ([]string, error) {
+	var nameservers []string
+	id, err := resolveZoneID(ctx, p.api, child, os.Getenv("CLOUDFLARE_ACCOUNT_ID"))
+	switch {
+	case err == nil:
+		z, err := p.api.ZoneDetails(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("ZoneDetails %s: %w", child, err)
+		}
+		nameservers = z.NameServers
+	case errors.Is(err, errZoneNotFound):
+		z, err := addCloudflareZone(ctx, p.api, child)
+		if err != nil {
+			return nil, err
+		}
+		nameservers = z.NameServers
+	default:
+		return nil, err
+	}
+
+	existing, _, err := p.api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(p.zoneID), cloudflare.ListDNSRecordsParams{
+		Type: "NS",
+		Name: child,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ListDNSRecords: %w", err)
+	}
+	have := map[string]bool{}
+	for _, r := range existing {
+		have[strings.TrimSuffix(r.Content, ".")] = true
+	}
+	for _, ns := range nameservers {
+		if have[ns] {
+			continue
+		}
+		_, err := p.api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(p.zoneID), cloudflare.CreateDNSRecordParams{
+			Type:    "NS",
+			Name:    child,
+			Content: ns,
+			TTL:     86400,
+			Comment: cfg().OwnershipMarker,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("CreateDNSRecord NS %s: %w", ns, err)
+		}
+	}
+	return nameservers, nil
+}
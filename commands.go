@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+func init() {
+	registerCommand("once", runOnce)
+	registerCommand("plan", runPlan)
+	registerCommand("purge", runPurge)
+	registerCommand("status", runStatus)
+}
+
+// openCommand loads the config of a one-shot command and opens the zones
+// and the state.
+func openCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	var err error
+	if zones, err = openZones(ctx); err != nil {
+		return err
+	}
+	return loadState(ctx)
+}
+
+// tailnetHosts returns the status and the hosts to publish, as a sync
+// cycle computes them.
+func tailnetHosts(ctx context.Context) (*ipnstate.Status, map[string]host, error) {
+	st, err := fetchStatus(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tailscale status: %w", err)
+	}
+	hosts := desiredHosts(st)
+	if err := mergeFileHosts(ctx, hosts); err != nil {
+		return nil, nil, err
+	}
+	mergeRegistrations(st, hosts)
+	trackStability(hosts, time.Now())
+	applyTagPolicies(hosts)
+	return st, hosts, nil
+}
+
+// The exit statuses of `once` besides 0 for a sync that completed, 1 for
+// other errors and 2 for bad flags.
+const (
+	// exitTailnet: the tailnet couldn't be read, e.g. tailscaled is down.
+	exitTailnet exitCode = 3
+	// exitProvider: the provider couldn't be opened or every zone failed.
+	exitProvider exitCode = 4
+	// exitPartial: some zones or changes failed, the others synced.
+	exitPartial exitCode = 5
+)
+
+// runOnce implements `tailscale-dns-sync once`: a single sync cycle, for
+// cron. It fails unless every zone synced. With -dry-run it prints the plan
+// like `plan` instead.
+func runOnce(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("once", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "print the changes instead of applying them")
+	jsonPath := fs.String("json", "", "with -dry-run, also write the changes as JSON to this file, - for stdout")
+	if err := openCommand(ctx, fs, args); err != nil {
+		if zones == nil {
+			slog.Error("open the zones failed", logErr(err))
+			return exitProvider
+		}
+		return err
+	}
+	if *dryRun {
+		return printPlan(ctx, *jsonPath)
+	}
+	if err := openSecondaryZones(ctx); err != nil {
+		return err
+	}
+	return syncOnce(ctx)
+}
+
+// syncOnce runs a single sync cycle and returns its exit status.
+func syncOnce(ctx context.Context) error {
+	report := reconcile(ctx)
+	// the changes are verified in the background, so wait for that
+	propagationWG.Wait()
+	pushMetrics(ctx)
+	if report.Result == "success" {
+		return nil
+	}
+	slog.Error("sync "+report.Result, "error", report.firstError())
+	switch {
+	case report.tailnetFailed:
+		return exitTailnet
+	case report.Result == "partial":
+		return exitPartial
+	case len(report.ZoneErrors) == len(zones):
+		return exitProvider
+	}
+	return exitCode(1)
+}
+
+// runPlan implements `tailscale-dns-sync plan`: it prints the changes the
+// next sync would make, without making them.
+func runPlan(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	jsonPath := fs.String("json", "", "also write the changes as JSON to this file, - for stdout")
+	if err := openCommand(ctx, fs, args); err != nil {
+		return err
+	}
+	return printPlan(ctx, *jsonPath)
+}
+
+// planOutput is the JSON written by plan -json.
+type planOutput struct {
+	Generated time.Time      `json:"generated"`
+	Provider  string         `json:"provider"`
+	Zones     []zonePlan     `json:"zones"`
+	Summary   map[string]int `json:"summary"`
+}
+
+type zonePlan struct {
+	Zone    string   `json:"zone"`
+	Suffix  string   `json:"suffix"`
+	Changes []change `json:"changes"`
+}
+
+// printPlan prints the changes the next sync would make to every zone, and
+// writes them as JSON to jsonPath when set. With jsonPath "-" the JSON goes
+// to stdout and the text to stderr.
+func printPlan(ctx context.Context, jsonPath string) error {
+	st, hosts, err := tailnetHosts(ctx)
+	if err != nil {
+		return err
+	}
+	text := io.Writer(os.Stdout)
+	if jsonPath == "-" {
+		text = os.Stderr
+	}
+	out := planOutput{Generated: time.Now().UTC(), Provider: cfg().Provider, Summary: map[string]int{actionCreate: 0, actionUpdate: 0, actionDelete: 0}}
+	for _, z := range zones {
+		records, err := currentRecords(ctx, z)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", z.Name, err)
+		}
+		changes := plan(z, zoneRecords(st, z, hosts), records)
+		for _, c := range changes {
+			fmt.Fprintf(text, "%s\t%s\n", z.Name, c)
+			out.Summary[c.Action]++
+		}
+		out.Zones = append(out.Zones, zonePlan{Zone: z.Name, Suffix: z.Suffix, Changes: append([]change{}, changes...)})
+	}
+	fmt.Fprintf(text, "%d to create, %d to update, %d to delete\n", out.Summary[actionCreate], out.Summary[actionUpdate], out.Summary[actionDelete])
+	if jsonPath == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	if jsonPath == "-" {
+		_, err = fmt.Printf("%s\n", b)
+		return err
+	}
+	return os.WriteFile(jsonPath, append(b, '\n'), 0o644)
+}
+
+// markerProviders are the providers whose records carry the ownership
+// marker, so `purge -all` can tell ours apart anywhere in a zone.
+var markerProviders = []string{"adguard", "cloudflare", "coredns", "file", "plugin", "powerdns", "technitium"}
+
+// runPurge implements `tailscale-dns-sync purge`: it deletes every record
+// the sync manages from every zone, e.g. before uninstalling. Without -yes
+// it only prints what would go.
+func runPurge(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "delete the records instead of listing them")
+	all := fs.Bool("all", false, "take every record carrying the ownership marker in the zones, not only those under the configured suffixes")
+	if err := openCommand(ctx, fs, args); err != nil {
+		return err
+	}
+	if *all && !slices.Contains(markerProviders, cfg().Provider) {
+		return fmt.Errorf("-all needs provider %s, the records of %s carry no marker", strings.Join(markerProviders, ", "), cfg().Provider)
+	}
+	failed := 0
+	listed := map[string]bool{}
+	for _, z := range zones {
+		var changes []change
+		if *all {
+			// zones of several suffixes share the records
+			if listed[z.Name] {
+				continue
+			}
+			listed[z.Name] = true
+			records, err := z.provider.List(ctx)
+			if err != nil {
+				return fmt.Errorf("list %s: %w", z.Name, err)
+			}
+			for _, r := range records {
+				name := strings.TrimSuffix(strings.ToLower(r.Name), ".")
+				if name == z.Name {
+					// the zone apex is nobody's host
+					continue
+				}
+				changes = append(changes, change{Action: actionDelete, Zone: z.Name, Suffix: z.Name, Name: strings.TrimSuffix(name, "."+z.Name), Type: r.Type, Content: r.Content, RecordID: r.ID, Reason: "purge"})
+			}
+		} else {
+			records, err := currentRecords(ctx, z)
+			if err != nil {
+				return fmt.Errorf("list %s: %w", z.Name, err)
+			}
+			for key, r := range records {
+				name, _, _ := strings.Cut(key, " ")
+				changes = append(changes, change{Action: actionDelete, Zone: z.Name, Suffix: z.Suffix, Name: name, Type: r.Type, Content: r.Content, RecordID: r.ID, Reason: "purge"})
+			}
+		}
+		sortChanges(changes)
+		if !*yes {
+			for _, c := range changes {
+				fmt.Printf("%s\t%s\n", z.Name, c)
+			}
+			continue
+		}
+		applied, f := applyChanges(ctx, z, changes)
+		var entries []auditEntry
+		for _, c := range applied {
+			entries = append(entries, newAuditEntry(c, ""))
+		}
+		for _, c := range f {
+			entries = append(entries, newAuditEntry(c.change, c.Error))
+		}
+		writeAudit(ctx, entries)
+		failed += len(f)
+		delete(syncState.Records, z.Name)
+		delete(syncState.Quarantined, z.Name)
+	}
+	if !*yes {
+		fmt.Println("run again with -yes to delete these records")
+		return nil
+	}
+	saveState(ctx)
+	if failed > 0 {
+		return fmt.Errorf("%d record(s) could not be deleted", failed)
+	}
+	return nil
+}
+
+// runStatus implements `tailscale-dns-sync status`: it prints every name the
+// tailnet maps to, with what the provider holds for it.
+func runStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	if err := openCommand(ctx, fs, args); err != nil {
+		return err
+	}
+	st, hosts, err := tailnetHosts(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tTAILNET\tPROVIDER\tONLINE")
+	for _, z := range zones {
+		records, err := currentRecords(ctx, z)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", z.Name, err)
+		}
+		want := zoneRecords(st, z, hosts)
+		keys := make([]string, 0, len(want)+len(records))
+		for key := range want {
+			keys = append(keys, key)
+		}
+		for key := range records {
+			if _, ok := want[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			h, r := want[key], records[key]
+			name, _, _ := strings.Cut(key, " ")
+			typ := h.Type
+			if typ == "" {
+				typ = r.Type
+			}
+			online := "-"
+			if h.Type != "" {
+				online = fmt.Sprint(h.Online)
+			}
+			fmt.Fprintf(w, "%s.%s\t%s\t%s\t%s\t%s\n", name, z.Suffix, typ, orDash(h.Content), orDash(r.Content), online)
+		}
+	}
+	return w.Flush()
+}
+
+// orDash returns s, or "-" when it is empty.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+var metricConflicts = newMetric("counter", "conflicts_total", "Updates and deletions skipped because the record changed after it was planned, by zone.")
+
+// verifyObserved re-lists z right before applying and holds back every update
+// or delete whose record no longer matches what plan saw, so a manual edit
+// made in the meantime isn't blindly overwritten. Held back changes are
+// re-planned next cycle, where the edit also shows up as an external change.
+func verifyObserved(ctx context.Context, z *zone, changes []change) (kept, stale []change) {
+	destructive := false
+	for _, c := range changes {
+		destructive = destructive || c.Action != actionCreate
+	}
+	if !destructive || !cfg().VerifyBeforeWrite {
+		return changes, nil
+	}
+	byID := map[string]record{}
+	err := listPages(ctx, z, func(page []record) error {
+		for _, r := range page {
+			byID[r.ID] = r
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Warn("re-list before applying failed, holding back updates and deletions", "zone", z.Name, logErr(err))
+	}
+	held := map[string]bool{}
+	for _, c := range changes {
+		if c.Action == actionCreate {
+			continue
+		}
+		if err == nil {
+			reason := conflict(c, byID)
+			if reason == "" {
+				continue
+			}
+			slog.Warn("skipping a change to a record changed since it was planned", append(c.logAttrs(z), "reason", reason)...)
+		}
+		held[c.key()] = true
+	}
+	for _, c := range changes {
+		// a create replacing a held back delete is held back with it
+		if held[c.key()] {
+			stale = append(stale, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if len(stale) > 0 && err == nil {
+		metricConflicts.Add(float64(len(stale)), "zone", z.Name)
+	}
+	return kept, stale
+}
+
+// conflict describes how the record c applies to changed after planning, or
+// returns "" when it is untouched.
+func conflict(c change, byID map[string]record) string {
+	r, ok := byID[c.RecordID]
+	observed := c.Content
+	if c.Action == actionUpdate {
+		observed = c.OldContent
+	}
+	switch {
+	case !ok:
+		return "the record was removed"
+	case r.Content != observed:
+		return fmt.Sprintf("the content changed to %s", r.Content)
+	case !c.observedAt.IsZero() && !r.ModifiedOn.Equal(c.observedAt):
+		return fmt.Sprintf("the record was modified at %s", r.ModifiedOn)
+	}
+	return ""
+}
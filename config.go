@@ -0,0 +1,1043 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// SyncModeSync applies every planned change to the provider.
+	SyncModeSync = "sync"
+	// SyncModeMonitor never mutates the provider and only reports drift.
+	SyncModeMonitor = "monitor"
+)
+
+// Management policies of POLICY.
+const (
+	// PolicySync creates, updates and deletes records.
+	PolicySync = "sync"
+	// PolicyUpsertOnly never deletes records.
+	PolicyUpsertOnly = "upsert-only"
+	// PolicyCreateOnly only creates records, leaving existing ones as they are.
+	PolicyCreateOnly = "create-only"
+)
+
+// Address families published with RECORD_TYPE=A.
+const (
+	AddressFamilyIPv4 = "ipv4"
+	AddressFamilyIPv6 = "ipv6"
+	AddressFamilyDual = "dual"
+)
+
+// Handling of peers without an IPv4 address under address_family ipv4.
+const (
+	// IPv6OnlyAAAA publishes their AAAA record instead.
+	IPv6OnlyAAAA = "aaaa"
+	// IPv6OnlyExclude leaves them out.
+	IPv6OnlyExclude = "exclude"
+)
+
+// config holds the runtime settings. They are read from the optional config
+// file first; environment variables override individual keys.
+type config struct {
+	Source string `yaml:"source"`
+	// TailscaledSocket is the LocalAPI socket of the tailscaled source.
+	// TailscaledSocketOnly never falls back to the macOS app's LocalAPI
+	// port, which is implied by setting TailscaledSocket.
+	TailscaledSocket     string `yaml:"tailscaled_socket"`
+	TailscaledSocketOnly bool   `yaml:"tailscaled_socket_only"`
+	// HeadscaleURL is the server of the headscale source, and
+	// HeadscaleBaseDomain its MagicDNS base_domain if enabled.
+	HeadscaleURL        string `yaml:"headscale_url"`
+	HeadscaleBaseDomain string `yaml:"headscale_base_domain"`
+	// TSNet makes the tailscaled source an embedded node joining the
+	// tailnet as TSNetHostname, keeping its state in TSNetStateDir.
+	TSNet         bool   `yaml:"tsnet"`
+	TSNetHostname string `yaml:"tsnet_hostname"`
+	TSNetStateDir string `yaml:"tsnet_state_dir"`
+	// FileSource is a file or URL of records published next to, or with
+	// source: file instead of, the tailnet.
+	FileSource string `yaml:"file_source"`
+	// StatusJSON is the saved tailnet status of source: status.
+	StatusJSON string   `yaml:"status_json"`
+	Tailnet    string   `yaml:"tailnet"`
+	Provider   string   `yaml:"provider"`
+	PluginPath string   `yaml:"provider_plugin"`
+	Suffixes   []string `yaml:"suffixes"`
+	Domains    []string `yaml:"domains"`
+	// ReverseZones get a PTR record for every published address in them.
+	ReverseZones []string `yaml:"reverse_zones"`
+	// DomainSuffix is the label hosts go under in DOMAIN zones,
+	// name.<DomainSuffix>.<zone>; empty puts them right under the zone.
+	DomainSuffix string        `yaml:"domain_suffix"`
+	SyncInterval time.Duration `yaml:"sync_interval"`
+	// SyncJitter is the most added to SyncInterval at random.
+	SyncJitter time.Duration `yaml:"sync_jitter"`
+	// WatchFallbackInterval replaces SyncInterval while tailscaled's IPN
+	// bus reports changes as they happen; 0 turns watching off.
+	// WatchDebounce is how long a burst of changes has to settle.
+	WatchFallbackInterval time.Duration `yaml:"watch_fallback_interval"`
+	WatchDebounce         time.Duration `yaml:"watch_debounce"`
+	// NameTemplate is a text/template rendering a node's record name from
+	// nameData, the MagicDNS short name when empty.
+	NameTemplate string `yaml:"name_template"`
+	// NameCollisions is what happens to a node whose name another node
+	// already has: skip, user or number.
+	NameCollisions string `yaml:"name_collisions"`
+	// NameMode is how a node's name comes from its MagicDNS name: short
+	// keeps the first label, fqdn everything before MagicDNSSuffix, which
+	// is read from the status when empty.
+	NameMode       string `yaml:"name_mode"`
+	MagicDNSSuffix string `yaml:"magicdns_suffix"`
+	// OwnershipMarker starts the comment of the records the sync owns.
+	// Records marked with one of LegacyMarkers are owned too and get the
+	// marker rewritten; with AdoptUnmarked a create takes over an unmarked
+	// record of its name and type instead of adding one next to it.
+	OwnershipMarker string   `yaml:"ownership_marker"`
+	LegacyMarkers   []string `yaml:"legacy_markers"`
+	AdoptUnmarked   bool     `yaml:"adopt_unmarked"`
+	// TTL of the records, 1 for the provider's automatic TTL, and
+	// Cloudflare's proxy flag; tag policies and DYNAMIC_TTL override them.
+	TTL        int    `yaml:"ttl"`
+	Proxied    bool   `yaml:"proxied"`
+	Mode       string `yaml:"sync_mode"`
+	Policy     string `yaml:"policy"`
+	RecordType string `yaml:"record_type"`
+	// AddressFamily selects the addresses published with record_type A:
+	// ipv4 (A records), ipv6 (AAAA records) or dual (both).
+	AddressFamily string `yaml:"address_family"`
+	// IPv6OnlyPeers is how address_family ipv4 handles IPv6-only peers.
+	IPv6OnlyPeers string `yaml:"ipv6_only_peers"`
+	// AddressRules pick which of a node's addresses are published.
+	AddressRules    []addressRule `yaml:"address_rules"`
+	StatsdAddr      string        `yaml:"statsd_addr"`
+	StatsdPrefix    string        `yaml:"statsd_prefix"`
+	StatsdDogStatsD bool          `yaml:"statsd_dogstatsd"`
+	StatsdTags      []string      `yaml:"statsd_tags"`
+	PushgatewayURL  string        `yaml:"pushgateway_url"`
+	PushgatewayJob  string        `yaml:"pushgateway_job"`
+	// MetricsAddr is the former name of listen.addr.
+	MetricsAddr string   `yaml:"metrics_addr"`
+	Listen      listener `yaml:"listen"`
+	// RegisterCapability is the grant capability letting nodes register
+	// names for themselves through POST /register.
+	RegisterCapability string       `yaml:"register_capability"`
+	NotifyWebhookURL   string       `yaml:"notify_webhook_url"`
+	NotifySinks        []notifySink `yaml:"notify_sinks"`
+	// NotifyBatchWindow is the batch window of sinks without their own.
+	NotifyBatchWindow time.Duration `yaml:"notify_batch_window"`
+	ReportPath        string        `yaml:"report_path"`
+	StatusPath        string        `yaml:"status_path"`
+	// SnapshotPath keeps the SnapshotKeep latest record sets for rollback.
+	SnapshotPath string `yaml:"snapshot_path"`
+	SnapshotKeep int    `yaml:"snapshot_keep"`
+	// ReverseMapPath is a JSON or .csv file mapping published addresses to
+	// names, keeping mappings for ReverseMapRetention after they go.
+	ReverseMapPath      string        `yaml:"reverse_map_path"`
+	ReverseMapRetention time.Duration `yaml:"reverse_map_retention"`
+	StatePath           string        `yaml:"state_path"`
+	AuditPath           string        `yaml:"audit_path"`
+	Registry            string        `yaml:"registry"`
+	RegistryOwnerID     string        `yaml:"registry_owner_id"`
+	RegistryPrefix      string        `yaml:"registry_prefix"`
+	Coordination        string        `yaml:"coordination"`
+	RedisURL            string        `yaml:"redis_url"`
+	LeaseName           string        `yaml:"lease_name"`
+	LeaseNamespace      string        `yaml:"lease_namespace"`
+	LeaseDuration       time.Duration `yaml:"lease_duration"`
+	MaxDeletes          int           `yaml:"max_deletes_per_cycle"`
+	BatchSize           int           `yaml:"batch_size"`
+	BatchRetries        int           `yaml:"batch_retries"`
+	RetryMax            int           `yaml:"retry_max"`
+	RetryBaseDelay      time.Duration `yaml:"retry_base_delay"`
+	RetryMaxDelay       time.Duration `yaml:"retry_max_delay"`
+	ProxyURL            string        `yaml:"proxy_url"`
+	CABundle            string        `yaml:"ca_bundle"`
+	Concurrency         int           `yaml:"concurrency"`
+	RequestsPerSecond   int           `yaml:"requests_per_second"`
+	// APIBudget caps the provider calls of a cycle, 0 meaning no cap.
+	APIBudget int `yaml:"api_budget_per_cycle"`
+	// CheckpointSize is how many changes are applied between checkpoints.
+	CheckpointSize int `yaml:"checkpoint_size"`
+	// Quarantine delays deletions, holding records with QuarantineTTL first.
+	Quarantine    time.Duration `yaml:"quarantine"`
+	QuarantineTTL int           `yaml:"quarantine_ttl"`
+	// FullAuditInterval is how often a cycle lists every zone completely and
+	// checks it against the state.
+	FullAuditInterval time.Duration `yaml:"full_audit_interval"`
+	// SkipUnchanged is how long a zone found in sync isn't listed again
+	// while the records wanted in it stay the same, 0 meaning always list.
+	SkipUnchanged time.Duration `yaml:"skip_unchanged"`
+	// SecondaryProvider is a second provider the zones are mirrored into
+	// every SecondaryInterval, as a backup for when the primary is down.
+	SecondaryProvider string        `yaml:"secondary_provider"`
+	SecondaryInterval time.Duration `yaml:"secondary_interval"`
+	ZoneTimeout       time.Duration `yaml:"zone_timeout"`
+	// SyncTimeout is the deadline of a whole cycle; one still running
+	// syncStuckGrace after it ends the process.
+	SyncTimeout time.Duration `yaml:"sync_timeout"`
+	// StatusMaxStale is how old the cached status may be for a cycle to
+	// use it when fetching the status fails.
+	StatusMaxStale    time.Duration `yaml:"status_max_stale"`
+	WakeThreshold     time.Duration `yaml:"wake_threshold"`
+	DynamicTTL        []ttlTier     `yaml:"dynamic_ttl"`
+	CreateZones       bool          `yaml:"create_zones"`
+	MaxPanics         int           `yaml:"max_consecutive_panics"`
+	SentryDSN         string        `yaml:"sentry_dsn"`
+	OTLPEndpoint      string        `yaml:"otlp_endpoint"`
+	OTLPServiceName   string        `yaml:"otlp_service_name"`
+	LogFormat         string        `yaml:"log_format"`
+	LogLevel          string        `yaml:"log_level"`
+	HealthMaxAge      time.Duration `yaml:"health_max_age"`
+	TagPolicies       []tagPolicy   `yaml:"tag_policies"`
+	HostPolicies      []hostPolicy  `yaml:"host_policies"`
+	MetadataTXT       bool          `yaml:"metadata_txt"`
+	ExitNodeName      string        `yaml:"exit_node_name"`
+	Services          []service     `yaml:"services"`
+	ServeSRV          bool          `yaml:"serve_srv"`
+	DeviceOverrides   bool          `yaml:"device_overrides"`
+	FunnelNames       []funnelName  `yaml:"funnel_names"`
+	Via6Hosts         []via6Host    `yaml:"via6_hosts"`
+	SubnetHosts       []subnetHost  `yaml:"subnet_hosts"`
+	DHCPLeases        string        `yaml:"dhcp_leases"`
+	Records           []fileRecord  `yaml:"records"`
+	VerifyBeforeWrite bool          `yaml:"verify_before_write"`
+	// VerifyResolvers are asked for the records created or updated until
+	// they answer with them or VerifyTimeout passes.
+	VerifyResolvers []string      `yaml:"verify_resolvers"`
+	VerifyTimeout   time.Duration `yaml:"verify_timeout"`
+	// AllowedRanges limits the addresses that may be published; zones
+	// listed in ZoneAllowedRanges use their own list instead.
+	AllowedRanges         []string            `yaml:"allowed_ranges"`
+	ZoneAllowedRanges     map[string][]string `yaml:"zone_allowed_ranges"`
+	CapacityCheckInterval time.Duration       `yaml:"capacity_check_interval"`
+	CapacityWarnRatio     float64             `yaml:"capacity_warn_ratio"`
+	// CredentialCheckInterval is how often credentials are verified and
+	// CredentialWarnBefore how long before they expire to warn.
+	CredentialCheckInterval time.Duration `yaml:"credential_check_interval"`
+	CredentialWarnBefore    time.Duration `yaml:"credential_warn_before"`
+	RecordMetricsLimit      int           `yaml:"record_metrics_limit"`
+	// IncludeTags limits the published nodes to those with one of the
+	// tags; ExcludeTags drops nodes with any of them.
+	IncludeTags []string `yaml:"include_tags"`
+	ExcludeTags []string `yaml:"exclude_tags"`
+	// IncludeNames and ExcludeNames do the same with regular expressions
+	// matched against the host name.
+	IncludeNames []string `yaml:"include_names"`
+	ExcludeNames []string `yaml:"exclude_names"`
+	// ZoneSettings override settings per zone.
+	ZoneSettings map[string]zoneSettings `yaml:"zones"`
+	// TagSubdomains also publish the hosts carrying a tag below a
+	// subdomain of the suffix.
+	TagSubdomains []tagSubdomain `yaml:"tag_subdomains"`
+	// Wildcard also publishes *.name for every host, WildcardTags only for
+	// the hosts carrying one of them.
+	Wildcard     bool     `yaml:"wildcard"`
+	WildcardTags []string `yaml:"wildcard_tags"`
+	// AllAddresses publishes a record for every address of a host instead
+	// of the first per family.
+	AllAddresses bool `yaml:"all_addresses"`
+	// OnlineOnly leaves out the hosts offline for OfflineGrace or longer.
+	OnlineOnly   bool          `yaml:"online_only"`
+	OfflineGrace time.Duration `yaml:"offline_grace"`
+	// Env sets environment variables that aren't set already, e.g. the
+	// credentials of a profile.
+	Env map[string]string `yaml:"env"`
+	// Pipelines are profiles run side by side, each in its own process,
+	// when the daemon is started without -profile.
+	Pipelines []string `yaml:"pipelines"`
+	// Profiles are applied over the rest of the file when selected with
+	// -profile or CONFIG_PROFILE.
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+
+	nameTmpl     *template.Template
+	includeNames []*regexp.Regexp
+	excludeNames []*regexp.Regexp
+}
+
+// ttlTier assigns TTL to hosts whose address and online state haven't
+// changed for at least StableFor.
+type ttlTier struct {
+	StableFor time.Duration `yaml:"stable_for"`
+	TTL       int           `yaml:"ttl"`
+}
+
+// liveConfig holds the running config. A reload swaps in a new one whole,
+// so handlers and watchers running next to the sync loop never see it half
+// applied; nothing writes to a config once it's published.
+var liveConfig atomic.Pointer[config]
+
+func init() {
+	liveConfig.Store(new(config))
+}
+
+// cfg returns the running config.
+func cfg() *config {
+	return liveConfig.Load()
+}
+
+func defaultConfig() config {
+	return config{
+		Source:                  SourceTailscaled,
+		Tailnet:                 "-",
+		TSNetHostname:           "tailscale-dns-sync",
+		Provider:                "cloudflare",
+		DomainSuffix:            "int",
+		SyncInterval:            30 * time.Second,
+		TTL:                     1,
+		StatsdPrefix:            "tailscale_dns_sync.",
+		PushgatewayJob:          "tailscale-dns-sync",
+		Mode:                    SyncModeSync,
+		Policy:                  PolicySync,
+		NameCollisions:          CollisionsSkip,
+		NameMode:                NameModeShort,
+		RecordType:              "A",
+		AddressFamily:           AddressFamilyIPv4,
+		IPv6OnlyPeers:           IPv6OnlyAAAA,
+		AddressRules:            defaultAddressRules,
+		Registry:                RegistryComment,
+		RegistryOwnerID:         "default",
+		OwnershipMarker:         CloudflareSyncDNSComment,
+		SnapshotKeep:            30,
+		LeaseName:               "tailscale-dns-sync",
+		LeaseDuration:           15 * time.Second,
+		BatchRetries:            2,
+		RetryMax:                3,
+		RetryBaseDelay:          time.Second,
+		RetryMaxDelay:           30 * time.Second,
+		Concurrency:             1,
+		CheckpointSize:          500,
+		QuarantineTTL:           60,
+		SecondaryInterval:       15 * time.Minute,
+		ReverseMapRetention:     7 * 24 * time.Hour,
+		ZoneTimeout:             2 * time.Minute,
+		SyncTimeout:             5 * time.Minute,
+		StatusMaxStale:          5 * time.Minute,
+		WatchFallbackInterval:   5 * time.Minute,
+		WatchDebounce:           2 * time.Second,
+		WakeThreshold:           time.Minute,
+		MaxPanics:               5,
+		OTLPServiceName:         "tailscale-dns-sync",
+		LogFormat:               LogFormatPlain,
+		LogLevel:                "info",
+		VerifyBeforeWrite:       true,
+		VerifyTimeout:           2 * time.Minute,
+		DeviceOverrides:         true,
+		AllowedRanges:           defaultAllowedRanges,
+		RecordMetricsLimit:      500,
+		CapacityCheckInterval:   time.Hour,
+		CapacityWarnRatio:       0.9,
+		CredentialCheckInterval: 12 * time.Hour,
+		CredentialWarnBefore:    14 * 24 * time.Hour,
+	}
+}
+
+// loadConfig reads the config file at path, if any, applies environment
+// overrides and validates the result.
+func loadConfig(path, profile string) {
+	c, err := readConfig(path, profile)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	liveConfig.Store(&c)
+	setupLogging(c.LogFormat, c.LogLevel)
+	if err := setupEgress(&c); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if cfg().TSNet && embeddedClient == nil {
+		client, err := startEmbeddedNode(ctx)
+		if err != nil {
+			log.Fatalf("tsnet: %v", err)
+		}
+		embeddedClient = client
+	}
+	liveClient.Store(localClient())
+}
+
+// fileEnv are the variables set from the env of the config file, which a
+// reload may change unlike those of the real environment.
+var fileEnv = map[string]bool{}
+
+// readConfig reads the config file at path with profile applied, overridden
+// by the environment, and validates it. The env of the file is only applied
+// to the process once the config is valid, so a bad reload leaves it alone.
+func readConfig(path, profile string) (config, error) {
+	c := defaultConfig()
+	if path != "" {
+		if err := loadConfigFile(path, profile, &c); err != nil {
+			return c, err
+		}
+	} else if profile != "" {
+		return c, fmt.Errorf("profile %s needs a config file", profile)
+	}
+	e := &envReader{file: c.Env}
+	c.Source = e.string("SOURCE", c.Source)
+	c.FileSource = e.string("FILE_SOURCE", c.FileSource)
+	c.StatusJSON = e.string("STATUS_JSON", c.StatusJSON)
+	c.Tailnet = e.string("TAILSCALE_TAILNET", c.Tailnet)
+	c.TailscaledSocket = e.string("TAILSCALED_SOCKET", c.TailscaledSocket)
+	c.TailscaledSocketOnly = e.bool("TAILSCALED_SOCKET_ONLY", c.TailscaledSocketOnly)
+	c.HeadscaleURL = e.string("HEADSCALE_URL", c.HeadscaleURL)
+	c.HeadscaleBaseDomain = e.string("HEADSCALE_BASE_DOMAIN", c.HeadscaleBaseDomain)
+	c.TSNet = e.bool("TSNET", c.TSNet)
+	c.TSNetHostname = e.string("TSNET_HOSTNAME", c.TSNetHostname)
+	c.TSNetStateDir = e.string("TSNET_STATE_DIR", c.TSNetStateDir)
+	c.Provider = e.string("PROVIDER", c.Provider)
+	c.PluginPath = e.string("PROVIDER_PLUGIN", c.PluginPath)
+	c.Domains = e.list("DOMAIN", e.string("CLOUDFLARE_DOMAIN", strings.Join(c.Domains, ",")))
+	c.DomainSuffix = e.string("DOMAIN_SUFFIX", c.DomainSuffix)
+	c.NameTemplate = e.string("NAME_TEMPLATE", c.NameTemplate)
+	c.NameCollisions = e.string("NAME_COLLISIONS", c.NameCollisions)
+	c.NameMode = e.string("NAME_MODE", c.NameMode)
+	c.MagicDNSSuffix = strings.ToLower(strings.Trim(e.string("MAGICDNS_SUFFIX", c.MagicDNSSuffix), "."))
+	c.TTL = e.int("TTL", c.TTL)
+	c.Proxied = e.bool("PROXIED", c.Proxied)
+	c.SyncInterval = e.duration("SYNC_INTERVAL", c.SyncInterval)
+	c.SyncJitter = e.duration("SYNC_JITTER", c.SyncJitter)
+	c.WatchFallbackInterval = e.duration("WATCH_FALLBACK_INTERVAL", c.WatchFallbackInterval)
+	c.WatchDebounce = e.duration("WATCH_DEBOUNCE", c.WatchDebounce)
+	c.Suffixes = e.list("SUFFIXES", strings.Join(c.Suffixes, ","))
+	c.ReverseZones = e.list("REVERSE_ZONES", strings.Join(c.ReverseZones, ","))
+	c.Mode = e.string("SYNC_MODE", c.Mode)
+	c.Policy = e.string("POLICY", c.Policy)
+	c.RecordType = strings.ToUpper(e.string("RECORD_TYPE", c.RecordType))
+	c.AddressFamily = strings.ToLower(e.string("ADDRESS_FAMILY", c.AddressFamily))
+	c.IPv6OnlyPeers = strings.ToLower(e.string("IPV6_ONLY_PEERS", c.IPv6OnlyPeers))
+	c.MetricsAddr = e.string("METRICS_ADDR", c.MetricsAddr)
+	c.Listen.Addr = e.string("LISTEN_ADDR", c.Listen.Addr)
+	if c.Listen.Addr == "" {
+		c.Listen.Addr = c.MetricsAddr
+	}
+	c.Listen.Tailscale = e.bool("LISTEN_TAILSCALE", c.Listen.Tailscale)
+	if v := e.secret("LISTEN_TOKEN"); v != "" {
+		c.Listen.Token = v
+	}
+	c.Listen.Allow = e.list("LISTEN_ALLOW", strings.Join(c.Listen.Allow, ","))
+	c.Listen.TLSCert = e.string("LISTEN_TLS_CERT", c.Listen.TLSCert)
+	c.Listen.TLSKey = e.string("LISTEN_TLS_KEY", c.Listen.TLSKey)
+	c.RegisterCapability = e.string("REGISTER_CAPABILITY", c.RegisterCapability)
+	c.StatsdAddr = e.string("STATSD_ADDR", c.StatsdAddr)
+	c.StatsdPrefix = e.string("STATSD_PREFIX", c.StatsdPrefix)
+	c.StatsdDogStatsD = e.bool("STATSD_DOGSTATSD", c.StatsdDogStatsD)
+	c.StatsdTags = e.list("STATSD_TAGS", strings.Join(c.StatsdTags, ","))
+	c.PushgatewayURL = e.string("PUSHGATEWAY_URL", c.PushgatewayURL)
+	c.PushgatewayJob = e.string("PUSHGATEWAY_JOB", c.PushgatewayJob)
+	c.NotifyWebhookURL = e.string("NOTIFY_WEBHOOK_URL", c.NotifyWebhookURL)
+	c.NotifyBatchWindow = e.duration("NOTIFY_BATCH_WINDOW", c.NotifyBatchWindow)
+	if u := e.get("CHANGE_WEBHOOK_URL"); u != "" {
+		s := notifySink{URL: u, Events: []string{"records_changed"}, PerChange: true}
+		if t := e.get("CHANGE_WEBHOOK_TEMPLATE"); t != "" {
+			s.Format, s.Template = "template", t
+		}
+		c.NotifySinks = append(c.NotifySinks, s)
+	}
+	c.ReportPath = e.string("REPORT_PATH", c.ReportPath)
+	c.SnapshotPath = e.string("SNAPSHOT_PATH", c.SnapshotPath)
+	c.SnapshotKeep = e.int("SNAPSHOT_KEEP", c.SnapshotKeep)
+	c.StatePath = e.string("STATE_PATH", c.StatePath)
+	c.StatusPath = e.string("STATUS_PATH", c.StatusPath)
+	c.ReverseMapPath = e.string("REVERSE_MAP_PATH", c.ReverseMapPath)
+	c.ReverseMapRetention = e.duration("REVERSE_MAP_RETENTION", c.ReverseMapRetention)
+	c.AuditPath = e.string("AUDIT_PATH", c.AuditPath)
+	c.Coordination = e.string("COORDINATION", c.Coordination)
+	c.RedisURL = e.string("REDIS_URL", c.RedisURL)
+	c.Registry = e.string("REGISTRY", c.Registry)
+	c.RegistryOwnerID = e.string("REGISTRY_OWNER_ID", c.RegistryOwnerID)
+	c.OwnershipMarker = e.string("OWNERSHIP_MARKER", c.OwnershipMarker)
+	c.LegacyMarkers = e.list("LEGACY_MARKERS", strings.Join(c.LegacyMarkers, ","))
+	c.AdoptUnmarked = e.bool("ADOPT_UNMARKED", c.AdoptUnmarked)
+	c.RegistryPrefix = e.string("REGISTRY_PREFIX", c.RegistryPrefix)
+	c.LeaseName = e.string("LEASE_NAME", c.LeaseName)
+	c.LeaseNamespace = e.string("LEASE_NAMESPACE", c.LeaseNamespace)
+	c.LeaseDuration = e.duration("LEASE_DURATION", c.LeaseDuration)
+	c.MaxDeletes = e.int("MAX_DELETES_PER_CYCLE", c.MaxDeletes)
+	c.BatchSize = e.int("BATCH_SIZE", c.BatchSize)
+	c.BatchRetries = e.int("BATCH_RETRIES", c.BatchRetries)
+	c.RetryMax = e.int("RETRY_MAX", c.RetryMax)
+	c.RetryBaseDelay = e.duration("RETRY_BASE_DELAY", c.RetryBaseDelay)
+	c.RetryMaxDelay = e.duration("RETRY_MAX_DELAY", c.RetryMaxDelay)
+	c.ProxyURL = e.string("PROXY_URL", c.ProxyURL)
+	c.CABundle = e.string("CA_BUNDLE", c.CABundle)
+	c.Concurrency = e.int("CONCURRENCY", c.Concurrency)
+	c.RequestsPerSecond = e.int("REQUESTS_PER_SECOND", c.RequestsPerSecond)
+	c.APIBudget = e.int("API_BUDGET_PER_CYCLE", c.APIBudget)
+	c.CheckpointSize = e.int("CHECKPOINT_SIZE", c.CheckpointSize)
+	c.Quarantine = e.duration("QUARANTINE", c.Quarantine)
+	c.QuarantineTTL = e.int("QUARANTINE_TTL", c.QuarantineTTL)
+	c.FullAuditInterval = e.duration("FULL_AUDIT_INTERVAL", c.FullAuditInterval)
+	c.SkipUnchanged = e.duration("SKIP_UNCHANGED", c.SkipUnchanged)
+	c.SecondaryProvider = e.string("SECONDARY_PROVIDER", c.SecondaryProvider)
+	c.SecondaryInterval = e.duration("SECONDARY_INTERVAL", c.SecondaryInterval)
+	c.ZoneTimeout = e.duration("ZONE_TIMEOUT", c.ZoneTimeout)
+	c.SyncTimeout = e.duration("SYNC_TIMEOUT", c.SyncTimeout)
+	c.StatusMaxStale = e.duration("STATUS_MAX_STALE", c.StatusMaxStale)
+	c.WakeThreshold = e.duration("WAKE_THRESHOLD", c.WakeThreshold)
+	c.MaxPanics = e.int("MAX_CONSECUTIVE_PANICS", c.MaxPanics)
+	c.SentryDSN = e.string("SENTRY_DSN", c.SentryDSN)
+	if v := e.get("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+		c.OTLPEndpoint = strings.TrimSuffix(v, "/") + "/v1/traces"
+	}
+	c.OTLPEndpoint = e.string("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", c.OTLPEndpoint)
+	c.OTLPServiceName = e.string("OTEL_SERVICE_NAME", c.OTLPServiceName)
+	c.LogFormat = e.string("LOG_FORMAT", c.LogFormat)
+	c.LogLevel = e.string("LOG_LEVEL", c.LogLevel)
+	c.HealthMaxAge = e.duration("HEALTH_MAX_AGE", c.HealthMaxAge)
+	c.CreateZones = e.bool("CREATE_ZONES", c.CreateZones)
+	c.VerifyBeforeWrite = e.bool("VERIFY_BEFORE_WRITE", c.VerifyBeforeWrite)
+	c.VerifyResolvers = e.list("VERIFY_RESOLVERS", strings.Join(c.VerifyResolvers, ","))
+	c.VerifyTimeout = e.duration("VERIFY_TIMEOUT", c.VerifyTimeout)
+	c.CapacityCheckInterval = e.duration("CAPACITY_CHECK_INTERVAL", c.CapacityCheckInterval)
+	c.CredentialCheckInterval = e.duration("CREDENTIAL_CHECK_INTERVAL", c.CredentialCheckInterval)
+	c.CredentialWarnBefore = e.duration("CREDENTIAL_WARN_BEFORE", c.CredentialWarnBefore)
+	if v := e.get("CAPACITY_WARN_RATIO"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid CAPACITY_WARN_RATIO %q: %v", v, err)
+		}
+		c.CapacityWarnRatio = r
+	}
+	c.RecordMetricsLimit = e.int("RECORD_METRICS_LIMIT", c.RecordMetricsLimit)
+	c.AllowedRanges = e.list("ALLOWED_RANGES", strings.Join(c.AllowedRanges, ","))
+	c.IncludeTags = e.list("INCLUDE_TAGS", strings.Join(c.IncludeTags, ","))
+	c.Wildcard = e.bool("WILDCARD", c.Wildcard)
+	c.WildcardTags = e.list("WILDCARD_TAGS", strings.Join(c.WildcardTags, ","))
+	c.ExcludeTags = e.list("EXCLUDE_TAGS", strings.Join(c.ExcludeTags, ","))
+	c.IncludeNames = e.list("INCLUDE_NAMES", strings.Join(c.IncludeNames, ","))
+	c.ExcludeNames = e.list("EXCLUDE_NAMES", strings.Join(c.ExcludeNames, ","))
+	c.AllAddresses = e.bool("ALL_ADDRESSES", c.AllAddresses)
+	if v := e.get("TAG_SUBDOMAINS"); v != "" {
+		subdomains, err := parseTagSubdomains(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid TAG_SUBDOMAINS: %v", err)
+		}
+		c.TagSubdomains = subdomains
+	}
+	c.OnlineOnly = e.bool("ONLINE_ONLY", c.OnlineOnly)
+	c.OfflineGrace = e.duration("OFFLINE_GRACE", c.OfflineGrace)
+	if v := e.get("ADDRESS_RULES"); v != "" {
+		c.AddressRules = parseAddressRules(v)
+	}
+	if v := e.get("VIA6_HOSTS"); v != "" {
+		hosts, err := parseVia6Hosts(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid VIA6_HOSTS: %v", err)
+		}
+		c.Via6Hosts = hosts
+	}
+	if v := e.get("SUBNET_HOSTS"); v != "" {
+		hosts, err := parseSubnetHosts(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid SUBNET_HOSTS: %v", err)
+		}
+		c.SubnetHosts = hosts
+	}
+	c.DHCPLeases = e.string("DHCP_LEASES", c.DHCPLeases)
+	if v := e.get("RECORDS"); v != "" {
+		records, err := parseRecords(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid RECORDS: %v", err)
+		}
+		c.Records = records
+	}
+	if v := e.get("SERVICES"); v != "" {
+		services, err := parseServices(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid SERVICES: %v", err)
+		}
+		c.Services = services
+	}
+	c.ServeSRV = e.bool("SERVE_SRV", c.ServeSRV)
+	c.DeviceOverrides = e.bool("DEVICE_OVERRIDES", c.DeviceOverrides)
+	c.MetadataTXT = e.bool("METADATA_TXT", c.MetadataTXT)
+	c.ExitNodeName = e.string("EXIT_NODE_NAME", c.ExitNodeName)
+	if v := e.get("FUNNEL_NAMES"); v != "" {
+		names, err := parseFunnelNames(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid FUNNEL_NAMES: %v", err)
+		}
+		c.FunnelNames = names
+	}
+	if v := e.get("DYNAMIC_TTL"); v != "" {
+		tiers, err := parseTTLTiers(v)
+		if err != nil {
+			return c, err
+		}
+		c.DynamicTTL = tiers
+	}
+	sort.Slice(c.DynamicTTL, func(i, j int) bool { return c.DynamicTTL[i].StableFor < c.DynamicTTL[j].StableFor })
+	if len(e.errs) > 0 {
+		return c, errors.Join(e.errs...)
+	}
+	if errs := c.validate(); len(errs) > 0 {
+		for i, err := range errs {
+			errs[i] = fmt.Errorf("config: %w", err)
+		}
+		return c, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	for k, v := range c.Env {
+		// the real environment wins, like for every other setting
+		if _, ok := os.LookupEnv(k); !ok || fileEnv[k] {
+			os.Setenv(k, v)
+			fileEnv[k] = true
+		}
+	}
+	if c.Coordination == "redis" && c.StatePath == "" {
+		// share the state cache between replicas by default
+		c.StatePath = c.RedisURL
+	}
+	return c, nil
+}
+
+// validate reports every invalid value and conflicting combination at once.
+func (c *config) validate() []error {
+	var errs []error
+	oneOf := func(key, v string, allowed ...string) {
+		for _, a := range allowed {
+			if v == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %q is not one of %s", key, v, strings.Join(allowed, ", ")))
+	}
+	oneOf("source", c.Source, SourceTailscaled, SourceAPI, SourceHeadscale, SourceFile, SourceStatus)
+	if c.Source == SourceStatus && c.StatusJSON == "" {
+		errs = append(errs, fmt.Errorf("source: status requires status_json"))
+	}
+	if c.Source == SourceHeadscale && c.HeadscaleURL == "" {
+		errs = append(errs, fmt.Errorf("source: headscale requires headscale_url"))
+	}
+	if c.Source == SourceHeadscale && c.RecordType == "CNAME" && c.HeadscaleBaseDomain == "" {
+		errs = append(errs, fmt.Errorf("record_type: CNAME with source: headscale requires headscale_base_domain"))
+	}
+	if c.Source == SourceFile && c.FileSource == "" && len(c.Records) == 0 {
+		errs = append(errs, fmt.Errorf("source: file requires file_source or records"))
+	}
+	oneOf("record_type", c.RecordType, "A", "CNAME")
+	oneOf("address_family", c.AddressFamily, AddressFamilyIPv4, AddressFamilyIPv6, AddressFamilyDual)
+	oneOf("ipv6_only_peers", c.IPv6OnlyPeers, IPv6OnlyAAAA, IPv6OnlyExclude)
+	if c.RecordType == "CNAME" && c.AddressFamily != AddressFamilyIPv4 {
+		errs = append(errs, fmt.Errorf("address_family: %s only applies to record_type A", c.AddressFamily))
+	}
+	oneOf("sync_mode", c.Mode, SyncModeSync, SyncModeMonitor)
+	oneOf("policy", c.Policy, PolicySync, PolicyUpsertOnly, PolicyCreateOnly)
+	oneOf("name_collisions", c.NameCollisions, CollisionsSkip, CollisionsUser, CollisionsNumber)
+	oneOf("name_mode", c.NameMode, NameModeShort, NameModeFQDN)
+	oneOf("registry", c.Registry, RegistryComment, RegistryTXT)
+	if c.Registry == RegistryTXT {
+		if !slices.Contains(registryProviders, c.Provider) {
+			errs = append(errs, fmt.Errorf("registry: txt needs provider %s", strings.Join(registryProviders, ", ")))
+		}
+		if c.RegistryOwnerID == "" || strings.ContainsAny(c.RegistryOwnerID, ",= \"") {
+			errs = append(errs, fmt.Errorf("registry_owner_id: %q must be set and hold no commas, equal signs, quotes or spaces", c.RegistryOwnerID))
+		}
+	}
+	if c.OwnershipMarker == "" || strings.ContainsAny(c.OwnershipMarker, " \t") {
+		errs = append(errs, fmt.Errorf("ownership_marker: %q must be set and hold no spaces", c.OwnershipMarker))
+	}
+	for _, m := range c.LegacyMarkers {
+		if m == "" || strings.ContainsAny(m, " \t") || m == c.OwnershipMarker {
+			errs = append(errs, fmt.Errorf("legacy_markers: %q must hold no spaces and differ from ownership_marker", m))
+		}
+	}
+	if c.MetadataTXT {
+		if !slices.Contains(metadataProviders, c.Provider) {
+			errs = append(errs, fmt.Errorf("metadata_txt: needs provider %s", strings.Join(metadataProviders, ", ")))
+		}
+		if c.Registry == RegistryTXT {
+			errs = append(errs, fmt.Errorf("metadata_txt: doesn't work with registry txt"))
+		}
+	}
+	if c.ExitNodeName != "" {
+		if !labelRe.MatchString(c.ExitNodeName) {
+			errs = append(errs, fmt.Errorf("exit_node_name: %q must be a DNS label, e.g. exit", c.ExitNodeName))
+		}
+		if c.RecordType != "A" {
+			errs = append(errs, fmt.Errorf("exit_node_name: needs record_type A"))
+		}
+	}
+	if c.AdoptUnmarked && c.Provider != "cloudflare" {
+		errs = append(errs, fmt.Errorf("adopt_unmarked: needs provider cloudflare"))
+	}
+	oneOf("log_format", c.LogFormat, LogFormatPlain, LogFormatText, LogFormatJSON)
+	oneOf("log_level", strings.ToLower(c.LogLevel), "debug", "info", "warn", "error")
+	if strings.HasPrefix(c.DomainSuffix, ".") || strings.HasSuffix(c.DomainSuffix, ".") {
+		errs = append(errs, fmt.Errorf("domain_suffix: %q must not start or end with a dot", c.DomainSuffix))
+	}
+	if c.NameTemplate != "" {
+		tmpl, err := template.New("name_template").Parse(c.NameTemplate)
+		if err == nil {
+			// unknown fields only show when executed
+			err = tmpl.Execute(io.Discard, nameData{})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("name_template: %w", err))
+		}
+		c.nameTmpl = tmpl
+	}
+	if c.TTL != 1 && (c.TTL < 60 || c.TTL > 86400) {
+		errs = append(errs, fmt.Errorf("ttl: %d must be 1 (automatic) or between 60 and 86400", c.TTL))
+	}
+	if c.Proxied && c.Provider != "cloudflare" {
+		errs = append(errs, fmt.Errorf("proxied: only supported by the cloudflare provider"))
+	}
+	if c.Proxied && (c.TTL != 1 || len(c.DynamicTTL) > 0) {
+		errs = append(errs, fmt.Errorf("ttl: proxied records always have the automatic TTL"))
+	}
+	if (c.TailscaledSocket != "" || c.TailscaledSocketOnly) && c.Source != SourceTailscaled {
+		errs = append(errs, fmt.Errorf("tailscaled_socket is only used with source: tailscaled"))
+	}
+	if c.TSNet {
+		switch {
+		case startEmbeddedNode == nil:
+			errs = append(errs, fmt.Errorf("tsnet: not compiled in, build with -tags tsnet"))
+		case c.Source != SourceTailscaled:
+			errs = append(errs, fmt.Errorf("tsnet: only used with source: tailscaled"))
+		case c.TailscaledSocket != "":
+			errs = append(errs, fmt.Errorf("tsnet: the embedded node replaces tailscaled_socket"))
+		case c.Listen.Tailscale:
+			// the node's addresses only exist inside its userspace network
+			errs = append(errs, fmt.Errorf("tsnet: listen: tailscale is not supported"))
+		}
+	}
+	if c.SyncInterval < time.Second {
+		errs = append(errs, fmt.Errorf("sync_interval: must be at least 1s"))
+	}
+	if c.SyncJitter < 0 {
+		errs = append(errs, fmt.Errorf("sync_jitter must not be negative"))
+	}
+	if c.OfflineGrace < 0 {
+		errs = append(errs, fmt.Errorf("offline_grace must not be negative"))
+	}
+	if c.OfflineGrace > 0 && !c.OnlineOnly {
+		errs = append(errs, fmt.Errorf("offline_grace is only used with online_only"))
+	}
+	if c.RetryBaseDelay <= 0 || c.RetryMaxDelay < c.RetryBaseDelay {
+		errs = append(errs, fmt.Errorf("retry_base_delay must be positive and retry_max_delay at least as long"))
+	}
+	if c.ProxyURL != "" {
+		if u, err := url.Parse(c.ProxyURL); err != nil || u.Host == "" {
+			errs = append(errs, fmt.Errorf("proxy_url %q is not a URL like http://proxy:3128", c.ProxyURL))
+		}
+	}
+	if c.CABundle != "" {
+		if _, err := readCABundle(c.CABundle); err != nil {
+			errs = append(errs, fmt.Errorf("ca_bundle: %w", err))
+		}
+	}
+	if c.PushgatewayURL != "" && c.PushgatewayJob == "" {
+		errs = append(errs, fmt.Errorf("pushgateway_job must not be empty"))
+	}
+	if len(c.VerifyResolvers) > 0 && c.VerifyTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("verify_timeout must be positive"))
+	}
+	if c.SyncTimeout < c.ZoneTimeout {
+		errs = append(errs, fmt.Errorf("sync_timeout must be at least as long as zone_timeout"))
+	}
+	if c.HealthMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("health_max_age must not be negative"))
+	}
+	if c.WatchDebounce < 0 {
+		errs = append(errs, fmt.Errorf("watch_debounce must not be negative"))
+	}
+	oneOf("coordination", c.Coordination, "", "redis", "kubernetes")
+	if c.PluginPath != "" && c.Provider != "plugin" {
+		errs = append(errs, fmt.Errorf("provider_plugin is only used with provider: plugin"))
+	}
+	if c.Provider == "plugin" && c.PluginPath == "" {
+		errs = append(errs, fmt.Errorf("provider: plugin requires provider_plugin"))
+	}
+	if c.CreateZones && zoneCreators[c.Provider] == nil {
+		errs = append(errs, fmt.Errorf("create_zones: provider %s can't create zones", c.Provider))
+	}
+	if c.Coordination == "redis" && c.RedisURL == "" {
+		errs = append(errs, fmt.Errorf("coordination: redis requires redis_url"))
+	}
+	if c.LeaseNamespace != "" && c.Coordination != "kubernetes" {
+		errs = append(errs, fmt.Errorf("lease_namespace is only used with coordination: kubernetes"))
+	}
+	if c.Coordination != "" && c.LeaseDuration < 3*time.Second {
+		errs = append(errs, fmt.Errorf("lease_duration: must be at least 3s"))
+	}
+	for key, n := range map[string]int{"max_deletes_per_cycle": c.MaxDeletes, "batch_size": c.BatchSize, "batch_retries": c.BatchRetries, "retry_max": c.RetryMax, "max_consecutive_panics": c.MaxPanics, "checkpoint_size": c.CheckpointSize, "quarantine_ttl": c.QuarantineTTL, "api_budget_per_cycle": c.APIBudget, "requests_per_second": c.RequestsPerSecond} {
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative", key))
+		}
+	}
+	if c.SnapshotKeep < 1 {
+		errs = append(errs, fmt.Errorf("snapshot_keep must be at least 1"))
+	}
+	if c.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("concurrency: must be at least 1"))
+	}
+	if c.CapacityWarnRatio <= 0 || c.CapacityWarnRatio > 1 {
+		errs = append(errs, fmt.Errorf("capacity_warn_ratio: must be in (0, 1]"))
+	}
+	errs = append(errs, validateRanges("allowed_ranges", c.AllowedRanges)...)
+	for zone, ranges := range c.ZoneAllowedRanges {
+		errs = append(errs, validateRanges("zone_allowed_ranges: "+zone, ranges)...)
+	}
+	for zone, s := range c.ZoneSettings {
+		errs = append(errs, s.validate(c, zone)...)
+	}
+	if c.FullAuditInterval < 0 {
+		errs = append(errs, fmt.Errorf("full_audit_interval must not be negative"))
+	}
+	if c.SkipUnchanged < 0 {
+		errs = append(errs, fmt.Errorf("skip_unchanged must not be negative"))
+	}
+	if c.SecondaryProvider != "" && c.SecondaryProvider == c.Provider {
+		errs = append(errs, fmt.Errorf("secondary_provider: must differ from provider, both read the same credentials"))
+	}
+	if c.SecondaryInterval < 0 {
+		errs = append(errs, fmt.Errorf("secondary_interval must not be negative"))
+	}
+	if c.Quarantine < 0 {
+		errs = append(errs, fmt.Errorf("quarantine must not be negative"))
+	}
+	if c.NotifyBatchWindow < 0 {
+		errs = append(errs, fmt.Errorf("notify_batch_window must not be negative"))
+	}
+	if err := c.Listen.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.RegisterCapability != "" && (c.Source != SourceTailscaled || !c.Listen.Tailscale) {
+		errs = append(errs, fmt.Errorf("register_capability: needs the tailscaled source and listen: tailscale"))
+	}
+	for i := range c.FunnelNames {
+		if err := c.FunnelNames[i].validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(c.FunnelNames) > 0 && !slices.Contains(funnelProviders, c.Provider) {
+		errs = append(errs, fmt.Errorf("funnel_names: needs provider %s", strings.Join(funnelProviders, ", ")))
+	}
+	if c.ServeSRV && c.Source != SourceTailscaled {
+		errs = append(errs, fmt.Errorf("serve_srv: needs the tailscaled source"))
+	}
+	if c.Listen.Tailscale && c.Source != SourceTailscaled {
+		errs = append(errs, fmt.Errorf("listen: tailscale needs the tailscaled source"))
+	}
+	for i := range c.NotifySinks {
+		if err := c.NotifySinks[i].validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := range c.TagPolicies {
+		if err := c.TagPolicies[i].validate(c.Provider); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	hostPolicies := map[string]bool{}
+	for _, p := range c.HostPolicies {
+		if err := p.validate(c.Provider); err != nil {
+			errs = append(errs, err)
+		}
+		if hostPolicies[p.Name] {
+			errs = append(errs, fmt.Errorf("host_policies: %s is listed twice", p.Name))
+		}
+		hostPolicies[p.Name] = true
+	}
+	for _, s := range c.TagSubdomains {
+		if err := s.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if (c.Wildcard || len(c.WildcardTags) > 0) && (c.Provider == "file" || c.Provider == "pihole" || c.Provider == "nextdns") {
+		errs = append(errs, fmt.Errorf("wildcard: %s entries can't be wildcards", c.Provider))
+	}
+	for _, l := range []struct {
+		key  string
+		tags []string
+	}{{"include_tags", c.IncludeTags}, {"exclude_tags", c.ExcludeTags}, {"wildcard_tags", c.WildcardTags}} {
+		for _, t := range l.tags {
+			if !strings.HasPrefix(t, "tag:") {
+				errs = append(errs, fmt.Errorf("%s: tag %q must start with tag:", l.key, t))
+			}
+		}
+	}
+	for _, l := range []struct {
+		key      string
+		patterns []string
+		compiled *[]*regexp.Regexp
+	}{{"include_names", c.IncludeNames, &c.includeNames}, {"exclude_names", c.ExcludeNames, &c.excludeNames}} {
+		*l.compiled = nil
+		for _, p := range l.patterns {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", l.key, err))
+				continue
+			}
+			*l.compiled = append(*l.compiled, re)
+		}
+	}
+	for _, r := range c.AddressRules {
+		if err := r.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, h := range c.Via6Hosts {
+		if err := h.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, name := range c.ReverseZones {
+		if err := validateReverseZone(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(c.ReverseZones) > 0 && !slices.Contains(ptrProviders, c.Provider) {
+		errs = append(errs, fmt.Errorf("reverse_zones: needs provider %s", strings.Join(ptrProviders, ", ")))
+	}
+	for _, h := range c.SubnetHosts {
+		if err := h.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for i := range c.Records {
+		if err := c.Records[i].normalize(); err != nil {
+			errs = append(errs, fmt.Errorf("records: %w", err))
+		}
+	}
+	for _, s := range c.Services {
+		if err := s.validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, t := range c.DynamicTTL {
+		if t.TTL < 60 || t.TTL > 86400 {
+			errs = append(errs, fmt.Errorf("dynamic_ttl: ttl %d for stable_for %s must be between 60 and 86400", t.TTL, t.StableFor))
+		}
+	}
+	return errs
+}
+
+// parseTTLTiers parses "0s=60,24h=300,336h=3600".
+func parseTTLTiers(v string) ([]ttlTier, error) {
+	var tiers []ttlTier
+	for _, part := range strings.Split(v, ",") {
+		d, ttl, ok := strings.Cut(strings.TrimSpace(part), "=")
+		stable, err := time.ParseDuration(d)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid DYNAMIC_TTL tier %q, want duration=ttl", part)
+		}
+		n, err := strconv.Atoi(ttl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DYNAMIC_TTL tier %q: %v", part, err)
+		}
+		tiers = append(tiers, ttlTier{StableFor: stable, TTL: n})
+	}
+	return tiers, nil
+}
+
+// envReader reads the settings of readConfig from the environment, with the
+// env of the config file not applied yet, and collects the invalid values.
+type envReader struct {
+	file map[string]string
+	errs []error
+}
+
+// fromFile returns the value the env of the file sets key to, unless the
+// real environment sets it: that wins, like for every other setting.
+func (e *envReader) fromFile(key string) (string, bool) {
+	v, ok := e.file[key]
+	if _, set := os.LookupEnv(key); !ok || set && !fileEnv[key] {
+		return "", false
+	}
+	return v, true
+}
+
+// get returns the variable key as it is once the env of the file is
+// applied.
+func (e *envReader) get(key string) string {
+	if v, ok := e.fromFile(key); ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+// secret is secretEnv with the env of the file applied.
+func (e *envReader) secret(key string) string {
+	if v, ok := e.fromFile(key); ok {
+		return v
+	}
+	return secretEnv(key)
+}
+
+func (e *envReader) string(key, def string) string {
+	if v := strings.TrimSpace(e.get(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func (e *envReader) duration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(e.get(key))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: %v", key, v, err))
+		return def
+	}
+	return d
+}
+
+func (e *envReader) int(key string, def int) int {
+	v := strings.TrimSpace(e.get(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: %v", key, v, err))
+		return def
+	}
+	return n
+}
+
+func (e *envReader) bool(key string, def bool) bool {
+	v := strings.TrimSpace(e.get(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s %q: %v", key, v, err))
+		return def
+	}
+	return b
+}
+
+func (e *envReader) list(key, def string) []string {
+	var out []string
+	for _, v := range strings.Split(e.string(key, def), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func envString(key, def string) string {
+	return (&envReader{}).string(key, def)
+}
+
+// envList splits a comma-separated variable, falling back to def.
+func envList(key, def string) []string {
+	return (&envReader{}).list(key, def)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfigFile decodes the YAML file at path into c, then the profile
+// named profile on top of it. Unknown keys, type mismatches and duplicate
+// keys are rejected with file:line context.
+func loadConfigFile(path, profile string, c *config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		// empty file
+		return nil
+	}
+	root := doc.Content[0]
+	errs := checkKeys(path, root, reflect.TypeOf(*c), "")
+	if err := decodeInto(path, root, c); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		node := c.Profiles[name]
+		errs = append(errs, checkKeys(path, &node, reflect.TypeOf(*c), "profiles."+name+".")...)
+	}
+	if profile != "" {
+		node, ok := c.Profiles[profile]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no profile %q (have: %s)", path, profile, strings.Join(names, ", ")))
+		} else if err := decodeInto(path, &node, c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeInto decodes node over c, keeping the fields it doesn't set.
+func decodeInto(path string, node *yaml.Node, c *config) error {
+	err := node.Decode(c)
+	var te *yaml.TypeError
+	if err == nil || !errors.As(err, &te) {
+		if err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+		}
+		return err
+	}
+	var errs []error
+	for _, msg := range te.Errors {
+		// yaml reports "line N: ...", match the path:line form used above
+		var line int
+		if n, _ := fmt.Sscanf(msg, "line %d:", &line); n == 1 {
+			msg = fmt.Sprintf("%d:%s", line, strings.SplitN(msg, ":", 2)[1])
+		}
+		errs = append(errs, fmt.Errorf("%s:%s", path, msg))
+	}
+	return errors.Join(errs...)
+}
+
+// checkKeys walks node alongside the struct type t and reports every key
+// that doesn't map to a field, suggesting the closest known key.
+func checkKeys(path string, node *yaml.Node, t reflect.Type, prefix string) []error {
+	if t == reflect.TypeOf(yaml.Node{}) {
+		// checked once it is known what it decodes into
+		return nil
+	}
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		if t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode {
+			var errs []error
+			for _, item := range node.Content {
+				errs = append(errs, checkKeys(path, item, t.Elem(), prefix+"[]")...)
+			}
+			return errs
+		}
+		if t.Kind() == reflect.Map && node.Kind == yaml.MappingNode {
+			var errs []error
+			for i := 1; i < len(node.Content); i += 2 {
+				errs = append(errs, checkKeys(path, node.Content[i], t.Elem(), prefix+node.Content[i-1].Value+".")...)
+			}
+			return errs
+		}
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if tag := strings.Split(f.Tag.Get("yaml"), ",")[0]; tag != "" && tag != "-" {
+			fields[tag] = f.Type
+		}
+	}
+	var errs []error
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k := node.Content[i]
+		if seen[k.Value] {
+			errs = append(errs, fmt.Errorf("%s:%d:%d: duplicate key %q", path, k.Line, k.Column, prefix+k.Value))
+			continue
+		}
+		seen[k.Value] = true
+		ft, ok := fields[k.Value]
+		if !ok {
+			msg := fmt.Sprintf("%s:%d:%d: unknown key %q", path, k.Line, k.Column, prefix+k.Value)
+			if s := suggest(k.Value, fields); s != "" {
+				msg += fmt.Sprintf(" (did you mean %q?)", prefix+s)
+			}
+			errs = append(errs, errors.New(msg))
+			continue
+		}
+		errs = append(errs, checkKeys(path, node.Content[i+1], ft, prefix+k.Value+".")...)
+	}
+	return errs
+}
+
+// suggest returns the known key closest to key, if it is close enough to be
+// a plausible typo.
+func suggest(key string, known map[string]reflect.Type) string {
+	names := make([]string, 0, len(known))
+	for k := range known {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	best, bestDist := "", len(key)/2+2
+	for _, k := range names {
+		if d := levenshtein(key, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
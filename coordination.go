@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// locker is a leader lock shared by replicas; only the holder reconciles.
+type locker interface {
+	// TryLock acquires or renews the lock and reports whether we hold it.
+	TryLock(ctx context.Context) (bool, error)
+	// Unlock releases the lock if we hold it.
+	Unlock(ctx context.Context) error
+}
+
+var (
+	leaderLock locker
+	leader     atomic.Bool
+	// wasLeader is only touched by the sync loop.
+	wasLeader bool
+	// leadershipAcquired wakes the sync loop when this replica takes over.
+	leadershipAcquired = make(chan struct{}, 1)
+)
+
+// syncQueue is implemented by leader locks that pass the syncs requested on
+// any replica to the leader through their backend, one at a time.
+type syncQueue interface {
+	// QueueSync stores a for ttl and reports false when a sync is queued
+	// already.
+	QueueSync(ctx context.Context, a *annotation, ttl time.Duration) (bool, error)
+	// TakeSync removes and returns the queued sync, nil when there is none.
+	TakeSync(ctx context.Context) (*annotation, error)
+}
+
+// queueSync queues the sync a requests and reports false when one is queued
+// already. With redis coordination the request goes through Redis, so the
+// leader runs it once whichever replica got it; a request nobody takes goes
+// away with the next regular sync.
+func queueSync(ctx context.Context, a *annotation) (bool, error) {
+	q, ok := leaderLock.(syncQueue)
+	if !ok {
+		select {
+		case manualSyncs <- a:
+			return true, nil
+		default:
+			return false, nil
+		}
+	}
+	queued, err := q.QueueSync(ctx, a, max(cfg().SyncInterval, cfg().LeaseDuration))
+	if err != nil || !queued {
+		return queued, err
+	}
+	if leader.Load() {
+		takeQueuedSync(ctx)
+	}
+	return true, nil
+}
+
+// takeQueuedSync hands the sync queued in the backend to the daemon loop.
+func takeQueuedSync(ctx context.Context) {
+	q, ok := leaderLock.(syncQueue)
+	if !ok {
+		return
+	}
+	a, err := q.TakeSync(ctx)
+	if err != nil {
+		slog.Warn("take the requested sync failed", logErr(err))
+		return
+	}
+	if a == nil {
+		return
+	}
+	select {
+	case manualSyncs <- a:
+	default:
+		// the sync queued in the loop covers it
+	}
+}
+
+// instanceID identifies this replica in leader locks.
+func instanceID() string {
+	if pod := os.Getenv("POD_NAME"); pod != "" {
+		return pod
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+func initCoordination() {
+	var err error
+	switch cfg().Coordination {
+	case "":
+		return
+	case "redis":
+		leaderLock, err = newRedisLock(cfg().RedisURL, cfg().LeaseName, cfg().LeaseDuration)
+	case "kubernetes":
+		leaderLock, err = newKubeLease(cfg().LeaseName, cfg().LeaseNamespace, cfg().LeaseDuration)
+	default:
+		err = fmt.Errorf("unknown coordination backend %q", cfg().Coordination)
+	}
+	if err != nil {
+		log.Fatalf("init coordination: %+v", err)
+	}
+}
+
+// runLeaderElection renews the leader lock every third of the lease
+// duration, independently of the sync interval, so a standby takes over
+// shortly after the leader dies. The leader picks up the syncs requested on
+// the other replicas at the same pace.
+func runLeaderElection(ctx context.Context) {
+	if leaderLock == nil {
+		return
+	}
+	try := func() {
+		held, err := leaderLock.TryLock(ctx)
+		if err != nil {
+			slog.Warn("leader lock failed", logErr(err))
+			held = false
+		}
+		metricLeader.Set(boolFloat(held))
+		if held {
+			takeQueuedSync(ctx)
+		}
+		if held == leader.Swap(held) {
+			return
+		}
+		if held {
+			slog.Info("acquired leadership", "instance", instanceID())
+			select {
+			case leadershipAcquired <- struct{}{}:
+			default:
+			}
+		} else {
+			slog.Info("lost leadership")
+		}
+	}
+	try()
+	go func() {
+		ticker := time.NewTicker(cfg().LeaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				try()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// holdsLeadership reports whether this replica may reconcile in this cycle.
+func holdsLeadership(ctx context.Context) bool {
+	if leaderLock == nil {
+		return true
+	}
+	held := leader.Load()
+	if held && !wasLeader {
+		// pick up state written by the previous leader
+		if err := loadState(ctx); err != nil {
+			slog.Warn("load the state of the previous leader failed", logErr(err))
+		}
+	}
+	wasLeader = held
+	return held
+}
+
+func releaseLeadership() {
+	if leaderLock == nil || !leader.Load() {
+		return
+	}
+	if err := leaderLock.Unlock(context.Background()); err != nil {
+		slog.Warn("release leader lock failed", logErr(err))
+	}
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
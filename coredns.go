@@ -0,0 +1,239 @@
+//go:build !no_coredns
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerProvider("coredns", newCoreDNSProvider)
+}
+
+// coreDNSProvider writes records into etcd in the SkyDNS layout read by the
+// CoreDNS etcd plugin, through the JSON gateway of the etcd v3 API. Every
+// record is a key of its own below the path of its name, e.g.
+//
+//	/skydns/com/example/int/laptop/a = {"host":"100.64.0.1","ttl":300,"comment":"_tailscale node=nXXXX"}
+//
+// CoreDNS ignores the comment field, which carries the ownership marker.
+type coreDNSProvider struct {
+	url    string
+	prefix string
+	zone   string
+	user   string
+	pass   string
+	// mu guards token, which authenticates requests when etcd has auth
+	// enabled.
+	mu    sync.Mutex
+	token string
+}
+
+// skyDNSService is the value of a key, as CoreDNS reads it.
+type skyDNSService struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Weight   int    `json:"weight,omitempty"`
+	TTL      int    `json:"ttl,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type etcdKV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+func newCoreDNSProvider(ctx context.Context, name string) (provider, error) {
+	base := strings.TrimSuffix(os.Getenv("ETCD_URL"), "/")
+	if base == "" {
+		return nil, fmt.Errorf("set ETCD_URL, e.g. http://etcd:2379")
+	}
+	prefix := os.Getenv("COREDNS_ETCD_PATH")
+	if prefix == "" {
+		prefix = "/skydns"
+	}
+	p := &coreDNSProvider{url: base + "/v3", prefix: path.Clean("/" + prefix), zone: name,
+		user: os.Getenv("ETCD_USERNAME"), pass: secretEnv("ETCD_PASSWORD")}
+	// etcd has no zones, only check that it answers
+	if _, err := p.List(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// call posts in to the v3 API method and decodes the response into out,
+// authenticating first when credentials are set.
+func (p *coreDNSProvider) call(ctx context.Context, method string, in, out any) error {
+	token, err := p.authenticate(ctx)
+	if err != nil {
+		return err
+	}
+	err = p.post(ctx, method, token, in, out)
+	if err != nil && token != "" && strings.Contains(err.Error(), "invalid auth token") {
+		// tokens expire, get a new one
+		p.mu.Lock()
+		p.token = ""
+		p.mu.Unlock()
+		if token, err = p.authenticate(ctx); err != nil {
+			return err
+		}
+		err = p.post(ctx, method, token, in, out)
+	}
+	return err
+}
+
+func (p *coreDNSProvider) authenticate(ctx context.Context) (string, error) {
+	if p.user == "" {
+		return "", nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token == "" {
+		var res struct {
+			Token string `json:"token"`
+		}
+		if err := p.post(ctx, "/auth/authenticate", "", map[string]string{"name": p.user, "password": p.pass}, &res); err != nil {
+			return "", fmt.Errorf("etcd authenticate: %w", err)
+		}
+		p.token = res.Token
+	}
+	return p.token, nil
+}
+
+func (p *coreDNSProvider) post(ctx context.Context, method, token string, in, out any) error {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+method, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// namePath returns the etcd path of a DNS name, its labels reversed.
+func (p *coreDNSProvider) namePath(name string) string {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(name, ".")), ".")
+	slices.Reverse(labels)
+	return p.prefix + "/" + strings.Join(labels, "/")
+}
+
+// key returns the etcd key of the record content as c's type. SRV records
+// share their name, so their key includes a hash of the content.
+func (p *coreDNSProvider) key(c change, content string) string {
+	leaf := strings.ToLower(c.Type)
+	if c.Type == "SRV" {
+		h := fnv.New32a()
+		h.Write([]byte(content))
+		leaf = fmt.Sprintf("srv-%08x", h.Sum32())
+	}
+	return p.namePath(c.fqdn()) + "/" + leaf
+}
+
+func (p *coreDNSProvider) List(ctx context.Context) ([]record, error) {
+	dir := p.namePath(p.zone) + "/"
+	// the range end is the prefix with its last byte incremented
+	end := []byte(dir)
+	end[len(end)-1]++
+	var res struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := p.call(ctx, "/kv/range", map[string][]byte{"key": []byte(dir), "range_end": end}, &res); err != nil {
+		return nil, fmt.Errorf("etcd range %s: %w", dir, err)
+	}
+	var out []record
+	for _, kv := range res.KVs {
+		var s skyDNSService
+		if json.Unmarshal(kv.Value, &s) != nil || !ownedComment(s.Comment) {
+			continue
+		}
+		key := string(kv.Key)
+		dir, leaf := path.Split(key)
+		labels := strings.Split(strings.Trim(strings.TrimPrefix(dir, p.prefix), "/"), "/")
+		slices.Reverse(labels)
+		r := record{ID: key, Name: strings.Join(labels, "."), Content: strings.TrimSuffix(s.Host, "."), TTL: s.TTL, Comment: s.Comment}
+		switch ip, err := netip.ParseAddr(s.Host); {
+		case strings.HasPrefix(leaf, "srv-"):
+			r.Type, r.Content = "SRV", fmt.Sprintf("%d %d %d %s", s.Priority, s.Weight, s.Port, r.Content)
+		case err != nil:
+			r.Type = "CNAME"
+		case ip.Is4():
+			r.Type = "A"
+		default:
+			r.Type = "AAAA"
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (p *coreDNSProvider) StoredTTL(ttl int) int {
+	if ttl <= 1 {
+		// CoreDNS has no automatic TTL
+		return 300
+	}
+	return ttl
+}
+
+// put writes the key of c.
+func (p *coreDNSProvider) put(ctx context.Context, c change) (string, error) {
+	s := skyDNSService{Host: c.Content, TTL: p.StoredTTL(c.TTL), Comment: c.Comment}
+	if c.Type == "SRV" {
+		if _, err := fmt.Sscanf(c.Content, "%d %d %d %s", &s.Priority, &s.Weight, &s.Port, &s.Host); err != nil {
+			return "", fmt.Errorf("malformed SRV content %q", c.Content)
+		}
+	}
+	value, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	key := p.key(c, c.Content)
+	return key, p.call(ctx, "/kv/put", etcdKV{Key: []byte(key), Value: value}, nil)
+}
+
+func (p *coreDNSProvider) Create(ctx context.Context, c change) (string, error) {
+	return p.put(ctx, c)
+}
+
+func (p *coreDNSProvider) Update(ctx context.Context, c change) error {
+	key, err := p.put(ctx, c)
+	if err != nil || key == c.RecordID {
+		return err
+	}
+	// the SRV content moved to another key
+	return p.Delete(ctx, c)
+}
+
+func (p *coreDNSProvider) Delete(ctx context.Context, c change) error {
+	return p.call(ctx, "/kv/deleterange", etcdKV{Key: []byte(c.RecordID)}, nil)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// credentialChecker is implemented by providers that can verify their
+// credentials. It returns when they expire, the zero time for never, and an
+// error when they are no longer valid.
+type credentialChecker interface {
+	CheckCredentials(ctx context.Context) (expires time.Time, err error)
+}
+
+var (
+	metricCredentialValid  = newMetric("gauge", "credential_valid", "Whether a credential passed its last check, by credential.")
+	metricCredentialExpiry = newMetric("gauge", "credential_expiry_days", "Days until a credential expires, by credential; absent for credentials that don't.")
+
+	credentialsMu sync.Mutex
+	// credentialsChecked is when the credentials were last checked.
+	credentialsChecked time.Time
+	// credentialsWarned holds the credentials already notified about, by
+	// the event type sent.
+	credentialsWarned = map[string]string{}
+)
+
+// checkCredentials verifies the provider's and the tailnet source's
+// credentials every CREDENTIAL_CHECK_INTERVAL and warns once when one is
+// invalid or expires within CREDENTIAL_WARN_BEFORE.
+func checkCredentials(ctx context.Context, st *ipnstate.Status) {
+	if cfg().CredentialCheckInterval <= 0 {
+		return
+	}
+	credentialsMu.Lock()
+	due := time.Since(credentialsChecked) >= cfg().CredentialCheckInterval
+	if due {
+		credentialsChecked = time.Now()
+	}
+	credentialsMu.Unlock()
+	if !due {
+		return
+	}
+	if len(zones) > 0 {
+		// zones share the provider's credentials
+		if cc, ok := zones[0].provider.(credentialChecker); ok {
+			countAPICall(apiCallOther)
+			expires, err := cc.CheckCredentials(ctx)
+			credentialResult(ctx, cfg().Provider, expires, err)
+		}
+	}
+	switch cfg().Source {
+	case SourceTailscaled:
+		if st.Self != nil && st.Self.KeyExpiry != nil {
+			credentialResult(ctx, "tailscale_node_key", *st.Self.KeyExpiry, nil)
+		}
+	case SourceAPI:
+		expires, err := apiKeyExpiry(ctx)
+		credentialResult(ctx, "tailscale_api_key", expires, err)
+	case SourceHeadscale:
+		expires, err := headscaleKeyExpiry(ctx)
+		credentialResult(ctx, "headscale_api_key", expires, err)
+	}
+}
+
+// credentialResult exports the outcome of checking name and notifies when
+// it changed for the worse.
+func credentialResult(ctx context.Context, name string, expires time.Time, err error) {
+	var typ, msg string
+	left := time.Until(expires)
+	switch {
+	case err != nil:
+		typ, msg = "credential_invalid", fmt.Sprintf("%s credentials failed verification: %v", name, err)
+		metricCredentialValid.Set(0, "credential", name)
+	case !expires.IsZero() && left <= 0:
+		typ, msg = "credential_invalid", fmt.Sprintf("%s credentials expired at %s", name, expires.Format(time.RFC3339))
+		metricCredentialValid.Set(0, "credential", name)
+	default:
+		metricCredentialValid.Set(1, "credential", name)
+		if !expires.IsZero() && left <= cfg().CredentialWarnBefore {
+			typ, msg = "credential_expiring", fmt.Sprintf("%s credentials expire in %d day(s), at %s", name, int(left.Hours()/24), expires.Format(time.RFC3339))
+		}
+	}
+	if !expires.IsZero() {
+		metricCredentialExpiry.Set(math.Floor(left.Hours()/24), "credential", name)
+	}
+	credentialsMu.Lock()
+	warned := credentialsWarned[name]
+	credentialsWarned[name] = typ
+	credentialsMu.Unlock()
+	if typ == "" || typ == warned {
+		return
+	}
+	slog.Warn(msg, "credential", name)
+	notify(ctx, event{Type: typ, Message: msg})
+}
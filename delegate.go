@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// delegator is implemented by providers that can create a child zone and
+// delegate it from the zone they manage.
+type delegator interface {
+	// Delegate creates the zone child unless it exists and adds NS records
+	// for it to the parent zone, returning the child's nameservers.
+	Delegate(ctx context.Context, child string) ([]string, error)
+}
+
+// bootstrapSubzone sets up child, e.g. int.example.com, as a zone of its own
+// delegated from its parent, so the tailnet records are kept apart from the
+// parent's records and the sync token only needs access to the child zone.
+func bootstrapSubzone(ctx context.Context, child string) error {
+	child = strings.TrimSuffix(strings.ToLower(child), ".")
+	_, parent, ok := strings.Cut(child, ".")
+	if !ok || !strings.Contains(parent, ".") {
+		return fmt.Errorf("%s is not a subdomain of a registered domain", child)
+	}
+	factory, ok := providerFactories[cfg().Provider]
+	if !ok {
+		return fmt.Errorf("provider %q is not compiled in (available: %s)", cfg().Provider, providerNames())
+	}
+	p, err := factory(ctx, parent)
+	if err != nil {
+		return fmt.Errorf("open %s zone %s: %w", cfg().Provider, parent, err)
+	}
+	d, ok := p.(delegator)
+	if !ok {
+		return fmt.Errorf("provider %s can't create zones, delegate %s by hand", cfg().Provider, child)
+	}
+	nameservers, err := d.Delegate(ctx, child)
+	if err != nil {
+		return fmt.Errorf("delegate %s: %w", child, err)
+	}
+	slog.Info("zone delegated", "zone", child, "parent", parent, "nameservers", strings.Join(nameservers, ","))
+	slog.Info("set DOMAIN=" + child + " and narrow the token to that zone")
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// diffLine is one removed or added record of a zone diff.
+type diffLine struct {
+	fqdn    string
+	typ     string
+	sign    byte
+	ttl     int
+	content string
+	reason  string
+}
+
+func (l diffLine) String() string {
+	ttl := l.ttl
+	if ttl <= 1 {
+		ttl = 300
+	}
+	s := fmt.Sprintf("%c%s.\t%d\t%s\t%s", l.sign, l.fqdn, ttl, l.typ, l.content)
+	if l.reason != "" {
+		// a zone file comment, e.g. for updates touching only the metadata
+		s += "\t; " + l.reason
+	}
+	return s
+}
+
+// exitCode is returned by commands that exit with a specific status.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
+func init() {
+	registerCommand("diff", runDiff)
+}
+
+// runDiff implements `tailscale-dns-sync diff`: it compares every zone with
+// the tailnet without changing anything and prints the drift as a unified
+// diff, exiting with -drift-exit when there is any. Meant for scheduled CI
+// checks holding read-only credentials.
+func runDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	driftExit := fs.Int("drift-exit", 2, "exit status when a zone differs from the tailnet")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	// never create anything
+	c := *cfg()
+	c.CreateZones = false
+	liveConfig.Store(&c)
+	var err error
+	if zones, err = openZones(ctx); err != nil {
+		return err
+	}
+	if err := loadState(ctx); err != nil {
+		return err
+	}
+	st, hosts, err := tailnetHosts(ctx)
+	if err != nil {
+		return err
+	}
+	drift := false
+	for _, z := range zones {
+		records, err := currentRecords(ctx, z)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", z.Name, err)
+		}
+		changes := plan(z, zoneRecords(st, z, hosts), records)
+		if len(changes) == 0 {
+			continue
+		}
+		drift = true
+		if err := writeDiff(os.Stdout, z, changes); err != nil {
+			return err
+		}
+	}
+	if drift {
+		return exitCode(*driftExit)
+	}
+	return nil
+}
+
+// writeDiff writes the changes of z sorted by name, type and content, with
+// removals before additions, so that runs over the same drift match.
+func writeDiff(w io.Writer, z *zone, changes []change) error {
+	var lines []diffLine
+	for _, c := range changes {
+		switch c.Action {
+		case actionCreate:
+			lines = append(lines, diffLine{c.fqdn(), c.Type, '+', c.TTL, c.Content, c.Reason})
+		case actionDelete:
+			lines = append(lines, diffLine{c.fqdn(), c.Type, '-', c.TTL, c.Content, c.Reason})
+		case actionUpdate:
+			lines = append(lines, diffLine{c.fqdn(), c.Type, '-', c.OldTTL, c.OldContent, ""}, diffLine{c.fqdn(), c.Type, '+', c.TTL, c.Content, c.Reason})
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		a, b := lines[i], lines[j]
+		if a.fqdn != b.fqdn {
+			return a.fqdn < b.fqdn
+		}
+		if a.typ != b.typ {
+			return a.typ < b.typ
+		}
+		if a.sign != b.sign {
+			return a.sign == '-'
+		}
+		return a.content < b.content
+	})
+	if _, err := fmt.Fprintf(w, "--- %s (%s)\n+++ %s (tailnet)\n", z.Name, cfg().Provider, z.Name); err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+//go:build !no_digitalocean
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// digitalOceanAPI is the base URL of the DigitalOcean API.
+const digitalOceanAPI = "https://api.digitalocean.com/v2"
+
+// digitalOceanDefaultTTL replaces the automatic TTL, which DigitalOcean
+// doesn't have; 30 seconds is the lowest it takes.
+const (
+	digitalOceanDefaultTTL = 300
+	digitalOceanMinTTL     = 30
+)
+
+// digitalOceanManaged are the record types the sync publishes.
+var digitalOceanManaged = []string{"A", "AAAA", "CNAME", "SRV"}
+
+// errDigitalOceanNotFound is returned for a domain or record that doesn't
+// exist.
+var errDigitalOceanNotFound = errors.New("not found")
+
+func init() {
+	registerProvider("digitalocean", newDigitalOceanProvider)
+}
+
+// digitalOceanProvider syncs into a domain of DigitalOcean's DNS. Its
+// records carry no comments, so like on Route53 every A, AAAA, CNAME and SRV
+// record under the managed suffix is treated as managed, unless REGISTRY=txt
+// says otherwise.
+type digitalOceanProvider struct {
+	base  string
+	token string
+	zone  string
+}
+
+// digitalOceanRecord is a domain record; Name is relative to the domain,
+// "@" for the domain itself.
+type digitalOceanRecord struct {
+	ID       int    `json:"id,omitempty"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Data     string `json:"data"`
+	TTL      int    `json:"ttl,omitempty"`
+	Priority *int   `json:"priority,omitempty"`
+	Port     *int   `json:"port,omitempty"`
+	Weight   *int   `json:"weight,omitempty"`
+}
+
+func newDigitalOceanProvider(ctx context.Context, name string) (provider, error) {
+	p := &digitalOceanProvider{
+		base:  strings.TrimSuffix(envString("DIGITALOCEAN_API_URL", digitalOceanAPI), "/"),
+		token: secretEnv("DIGITALOCEAN_TOKEN"),
+		zone:  name,
+	}
+	if p.token == "" {
+		return nil, fmt.Errorf("set DIGITALOCEAN_TOKEN to a token with write access to the domains")
+	}
+	err := p.call(ctx, http.MethodGet, p.base+"/domains/"+url.PathEscape(name), nil, nil)
+	if errors.Is(err, errDigitalOceanNotFound) {
+		return nil, fmt.Errorf("%w: %s", errZoneNotFound, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get domain %s: %w", name, err)
+	}
+	return p, nil
+}
+
+func (p *digitalOceanProvider) call(ctx context.Context, method, endpoint string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errDigitalOceanNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var e struct {
+			ID      string `json:"id"`
+			Message string `json:"message"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if json.Unmarshal(msg, &e) == nil && e.Message != "" {
+			return fmt.Errorf("%s: %s", resp.Status, e.Message)
+		}
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *digitalOceanProvider) records() string {
+	return p.base + "/domains/" + url.PathEscape(p.zone) + "/records"
+}
+
+func (p *digitalOceanProvider) List(ctx context.Context) ([]record, error) {
+	var out []record
+	err := p.ListPages(ctx, "", func(page []record) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// ListPages passes the records under suffix to fn a page at a time. The API
+// can't filter by name, so the domain is listed whole.
+func (p *digitalOceanProvider) ListPages(ctx context.Context, suffix string, fn func([]record) error) error {
+	if suffix == "" {
+		suffix = p.zone
+	}
+	next := p.records() + "?per_page=200"
+	for next != "" {
+		var page struct {
+			Records []digitalOceanRecord `json:"domain_records"`
+			Links   struct {
+				Pages struct {
+					Next string `json:"next"`
+				} `json:"pages"`
+			} `json:"links"`
+		}
+		if err := p.call(ctx, http.MethodGet, next, nil, &page); err != nil {
+			return fmt.Errorf("list records: %w", err)
+		}
+		var out []record
+		for _, r := range page.Records {
+			registry := r.Type == "TXT" && managedTXT()
+			name := p.fqdn(r.Name)
+			if !slices.Contains(digitalOceanManaged, r.Type) && !registry || name != suffix && !strings.HasSuffix(name, "."+suffix) {
+				continue
+			}
+			out = append(out, record{ID: strconv.Itoa(r.ID), Name: name, Type: r.Type, Content: digitalOceanContent(r), TTL: r.TTL})
+		}
+		if err := fn(out); err != nil {
+			return err
+		}
+		next = page.Links.Pages.Next
+	}
+	return nil
+}
+
+// fqdn returns the full name of a record named name in the domain.
+func (p *digitalOceanProvider) fqdn(name string) string {
+	if name == "@" {
+		return p.zone
+	}
+	return strings.ToLower(name) + "." + p.zone
+}
+
+// digitalOceanContent returns the content of r as the sync writes it:
+// names without the trailing dot, SRV records as "priority weight port
+// target".
+func digitalOceanContent(r digitalOceanRecord) string {
+	data := strings.TrimSuffix(r.Data, ".")
+	if r.Type == "SRV" && r.Priority != nil && r.Weight != nil && r.Port != nil {
+		return fmt.Sprintf("%d %d %d %s", *r.Priority, *r.Weight, *r.Port, data)
+	}
+	return data
+}
+
+func (p *digitalOceanProvider) StoredTTL(ttl int) int {
+	if ttl <= 1 {
+		return digitalOceanDefaultTTL
+	}
+	return max(ttl, digitalOceanMinTTL)
+}
+
+// record returns the domain record of c.
+func (p *digitalOceanProvider) record(c change) (digitalOceanRecord, error) {
+	name := strings.TrimSuffix(c.fqdn(), "."+p.zone)
+	if c.fqdn() == p.zone {
+		name = "@"
+	}
+	r := digitalOceanRecord{Type: c.Type, Name: name, Data: c.Content, TTL: p.StoredTTL(c.TTL)}
+	switch c.Type {
+	case "CNAME":
+		r.Data += "."
+	case "SRV":
+		var priority, weight, port int
+		var target string
+		if _, err := fmt.Sscan(c.Content, &priority, &weight, &port, &target); err != nil {
+			return r, fmt.Errorf("SRV content %q: %w", c.Content, err)
+		}
+		r.Priority, r.Weight, r.Port, r.Data = &priority, &weight, &port, target+"."
+	}
+	return r, nil
+}
+
+func (p *digitalOceanProvider) Create(ctx context.Context, c change) (string, error) {
+	r, err := p.record(c)
+	if err != nil {
+		return "", err
+	}
+	var created struct {
+		Record digitalOceanRecord `json:"domain_record"`
+	}
+	if err := p.call(ctx, http.MethodPost, p.records(), r, &created); err != nil {
+		return "", fmt.Errorf("create record %s: %w", c.fqdn(), err)
+	}
+	return strconv.Itoa(created.Record.ID), nil
+}
+
+func (p *digitalOceanProvider) Update(ctx context.Context, c change) error {
+	r, err := p.record(c)
+	if err != nil {
+		return err
+	}
+	if err := p.call(ctx, http.MethodPut, p.records()+"/"+url.PathEscape(c.RecordID), r, nil); err != nil {
+		return fmt.Errorf("update record %s: %w", c.fqdn(), err)
+	}
+	return nil
+}
+
+func (p *digitalOceanProvider) Delete(ctx context.Context, c change) error {
+	err := p.call(ctx, http.MethodDelete, p.records()+"/"+url.PathEscape(c.RecordID), nil, nil)
+	if errors.Is(err, errDigitalOceanNotFound) {
+		// already gone
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("delete record %s: %w", c.fqdn(), err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+//go:build !no_dnsserver
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	dnsServerOnce sync.Once
+	dnsServerErr  error
+	// builtinZones are the zones served, their records kept in memory.
+	builtinMu    sync.RWMutex
+	builtinZones = map[string]*memoryProvider{}
+)
+
+func init() {
+	registerProvider("builtin", newBuiltinProvider)
+}
+
+// newBuiltinProvider keeps the records of zone name in memory and answers
+// DNS queries for them from an embedded authoritative server on DNS_LISTEN,
+// instead of pushing them anywhere. The records are rebuilt by the first
+// sync after a restart.
+func newBuiltinProvider(ctx context.Context, name string) (provider, error) {
+	dnsServerOnce.Do(func() {
+		addr := os.Getenv("DNS_LISTEN")
+		if addr == "" {
+			addr = "127.0.0.1:53"
+		}
+		dnsServerErr = serveDNS(addr)
+	})
+	if dnsServerErr != nil {
+		return nil, dnsServerErr
+	}
+	builtinMu.Lock()
+	defer builtinMu.Unlock()
+	p, ok := builtinZones[name]
+	if !ok {
+		p = newMemoryProvider()
+		builtinZones[name] = p
+	}
+	return p, nil
+}
+
+// serveDNS starts answering on addr over UDP and TCP.
+func serveDNS(addr string) error {
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", answerDNS)
+	for _, network := range []string{"udp", "tcp"} {
+		srv := &dns.Server{Addr: addr, Net: network, Handler: mux}
+		started := make(chan error, 1)
+		srv.NotifyStartedFunc = func() { started <- nil }
+		go func() {
+			if err := srv.ListenAndServe(); err != nil {
+				started <- err
+			}
+		}()
+		if err := <-started; err != nil {
+			return fmt.Errorf("DNS server on %s/%s: %w", addr, network, err)
+		}
+		onShutdown(func() { srv.Shutdown() })
+	}
+	slog.Info("DNS listening", "addr", addr)
+	return nil
+}
+
+// answerDNS answers a query from the records of the zone containing the
+// name, and refuses names outside of the zones served.
+func answerDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if len(req.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		w.WriteMsg(m)
+		return
+	}
+	q := req.Question[0]
+	name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	zone, p := builtinZone(name)
+	if p == nil {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+	m.Authoritative = true
+	exists := name == zone
+	p.mu.Lock()
+	for _, r := range p.records {
+		if !strings.EqualFold(r.Name, name) {
+			continue
+		}
+		exists = true
+		if r.Type != dns.TypeToString[q.Qtype] && r.Type != "CNAME" && q.Qtype != dns.TypeANY {
+			continue
+		}
+		if rr, err := builtinRR(r); err != nil {
+			slog.Warn("DNS record not served", "name", r.Name, "type", r.Type, logErr(err))
+		} else {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	p.mu.Unlock()
+	if len(m.Answer) == 0 {
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+		// negative answers are cached for the SOA's minimum TTL
+		m.Ns = append(m.Ns, builtinSOA(zone))
+	}
+	w.WriteMsg(m)
+}
+
+// builtinZone returns the longest zone served containing name.
+func builtinZone(name string) (string, *memoryProvider) {
+	builtinMu.RLock()
+	defer builtinMu.RUnlock()
+	best := ""
+	for zone := range builtinZones {
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	if best == "" {
+		return "", nil
+	}
+	return best, builtinZones[best]
+}
+
+// builtinRR returns the resource record for r.
+func builtinRR(r record) (dns.RR, error) {
+	ttl := r.TTL
+	if ttl <= 1 {
+		ttl = 60
+	}
+	content := r.Content
+	switch r.Type {
+	case "CNAME", "SRV":
+		content = dns.Fqdn(content)
+	case "TXT":
+		content = strconv.Quote(content)
+	}
+	return dns.NewRR(dns.Fqdn(r.Name) + " " + strconv.Itoa(ttl) + " IN " + r.Type + " " + content)
+}
+
+// builtinSOA returns the SOA record of zone, its serial the current time.
+func builtinSOA(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:      dns.Fqdn(hostname()),
+		Mbox:    dns.Fqdn("hostmaster." + zone),
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600, Retry: 600, Expire: 86400, Minttl: 60,
+	}
+}
+
+// hostname returns the name the server calls itself in its SOA.
+func hostname() string {
+	if h, err := os.Hostname(); err == nil && h != "" {
+		return h
+	}
+	return "localhost"
+}
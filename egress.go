@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// setupEgress sends the outbound HTTP(S) requests of the providers, the
+// APIs and the notifications through PROXY_URL, if set, instead of the
+// proxy in HTTPS_PROXY and friends, and trusts the certificates in
+// CA_BUNDLE next to the system's. It changes http.DefaultTransport, which
+// providerClient and http.DefaultClient both use.
+func setupEgress(c *config) error {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil
+	}
+	t.Proxy = http.ProxyFromEnvironment
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("proxy_url: %w", err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if c.CABundle != "" {
+		pool, err := readCABundle(c.CABundle)
+		if err != nil {
+			return fmt.Errorf("ca_bundle: %w", err)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return nil
+}
+
+// readCABundle returns the system's certificates with those in the PEM
+// file at path added.
+func readCABundle(path string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no PEM certificates in %s", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// addExitNodes adds EXIT_NODE_NAME with the addresses of every online exit
+// node, a round-robin name for scripts wanting any exit node. It takes the
+// records the nodes are published with, so it follows their filters,
+// ADDRESS_RULES and ADDRESS_FAMILY; a node already published under the
+// name keeps it.
+func addExitNodes(st *ipnstate.Status, hosts map[string]host) {
+	name := cfg().ExitNodeName
+	if name == "" {
+		return
+	}
+	for _, typ := range []string{"A", "AAAA"} {
+		if h, ok := findHost(hosts, typ, name); ok && h.NodeID != "" {
+			warnOnce("exit "+name, "not publishing the exit nodes, the name belongs to a node", "host", name)
+			return
+		}
+	}
+	exits := map[string]bool{}
+	if st.Self != nil && st.Self.ExitNodeOption {
+		exits[string(st.Self.ID)] = true
+	}
+	for _, ps := range st.Peer {
+		if ps.ExitNodeOption {
+			exits[string(ps.ID)] = true
+		}
+	}
+	var members []host
+	for _, h := range hosts {
+		// tag subdomain copies and wildcards hold the same addresses
+		if !exits[h.NodeID] || !h.Online || h.Content == "" || h.Type != "A" && h.Type != "AAAA" || strings.Contains(h.Name, ".") {
+			continue
+		}
+		members = append(members, host{Name: name, Type: h.Type, Content: h.Content, Online: true, TTL: cfg().TTL})
+	}
+	for _, h := range members {
+		hosts[recordKey(h.Type, h.Name, h.Content)] = h
+	}
+}
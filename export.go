@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// exportEntry is one record of the desired mapping.
+type exportEntry struct {
+	Zone    string `json:"zone"`
+	Suffix  string `json:"suffix"`
+	Name    string `json:"name"`
+	FQDN    string `json:"fqdn"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Online  bool   `json:"online"`
+}
+
+func init() {
+	registerCommand("export", runExport)
+}
+
+// runExport implements `tailscale-dns-sync export`: it writes the records the
+// sync would publish to stdout without opening any provider.
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	format := fs.String("format", "hosts", "output format: hosts, csv, json or zone")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	entries, err := desiredEntries(ctx)
+	if err != nil {
+		return err
+	}
+	switch *format {
+	case "hosts":
+		return exportHosts(os.Stdout, entries)
+	case "csv":
+		return exportCSV(os.Stdout, entries)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case "zone":
+		return exportZone(os.Stdout, entries)
+	}
+	return fmt.Errorf("unknown format %q, want hosts, csv, json or zone", *format)
+}
+
+// desiredEntries returns the records the sync would publish in every
+// configured zone, sorted by zone, name and content.
+func desiredEntries(ctx context.Context) ([]exportEntry, error) {
+	if len(configuredZones()) == 0 {
+		return nil, fmt.Errorf("no zone configured, set DOMAIN or SUFFIXES")
+	}
+	st, err := fetchStatus(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tailscale status: %w", err)
+	}
+	hosts := desiredHosts(st)
+	if err := mergeFileHosts(ctx, hosts); err != nil {
+		return nil, err
+	}
+	if err := loadState(ctx); err == nil {
+		// stability is only known from the daemon's state file
+		trackStability(hosts, time.Now())
+	}
+	applyTagPolicies(hosts)
+	var entries []exportEntry
+	for _, z := range configuredZones() {
+		for _, h := range zoneRecords(st, z, hosts) {
+			if h.Content == "" {
+				continue
+			}
+			c := change{Zone: z.Name, Suffix: z.Suffix, Name: h.Name}
+			entries = append(entries, exportEntry{Zone: z.Name, Suffix: z.Suffix, Name: h.Name, FQDN: c.fqdn(),
+				Type: h.Type, Content: h.Content, TTL: h.TTL, Online: h.Online})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Zone != entries[j].Zone {
+			return entries[i].Zone < entries[j].Zone
+		}
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Content < entries[j].Content
+	})
+	return entries, nil
+}
+
+func exportHosts(w io.Writer, entries []exportEntry) error {
+	if cfg().RecordType == "CNAME" {
+		return fmt.Errorf("hosts files can't hold CNAMEs, use RECORD_TYPE=A or another format")
+	}
+	for _, e := range entries {
+		if e.Type != "A" && e.Type != "AAAA" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", e.Content, e.FQDN); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func exportCSV(w io.Writer, entries []exportEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"zone", "name", "fqdn", "type", "content", "ttl", "online"})
+	for _, e := range entries {
+		cw.Write([]string{e.Zone, e.Name, e.FQDN, e.Type, e.Content, fmt.Sprint(e.TTL), fmt.Sprint(e.Online)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportZone writes one RFC 1035 master file section per zone. TTL 1 (the
+// provider's automatic TTL) is written as 300.
+func exportZone(w io.Writer, entries []exportEntry) error {
+	zone := ""
+	for _, e := range entries {
+		if e.Zone != zone {
+			if zone != "" {
+				fmt.Fprintln(w)
+			}
+			zone = e.Zone
+			fmt.Fprintf(w, "$ORIGIN %s.\n", e.Suffix)
+		}
+		if err := writeZoneRecord(w, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZoneRecord writes e as a master file line relative to its suffix.
+func writeZoneRecord(w io.Writer, e exportEntry) error {
+	ttl := e.TTL
+	if ttl <= 1 {
+		ttl = 300
+	}
+	content := e.Content
+	if e.Type == "CNAME" || e.Type == "SRV" {
+		content += "."
+	}
+	_, err := fmt.Fprintf(w, "%s\t%d\tIN\t%s\t%s\n", e.Name, ttl, e.Type, content)
+	return err
+}
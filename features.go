@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// tailscaledFeatures are the optional LocalAPI endpoints the local
+// tailscaled serves. Older versions lack some of them, which then answer
+// 404 and the features relying on them fall back to polling status.
+type tailscaledFeatures struct {
+	Version string
+	// IPNBus is /localapi/v0/watch-ipn-bus, used to react to netmap changes.
+	IPNBus bool
+	// Serve is /localapi/v0/serve-config, the node's Serve and Funnel setup.
+	Serve bool
+}
+
+// features is what detectFeatures found; the zero value means polling only.
+var features tailscaledFeatures
+
+// detectFeatures probes tailscaled for the optional endpoints and logs what
+// is missing. A tailscaled that can't be reached leaves features as they
+// are, so the next successful probe decides.
+func detectFeatures(ctx context.Context) {
+	if cfg().Source != SourceTailscaled || syntheticStatus != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	st, err := lc().StatusWithoutPeers(ctx)
+	if err != nil {
+		slog.Warn("detect tailscaled features failed", logErr(err))
+		return
+	}
+	f := tailscaledFeatures{Version: st.Version}
+	missing := func(name, fallback string, err error) bool {
+		if err == nil {
+			return false
+		}
+		if isNotFound(err) {
+			slog.Info("tailscaled has no "+name+" endpoint, "+fallback, "version", f.Version)
+		} else {
+			slog.Warn("detect tailscaled feature failed", "feature", name, logErr(err))
+		}
+		return true
+	}
+	// a subscription that opens is enough, it isn't kept
+	if w, err := lc().WatchIPNBus(ctx, 0); !missing("watch-ipn-bus", "polling status every "+cfg().SyncInterval.String(), err) {
+		w.Close()
+		f.IPNBus = true
+	}
+	_, err = lc().GetServeConfig(ctx)
+	f.Serve = !missing("serve-config", "not reading Serve configuration", err)
+	features = f
+}
+
+// isNotFound reports whether err is a LocalAPI 404, the answer of a
+// tailscaled too old for the endpoint.
+func isNotFound(err error) bool {
+	return strings.Contains(err.Error(), "404")
+}
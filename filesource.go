@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fileRecord is a record declared in FILE_SOURCE.
+type fileRecord struct {
+	Name string `json:"name" yaml:"name"`
+	// Type defaults to A or AAAA from the content.
+	Type    string   `json:"type" yaml:"type"`
+	Content string   `json:"content" yaml:"content"`
+	TTL     int      `json:"ttl" yaml:"ttl"`
+	Tags    []string `json:"tags" yaml:"tags"`
+}
+
+// readFileSource reads FILE_SOURCE, a local path or an http(s) URL.
+func readFileSource(ctx context.Context, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseFileSource parses b by the extension of src: a YAML or JSON list of
+// records, or a hosts file.
+func parseFileSource(src string, b []byte) ([]fileRecord, error) {
+	var records []fileRecord
+	switch path.Ext(strings.SplitN(src, "?", 2)[0]) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		dec.KnownFields(true)
+		if err := dec.Decode(&records); err != nil && err != io.EOF {
+			return nil, err
+		}
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&records); err != nil {
+			return nil, err
+		}
+	default:
+		s := bufio.NewScanner(bytes.NewReader(b))
+		for line := 1; s.Scan(); line++ {
+			text, _, _ := strings.Cut(s.Text(), "#")
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				continue
+			}
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: want address and names", line)
+			}
+			for _, name := range fields[1:] {
+				records = append(records, fileRecord{Name: name, Content: fields[0]})
+			}
+		}
+	}
+	for i := range records {
+		if err := records[i].normalize(); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// parseRecords parses RECORDS, "name=content,...", guessing the type from
+// the content: A or AAAA for addresses, CNAME otherwise.
+func parseRecords(v string) ([]fileRecord, error) {
+	var out []fileRecord
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, content, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want name=content", part)
+		}
+		r := fileRecord{Name: strings.TrimSpace(name), Content: strings.TrimSpace(content)}
+		if _, err := netip.ParseAddr(r.Content); err != nil {
+			r.Type = "CNAME"
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// normalize lower-cases the name, guesses a missing type from the content
+// and validates r.
+func (r *fileRecord) normalize() error {
+	r.Name = strings.ToLower(r.Name)
+	if r.Type == "" {
+		r.Type = "A"
+		if addr, err := netip.ParseAddr(r.Content); err == nil && addr.Is6() {
+			r.Type = "AAAA"
+		}
+	}
+	r.Type = strings.ToUpper(r.Type)
+	return r.validate()
+}
+
+func (r fileRecord) validate() error {
+	if r.Name == "" || strings.Contains(r.Name, ".") {
+		return fmt.Errorf("record %q: name must be a single label below the managed suffix", r.Name)
+	}
+	switch r.Type {
+	case "A", "AAAA":
+		addr, err := netip.ParseAddr(r.Content)
+		if err != nil || addr.Is4() != (r.Type == "A") {
+			return fmt.Errorf("record %s: %q is not an %s address", r.Name, r.Content, r.Type)
+		}
+	case "CNAME":
+		if r.Content == "" {
+			return fmt.Errorf("record %s: CNAME needs a target", r.Name)
+		}
+	default:
+		return fmt.Errorf("record %s: type %s is not one of A, AAAA, CNAME", r.Name, r.Type)
+	}
+	return nil
+}
+
+// mergeFileHosts adds the records of RECORDS and then FILE_SOURCE to hosts.
+// They are always online; tailnet hosts of the same name and type win.
+func mergeFileHosts(ctx context.Context, hosts map[string]host) error {
+	records := cfg().Records
+	if cfg().FileSource != "" {
+		b, err := readFileSource(ctx, cfg().FileSource)
+		if err != nil {
+			return fmt.Errorf("file source: %w", err)
+		}
+		fromSource, err := parseFileSource(cfg().FileSource, b)
+		if err != nil {
+			return fmt.Errorf("file source %s: %w", cfg().FileSource, err)
+		}
+		records = append(records[:len(records):len(records)], fromSource...)
+	}
+	// the names the file itself added, which may have several addresses
+	// with ALL_ADDRESSES
+	fromFile := map[string]bool{}
+	for _, r := range records {
+		key := recordKey(r.Type, r.Name, r.Content)
+		if _, ok := findHost(hosts, r.Type, r.Name); ok && !fromFile[r.Type+" "+r.Name] || syncState.Excluded[r.Name] || !nameAllowed(r.Name) {
+			continue
+		}
+		if _, ok := hosts[key]; ok {
+			continue
+		}
+		fromFile[r.Type+" "+r.Name] = true
+		h := host{Name: r.Name, Type: r.Type, Content: strings.TrimSuffix(r.Content, "."), Online: true, TTL: r.TTL, Tags: r.Tags}
+		if h.TTL <= 0 {
+			h.TTL = cfg().TTL
+		}
+		// only address records and aliases can be proxied
+		h.Proxied = cfg().Proxied && (r.Type == "A" || r.Type == "AAAA" || r.Type == "CNAME")
+		hosts[key] = h
+	}
+	return nil
+}
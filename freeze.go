@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// freeze pins the managed records: while set, cycles still list, plan and
+// report drift, but apply nothing.
+type freeze struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason,omitempty"`
+	// By is who froze the records, as authorized by the listener.
+	By string `json:"by,omitempty"`
+}
+
+func (f *freeze) String() string {
+	s := "frozen since " + f.Since.Format(time.RFC3339)
+	if f.By != "" {
+		s += " by " + f.By
+	}
+	if f.Reason != "" {
+		s += ": " + f.Reason
+	}
+	return s
+}
+
+var (
+	freezeMu   sync.Mutex
+	freezeOnce sync.Once
+	// frozen is the freeze in effect, kept apart from the state so the
+	// endpoints don't race the cycle saving it.
+	frozen *freeze
+)
+
+// currentFreeze returns the freeze in effect, nil when there is none. The
+// first call picks up the freeze of the loaded state.
+func currentFreeze() *freeze {
+	freezeOnce.Do(func() { frozen = syncState.Frozen })
+	freezeMu.Lock()
+	defer freezeMu.Unlock()
+	return frozen
+}
+
+// applying reports whether cycles apply their changes: in sync mode and
+// while not frozen.
+func applying() bool {
+	return cfg().Mode == SyncModeSync && currentFreeze() == nil
+}
+
+func setFreeze(f *freeze) {
+	freezeOnce.Do(func() {})
+	freezeMu.Lock()
+	frozen = f
+	freezeMu.Unlock()
+}
+
+func init() {
+	handle("/freeze", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			if f := currentFreeze(); f != nil {
+				http.Error(w, f.String(), http.StatusConflict)
+				return
+			}
+			f := &freeze{Since: time.Now().UTC(), Reason: r.FormValue("reason"), By: caller(r)}
+			setFreeze(f)
+			slog.Info("records frozen", "by", f.By, "reason", f.Reason)
+			notify(r.Context(), event{Type: "freeze", Message: "DNS records " + f.String()})
+			// saves the freeze and reports the drift it holds back
+			requestSync("freeze", f.By)
+		case http.MethodDelete:
+			f := currentFreeze()
+			if f == nil {
+				http.Error(w, "not frozen", http.StatusConflict)
+				return
+			}
+			setFreeze(nil)
+			slog.Info("records unfrozen", "frozen", f.String())
+			notify(r.Context(), event{Type: "unfreeze", Message: fmt.Sprintf("DNS records unfrozen after %s", time.Since(f.Since).Round(time.Second))})
+			requestSync("unfreeze", caller(r))
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "use POST or DELETE", http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	registerCommand("freeze", func(ctx context.Context, args []string) error { return runFreeze(ctx, "freeze", args) })
+	registerCommand("unfreeze", func(ctx context.Context, args []string) error { return runFreeze(ctx, "unfreeze", args) })
+}
+
+// runFreeze implements `tailscale-dns-sync freeze` and `unfreeze`: they stop
+// and resume the changes of the running daemon, e.g. during an incident,
+// without stopping its drift reports.
+func runFreeze(ctx context.Context, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	reason := fs.String("reason", "", "why the records are frozen, kept in the state and notifications")
+	target := fs.String("url", "", "daemon URL, default from the listen config")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	method := http.MethodPost
+	if name == "unfreeze" {
+		method = http.MethodDelete
+	}
+	if err := callDaemon(ctx, *target, method, "/freeze", url.Values{"reason": {*reason}}); err != nil {
+		return err
+	}
+	slog.Info(name + " done")
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+)
+
+var (
+	metricRecordVerified = newMetric("gauge", "record_last_verified_timestamp_seconds", "Unix time a managed record was last seen matching the tailnet, by zone and name.")
+	metricRecordUpdated  = newMetric("gauge", "record_last_updated_timestamp_seconds", "Unix time a managed record was last written by the sync, by zone and name.")
+)
+
+// markFresh stamps the cached records of res.zone: records that needed no
+// change were verified now, applied ones were also updated now. Stamps of
+// records that are still pending keep their previous value, so a record the
+// sync keeps failing to fix goes stale in the metrics.
+func markFresh(res *zoneResult, previous map[string]stateRecord, now time.Time) {
+	pending := map[string]bool{}
+	for _, c := range res.planned {
+		pending[c.key()] = true
+	}
+	applied := map[string]bool{}
+	for _, c := range res.applied {
+		applied[c.key()] = true
+	}
+	cached := syncState.Records[res.zone.Name]
+	for key, r := range cached {
+		prev := previous[key]
+		r.Verified, r.Updated = prev.Verified, prev.Updated
+		switch {
+		case applied[key]:
+			r.Verified, r.Updated = now, now
+		case !pending[key]:
+			r.Verified = now
+		}
+		cached[key] = r
+	}
+}
+
+// exportFreshness publishes the freshness gauges of every cached record, up
+// to RECORD_METRICS_LIMIT series per gauge to keep label cardinality bounded.
+func exportFreshness() {
+	metricRecordVerified.Reset()
+	metricRecordUpdated.Reset()
+	if cfg().RecordMetricsLimit <= 0 {
+		return
+	}
+	zoneNames := make([]string, 0, len(syncState.Records))
+	for name := range syncState.Records {
+		zoneNames = append(zoneNames, name)
+	}
+	sort.Strings(zoneNames)
+	n := 0
+	for _, zoneName := range zoneNames {
+		keys := make([]string, 0, len(syncState.Records[zoneName]))
+		for key := range syncState.Records[zoneName] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if n >= cfg().RecordMetricsLimit {
+				slog.Warn("too many managed records, freshness metrics are truncated", "limit", cfg().RecordMetricsLimit)
+				return
+			}
+			n++
+			r := syncState.Records[zoneName][key]
+			if !r.Verified.IsZero() {
+				metricRecordVerified.Set(float64(r.Verified.Unix()), "zone", zoneName, "name", key)
+			}
+			if !r.Updated.IsZero() {
+				metricRecordUpdated.Set(float64(r.Updated.Unix()), "zone", zoneName, "name", key)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fullAuditDue reports whether the cycle starting at now runs the full audit.
+func fullAuditDue(now time.Time) bool {
+	return cfg().FullAuditInterval > 0 && now.Sub(syncState.LastFullAudit) >= cfg().FullAuditInterval
+}
+
+// listAll lists the records we manage in z with the provider's complete
+// listing, without narrowing it to the suffix on the server.
+func listAll(ctx context.Context, z *zone) (map[string]record, error) {
+	records, err := z.provider.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	foreign, err := foreignRegistry(ctx, z, "")
+	if err != nil {
+		return nil, err
+	}
+	records = append(records, foreign...)
+	out := map[string]record{}
+	keepManaged(out, z, z.registry.filter(z, records))
+	return out, nil
+}
+
+// stateDiscrepancies compares the complete listing of z with the records the
+// state says the sync left there, in both directions.
+func stateDiscrepancies(z *zone, records map[string]record) []string {
+	cached, ok := syncState.Records[z.Name]
+	if !ok {
+		// nothing to compare with before the first cycle
+		return nil
+	}
+	out := externalChanges(z, records)
+	for key, r := range records {
+		if _, ok := cached[key]; !ok {
+			out = append(out, fmt.Sprintf("%s %s %s is missing from the state", key, r.Type, r.Content))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// reportFullAudit logs and notifies what the full audit found and repaired,
+// and schedules the next one once every zone was covered.
+func reportFullAudit(ctx context.Context, results []*zoneResult) {
+	var (
+		lines    []string
+		found    int
+		repaired int
+		complete = true
+	)
+	for _, res := range results {
+		if res.err != nil {
+			complete = false
+			lines = append(lines, fmt.Sprintf("%s: failed: %v", res.zone.Name, res.err))
+			continue
+		}
+		for _, msg := range res.audit {
+			slog.Warn("full audit discrepancy", "zone", res.zone.Name, "discrepancy", msg)
+		}
+		found += len(res.audit)
+		repaired += len(res.applied)
+		lines = append(lines, fmt.Sprintf("%s: %d record(s) listed, %d discrepancies with the state, %d change(s) planned, %d applied, %d failed",
+			res.zone.Name, len(res.records), len(res.audit), len(res.planned), len(res.applied), len(res.failed)))
+	}
+	severity := severityInfo
+	if found > 0 || repaired > 0 || !complete {
+		severity = severityWarning
+	}
+	notify(ctx, event{
+		Type:     "full_audit",
+		Severity: severity,
+		Message:  "full audit: " + strings.Join(lines, "; "),
+	})
+	if complete {
+		syncState.LastFullAudit = time.Now()
+	}
+	slog.Info("full audit done", "discrepancies", found, "applied", repaired)
+}
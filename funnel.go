@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// funnelProviders are the public DNS providers FUNNEL_NAMES can go to.
+var funnelProviders = []string{"cloudflare", "clouddns", "digitalocean", "memory", "powerdns", "rfc2136", "route53", "webhook"}
+
+// funnelName is a public name pointing at a node's Funnel hostname, its
+// MagicDNS name, e.g. blog.example.com for the node blog-server.
+type funnelName struct {
+	Name string `yaml:"name"`
+	Node string `yaml:"node"`
+}
+
+// parseFunnelNames parses FUNNEL_NAMES, "name=node,...".
+func parseFunnelNames(v string) ([]funnelName, error) {
+	var out []funnelName
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, node, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid funnel name %q, want name=node", part)
+		}
+		out = append(out, funnelName{Name: strings.TrimSpace(name), Node: strings.TrimSpace(node)})
+	}
+	return out, nil
+}
+
+func (f *funnelName) validate() error {
+	f.Name = strings.ToLower(strings.TrimSuffix(f.Name, "."))
+	label, parent, _ := strings.Cut(f.Name, ".")
+	if !labelRe.MatchString(label) || !strings.Contains(parent, ".") {
+		return fmt.Errorf("funnel_names: %q must be a name below a zone, e.g. blog.example.com", f.Name)
+	}
+	if f.Node == "" || strings.Contains(f.Node, ".") {
+		return fmt.Errorf("funnel_names: %s: node %q must be a MagicDNS short name", f.Name, f.Node)
+	}
+	return nil
+}
+
+// funnelHosts returns the CNAME record of the funnel zone z, pointing at its
+// node's MagicDNS name while the node is in the tailnet. For this node it
+// is only published while its Serve setup allows Funnel; the Funnel state
+// of peers isn't visible, so theirs is published as configured.
+func funnelHosts(st *ipnstate.Status, z *zone) map[string]host {
+	out := map[string]host{}
+	label, _, _ := strings.Cut(z.funnel.Name, ".")
+	peers := []*ipnstate.PeerStatus{st.Self}
+	for _, ps := range st.Peer {
+		peers = append(peers, ps)
+	}
+	for _, ps := range peers {
+		if ps == nil || getName(ps.DNSName) != z.funnel.Node {
+			continue
+		}
+		online := ps.Online
+		if ps == st.Self {
+			serveMu.Lock()
+			allowed := serveFunnel
+			serveMu.Unlock()
+			if features.Serve && !allowed {
+				break
+			}
+			online = true
+		}
+		target := strings.TrimSuffix(ps.DNSName, ".")
+		out[recordKey("CNAME", label, target)] = host{Name: label, Type: "CNAME", Content: target, Online: online, TTL: cfg().TTL, NodeID: string(ps.ID)}
+		break
+	}
+	return out
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+)
+
+// SourceHeadscale reads the tailnet from a Headscale server's REST API.
+const SourceHeadscale = "headscale"
+
+// headscaleNode is the part of a node in Headscale's API we use.
+type headscaleNode struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	GivenName   string   `json:"givenName"`
+	IPAddresses []string `json:"ipAddresses"`
+	User        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+	LastSeen   time.Time `json:"lastSeen"`
+	Online     bool      `json:"online"`
+	ForcedTags []string  `json:"forcedTags"`
+	ValidTags  []string  `json:"validTags"`
+}
+
+// headscaleGet decodes the Headscale resource at path into out, using
+// HEADSCALE_API_KEY.
+func headscaleGet(ctx context.Context, path string, out any) error {
+	apiKey := secretEnv("HEADSCALE_API_KEY")
+	if apiKey == "" {
+		return errors.New("set HEADSCALE_API_KEY, see headscale apikeys create")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(cfg().HeadscaleURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// headscaleStatus builds a tailscaled-style status from Headscale's nodes,
+// with an empty Self like the Admin API source.
+func headscaleStatus(ctx context.Context) (*ipnstate.Status, error) {
+	var out struct {
+		Nodes []headscaleNode `json:"nodes"`
+	}
+	if err := headscaleGet(ctx, "/api/v1/node", &out); err != nil {
+		return nil, fmt.Errorf("list headscale nodes: %w", err)
+	}
+	st := &ipnstate.Status{
+		Self: &ipnstate.PeerStatus{},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{},
+		User: map[tailcfg.UserID]tailcfg.UserProfile{},
+	}
+	if cfg().HeadscaleBaseDomain != "" {
+		st.CurrentTailnet = &ipnstate.TailnetStatus{MagicDNSSuffix: cfg().HeadscaleBaseDomain}
+	}
+	for _, n := range out.Nodes {
+		// givenName is the DNS label, name what the node calls itself
+		label := n.GivenName
+		if label == "" {
+			label = n.Name
+		}
+		ps := &ipnstate.PeerStatus{
+			ID:       tailcfg.StableNodeID(n.ID),
+			HostName: n.Name,
+			DNSName:  label + ".",
+			LastSeen: n.LastSeen,
+			Online:   n.Online,
+		}
+		if cfg().HeadscaleBaseDomain != "" {
+			ps.DNSName = label + "." + cfg().HeadscaleBaseDomain + "."
+		}
+		if uid, err := strconv.ParseInt(n.User.ID, 10, 64); err == nil {
+			ps.UserID = tailcfg.UserID(uid)
+			st.User[ps.UserID] = tailcfg.UserProfile{ID: ps.UserID, LoginName: n.User.Name}
+		}
+		for _, a := range n.IPAddresses {
+			if ip, err := netip.ParseAddr(a); err == nil {
+				ps.TailscaleIPs = append(ps.TailscaleIPs, ip)
+			}
+		}
+		var tags []string
+		for _, t := range append(n.ForcedTags, n.ValidTags...) {
+			if !slices.Contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+		if len(tags) > 0 {
+			v := views.SliceOf(tags)
+			ps.Tags = &v
+		}
+		st.Peer[key.NewNode().Public()] = ps
+	}
+	return st, nil
+}
+
+// headscaleKeyExpiry returns when HEADSCALE_API_KEY expires, found by its
+// prefix among the server's API keys.
+func headscaleKeyExpiry(ctx context.Context) (time.Time, error) {
+	// keys look like hskey-api-<prefix>-<secret>, or <prefix>.<secret>
+	// before Headscale 0.26
+	apiKey := secretEnv("HEADSCALE_API_KEY")
+	prefix, _, _ := strings.Cut(apiKey, ".")
+	if rest, ok := strings.CutPrefix(apiKey, "hskey-api-"); ok {
+		prefix, _, _ = strings.Cut(rest, "-")
+	}
+	var out struct {
+		APIKeys []struct {
+			Prefix     string    `json:"prefix"`
+			Expiration time.Time `json:"expiration"`
+		} `json:"apiKeys"`
+	}
+	if err := headscaleGet(ctx, "/api/v1/apikey", &out); err != nil {
+		return time.Time{}, fmt.Errorf("list headscale API keys: %w", err)
+	}
+	for _, k := range out.APIKeys {
+		if k.Prefix == prefix {
+			return k.Expiration, nil
+		}
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// healthCheck is the outcome of one dependency as of the last cycle.
+type healthCheck struct {
+	OK      bool      `json:"ok"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// healthReport is the body of /healthz and /readyz.
+type healthReport struct {
+	OK          bool                   `json:"ok"`
+	Leader      bool                   `json:"leader"`
+	LastCycle   *time.Time             `json:"last_cycle,omitempty"`
+	LastSuccess *time.Time             `json:"last_success,omitempty"`
+	SuccessAge  float64                `json:"last_success_age_seconds,omitempty"`
+	MaxAge      float64                `json:"max_age_seconds"`
+	Checks      map[string]healthCheck `json:"checks,omitempty"`
+	Problems    []string               `json:"problems,omitempty"`
+}
+
+var (
+	healthMu sync.Mutex
+	// healthChecks are the tailnet and zone checks of the last cycle.
+	healthChecks = map[string]healthCheck{}
+	// lastCycle and lastSuccess are when the last cycle ended and when
+	// the last one without error did, since this process started.
+	lastCycle, lastSuccess time.Time
+)
+
+// setHealth records the outcome of the check name.
+func setHealth(name string, err error) {
+	c := healthCheck{OK: err == nil, Checked: time.Now()}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	healthMu.Lock()
+	healthChecks[name] = c
+	healthMu.Unlock()
+}
+
+// recordCycle notes that the cycle r ended, for the health endpoints.
+func recordCycle(r *runReport) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	lastCycle = r.End
+	if r.Result != "error" {
+		lastSuccess = r.End
+	}
+}
+
+// healthMaxAge returns HEALTH_MAX_AGE, by default three sync intervals plus
+// the time a cycle may take.
+func healthMaxAge() time.Duration {
+	if cfg().HealthMaxAge > 0 {
+		return cfg().HealthMaxAge
+	}
+	interval := cfg().SyncInterval
+	if watching.Load() {
+		interval = max(interval, cfg().WatchFallbackInterval)
+	}
+	return 3*(interval+cfg().SyncJitter) + cfg().ZoneTimeout
+}
+
+// checkHealth builds the report of /healthz, or of /readyz when ready is set.
+// Both fail when the leader's sync loop completed no cycle within the max
+// age; readiness also needs a recent successful sync and every check
+// passing. Replicas that aren't the leader don't sync and are always ok.
+func checkHealth(ctx context.Context, ready bool) healthReport {
+	maxAge := healthMaxAge()
+	healthMu.Lock()
+	h := healthReport{
+		OK:     true,
+		Leader: leaderLock == nil || leader.Load(),
+		MaxAge: maxAge.Seconds(),
+		Checks: make(map[string]healthCheck, len(healthChecks)),
+	}
+	for name, c := range healthChecks {
+		h.Checks[name] = c
+	}
+	cycle, success := lastCycle, lastSuccess
+	healthMu.Unlock()
+	if !cycle.IsZero() {
+		h.LastCycle = &cycle
+	}
+	if !success.IsZero() {
+		h.LastSuccess = &success
+		h.SuccessAge = time.Since(success).Seconds()
+	}
+	if !h.Leader {
+		return h
+	}
+	since := cycle
+	if since.IsZero() {
+		since = processStart
+	}
+	if time.Since(since) > maxAge {
+		h.Problems = append(h.Problems, "no sync cycle completed within the max age")
+	}
+	if ready {
+		if cfg().Source == SourceTailscaled {
+			// tailscaled is local, so ask it now rather than trust the last cycle
+			cctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			_, err := lc().StatusWithoutPeers(cctx)
+			cancel()
+			c := healthCheck{OK: err == nil, Checked: time.Now()}
+			if err != nil {
+				c.Error = err.Error()
+			}
+			h.Checks["tailnet"] = c
+		}
+		if success.IsZero() || time.Since(success) > maxAge {
+			h.Problems = append(h.Problems, "no successful sync within the max age")
+		}
+		names := make([]string, 0, len(h.Checks))
+		for name := range h.Checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if c := h.Checks[name]; !c.OK {
+				h.Problems = append(h.Problems, name+": "+c.Error)
+			}
+		}
+	}
+	h.OK = len(h.Problems) == 0
+	return h
+}
+
+func serveHealth(ready bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h := checkHealth(r.Context(), ready)
+		w.Header().Set("Content-Type", "application/json")
+		if !h.OK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(h)
+	}
+}
+
+func init() {
+	// probes can't present the token, and the reports hold no secrets
+	handlePublic("/healthz", serveHealth(false))
+	handlePublic("/readyz", serveHealth(true))
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// host is a record as it should appear in DNS, normally the address record
+// of a tailnet node.
+type host struct {
+	Name string
+	Type string
+	// Content is the record content: the node's IPv4 address, or its
+	// MagicDNS name in CNAME mode. Empty when there is nothing to publish.
+	Content string
+	Online  bool
+	// TTL is the record TTL, 1 meaning the provider's automatic TTL.
+	TTL  int
+	Tags []string
+	// NodeID is the stable ID of the node the record belongs to.
+	NodeID string
+	// Comment is the policy comment appended to the ownership marker.
+	// Proxied is Cloudflare's proxy flag.
+	Comment string
+	Proxied bool
+}
+
+// nodeMarker precedes the node ID in the ownership marker.
+const nodeMarker = "node="
+
+// comment returns the full comment the record of h carries: the ownership
+// marker, the node it belongs to and the policy comment.
+func (h host) comment() string {
+	c := cfg().OwnershipMarker
+	if h.NodeID != "" {
+		c += " " + nodeMarker + h.NodeID
+	}
+	if h.Comment != "" {
+		c += " " + h.Comment
+	}
+	return c
+}
+
+// ownedRest returns what follows the ownership marker in comment, ok when
+// it starts with OWNERSHIP_MARKER or one of LEGACY_MARKERS.
+func ownedRest(comment string) (string, bool) {
+	for _, m := range append([]string{cfg().OwnershipMarker}, cfg().LegacyMarkers...) {
+		if comment == m {
+			return "", true
+		}
+		if rest, ok := strings.CutPrefix(comment, m+" "); ok {
+			return rest, true
+		}
+	}
+	return "", false
+}
+
+// ownedComment reports whether a record with comment belongs to the sync.
+// The marker must be followed by a space or nothing, so `_tailscale` doesn't
+// claim the records of another instance marking them `_tailscale-lab`.
+func ownedComment(comment string) bool {
+	_, ok := ownedRest(comment)
+	return ok
+}
+
+// legacyComment reports whether comment carries one of LEGACY_MARKERS
+// rather than OWNERSHIP_MARKER.
+func legacyComment(comment string) bool {
+	return comment != cfg().OwnershipMarker && !strings.HasPrefix(comment, cfg().OwnershipMarker+" ") && ownedComment(comment)
+}
+
+// commentNodeID returns the node ID recorded in a record comment, empty for
+// records written before node IDs were tracked.
+func commentNodeID(comment string) string {
+	rest, ok := ownedRest(comment)
+	if !ok {
+		return ""
+	}
+	field, _, _ := strings.Cut(rest, " ")
+	id, _ := strings.CutPrefix(field, nodeMarker)
+	if id == field {
+		return ""
+	}
+	return id
+}
+
+func getName(name string) string {
+	// normalize name
+	s := strings.Split(strings.ToLower(name), ".")
+	if len(s) > 0 {
+		return s[0]
+	}
+	return ""
+}
+
+// nameAllowed reports whether the host name passes INCLUDE_NAMES and
+// EXCLUDE_NAMES.
+func nameAllowed(name string) bool {
+	for _, re := range cfg().excludeNames {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(cfg().includeNames) == 0 {
+		return true
+	}
+	for _, re := range cfg().includeNames {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Strategies of NAME_COLLISIONS.
+const (
+	CollisionsSkip   = "skip"
+	CollisionsUser   = "user"
+	CollisionsNumber = "number"
+)
+
+// Name modes of NAME_MODE.
+const (
+	NameModeShort = "short"
+	NameModeFQDN  = "fqdn"
+)
+
+var metricCollisions = newMetric("gauge", "name_collisions", "Nodes whose name another node already had in the last cycle, by how they were handled: skipped or renamed.")
+
+// nameData is what NAME_TEMPLATE is rendered with.
+type nameData struct {
+	// Host is the node's MagicDNS name without the tailnet suffix.
+	Host string
+	// User is the owner's login name up to the @, Login all of it.
+	User  string
+	Login string
+	OS    string
+	Tags  []string
+}
+
+var labelUnsafe = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// hostName returns the name ps is published under: the name it asked for
+// with a dns-name tag or attribute, its MagicDNS short name (or with
+// NAME_MODE=fqdn all of its MagicDNS name before the suffix), or
+// NAME_TEMPLATE rendered for it and made a valid DNS label, "" when that
+// fails.
+func hostName(st *ipnstate.Status, ps *ipnstate.PeerStatus) string {
+	if name := readDeviceOverrides(ps).Name; name != "" {
+		return name
+	}
+	short := getName(ps.DNSName)
+	if cfg().NameMode == NameModeFQDN {
+		short = magicDNSName(st, ps.DNSName)
+	}
+	if cfg().nameTmpl == nil || short == "" {
+		return short
+	}
+	d := nameData{Host: short, OS: ps.OS}
+	d.User, d.Login = nodeUser(st, ps)
+	if ps.Tags != nil {
+		d.Tags = ps.Tags.AsSlice()
+	}
+	var b strings.Builder
+	if err := cfg().nameTmpl.Execute(&b, d); err != nil {
+		slog.Warn("name_template failed", "host", short, logErr(err))
+		return ""
+	}
+	return dnsLabel(b.String())
+}
+
+// dnsLabel lower-cases s and replaces what isn't valid in a DNS label,
+// dots included, with dashes.
+func dnsLabel(s string) string {
+	name := strings.Trim(labelUnsafe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
+
+// magicDNSSuffix returns MAGICDNS_SUFFIX, or the tailnet's MagicDNS suffix
+// as st reports it, or as this node's name shows it when st has no tailnet.
+func magicDNSSuffix(st *ipnstate.Status) string {
+	if cfg().MagicDNSSuffix != "" {
+		return cfg().MagicDNSSuffix
+	}
+	if st.CurrentTailnet != nil && st.CurrentTailnet.MagicDNSSuffix != "" {
+		return strings.ToLower(strings.Trim(st.CurrentTailnet.MagicDNSSuffix, "."))
+	}
+	if st.Self != nil {
+		_, suffix, _ := strings.Cut(strings.ToLower(strings.TrimSuffix(st.Self.DNSName, ".")), ".")
+		return suffix
+	}
+	return ""
+}
+
+// magicDNSName returns dnsName without the MagicDNS suffix, its labels
+// joined with dashes: `web.prod` of `web.prod.tail1234.ts.net` becomes
+// `web-prod`. Shared nodes, named in their own tailnet, only lose the domain
+// the tailnets share, so `nas.other.ts.net` becomes `nas-other` and doesn't
+// collide with a `nas` of this tailnet.
+func magicDNSName(st *ipnstate.Status, dnsName string) string {
+	name := strings.ToLower(strings.TrimSuffix(dnsName, "."))
+	suffix := magicDNSSuffix(st)
+	if rest, ok := strings.CutSuffix(name, "."+suffix); ok && suffix != "" {
+		name = rest
+	} else if _, base, _ := strings.Cut(suffix, "."); strings.Contains(base, ".") {
+		name = strings.TrimSuffix(name, "."+base)
+	}
+	return dnsLabel(name)
+}
+
+// nodeUser returns the login name of ps's owner up to the @, and all of it.
+func nodeUser(st *ipnstate.Status, ps *ipnstate.PeerStatus) (user, login string) {
+	if u, ok := st.User[ps.UserID]; ok {
+		login = u.LoginName
+		user, _, _ = strings.Cut(login, "@")
+	}
+	return user, login
+}
+
+// resolveCollision returns the name NAME_COLLISIONS gives ps when name
+// already belongs to another node, "" to leave ps out.
+func resolveCollision(st *ipnstate.Status, ps *ipnstate.PeerStatus, name string, taken func(string) bool) string {
+	switch cfg().NameCollisions {
+	case CollisionsUser:
+		if user, _ := nodeUser(st, ps); user != "" {
+			renamed := strings.Trim(labelUnsafe.ReplaceAllString(strings.ToLower(user), "-"), "-") + "-" + name
+			if len(renamed) <= 63 && !taken(renamed) {
+				return renamed
+			}
+		}
+	case CollisionsNumber:
+		for i := 2; i < 100; i++ {
+			renamed := fmt.Sprintf("%s-%d", name, i)
+			if len(renamed) <= 63 && !taken(renamed) {
+				return renamed
+			}
+		}
+	}
+	return ""
+}
+
+// desiredHosts returns name => host for every node in the tailnet.
+func desiredHosts(st *ipnstate.Status) map[string]host {
+	hosts := map[string]host{}
+	// owners maps names to their node, the first of the nodes sorted by
+	// MagicDNS name when NAME_TEMPLATE gives several the same name
+	owners := map[string]string{}
+	taken := func(name string) bool {
+		_, ok := owners[name]
+		return ok
+	}
+	collisions := map[string]int{"skipped": 0, "renamed": 0}
+	add := func(ps *ipnstate.PeerStatus) {
+		if readDeviceOverrides(ps).Skip {
+			return
+		}
+		name := hostName(st, ps)
+		if name == "" || syncState.Excluded[name] {
+			return
+		}
+		if owner, ok := owners[name]; ok && owner != string(ps.ID) {
+			renamed := resolveCollision(st, ps, name, taken)
+			if renamed == "" {
+				collisions["skipped"]++
+				warnOnce("name "+name+" "+string(ps.ID), "not publishing the node, the name belongs to another node", "node", ps.DNSName, "host", name)
+				return
+			}
+			collisions["renamed"]++
+			warnOnce("name "+name+" "+string(ps.ID), "publishing the node under another name, the name belongs to another node", "node", ps.DNSName, "host", renamed, "taken", name)
+			name = renamed
+			if syncState.Excluded[name] {
+				return
+			}
+		}
+		h := host{Name: name, Type: cfg().RecordType, NodeID: string(ps.ID), Online: ps.Online, TTL: cfg().TTL, Proxied: cfg().Proxied}
+		if ps.Tags != nil {
+			h.Tags = ps.Tags.AsSlice()
+		}
+		if !tagsAllowed(h.Tags) || !nameAllowed(name) {
+			return
+		}
+		owners[name] = string(ps.ID)
+		if cfg().RecordType == "CNAME" {
+			h.Content = strings.TrimSuffix(ps.DNSName, ".")
+			hosts[name] = h
+			return
+		}
+		// a family without an address keeps an empty host, which leaves an
+		// existing record alone rather than deleting it, unless the peer is
+		// IPv6-only: then its A record goes
+		addrs := candidateAddrs(ps)
+		v6only := pickAddr(addrs, netip.Addr.Is4) == "" && pickAddr(addrs, netip.Addr.Is6) != ""
+		if cfg().AddressFamily != AddressFamilyIPv6 && !v6only {
+			for _, addr := range publishedAddrs(addrs, netip.Addr.Is4) {
+				v4 := h
+				v4.Type = "A"
+				v4.Content = addr
+				hosts[recordKey("A", name, addr)] = v4
+			}
+		}
+		if v6only && cfg().AddressFamily == AddressFamilyIPv4 && cfg().IPv6OnlyPeers == IPv6OnlyExclude {
+			slog.Info("not publishing the host, it has no IPv4 address (ipv6_only_peers: exclude)", "host", name)
+			return
+		}
+		if cfg().AddressFamily != AddressFamilyIPv4 || v6only {
+			for _, addr := range publishedAddrs(addrs, netip.Addr.Is6) {
+				v6 := h
+				v6.Type = "AAAA"
+				v6.Content = addr
+				hosts[recordKey("AAAA", name, addr)] = v6
+			}
+		}
+	}
+	// add self name, which tailscaled always reports as offline
+	add(st.Self)
+	for key, h := range hosts {
+		h.Online = true
+		hosts[key] = h
+	}
+	// add peer name
+	peers := make([]*ipnstate.PeerStatus, 0, len(st.Peer))
+	for _, ps := range st.Peer {
+		peers = append(peers, ps)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].DNSName < peers[j].DNSName })
+	for _, ps := range peers {
+		add(ps)
+	}
+	for how, n := range collisions {
+		metricCollisions.Set(float64(n), "handled", how)
+	}
+	addDeviceAliases(st, hosts)
+	addVia6Hosts(st, hosts)
+	addSubnetHosts(st, hosts)
+	addTagSubdomains(hosts)
+	addWildcards(hosts)
+	addExitNodes(st, hosts)
+	addMetadataTXT(st, hosts)
+	return hosts
+}
+
+// trackStability records when each host's content or online state last
+// changed and assigns the TTL of the matching DYNAMIC_TTL tier. With
+// ONLINE_ONLY it then leaves out the hosts offline past OFFLINE_GRACE.
+func trackStability(hosts map[string]host, now time.Time) {
+	if syncState.Hosts == nil {
+		syncState.Hosts = map[string]hostState{}
+	}
+	for name, h := range hosts {
+		prev, ok := syncState.Hosts[name]
+		hs := prev
+		if !ok || hs.Content != h.Content || hs.Online != h.Online {
+			hs = hostState{Content: h.Content, Online: h.Online, Since: now, OfflineSince: prev.OfflineSince}
+		}
+		switch {
+		case h.Online:
+			hs.OfflineSince = time.Time{}
+		case hs.OfflineSince.IsZero():
+			hs.OfflineSince = now
+		}
+		syncState.Hosts[name] = hs
+		if ttl := dynamicTTL(now.Sub(hs.Since)); ttl > 0 {
+			h.TTL = ttl
+			hosts[name] = h
+		}
+	}
+	for name := range syncState.Hosts {
+		if _, ok := hosts[name]; !ok {
+			delete(syncState.Hosts, name)
+		}
+	}
+	if cfg().OnlineOnly {
+		// after the bookkeeping above, so the hosts dropped keep their
+		// offline time
+		for name := range hosts {
+			if offlineTooLong(name, now) {
+				delete(hosts, name)
+			}
+		}
+	}
+}
+
+// offlineTooLong reports whether the host name has been offline for longer
+// than OFFLINE_GRACE.
+func offlineTooLong(name string, now time.Time) bool {
+	hs, ok := syncState.Hosts[name]
+	return ok && !hs.OfflineSince.IsZero() && now.Sub(hs.OfflineSince) >= cfg().OfflineGrace
+}
+
+// dynamicTTL returns the TTL of the longest DYNAMIC_TTL tier a host stable
+// for d qualifies for, or 0 when dynamic TTLs are off.
+func dynamicTTL(d time.Duration) int {
+	ttl := 0
+	for _, t := range cfg().DynamicTTL {
+		if d >= t.StableFor {
+			ttl = t.TTL
+		}
+	}
+	return ttl
+}
@@ -0,0 +1,197 @@
+//go:build !no_hostsfile
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// hostsFileMu serializes the rewrites of the file, which every zone shares.
+var hostsFileMu sync.Mutex
+
+func init() {
+	registerProvider("file", newHostsFileProvider)
+}
+
+// hostsFileProvider renders the records of a zone into an /etc/hosts style
+// file, e.g. a dnsmasq addn-hosts file, for setups without any DNS API. Our
+// lines end with the ownership marker as a comment; other lines are kept as
+// they are.
+//
+//	100.64.0.1	laptop.int.example.com	# _tailscale node=nXXXX
+type hostsFileProvider struct {
+	path string
+	zone string
+}
+
+func newHostsFileProvider(ctx context.Context, name string) (provider, error) {
+	path := os.Getenv("HOSTS_FILE")
+	if path == "" {
+		return nil, fmt.Errorf("set HOSTS_FILE, e.g. /etc/dnsmasq.hosts/tailscale")
+	}
+	// created readable up front, rewrites keep the permissions
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &hostsFileProvider{path: path, zone: name}, nil
+}
+
+// hostsLine is a line of the file, ours when comment has the marker.
+type hostsLine struct {
+	text    string
+	addr    string
+	name    string
+	comment string
+}
+
+func (l hostsLine) owned() bool {
+	return ownedComment(l.comment)
+}
+
+func (p *hostsFileProvider) read() ([]hostsLine, error) {
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	var out []hostsLine
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		l := hostsLine{text: sc.Text()}
+		entry, comment, _ := strings.Cut(l.text, "#")
+		if f := strings.Fields(entry); len(f) == 2 {
+			l.addr, l.name, l.comment = f[0], strings.ToLower(f[1]), strings.TrimSpace(comment)
+		}
+		out = append(out, l)
+	}
+	return out, sc.Err()
+}
+
+// write replaces the file with lines and tells dnsmasq to reread it.
+func (p *hostsFileProvider) write(lines []hostsLine) error {
+	var b bytes.Buffer
+	for _, l := range lines {
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+	if err := writeFileAtomic(p.path, b.Bytes()); err != nil {
+		return err
+	}
+	pidFile := os.Getenv("HOSTS_FILE_RELOAD_PID")
+	if pidFile == "" {
+		return nil
+	}
+	pid, err := os.ReadFile(pidFile)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(pid)))
+	if err != nil {
+		return fmt.Errorf("reload: bad pid file %s: %w", pidFile, err)
+	}
+	if err := signalReload(n); err != nil {
+		return fmt.Errorf("reload: SIGHUP %d: %w", n, err)
+	}
+	return nil
+}
+
+func (p *hostsFileProvider) List(ctx context.Context) ([]record, error) {
+	hostsFileMu.Lock()
+	defer hostsFileMu.Unlock()
+	lines, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	var out []record
+	for _, l := range lines {
+		if !l.owned() || (l.name != p.zone && !strings.HasSuffix(l.name, "."+p.zone)) {
+			continue
+		}
+		ip, err := netip.ParseAddr(l.addr)
+		if err != nil {
+			continue
+		}
+		typ := "A"
+		if ip.Is6() {
+			typ = "AAAA"
+		}
+		// hosts files have no TTL, report it as automatic
+		out = append(out, record{ID: l.name + " " + l.addr, Name: l.name, Type: typ, Content: l.addr, Comment: l.comment})
+	}
+	return out, nil
+}
+
+// ApplyBatch applies chunk with a single rewrite of the file.
+func (p *hostsFileProvider) ApplyBatch(ctx context.Context, chunk []change) ([]change, error) {
+	hostsFileMu.Lock()
+	defer hostsFileMu.Unlock()
+	lines, err := p.read()
+	if err != nil {
+		return nil, err
+	}
+	done := make([]change, 0, len(chunk))
+	for _, c := range chunk {
+		if lines, err = p.apply(lines, c); err != nil {
+			return nil, err
+		}
+		if c.Action == actionCreate {
+			c.RecordID = c.fqdn() + " " + c.Content
+		}
+		done = append(done, c)
+	}
+	return done, p.write(lines)
+}
+
+// apply returns lines with c applied.
+func (p *hostsFileProvider) apply(lines []hostsLine, c change) ([]hostsLine, error) {
+	if c.Type != "A" && c.Type != "AAAA" {
+		return nil, fmt.Errorf("%s %s: hosts files only hold A and AAAA records", c.fqdn(), c.Type)
+	}
+	line := hostsLine{text: c.Content + "\t" + c.fqdn() + "\t# " + c.Comment, addr: c.Content, name: c.fqdn(), comment: c.Comment}
+	if c.Action == actionCreate {
+		return append(lines, line), nil
+	}
+	for i, l := range lines {
+		if l.owned() && l.name+" "+l.addr == c.RecordID {
+			if c.Action == actionDelete {
+				return append(lines[:i], lines[i+1:]...), nil
+			}
+			lines[i] = line
+			return lines, nil
+		}
+	}
+	if c.Action == actionDelete {
+		// already gone
+		return lines, nil
+	}
+	slog.Warn("hosts file line vanished, adding it again", "path", p.path, "line", c.RecordID)
+	return append(lines, line), nil
+}
+
+// edit applies a single change.
+func (p *hostsFileProvider) edit(ctx context.Context, c change) error {
+	_, err := p.ApplyBatch(ctx, []change{c})
+	return err
+}
+
+func (p *hostsFileProvider) Create(ctx context.Context, c change) (string, error) {
+	return c.fqdn() + " " + c.Content, p.edit(ctx, c)
+}
+
+func (p *hostsFileProvider) Update(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+func (p *hostsFileProvider) Delete(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
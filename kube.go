@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// errKubeNotFound is returned by kubeClient.do for 404 responses.
+var errKubeNotFound = errors.New("kubernetes object not found")
+
+// errKubeConflict is returned by kubeClient.do for 409 responses.
+var errKubeConflict = errors.New("kubernetes object changed concurrently")
+
+// kubeClient is a minimal in-cluster Kubernetes API client.
+type kubeClient struct {
+	host      string
+	namespace string
+	http      *http.Client
+}
+
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster")
+	}
+	ca, err := os.ReadFile(kubeServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid service account ca.crt")
+	}
+	ns, err := os.ReadFile(kubeServiceAccountDir + "/namespace")
+	if err != nil {
+		return nil, err
+	}
+	return &kubeClient{
+		host:      "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(ns)),
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// do sends in as JSON to path and decodes the response into out.
+func (c *kubeClient) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, body)
+	if err != nil {
+		return err
+	}
+	// the projected token is rotated by the kubelet, so read it on every call
+	token, err := os.ReadFile(kubeServiceAccountDir + "/token")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return errKubeNotFound
+	case resp.StatusCode == http.StatusConflict:
+		return errKubeConflict
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("kubernetes %s %s: %s: %s", method, path, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
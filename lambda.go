@@ -0,0 +1,64 @@
+//go:build !no_lambda
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+func init() {
+	if api := os.Getenv("AWS_LAMBDA_RUNTIME_API"); api != "" {
+		lambdaRuntime = func(ctx context.Context) error { return runLambda(ctx, api) }
+	}
+}
+
+// runLambda serves invocations through the Lambda runtime API when the
+// binary is the bootstrap of a custom runtime (provided.al2). Every
+// invocation, typically from an EventBridge schedule, runs one sync cycle
+// and returns its report; a failed cycle is reported as an invocation error.
+func runLambda(ctx context.Context, api string) error {
+	if cfg().Source == SourceTailscaled {
+		return fmt.Errorf("there is no tailscaled in Lambda, set SOURCE=api or headscale")
+	}
+	base := "http://" + api + "/2018-06-01/runtime/invocation/"
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"next", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("next invocation: %w", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		deadline := time.Now().Add(cfg().ZoneTimeout)
+		if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			deadline = time.UnixMilli(ms)
+		}
+		ictx, cancel := context.WithDeadline(ctx, deadline)
+		// the state may have been written by another invocation meanwhile
+		if err := loadState(ictx); err != nil {
+			slog.Warn("load the state failed", logErr(err))
+		}
+		report := reconcile(ictx)
+		cancel()
+		pushMetrics(ctx)
+		if report.Result == "error" {
+			err = postJSON(ctx, base+id+"/error", map[string]string{"errorMessage": report.Error, "errorType": "SyncError"})
+		} else {
+			err = postJSON(ctx, base+id+"/response", report)
+		}
+		if err != nil {
+			slog.Warn("lambda response failed", logErr(err))
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// kubeLease is a leader lock backed by a coordination.k8s.io/v1 Lease, the
+// same object client-go's leader election uses.
+type kubeLease struct {
+	client   *kubeClient
+	name     string
+	id       string
+	duration time.Duration
+}
+
+type leaseObject struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   leaseObjectMeta `json:"metadata"`
+	Spec       leaseObjectSpec `json:"spec"`
+}
+
+type leaseObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseObjectSpec struct {
+	HolderIdentity       string     `json:"holderIdentity"`
+	LeaseDurationSeconds int        `json:"leaseDurationSeconds"`
+	AcquireTime          *microTime `json:"acquireTime,omitempty"`
+	RenewTime            *microTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int        `json:"leaseTransitions"`
+}
+
+// microTime marshals like metav1.MicroTime.
+type microTime struct{ time.Time }
+
+const microTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+func (t microTime) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.UTC().Format(microTimeFormat) + `"`), nil
+}
+
+func (t *microTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	v, err := time.Parse(`"`+time.RFC3339Nano+`"`, string(b))
+	t.Time = v
+	return err
+}
+
+func newKubeLease(name, namespace string, duration time.Duration) (*kubeLease, error) {
+	c, err := newInClusterKubeClient()
+	if err != nil {
+		return nil, err
+	}
+	if namespace != "" {
+		c.namespace = namespace
+	}
+	return &kubeLease{client: c, name: name, id: instanceID(), duration: duration}, nil
+}
+
+func (l *kubeLease) path() string {
+	return fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", l.client.namespace, l.name)
+}
+
+func (l *kubeLease) TryLock(ctx context.Context) (bool, error) {
+	now := &microTime{time.Now()}
+	var lease leaseObject
+	err := l.client.do(ctx, http.MethodGet, l.path(), nil, &lease)
+	if errors.Is(err, errKubeNotFound) {
+		lease = leaseObject{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseObjectMeta{Name: l.name, Namespace: l.client.namespace},
+			Spec: leaseObjectSpec{
+				HolderIdentity:       l.id,
+				LeaseDurationSeconds: int(l.duration.Seconds()),
+				AcquireTime:          now,
+				RenewTime:            now,
+			},
+		}
+		err = l.client.do(ctx, http.MethodPost,
+			fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", l.client.namespace), lease, nil)
+		if errors.Is(err, errKubeConflict) {
+			// another replica created it first
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	spec := &lease.Spec
+	if spec.HolderIdentity != l.id {
+		if spec.HolderIdentity != "" && spec.RenewTime != nil &&
+			time.Since(spec.RenewTime.Time) < time.Duration(spec.LeaseDurationSeconds)*time.Second {
+			return false, nil
+		}
+		spec.HolderIdentity = l.id
+		spec.AcquireTime = now
+		spec.LeaseTransitions++
+	}
+	spec.LeaseDurationSeconds = int(l.duration.Seconds())
+	spec.RenewTime = now
+	// the resourceVersion makes the update fail if someone else got there first
+	err = l.client.do(ctx, http.MethodPut, l.path(), lease, nil)
+	if errors.Is(err, errKubeConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *kubeLease) Unlock(ctx context.Context) error {
+	var lease leaseObject
+	if err := l.client.do(ctx, http.MethodGet, l.path(), nil, &lease); err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity != l.id {
+		return nil
+	}
+	// mirror client-go's ReleaseOnCancel so a standby takes over immediately
+	lease.Spec.HolderIdentity = ""
+	lease.Spec.LeaseDurationSeconds = 1
+	lease.Spec.RenewTime = &microTime{time.Now()}
+	return l.client.do(ctx, http.MethodPut, l.path(), lease, nil)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// listener configures the HTTP server behind every endpoint of the daemon.
+// Nothing is served unless Addr is set, and an address without a host binds
+// to the loopback interface only.
+type listener struct {
+	// Addr is host:port, or :port for localhost.
+	Addr string `yaml:"addr"`
+	// Tailscale binds to the node's tailnet addresses on Addr's port
+	// instead, so only the tailnet (and its ACLs) can reach the endpoints.
+	Tailscale bool `yaml:"tailscale"`
+	// Token, when set, must be sent as `Authorization: Bearer <token>`.
+	Token string `yaml:"token"`
+	// Allow lets in the tailnet users (login names) and tagged nodes
+	// listed, identified by tailscaled from the connection, without a
+	// token.
+	Allow   []string `yaml:"allow"`
+	TLSCert string   `yaml:"tls_cert"`
+	TLSKey  string   `yaml:"tls_key"`
+}
+
+func (l *listener) validate() error {
+	if l.Addr == "" {
+		if l.Tailscale || l.Token != "" || len(l.Allow) > 0 || l.TLSCert != "" {
+			return fmt.Errorf("listen: addr is required")
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(l.Addr); err != nil {
+		return fmt.Errorf("listen: addr: %w", err)
+	}
+	if (l.TLSCert == "") != (l.TLSKey == "") {
+		return fmt.Errorf("listen: tls_cert and tls_key go together")
+	}
+	return nil
+}
+
+// handlers are the endpoints served by serveHTTP, keyed by pattern.
+var handlers = map[string]http.Handler{}
+
+// handle registers an endpoint on the daemon's HTTP server.
+func handle(pattern string, h http.HandlerFunc) {
+	handlers[pattern] = h
+}
+
+// selfAuthorized are the endpoints that authorize requests themselves, from
+// the caller's tailnet identity, instead of with the token or allow list.
+var selfAuthorized = map[string]bool{}
+
+// handleTailnet registers an endpoint that authorizes requests itself.
+func handleTailnet(pattern string, h http.HandlerFunc) {
+	handlers[pattern] = h
+	selfAuthorized[pattern] = true
+}
+
+// handlePublic registers an endpoint open to every caller.
+func handlePublic(pattern string, h http.HandlerFunc) {
+	handleTailnet(pattern, h)
+}
+
+// addrs returns the addresses to listen on.
+func (l *listener) addrs(ctx context.Context) ([]string, error) {
+	host, port, _ := net.SplitHostPort(l.Addr)
+	if !l.Tailscale {
+		if host == "" {
+			host = "localhost"
+		}
+		return []string{net.JoinHostPort(host, port)}, nil
+	}
+	st, err := lc().Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("tailscale status: %w", err)
+	}
+	var out []string
+	for _, ip := range st.TailscaleIPs {
+		out = append(out, net.JoinHostPort(ip.String(), port))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("the node has no tailnet address yet")
+	}
+	return out, nil
+}
+
+// callerKey keys the identity an authorized request was let in as: an
+// allowed tailnet identity or "token".
+type callerKey struct{}
+
+// caller returns who r was authorized as, "" when no check is configured.
+func caller(r *http.Request) string {
+	who, _ := r.Context().Value(callerKey{}).(string)
+	return who
+}
+
+// authorize wraps h with the checks configured: a request passes with the
+// bearer token or from an allowed tailnet identity.
+func (l *listener) authorize(h http.Handler) http.Handler {
+	if l.Token == "" && len(l.Allow) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok && l.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(l.Token)) == 1 {
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, "token")))
+			return
+		}
+		if who, err := l.allowed(r); err != nil {
+			slog.Warn("HTTP request refused", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, logErr(err))
+		} else if who != "" {
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, who)))
+			return
+		}
+		if l.Token != "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// allowed returns the allowed identity r comes from: the node's first
+// matching tag or its user's login name, or "" when none is allowed.
+func (l *listener) allowed(r *http.Request) (string, error) {
+	if len(l.Allow) == 0 {
+		return "", nil
+	}
+	who, err := lc().WhoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		// not a tailnet peer, e.g. localhost
+		return "", nil
+	}
+	if who.Node != nil {
+		for _, tag := range who.Node.Tags {
+			if slices.Contains(l.Allow, tag) {
+				return tag, nil
+			}
+		}
+		if len(who.Node.Tags) > 0 {
+			// tagged nodes act for their tags, not for the user who tagged them
+			return "", fmt.Errorf("tags %s not allowed", strings.Join(who.Node.Tags, ", "))
+		}
+	}
+	if who.UserProfile != nil && slices.Contains(l.Allow, who.UserProfile.LoginName) {
+		return who.UserProfile.LoginName, nil
+	}
+	if who.UserProfile != nil {
+		return "", fmt.Errorf("user %s not allowed", who.UserProfile.LoginName)
+	}
+	return "", nil
+}
+
+// serveHTTP starts serving the registered endpoints as configured by
+// cfg().Listen.
+func serveHTTP(ctx context.Context) error {
+	l := cfg().Listen
+	if l.Addr == "" || len(handlers) == 0 {
+		return nil
+	}
+	mux := http.NewServeMux()
+	for pattern, h := range handlers {
+		if selfAuthorized[pattern] {
+			mux.Handle(pattern, h)
+			continue
+		}
+		mux.Handle(pattern, l.authorize(h))
+	}
+	addrs, err := l.addrs(ctx)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("listen: %w", err)
+		}
+		srv := &http.Server{Handler: mux}
+		go func() {
+			slog.Info("HTTP listening", "addr", ln.Addr().String())
+			var err error
+			if l.TLSCert != "" {
+				err = srv.ServeTLS(ln, l.TLSCert, l.TLSKey)
+			} else {
+				err = srv.Serve(ln)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTP server failed", "addr", ln.Addr().String(), logErr(err))
+			}
+		}()
+		onShutdown(func() { srv.Close() })
+	}
+	return nil
+}
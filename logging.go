@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Formats of LOG_FORMAT.
+const (
+	LogFormatPlain = "plain"
+	LogFormatText  = "text"
+	LogFormatJSON  = "json"
+)
+
+// logLevels maps LOG_LEVEL values to slog levels.
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+var (
+	logLevel = new(slog.LevelVar)
+	// logFormat is the format set up last, for the pipeline supervisor to
+	// know whether it may prefix the output of its children.
+	logFormat = LogFormatPlain
+)
+
+// setupLogging sends log and slog output to stderr in format, dropping
+// records below level. The log package's lines keep working and are logged
+// at info; a pipeline child adds its pipeline to every structured record.
+func setupLogging(format, level string) error {
+	l, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return fmt.Errorf("log_level: %q is not one of debug, info, warn, error", level)
+	}
+	logLevel.Set(l)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch format {
+	case LogFormatPlain:
+		h = &plainHandler{mu: new(sync.Mutex), w: os.Stderr, level: logLevel}
+	case LogFormatText:
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case LogFormatJSON:
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("log_format: %q is not one of plain, text, json", format)
+	}
+	if pipelineName != "" && format != LogFormatPlain {
+		h = h.WithAttrs([]slog.Attr{slog.String("pipeline", pipelineName)})
+	}
+	slog.SetDefault(slog.New(h))
+	logFormat = format
+	return nil
+}
+
+// plainHandler writes records like the log package does, with the attributes
+// appended as key=value.
+type plainHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+func (h *plainHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	if r.Level != slog.LevelInfo {
+		b.WriteString(r.Level.String() + " ")
+	}
+	b.WriteString(r.Message)
+	write := func(a slog.Attr) bool {
+		v := a.Value.String()
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", a.Key, v)
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *plainHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &plainHandler{mu: h.mu, w: h.w, level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+// WithGroup isn't used by the sync, groups are flattened.
+func (h *plainHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// logErr is the attribute of an error, like log's %+v.
+func logErr(err error) slog.Attr {
+	return slog.String("error", fmt.Sprintf("%+v", err))
+}
+
+// logDuration is the attribute of how long since start, in seconds.
+func logDuration(start time.Time) slog.Attr {
+	return slog.Float64("duration", time.Since(start).Seconds())
+}
+
+func init() {
+	setupLogging(LogFormatPlain, "info")
+}
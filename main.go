@@ -2,171 +2,205 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"math/rand"
 	"os"
 	"os/signal"
-	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/cloudflare/cloudflare-go"
-	mapset "github.com/deckarep/golang-set/v2"
-	"golang.org/x/sys/unix"
 	"tailscale.com/client/tailscale"
 )
 
-const (
-	CloudflareSyncDNSComment = "_tailscale"
-	CloudflareDomainSuffix   = ".int"
-	SyncInternal             = 30 * time.Second
-)
+const CloudflareSyncDNSComment = "_tailscale"
 
 var (
-	ctx    context.Context
-	lc     tailscale.LocalClient
-	api    *cloudflare.API
-	zoneID string
-	stop   context.CancelFunc
+	ctx   context.Context
+	zones []*zone
+	stop  context.CancelFunc
 )
 
+// liveClient is the client of tailscaled; the daemon replaces it after a
+// suspend while handlers and the IPN bus watcher are using it.
+var liveClient atomic.Pointer[tailscale.LocalClient]
+
+// lc returns the current client of tailscaled.
+func lc() *tailscale.LocalClient {
+	return liveClient.Load()
+}
+
 func init() {
-	signal.Reset(syscall.SIGTERM, syscall.SIGINT)
-	ctx, stop = signal.NotifyContext(context.Background(), unix.SIGTERM, unix.SIGINT)
+	liveClient.Store(new(tailscale.LocalClient))
+	signal.Reset(shutdownSignals...)
+	ctx, stop = signal.NotifyContext(context.Background(), shutdownSignals...)
+}
 
-	var err error
-	// init cloudflare client
-	api, err = cloudflare.NewWithAPIToken(os.Getenv("CLOUDFLARE_TOKEN"))
-	if err != nil {
-		panic(err)
+// lambdaRuntime replaces the sync loop when running in AWS Lambda.
+var lambdaRuntime func(ctx context.Context) error
+
+// commands are the subcommands run instead of the daemon, keyed by name.
+var commands = map[string]func(ctx context.Context, args []string) error{}
+
+func registerCommand(name string, fn func(ctx context.Context, args []string) error) {
+	commands[name] = fn
+}
+
+func init() {
+	registerCommand("daemon", runDaemon)
+}
+
+// main runs the subcommand named by the first argument, the daemon when
+// there is none.
+func main() {
+	defer stop()
+	name, args := "daemon", os.Args[1:]
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			name, args = args[0], args[1:]
+		}
+	}
+	err := commands[name](ctx, args)
+	runShutdownHooks()
+	var code exitCode
+	if errors.As(err, &code) {
+		os.Exit(int(code))
 	}
-	// get zone id
-	zoneID, err = api.ZoneIDByName(os.Getenv("CLOUDFLARE_DOMAIN"))
 	if err != nil {
-		panic(err)
+		log.Fatalf("%v", err)
 	}
 }
 
-func getName(name string) string {
-	// normalize name
-	s := strings.Split(strings.ToLower(name), ".")
-	if len(s) > 0 {
-		return s[0]
+// syncInterval returns the time until the next sync: SYNC_INTERVAL, or
+// WATCH_FALLBACK_INTERVAL if longer while tailscaled reports changes, plus
+// a random part of SYNC_JITTER, so instances started together drift apart.
+func syncInterval() time.Duration {
+	interval := cfg().SyncInterval
+	if watching.Load() {
+		interval = max(interval, cfg().WatchFallbackInterval)
 	}
-	return ""
+	if cfg().SyncJitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(int64(cfg().SyncJitter)))
 }
 
-func sync(ctx context.Context) {
-	log.Printf("sync start")
-	st, err := lc.Status(ctx)
-	if err != nil {
-		log.Printf("get status error: %+v", err)
-		return
-	}
-	// name => ip string
-	tsMap := map[string]string{}
-	ts := mapset.NewSet[string]()
-	// name => record id
-	cfMap := map[string]string{}
-	cf := mapset.NewSet[string]()
-	// add self name
-	{
-		name := getName(st.Self.DNSName)
-		if name != "" {
-			ts.Add(name)
-			// now only support ipv4
-			for _, ip := range st.Self.TailscaleIPs {
-				if ip.Is4() {
-					tsMap[name] = ip.String()
-				}
-			}
+// runDaemon implements `tailscale-dns-sync daemon`: it syncs every
+// SYNC_INTERVAL until stopped.
+func runDaemon(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	delegate := fs.String("delegate", "", "create the subzone `name` (e.g. int.example.com), delegate it from its parent and exit")
+	once := fs.Bool("once", false, "run a single sync and exit with the status of the once command")
+	fs.Parse(args)
+	if *profile == "" && *delegate == "" && !*once {
+		names, err := configuredPipelines(*configPath)
+		if err != nil {
+			return err
 		}
-	}
-	// add peer name
-	for _, ps := range st.Peer {
-		name := getName(ps.DNSName)
-		if name != "" {
-			ts.Add(name)
-			// now only support ipv4
-			for _, ip := range ps.TailscaleIPs {
-				if ip.Is4() {
-					tsMap[name] = ip.String()
-				}
-			}
+		if len(names) > 0 {
+			return runPipelines(ctx, *configPath, names)
 		}
 	}
-	records, _, err := api.ListDNSRecords(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.ListDNSRecordsParams{
-		Comment: CloudflareSyncDNSComment,
-		ResultInfo: cloudflare.ResultInfo{
-			// cloudflare limit 1000 records per page
-			PerPage: 1000,
-		},
-	})
-	if err != nil {
-		log.Printf("ListDNSRecords: %+v", err)
-		return
+	loadConfig(*configPath, *profile)
+	if *delegate != "" {
+		return bootstrapSubzone(ctx, *delegate)
 	}
-	for _, r := range records {
-		name := getName(r.Name)
-		if name != "" {
-			cf.Add(getName(r.Name))
-			cfMap[name] = r.ID
+	var err error
+	// open the provider for every zone
+	zones, err = openZones(ctx)
+	if err != nil {
+		if *once {
+			slog.Error("open the zones failed", logErr(err))
+			return exitProvider
 		}
+		return err
 	}
-	needToSync := ts.SymmetricDifference(cf).ToSlice()
-	if len(needToSync) == 0 {
-		log.Printf("no host need to sync")
-		return
+	if err := openSecondaryZones(ctx); err != nil {
+		return err
 	}
-	for _, name := range ts.SymmetricDifference(cf).ToSlice() {
-		if ts.Contains(name) {
-			ip, ok := tsMap[name]
-			if !ok {
-				continue
-			}
-			log.Printf("%s need to add to cf", name)
-			_, err := api.CreateDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), cloudflare.CreateDNSRecordParams(cloudflare.DNSRecord{
-				Type:    "A",
-				Name:    fmt.Sprintf("%s"+CloudflareDomainSuffix, name),
-				Content: ip,
-				Comment: CloudflareSyncDNSComment,
-				TTL:     1,
-			}))
-			if err != nil {
-				log.Printf("CreateDNSRecord: %+v", err)
-				continue
-			}
-			log.Printf("%s added to cf", name)
-		}
-		if cf.Contains(name) {
-			recordID, ok := cfMap[name]
-			if !ok {
-				continue
-			}
-			log.Printf("%s need to remove from cf", name)
-			err := api.DeleteDNSRecord(ctx, cloudflare.ZoneIdentifier(zoneID), recordID)
-			if err != nil {
-				log.Printf("DeleteDNSRecord: %+v", err)
-				continue
-			}
-			log.Printf("%s removed from cf", name)
-		}
+	if err := loadState(ctx); err != nil {
+		return err
 	}
-	log.Printf("sync end")
-}
-
-func main() {
-	defer stop()
-	ticker := time.NewTicker(SyncInternal)
+	if *once {
+		return syncOnce(ctx)
+	}
+	if lambdaRuntime != nil {
+		return lambdaRuntime(ctx)
+	}
+	initCoordination()
+	runLeaderElection(ctx)
+	defer releaseLeadership()
+	if err := serveHTTP(ctx); err != nil {
+		return err
+	}
+	detectFeatures(ctx)
+	onShutdown(func() { sdNotify("STOPPING=1") })
+	sdWatchdog(ctx)
+	if leaderLock != nil && !leader.Load() {
+		// another replica syncs, so there is no first sync to wait for
+		sdReady()
+	}
+	wake := watchWake(ctx, cfg().WakeThreshold)
+	netmapChanged := watchNetmap(ctx)
+	signals := make(chan os.Signal, 1)
+	notifyControlSignals(signals)
+	defer signal.Stop(signals)
+	ticker := time.NewTicker(syncInterval())
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			sync(ctx)
-			ticker.Reset(SyncInternal)
+			if holdsLeadership(ctx) {
+				reconcile(ctx)
+			}
+			ticker.Reset(syncInterval())
+		case gap := <-wake:
+			slog.Warn("clock jumped (resumed from sleep?), reconnecting to tailscaled and syncing now", "gap", gap.Round(time.Second).String())
+			// drop connections that may have gone stale while suspended
+			liveClient.Store(localClient())
+			if holdsLeadership(ctx) {
+				reconcile(ctx)
+			}
+			ticker.Reset(syncInterval())
+		case sig := <-signals:
+			name := "SIGUSR1"
+			if sig == syscall.SIGHUP {
+				name = "SIGHUP"
+				reloadConfig(*configPath, *profile)
+			}
+			if holdsLeadership(ctx) {
+				slog.Info("signal received, syncing now", "signal", name)
+				reconcile(withAnnotation(ctx, &annotation{Reason: name}))
+			}
+			ticker.Reset(syncInterval())
+		case <-netmapChanged:
+			if holdsLeadership(ctx) {
+				slog.Info("tailnet changed, syncing now")
+				reconcile(ctx)
+			}
+			ticker.Reset(syncInterval())
+		case a := <-manualSyncs:
+			if holdsLeadership(ctx) {
+				reconcile(withAnnotation(ctx, a))
+			} else {
+				// only with kubernetes coordination; redis queues the
+				// requests of every replica for the leader
+				slog.Info("not the leader, ignoring the requested sync")
+			}
+			ticker.Reset(syncInterval())
+		case <-leadershipAcquired:
+			if holdsLeadership(ctx) {
+				reconcile(ctx)
+			}
+			ticker.Reset(syncInterval())
 		case <-ctx.Done():
-			log.Println("sync stopped")
-			return
+			slog.Info("sync stopped")
+			return nil
 		}
 	}
 }
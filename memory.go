@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+func init() {
+	registerProvider("memory", newMemoryZone)
+}
+
+// memoryProvider keeps records in memory and counts the calls made to it,
+// for benchmarks and simulations.
+type memoryProvider struct {
+	mu      sync.Mutex
+	records map[string]record
+	nextID  int
+	calls   atomic.Int64
+	// saved is called after every change, to write MEMORY_FILE.
+	saved func()
+}
+
+func newMemoryProvider() *memoryProvider {
+	return &memoryProvider{records: map[string]record{}}
+}
+
+// memoryRecord is a record of MEMORY_FILE.
+type memoryRecord struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+var (
+	memoryZonesMu sync.Mutex
+	// memoryZones are the zones of PROVIDER=memory by name, kept when the
+	// zones are reopened.
+	memoryZones = map[string]*memoryProvider{}
+)
+
+// newMemoryZone opens a zone of PROVIDER=memory, starting with its records
+// in MEMORY_FILE, if set.
+func newMemoryZone(ctx context.Context, name string) (provider, error) {
+	memoryZonesMu.Lock()
+	defer memoryZonesMu.Unlock()
+	if p, ok := memoryZones[name]; ok {
+		return p, nil
+	}
+	p := newMemoryProvider()
+	if path := os.Getenv("MEMORY_FILE"); path != "" {
+		saved, err := readMemoryFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range saved[name] {
+			p.records[r.ID] = record{ID: r.ID, Name: r.Name, Type: r.Type, Content: r.Content, TTL: r.TTL}
+		}
+		p.saved = func() {
+			if err := writeMemoryFile(path); err != nil {
+				slog.Error("writing MEMORY_FILE failed", "path", path, logErr(err))
+			}
+		}
+	}
+	memoryZones[name] = p
+	return p, nil
+}
+
+// readMemoryFile reads the records of the zones in path, none if it doesn't
+// exist yet.
+func readMemoryFile(path string) (map[string][]memoryRecord, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved map[string][]memoryRecord
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("MEMORY_FILE %s: %w", path, err)
+	}
+	return saved, nil
+}
+
+// writeMemoryFile writes the records of every zone to path, sorted so runs
+// can be diffed.
+func writeMemoryFile(path string) error {
+	memoryZonesMu.Lock()
+	defer memoryZonesMu.Unlock()
+	out := map[string][]memoryRecord{}
+	for name, p := range memoryZones {
+		p.mu.Lock()
+		records := []memoryRecord{}
+		for _, r := range p.records {
+			records = append(records, memoryRecord{ID: r.ID, Name: r.Name, Type: r.Type, Content: r.Content, TTL: r.TTL})
+		}
+		p.mu.Unlock()
+		sort.Slice(records, func(i, j int) bool {
+			a, b := records[i], records[j]
+			return a.Name+" "+a.Type+" "+a.Content < b.Name+" "+b.Type+" "+b.Content
+		})
+		out[name] = records
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+func (p *memoryProvider) List(ctx context.Context) ([]record, error) {
+	p.calls.Add(1)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]record, 0, len(p.records))
+	for _, r := range p.records {
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (p *memoryProvider) Create(ctx context.Context, c change) (string, error) {
+	p.calls.Add(1)
+	defer p.changed()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := ""
+	for id == "" || p.records[id].ID != "" {
+		p.nextID++
+		id = fmt.Sprintf("mem-%d", p.nextID)
+	}
+	p.records[id] = record{ID: id, Name: c.fqdn(), Type: c.Type, Content: c.Content, TTL: c.TTL}
+	return id, nil
+}
+
+func (p *memoryProvider) Update(ctx context.Context, c change) error {
+	p.calls.Add(1)
+	defer p.changed()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.records[c.RecordID]; !ok {
+		return fmt.Errorf("record %s not found", c.RecordID)
+	}
+	p.records[c.RecordID] = record{ID: c.RecordID, Name: c.fqdn(), Type: c.Type, Content: c.Content, TTL: c.TTL}
+	return nil
+}
+
+func (p *memoryProvider) Delete(ctx context.Context, c change) error {
+	p.calls.Add(1)
+	defer p.changed()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.records[c.RecordID]; !ok {
+		return fmt.Errorf("record %s not found", c.RecordID)
+	}
+	delete(p.records, c.RecordID)
+	return nil
+}
+
+// changed runs saved, once the change is made and p unlocked.
+func (p *memoryProvider) changed() {
+	if p.saved != nil {
+		p.saved()
+	}
+}
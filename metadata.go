@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// metadataProviders are the providers METADATA_TXT can publish TXT records
+// to.
+var metadataProviders = []string{"cloudflare", "digitalocean", "memory", "rfc2136", "route53", "webhook"}
+
+// nodeVersions are the Tailscale versions of the nodes as the Admin API
+// reports them, since tailscaled only tells its own.
+var nodeVersions sync.Map // tailcfg.StableNodeID => string
+
+// managedTXT reports whether TXT records are among the records listed as
+// ours, for REGISTRY=txt or METADATA_TXT.
+func managedTXT() bool {
+	return cfg().Registry == RegistryTXT || cfg().MetadataTXT
+}
+
+// addMetadataTXT adds a TXT record next to the records of every device
+// name, e.g. `os=linux version=1.70.0 tags=tag:server online=true`, for
+// inventory tools reading the fleet from DNS. Offline devices have
+// `last_seen` instead of `online=true`; online ones get no timestamp, so
+// their records don't change every cycle.
+func addMetadataTXT(st *ipnstate.Status, hosts map[string]host) {
+	if !cfg().MetadataTXT {
+		return
+	}
+	nodes := map[string]*ipnstate.PeerStatus{}
+	if st.Self != nil {
+		nodes[string(st.Self.ID)] = st.Self
+		storeNodeVersion(st.Self.ID, st.Version)
+	}
+	for _, ps := range st.Peer {
+		nodes[string(ps.ID)] = ps
+	}
+	var txts []host
+	for _, h := range hosts {
+		ps, ok := nodes[h.NodeID]
+		// tag subdomain copies and wildcards go without
+		if !ok || strings.Contains(h.Name, ".") || h.Type != "A" && h.Type != "AAAA" && h.Type != "CNAME" {
+			continue
+		}
+		t := h
+		t.Type, t.Content, t.Proxied = "TXT", nodeMetadata(ps, h.Online || ps == st.Self), false
+		txts = append(txts, t)
+	}
+	for _, t := range txts {
+		hosts[recordKey(t.Type, t.Name, t.Content)] = t
+	}
+}
+
+// nodeMetadata returns the TXT content describing ps, cut to the 255 bytes
+// a TXT string holds.
+func nodeMetadata(ps *ipnstate.PeerStatus, online bool) string {
+	var fields []string
+	if ps.OS != "" {
+		fields = append(fields, "os="+ps.OS)
+	}
+	if v, ok := nodeVersions.Load(ps.ID); ok && v.(string) != "" {
+		fields = append(fields, "version="+v.(string))
+	}
+	if ps.Tags != nil && ps.Tags.Len() > 0 {
+		tags := ps.Tags.AsSlice()
+		sort.Strings(tags)
+		fields = append(fields, "tags="+strings.Join(tags, ","))
+	}
+	switch {
+	case online:
+		fields = append(fields, "online=true")
+	case !ps.LastSeen.IsZero():
+		fields = append(fields, "last_seen="+ps.LastSeen.UTC().Format(time.RFC3339))
+	default:
+		fields = append(fields, "online=false")
+	}
+	s := strings.Join(fields, " ")
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	return s
+}
+
+// storeNodeVersion remembers the Tailscale version the Admin API reported
+// for a node.
+func storeNodeVersion(id tailcfg.StableNodeID, version string) {
+	if version != "" {
+		nodeVersions.Store(id, version)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const metricsNamespace = "tailscale_dns_sync_"
+
+var (
+	metricsMu sync.Mutex
+	metricSet []*metric
+
+	metricRuns        = newMetric("counter", "runs_total", "Number of sync cycles by result.")
+	metricChanges     = newMetric("counter", "changes_total", "Record changes applied to the provider, by action.")
+	metricLastSuccess = newMetric("gauge", "last_success_timestamp_seconds", "Unix time of the last sync cycle that completed without error.")
+	metricDrift       = newMetric("gauge", "drift_records", "Records that differ between the tailnet and the provider, by action.")
+	metricLeader      = newMetric("gauge", "leader", "Whether this replica currently holds the leader lock.")
+	metricManaged     = newMetric("gauge", "managed_records", "Records the sync manages, by zone.")
+	metricDuration    = newHistogram("cycle_duration_seconds", "Duration of the sync cycles.", []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300})
+
+	metricExternalChanges = newMetric("counter", "external_changes_total", "Managed records changed outside of the sync, by zone.")
+	metricDeferredDeletes = newMetric("gauge", "deferred_deletes", "Deletions postponed to a later cycle by MAX_DELETES_PER_CYCLE.")
+)
+
+// metric is a family of series rendered in the Prometheus text format.
+type metric struct {
+	kind   string
+	name   string
+	help   string
+	values map[string]float64
+	// buckets are the upper bounds of a histogram; counts holds the
+	// cumulative bucket counts of each series followed by its total count,
+	// and values its sum.
+	buckets []float64
+	counts  map[string][]float64
+}
+
+func newMetric(kind, name, help string) *metric {
+	m := &metric{kind: kind, name: metricsNamespace + name, help: help, values: map[string]float64{}}
+	metricsMu.Lock()
+	metricSet = append(metricSet, m)
+	metricsMu.Unlock()
+	return m
+}
+
+func newHistogram(name, help string, buckets []float64) *metric {
+	m := newMetric("histogram", name, help)
+	m.buckets = buckets
+	m.counts = map[string][]float64{}
+	return m
+}
+
+// labelKey renders label pairs ("k1", "v1", "k2", "v2") as {k1="v1",k2="v2"}.
+func labelKey(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// withPipeline adds the pipeline label to the label set k of a series.
+func withPipeline(k string) string {
+	if pipelineName == "" {
+		return k
+	}
+	return withLabel(k, "pipeline", pipelineName)
+}
+
+// withLabel adds the label name="value" in front of the label set k.
+func withLabel(k, name, value string) string {
+	l := labelKey([]string{name, value})
+	if k == "" {
+		return l
+	}
+	return strings.TrimSuffix(l, "}") + "," + k[1:]
+}
+
+func (m *metric) Set(v float64, labels ...string) {
+	metricsMu.Lock()
+	m.values[labelKey(labels)] = v
+	metricsMu.Unlock()
+}
+
+func (m *metric) Add(v float64, labels ...string) {
+	metricsMu.Lock()
+	m.values[labelKey(labels)] += v
+	metricsMu.Unlock()
+}
+
+func (m *metric) Inc(labels ...string) {
+	m.Add(1, labels...)
+}
+
+// Observe adds v to the histogram series of labels.
+func (m *metric) Observe(v float64, labels ...string) {
+	k := labelKey(labels)
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	counts, ok := m.counts[k]
+	if !ok {
+		counts = make([]float64, len(m.buckets)+1)
+		m.counts[k] = counts
+	}
+	for i, b := range m.buckets {
+		if v <= b {
+			counts[i]++
+		}
+	}
+	counts[len(m.buckets)]++
+	m.values[k] += v
+}
+
+// Reset drops every series, for families rebuilt from scratch each cycle.
+func (m *metric) Reset() {
+	metricsMu.Lock()
+	m.values = map[string]float64{}
+	if m.counts != nil {
+		m.counts = map[string][]float64{}
+	}
+	metricsMu.Unlock()
+}
+
+func writeMetrics(w io.Writer) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, m := range metricSet {
+		if len(m.values) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if m.kind == "histogram" {
+				writeHistogram(w, m, k)
+				continue
+			}
+			fmt.Fprintf(w, "%s%s %g\n", m.name, withPipeline(k), m.values[k])
+		}
+	}
+}
+
+// writeHistogram writes the buckets, sum and count of the series k of m.
+func writeHistogram(w io.Writer, m *metric, k string) {
+	counts := m.counts[k]
+	for i, b := range m.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %g\n", m.name, withPipeline(withLabel(k, "le", fmt.Sprint(b))), counts[i])
+	}
+	total := counts[len(m.buckets)]
+	fmt.Fprintf(w, "%s_bucket%s %g\n", m.name, withPipeline(withLabel(k, "le", "+Inf")), total)
+	fmt.Fprintf(w, "%s_sum%s %g\n", m.name, withPipeline(k), m.values[k])
+	fmt.Fprintf(w, "%s_count%s %g\n", m.name, withPipeline(k), total)
+}
+
+func init() {
+	handle("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+}
@@ -0,0 +1,169 @@
+//go:build !no_nextdns
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// nextDNSAPI is the base URL of the NextDNS API.
+const nextDNSAPI = "https://api.nextdns.io"
+
+// errNextDNSNotFound is returned for a rewrite that doesn't exist.
+var errNextDNSNotFound = errors.New("not found")
+
+func init() {
+	registerProvider("nextdns", newNextDNSProvider)
+}
+
+// nextDNSProvider manages the rewrites of a NextDNS profile, for tailnets
+// using NextDNS as their resolver. Rewrites carry no comments, so every
+// rewrite under the managed suffix is considered ours: the suffix must be
+// dedicated to the sync.
+type nextDNSProvider struct {
+	base    string
+	key     string
+	profile string
+	zone    string
+}
+
+// nextDNSRewrite is a rewrite of a profile; NextDNS derives its type from
+// the content.
+type nextDNSRewrite struct {
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Type    string `json:"type,omitempty"`
+	Content string `json:"content"`
+}
+
+func newNextDNSProvider(ctx context.Context, name string) (provider, error) {
+	profile := os.Getenv("NEXTDNS_PROFILE")
+	if profile == "" {
+		return nil, fmt.Errorf("set NEXTDNS_PROFILE to the ID of the profile, e.g. abc123")
+	}
+	p := &nextDNSProvider{
+		base:    strings.TrimSuffix(envString("NEXTDNS_API_URL", nextDNSAPI), "/"),
+		key:     secretEnv("NEXTDNS_API_KEY"),
+		profile: profile,
+		zone:    name,
+	}
+	// NextDNS has no zones, only check that the profile answers
+	if _, err := p.rewrites(ctx); err != nil {
+		return nil, fmt.Errorf("get rewrites of profile %s: %w", profile, err)
+	}
+	return p, nil
+}
+
+func (p *nextDNSProvider) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.base+"/profiles/"+url.PathEscape(p.profile)+"/rewrites"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Api-Key", p.key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errNextDNSNotFound
+	}
+	// errors come with a 4xx status or, for invalid input, next to the data
+	var res struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Code   string `json:"code"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err := json.Unmarshal(b, &res); err != nil && resp.StatusCode < 300 && len(b) > 0 {
+		return fmt.Errorf("decode answer: %w", err)
+	}
+	if len(res.Errors) > 0 {
+		e := res.Errors[0]
+		return fmt.Errorf("%s: %s %s", resp.Status, e.Code, e.Detail)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	if out == nil || len(res.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(res.Data, out)
+}
+
+func (p *nextDNSProvider) rewrites(ctx context.Context) ([]nextDNSRewrite, error) {
+	var out []nextDNSRewrite
+	err := p.call(ctx, http.MethodGet, "", nil, &out)
+	return out, err
+}
+
+func (p *nextDNSProvider) List(ctx context.Context) ([]record, error) {
+	rewrites, err := p.rewrites(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get rewrites: %w", err)
+	}
+	var out []record
+	for _, r := range rewrites {
+		name := strings.ToLower(r.Name)
+		if name != p.zone && !strings.HasSuffix(name, "."+p.zone) {
+			continue
+		}
+		// rewrites have no TTL of their own, report it as automatic
+		out = append(out, record{ID: r.ID, Name: name, Type: strings.ToUpper(r.Type), Content: r.Content})
+	}
+	return out, nil
+}
+
+func (p *nextDNSProvider) Create(ctx context.Context, c change) (string, error) {
+	if c.Type != "A" && c.Type != "AAAA" && c.Type != "CNAME" {
+		return "", fmt.Errorf("nextdns can't publish %s records", c.Type)
+	}
+	var created nextDNSRewrite
+	if err := p.call(ctx, http.MethodPost, "", nextDNSRewrite{Name: c.fqdn(), Content: c.Content}, &created); err != nil {
+		return "", fmt.Errorf("create rewrite %s: %w", c.fqdn(), err)
+	}
+	return created.ID, nil
+}
+
+func (p *nextDNSProvider) Update(ctx context.Context, c change) error {
+	if c.OldContent == c.Content {
+		// nothing NextDNS stores has changed
+		return nil
+	}
+	if err := p.call(ctx, http.MethodPatch, "/"+url.PathEscape(c.RecordID), map[string]string{"content": c.Content}, nil); err != nil {
+		return fmt.Errorf("update rewrite %s: %w", c.fqdn(), err)
+	}
+	return nil
+}
+
+func (p *nextDNSProvider) Delete(ctx context.Context, c change) error {
+	err := p.call(ctx, http.MethodDelete, "/"+url.PathEscape(c.RecordID), nil, nil)
+	if errors.Is(err, errNextDNSNotFound) {
+		// already gone
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("delete rewrite %s: %w", c.fqdn(), err)
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// event is a notification payload delivered to the configured sinks.
+type event struct {
+	Type     string    `json:"type"`
+	Severity string    `json:"severity"`
+	Time     time.Time `json:"time"`
+	Message  string    `json:"message"`
+	Changes  []change  `json:"changes,omitempty"`
+	// Events are the events a digest collects.
+	Events []event `json:"events,omitempty"`
+	// Annotation is the operator's note on a manually triggered sync.
+	Annotation *annotation `json:"annotation,omitempty"`
+	// Failures counts the failed cycles in a row of sync_failed events.
+	Failures int `json:"failures,omitempty"`
+	// repeated marks a sync_failed event with the error of the last one,
+	// only sent for sinks whose failure_threshold it reaches.
+	repeated bool
+}
+
+// Event severities, in increasing order.
+const (
+	severityInfo    = "info"
+	severityWarning = "warning"
+	severityError   = "error"
+)
+
+var severities = []string{severityInfo, severityWarning, severityError}
+
+// eventSeverity is the severity of every event type sent.
+var eventSeverity = map[string]string{
+	"drift":               severityWarning,
+	"drift_resolved":      severityInfo,
+	"tailnet_renamed":     severityInfo,
+	"external_change":     severityWarning,
+	"node_replaced":       severityWarning,
+	"capacity":            severityWarning,
+	"full_audit":          severityInfo,
+	"freeze":              severityWarning,
+	"unfreeze":            severityInfo,
+	"credential_expiring": severityWarning,
+	"credential_invalid":  severityError,
+	"sync_failed":         severityError,
+	"panic":               severityError,
+	"records_changed":     severityInfo,
+	"sync_recovered":      severityInfo,
+}
+
+// notifySink is a destination for events. Every rule that is set must match
+// for an event to be delivered.
+type notifySink struct {
+	URL string `yaml:"url"`
+	// Format is json (the event as is), slack (a {"text": ...} message,
+	// also understood by Mattermost), discord, telegram (URL is the bot's
+	// https://api.telegram.org/bot<token>, posting to ChatID), ntfy (a
+	// plain text body) or template.
+	Format string `yaml:"format"`
+	ChatID string `yaml:"chat_id"`
+	// Events are the event types delivered, all of them when empty.
+	Events      []string `yaml:"events"`
+	MinSeverity string   `yaml:"min_severity"`
+	// Actions narrows the changes of drift events to these actions; events
+	// left without changes aren't delivered.
+	Actions []string `yaml:"actions"`
+	// BatchWindow collects the events of the window into one digest,
+	// NOTIFY_BATCH_WINDOW when unset.
+	BatchWindow time.Duration `yaml:"batch_window"`
+	// Template renders the body of format template from the event, sent as
+	// ContentType (application/json by default) with Headers.
+	Template    string            `yaml:"template"`
+	ContentType string            `yaml:"content_type"`
+	Headers     map[string]string `yaml:"headers"`
+	// PerChange sends one request for each change of an event.
+	PerChange bool `yaml:"per_change"`
+	// FailureThreshold delivers sync_failed only once that many cycles
+	// in a row failed, then sync_recovered when one succeeds again.
+	FailureThreshold int `yaml:"failure_threshold"`
+
+	tmpl *template.Template
+}
+
+// notifyFuncs are the functions available to sink templates.
+var notifyFuncs = template.FuncMap{
+	"fqdn": func(c change) string { return c.fqdn() },
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func (s *notifySink) validate() error {
+	if s.URL == "" {
+		return fmt.Errorf("notify_sinks: url is required")
+	}
+	if !slices.Contains([]string{"", "json", "slack", "discord", "telegram", "ntfy", "template"}, s.Format) {
+		return fmt.Errorf("notify_sinks: %s: format %q is not one of json, slack, discord, telegram, ntfy, template", s.URL, s.Format)
+	}
+	if (s.Format == "telegram") != (s.ChatID != "") {
+		return fmt.Errorf("notify_sinks: %s: chat_id goes with format telegram", s.URL)
+	}
+	if s.FailureThreshold < 0 {
+		return fmt.Errorf("notify_sinks: %s: failure_threshold must not be negative", s.URL)
+	}
+	if (s.Format == "template") != (s.Template != "") {
+		return fmt.Errorf("notify_sinks: %s: template goes with format template", s.URL)
+	}
+	if s.Template != "" {
+		t, err := template.New(s.URL).Funcs(notifyFuncs).Parse(s.Template)
+		if err != nil {
+			return fmt.Errorf("notify_sinks: %s: template: %w", s.URL, err)
+		}
+		s.tmpl = t
+	}
+	for _, t := range s.Events {
+		if _, ok := eventSeverity[t]; !ok {
+			return fmt.Errorf("notify_sinks: %s: unknown event type %q", s.URL, t)
+		}
+	}
+	if s.MinSeverity != "" && !slices.Contains(severities, s.MinSeverity) {
+		return fmt.Errorf("notify_sinks: %s: min_severity %q is not one of %s", s.URL, s.MinSeverity, strings.Join(severities, ", "))
+	}
+	if s.BatchWindow < 0 {
+		return fmt.Errorf("notify_sinks: %s: batch_window must not be negative", s.URL)
+	}
+	for _, a := range s.Actions {
+		if a != actionCreate && a != actionUpdate && a != actionDelete {
+			return fmt.Errorf("notify_sinks: %s: action %q is not one of create, update, delete", s.URL, a)
+		}
+	}
+	return nil
+}
+
+// sinkName names s in logs without the bot token of a Telegram URL.
+func (s *notifySink) sinkName() string {
+	if s.Format == "telegram" {
+		return "telegram chat " + s.ChatID
+	}
+	return s.URL
+}
+
+// route returns ev as s receives it, or false when s doesn't subscribe to it.
+func (s *notifySink) route(ev event) (event, bool) {
+	if len(s.Events) > 0 && !slices.Contains(s.Events, ev.Type) {
+		return ev, false
+	}
+	if slices.Index(severities, ev.Severity) < slices.Index(severities, s.MinSeverity) {
+		return ev, false
+	}
+	switch ev.Type {
+	case "sync_failed":
+		if s.FailureThreshold > 0 && ev.Failures != s.FailureThreshold || s.FailureThreshold == 0 && ev.repeated {
+			return ev, false
+		}
+	case "sync_recovered":
+		if ev.Failures < s.FailureThreshold {
+			return ev, false
+		}
+	}
+	if len(s.Actions) > 0 && len(ev.Changes) > 0 {
+		var changes []change
+		for _, c := range ev.Changes {
+			if slices.Contains(s.Actions, c.Action) {
+				changes = append(changes, c)
+			}
+		}
+		if len(changes) == 0 {
+			return ev, false
+		}
+		ev.Changes = changes
+	}
+	return ev, true
+}
+
+func (s *notifySink) send(ctx context.Context, ev event) error {
+	if s.PerChange && len(ev.Changes) > 1 {
+		var errs []error
+		for _, c := range ev.Changes {
+			one := ev
+			one.Changes = []change{c}
+			if err := s.send(ctx, one); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", c.fqdn(), err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	switch s.Format {
+	case "template":
+		var body bytes.Buffer
+		if err := s.tmpl.Execute(&body, ev); err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, &body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if s.ContentType != "" {
+			req.Header.Set("Content-Type", s.ContentType)
+		}
+		for k, v := range s.Headers {
+			req.Header.Set(k, v)
+		}
+		return doRequest(req)
+	case "slack":
+		return postJSON(ctx, s.URL, map[string]string{"text": ev.text()})
+	case "discord":
+		return postJSON(ctx, s.URL, map[string]string{"content": truncate(ev.text(), 2000)})
+	case "telegram":
+		return postJSON(ctx, strings.TrimSuffix(s.URL, "/")+"/sendMessage", map[string]string{"chat_id": s.ChatID, "text": truncate(ev.text(), 4096)})
+	case "ntfy":
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, strings.NewReader(ev.text()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Title", "tailscale-dns-sync: "+ev.Type)
+		if ev.Severity == severityError {
+			req.Header.Set("Priority", "high")
+		}
+		return doRequest(req)
+	}
+	return postJSON(ctx, s.URL, ev)
+}
+
+// truncate cuts s to at most n bytes for sinks limiting message length.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return strings.ToValidUTF8(s[:n-3], "") + "..."
+}
+
+// text renders ev for chat-style sinks.
+func (ev event) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s: %s", ev.Severity, ev.Type, ev.Message)
+	for _, c := range ev.Changes {
+		fmt.Fprintf(&b, "\n%s", c)
+	}
+	for _, e := range ev.Events {
+		fmt.Fprintf(&b, "\n%s", e.text())
+	}
+	if ev.Annotation != nil {
+		fmt.Fprintf(&b, "\n(%s)", ev.Annotation)
+	}
+	return b.String()
+}
+
+// notifySinks returns the configured sinks; NOTIFY_WEBHOOK_URL receives
+// every event.
+func notifySinks() []notifySink {
+	sinks := cfg().NotifySinks
+	if cfg().NotifyWebhookURL != "" {
+		sinks = append([]notifySink{{URL: cfg().NotifyWebhookURL}}, sinks...)
+	}
+	for i := range sinks {
+		if sinks[i].BatchWindow == 0 {
+			sinks[i].BatchWindow = cfg().NotifyBatchWindow
+		}
+	}
+	return sinks
+}
+
+func notify(ctx context.Context, ev event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Severity == "" {
+		ev.Severity = eventSeverity[ev.Type]
+	}
+	if ev.Annotation == nil {
+		ev.Annotation = annotationFrom(ctx)
+	}
+	for i, s := range notifySinks() {
+		routed, ok := s.route(ev)
+		if !ok {
+			continue
+		}
+		if s.BatchWindow > 0 {
+			queueEvent(i, s, routed)
+			continue
+		}
+		if err := s.send(ctx, routed); err != nil {
+			slog.Warn("notify failed", "event", ev.Type, "sink", s.sinkName(), logErr(err))
+		}
+	}
+}
+
+func init() {
+	onShutdown(flushBatches)
+}
+
+var (
+	batchesMu sync.Mutex
+	// batches holds the events waiting for the end of a sink's window,
+	// keyed by the sink's position in notifySinks.
+	batches = map[int][]event{}
+)
+
+// queueEvent holds ev until the batch window of s ends. The first event of
+// a window starts it.
+func queueEvent(i int, s notifySink, ev event) {
+	batchesMu.Lock()
+	defer batchesMu.Unlock()
+	if len(batches[i]) == 0 {
+		time.AfterFunc(s.BatchWindow, func() { flushBatch(i, s) })
+	}
+	batches[i] = append(batches[i], ev)
+}
+
+// flushBatch sends the events queued for s, as a digest when there are
+// several.
+func flushBatch(i int, s notifySink) {
+	batchesMu.Lock()
+	events := batches[i]
+	delete(batches, i)
+	batchesMu.Unlock()
+	if len(events) == 0 {
+		return
+	}
+	ev := events[0]
+	if len(events) > 1 {
+		ev = digest(events)
+	}
+	// the daemon's context may already be cancelled when shutting down
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.send(ctx, ev); err != nil {
+		slog.Warn("notify failed", "event", ev.Type, "sink", s.sinkName(), logErr(err))
+	}
+}
+
+// flushBatches sends every pending batch right away.
+func flushBatches() {
+	sinks := notifySinks()
+	batchesMu.Lock()
+	pending := make([]int, 0, len(batches))
+	for i := range batches {
+		pending = append(pending, i)
+	}
+	batchesMu.Unlock()
+	for _, i := range pending {
+		flushBatch(i, sinks[i])
+	}
+}
+
+// digest collects events into one, as severe as the most severe of them.
+func digest(events []event) event {
+	ev := event{Type: "digest", Severity: severityInfo, Time: time.Now(), Events: events}
+	counts := map[string]int{}
+	var types []string
+	for _, e := range events {
+		if slices.Index(severities, e.Severity) > slices.Index(severities, ev.Severity) {
+			ev.Severity = e.Severity
+		}
+		if counts[e.Type] == 0 {
+			types = append(types, e.Type)
+		}
+		counts[e.Type]++
+	}
+	parts := make([]string, 0, len(types))
+	for _, t := range types {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[t], t))
+	}
+	ev.Message = fmt.Sprintf("%d events: %s", len(events), strings.Join(parts, ", "))
+	return ev
+}
+
+// failedCycles counts the cycles in a row that ended with an error.
+var failedCycles int
+
+// notifyFailure sends a sync_failed event for a failed cycle, to most sinks
+// once per distinct error until a cycle succeeds again, and sync_recovered
+// after a failure. It must run before countRun records the error.
+func notifyFailure(ctx context.Context, r *runReport) {
+	msg := r.firstError()
+	if msg == "" {
+		if failedCycles > 0 {
+			notify(ctx, event{
+				Type:     "sync_recovered",
+				Message:  fmt.Sprintf("sync succeeded again after %d failed cycle(s)", failedCycles),
+				Failures: failedCycles,
+			})
+		}
+		failedCycles = 0
+		return
+	}
+	failedCycles++
+	repeated := msg == syncState.LastError && !syncState.LastSuccess.After(syncState.LastErrorTime)
+	notify(ctx, event{Type: "sync_failed", Message: msg, Failures: failedCycles, repeated: repeated})
+}
+
+func postJSON(ctx context.Context, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(req)
+}
+
+func doRequest(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+//go:build !no_operator
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// operatorAPI is the group and version of the TailnetDNSSync resources.
+const operatorAPI = "tailscale-dns-sync.io/v1alpha1"
+
+// tailnetDNSSync is a TailnetDNSSync resource: spec.config holds the keys of
+// a config file and spec.secretName a Secret whose keys are passed on as
+// environment variables, e.g. CLOUDFLARE_TOKEN.
+type tailnetDNSSync struct {
+	Metadata struct {
+		Name       string `json:"name"`
+		Generation int64  `json:"generation"`
+	} `json:"metadata"`
+	Spec struct {
+		Config     json.RawMessage `json:"config"`
+		SecretName string          `json:"secretName"`
+	} `json:"spec"`
+}
+
+type kubeSecret struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string]string `json:"data"`
+}
+
+// operatorPipeline is the running pipeline of a resource.
+type operatorPipeline struct {
+	// version changes with the resource's generation and its Secret.
+	version string
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func init() {
+	registerCommand("operator", runOperator)
+}
+
+// runOperator implements `tailscale-dns-sync operator`: it runs a pipeline
+// for every TailnetDNSSync resource in its namespace, each a daemon of its
+// own with the resource's config, and restarts it when the resource or its
+// Secret changes, or stops it when the resource is deleted.
+func runOperator(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("operator", flag.ExitOnError)
+	namespace := fs.String("namespace", os.Getenv("OPERATOR_NAMESPACE"), "namespace to watch, default the pod's")
+	dir := fs.String("dir", envString("OPERATOR_DIR", filepath.Join(os.TempDir(), "tailscale-dns-sync-operator")), "directory for the config files written from the resources")
+	interval := fs.Duration("interval", 30*time.Second, "how often the resources are listed")
+	fs.Parse(args)
+	c := defaultConfig()
+	if err := setupLogging(envString("LOG_FORMAT", c.LogFormat), envString("LOG_LEVEL", c.LogLevel)); err != nil {
+		return err
+	}
+	client, err := newInClusterKubeClient()
+	if err != nil {
+		return err
+	}
+	if *namespace != "" {
+		client.namespace = *namespace
+	}
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	slog.Info("operator watching TailnetDNSSync resources", "api", operatorAPI, "namespace", client.namespace)
+	running := map[string]*operatorPipeline{}
+	stop := func(name string) {
+		p := running[name]
+		p.cancel()
+		<-p.done
+		delete(running, name)
+	}
+	defer func() {
+		for name := range running {
+			stop(name)
+		}
+	}()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		if err := reconcileOperator(ctx, client, exe, *dir, running, stop); err != nil {
+			slog.Warn("operator reconcile failed", logErr(err))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// reconcileOperator starts, restarts and stops pipelines to match the
+// resources. A resource that can't be read keeps its pipeline as it is.
+func reconcileOperator(ctx context.Context, client *kubeClient, exe, dir string, running map[string]*operatorPipeline, stop func(string)) error {
+	var list struct {
+		Items []tailnetDNSSync `json:"items"`
+	}
+	err := client.do(ctx, http.MethodGet, fmt.Sprintf("/apis/%s/namespaces/%s/tailnetdnssyncs", operatorAPI, client.namespace), nil, &list)
+	if err != nil {
+		return fmt.Errorf("list TailnetDNSSync resources: %w", err)
+	}
+	seen := map[string]bool{}
+	for _, res := range list.Items {
+		name := res.Metadata.Name
+		seen[name] = true
+		env, secretVersion, err := operatorSecretEnv(ctx, client, res.Spec.SecretName)
+		if err != nil {
+			slog.Warn("operator: resource failed", "resource", name, logErr(err))
+			continue
+		}
+		version := strconv.FormatInt(res.Metadata.Generation, 10) + "/" + secretVersion
+		if p, ok := running[name]; ok {
+			if p.version == version {
+				continue
+			}
+			slog.Info("operator: resource changed, restarting its pipeline", "resource", name)
+			stop(name)
+		}
+		if len(res.Spec.Config) == 0 {
+			slog.Warn("operator: spec.config is empty", "resource", name)
+			continue
+		}
+		// YAML reads JSON, so the config is written as the API returns it
+		path := filepath.Join(dir, name+".yaml")
+		if err := writeFileAtomic(path, res.Spec.Config); err != nil {
+			slog.Warn("operator: resource failed", "resource", name, logErr(err))
+			continue
+		}
+		pctx, cancel := context.WithCancel(ctx)
+		p := &operatorPipeline{version: version, cancel: cancel, done: make(chan struct{})}
+		running[name] = p
+		slog.Info("operator: starting the pipeline", "resource", name)
+		go func() {
+			defer close(p.done)
+			supervisePipeline(pctx, exe, name, []string{"daemon", "-config", path}, env)
+		}()
+	}
+	for name := range running {
+		if !seen[name] {
+			slog.Info("operator: resource deleted, stopping its pipeline", "resource", name)
+			stop(name)
+			os.Remove(filepath.Join(dir, name+".yaml"))
+		}
+	}
+	return nil
+}
+
+// operatorSecretEnv returns the keys of the Secret name as environment
+// variables, sorted, and the Secret's resourceVersion.
+func operatorSecretEnv(ctx context.Context, client *kubeClient, name string) ([]string, string, error) {
+	if name == "" {
+		return nil, "", nil
+	}
+	var secret kubeSecret
+	err := client.do(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", client.namespace, name), nil, &secret)
+	if errors.Is(err, errKubeNotFound) {
+		return nil, "", fmt.Errorf("secret %s not found", name)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	var env []string
+	for k, v := range secret.Data {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("secret %s: key %s: %w", name, k, err)
+		}
+		env = append(env, k+"="+string(b))
+	}
+	sort.Strings(env)
+	return env, secret.Metadata.ResourceVersion, nil
+}
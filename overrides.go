@@ -0,0 +1,90 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// deviceAttrRe matches the tags and node attributes a device asks for its
+// own DNS settings with, e.g. tag:dns-name--fileserver, tag:dns-alias--nas
+// or tag:dns-skip.
+var deviceAttrRe = regexp.MustCompile(`dns-skip$|dns-(name|alias)--([a-z0-9-]+)$`)
+
+// deviceOverrides is what a device asked for through its tags and node
+// attributes with DEVICE_OVERRIDES.
+type deviceOverrides struct {
+	Name    string
+	Aliases []string
+	Skip    bool
+}
+
+// readDeviceOverrides returns the overrides of ps. Names that aren't valid
+// DNS labels are ignored; of several dns-name ones the first sorted wins.
+func readDeviceOverrides(ps *ipnstate.PeerStatus) deviceOverrides {
+	var o deviceOverrides
+	if !cfg().DeviceOverrides || ps == nil {
+		return o
+	}
+	var attrs []string
+	if ps.Tags != nil {
+		attrs = ps.Tags.AsSlice()
+	}
+	for capability := range ps.CapMap {
+		attrs = append(attrs, string(capability))
+	}
+	sort.Strings(attrs)
+	for _, a := range attrs {
+		m := deviceAttrRe.FindStringSubmatch(a)
+		switch {
+		case m == nil:
+		case m[1] == "":
+			o.Skip = true
+		case !labelRe.MatchString(m[2]):
+		case m[1] == "name" && o.Name == "":
+			o.Name = m[2]
+		case m[1] == "alias":
+			o.Aliases = append(o.Aliases, m[2])
+		}
+	}
+	return o
+}
+
+// addDeviceAliases adds the aliases devices asked for as copies of their own
+// records. Node names and earlier nodes, this node before its peers, win.
+func addDeviceAliases(st *ipnstate.Status, hosts map[string]host) {
+	if !cfg().DeviceOverrides {
+		return
+	}
+	peers := make([]*ipnstate.PeerStatus, 0, len(st.Peer))
+	for _, ps := range st.Peer {
+		peers = append(peers, ps)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].DNSName < peers[j].DNSName })
+	own := map[string][]host{}
+	taken := map[string]bool{}
+	for _, h := range hosts {
+		if h.NodeID != "" {
+			own[h.NodeID] = append(own[h.NodeID], h)
+		}
+		taken[h.Name] = true
+	}
+	for _, ps := range append([]*ipnstate.PeerStatus{st.Self}, peers...) {
+		o := readDeviceOverrides(ps)
+		if o.Skip || len(o.Aliases) == 0 {
+			continue
+		}
+		for _, alias := range o.Aliases {
+			if taken[alias] || syncState.Excluded[alias] || !nameAllowed(alias) {
+				warnOnce("alias "+alias+" "+string(ps.ID), "not publishing the alias, the name is taken or not allowed", "alias", alias, "node", ps.DNSName)
+				continue
+			}
+			for _, h := range own[string(ps.ID)] {
+				h.Name = alias
+				hosts[recordKey(h.Type, alias, h.Content)] = h
+				taken[alias] = true
+			}
+		}
+	}
+}
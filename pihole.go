@@ -0,0 +1,159 @@
+//go:build !no_pihole
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func init() {
+	registerProvider("pihole", newPiholeProvider)
+}
+
+// piholeProvider manages the local DNS records (custom.list) and local
+// CNAME records of a Pi-hole through its admin API. The entries carry no
+// comments, so every entry under the managed suffix is considered ours: the
+// suffix must be dedicated to the sync.
+type piholeProvider struct {
+	url   string
+	token string
+	zone  string
+}
+
+func newPiholeProvider(ctx context.Context, name string) (provider, error) {
+	base := strings.TrimSuffix(os.Getenv("PIHOLE_URL"), "/")
+	if base == "" {
+		return nil, fmt.Errorf("set PIHOLE_URL, e.g. http://pi.hole")
+	}
+	p := &piholeProvider{url: base + "/admin/api.php", token: secretEnv("PIHOLE_API_TOKEN"), zone: name}
+	// Pi-hole has no zones, only check that the API answers
+	if _, err := p.entries(ctx, "customdns"); err != nil {
+		return nil, fmt.Errorf("get local DNS records: %w", err)
+	}
+	return p, nil
+}
+
+// call runs action on the list (customdns or customcname) and decodes the
+// answer into out.
+func (p *piholeProvider) call(ctx context.Context, list, action string, params url.Values, out any) error {
+	q := url.Values{list: {""}, "action": {action}, "auth": {p.token}}
+	for k, v := range params {
+		q[k] = v
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+"?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	// a wrong token gets an empty array instead of an error
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("decode answer: %w", err)
+	}
+	if string(raw) == "[]" {
+		return fmt.Errorf("unauthorized, check PIHOLE_API_TOKEN")
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// entries returns the (name, value) pairs of list.
+func (p *piholeProvider) entries(ctx context.Context, list string) ([][2]string, error) {
+	var res struct {
+		Data [][2]string `json:"data"`
+	}
+	if err := p.call(ctx, list, "get", nil, &res); err != nil {
+		return nil, err
+	}
+	return res.Data, nil
+}
+
+func (p *piholeProvider) List(ctx context.Context) ([]record, error) {
+	var out []record
+	for _, list := range []string{"customdns", "customcname"} {
+		entries, err := p.entries(ctx, list)
+		if err != nil {
+			return nil, fmt.Errorf("get %s: %w", list, err)
+		}
+		for _, e := range entries {
+			name, value := strings.ToLower(e[0]), e[1]
+			if name != p.zone && !strings.HasSuffix(name, "."+p.zone) {
+				continue
+			}
+			typ := "CNAME"
+			if list == "customdns" {
+				ip, err := netip.ParseAddr(value)
+				if err != nil {
+					continue
+				}
+				typ = "A"
+				if ip.Is6() {
+					typ = "AAAA"
+				}
+			}
+			// Pi-hole answers with its own local TTL, report it as automatic
+			out = append(out, record{ID: name + " " + value, Name: name, Type: typ, Content: value})
+		}
+	}
+	return out, nil
+}
+
+// write adds or deletes the entry for content as c's type.
+func (p *piholeProvider) write(ctx context.Context, action string, c change, content string) error {
+	list, params := "customdns", url.Values{"domain": {c.fqdn()}, "ip": {content}}
+	switch c.Type {
+	case "A", "AAAA":
+	case "CNAME":
+		list, params = "customcname", url.Values{"domain": {c.fqdn()}, "target": {content}}
+	default:
+		return fmt.Errorf("pihole can't publish %s records", c.Type)
+	}
+	var res struct {
+		Success bool   `json:"success"`
+		Message string `json:"message"`
+	}
+	if err := p.call(ctx, list, action, params, &res); err != nil {
+		return err
+	}
+	if !res.Success {
+		if action == "delete" && strings.Contains(res.Message, "does not exist") {
+			// already gone
+			return nil
+		}
+		return fmt.Errorf("%s %s %s: %s", action, c.fqdn(), content, res.Message)
+	}
+	return nil
+}
+
+func (p *piholeProvider) Create(ctx context.Context, c change) (string, error) {
+	return c.fqdn() + " " + c.Content, p.write(ctx, "add", c, c.Content)
+}
+
+// Update replaces the entry, which Pi-hole can only delete and add again.
+func (p *piholeProvider) Update(ctx context.Context, c change) error {
+	if c.OldContent == c.Content {
+		// nothing Pi-hole stores has changed
+		return nil
+	}
+	if err := p.write(ctx, "delete", c, c.OldContent); err != nil {
+		return err
+	}
+	return p.write(ctx, "add", c, c.Content)
+}
+
+func (p *piholeProvider) Delete(ctx context.Context, c change) error {
+	return p.write(ctx, "delete", c, c.Content)
+}
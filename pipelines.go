@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pipelineName is the pipeline this process runs as a child of a
+// supervising daemon, added as a label to every metric.
+var pipelineName = os.Getenv("PIPELINE")
+
+var (
+	pipelinesMu sync.Mutex
+	// pipelineProcs are the running pipelines, to forward signals to.
+	pipelineProcs = map[string]*os.Process{}
+)
+
+// configuredPipelines returns the pipelines of the config file at path
+// (PIPELINES overrides them), without validating the rest of the file.
+func configuredPipelines(path string) ([]string, error) {
+	c := defaultConfig()
+	if path != "" {
+		if err := loadConfigFile(path, "", &c); err != nil {
+			return nil, err
+		}
+	}
+	names := envList("PIPELINES", strings.Join(c.Pipelines, ","))
+	if len(names) == 0 {
+		return nil, nil
+	}
+	if err := setupLogging(envString("LOG_FORMAT", c.LogFormat), envString("LOG_LEVEL", c.LogLevel)); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, fmt.Errorf("pipelines need a config file")
+	}
+	// each pipeline keeps its own state and leader, so two sharing a file,
+	// port or coordination keys would trample each other, and two
+	// publishing under the same suffix would delete each other's records
+	seen := map[string]string{}
+	var errs []string
+	for _, name := range names {
+		if _, ok := c.Profiles[name]; !ok {
+			errs = append(errs, fmt.Sprintf("pipeline %s: no such profile", name))
+			continue
+		}
+		pc := defaultConfig()
+		if err := loadConfigFile(path, name, &pc); err != nil {
+			return nil, err
+		}
+		values := []struct{ what, value string }{
+			{"state_path", pc.StatePath},
+			{"status_path", pc.StatusPath},
+			{"audit_path", pc.AuditPath},
+			{"report_path", pc.ReportPath},
+			{"listen.addr", pc.Listen.Addr},
+		}
+		switch pc.Coordination {
+		case "redis":
+			server := pc.RedisURL
+			if u, err := url.Parse(server); err == nil {
+				server = u.Redacted()
+			}
+			values = append(values, struct{ what, value string }{"redis keys", server + " " + pc.LeaseName + ":*"})
+		case "kubernetes":
+			values = append(values, struct{ what, value string }{"lease", pc.LeaseNamespace + "/" + pc.LeaseName})
+		}
+		for _, suffix := range pipelineSuffixes(&pc) {
+			values = append(values, struct{ what, value string }{"suffix", pc.Provider + ":" + suffix})
+		}
+		for _, v := range values {
+			if v.value == "" {
+				continue
+			}
+			key := v.what + "=" + v.value
+			if other, ok := seen[key]; ok {
+				errs = append(errs, fmt.Sprintf("pipelines %s and %s share %s %s", other, name, v.what, v.value))
+				continue
+			}
+			seen[key] = name
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return names, nil
+}
+
+// pipelineSuffixes returns the suffixes the pipeline configured by c
+// publishes under, like configuredZones does for the running config.
+func pipelineSuffixes(c *config) []string {
+	var out []string
+	for _, name := range c.Domains {
+		out = append(out, c.domainSuffix(name))
+	}
+	return append(out, c.Suffixes...)
+}
+
+// runPipelines runs one daemon per pipeline, each with the profile of the
+// same name, and restarts the ones that exit until ctx is done. The
+// pipelines are separate processes, so their sources, providers,
+// credentials and state can't leak into each other.
+func runPipelines(ctx context.Context, path string, names []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	slog.Info("running pipelines", "pipelines", strings.Join(names, ","))
+	signals := make(chan os.Signal, 1)
+	notifyControlSignals(signals)
+	defer signal.Stop(signals)
+	go func() {
+		for sig := range signals {
+			// the pipelines reload and sync themselves
+			pipelinesMu.Lock()
+			for _, p := range pipelineProcs {
+				p.Signal(sig)
+			}
+			pipelinesMu.Unlock()
+		}
+	}()
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			supervisePipeline(ctx, exe, name, []string{"daemon", "-config", path, "-profile", name}, nil)
+		}(name)
+	}
+	wg.Wait()
+	slog.Info("pipelines stopped")
+	return nil
+}
+
+// supervisePipeline restarts the pipeline name, exe run with args and env
+// added to ours, with backoff whenever it exits before ctx is done.
+func supervisePipeline(ctx context.Context, exe, name string, args, env []string) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := runPipeline(ctx, exe, name, args, env)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > time.Minute {
+			backoff = time.Second
+		}
+		slog.Warn("pipeline exited, restarting it", "pipeline", name, logErr(err), "backoff", backoff.String())
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		backoff = min(2*backoff, time.Minute)
+	}
+}
+
+// runPipeline runs the pipeline name until it exits, prefixing its output
+// with the name unless it's structured and carries a pipeline attribute
+// instead. It's sent SIGTERM when ctx is done.
+func runPipeline(ctx context.Context, exe, name string, args, env []string) error {
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Env = append(append(os.Environ(), env...), "PIPELINE="+name)
+	cmd.Cancel = func() error { return terminate(cmd.Process) }
+	cmd.WaitDelay = 30 * time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pipelinesMu.Lock()
+	pipelineProcs[name] = cmd.Process
+	pipelinesMu.Unlock()
+	defer func() {
+		pipelinesMu.Lock()
+		delete(pipelineProcs, name)
+		pipelinesMu.Unlock()
+	}()
+	prefix := "[" + name + "] "
+	if logFormat != LogFormatPlain {
+		prefix = ""
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go prefixLines(&wg, os.Stdout, stdout, prefix)
+	go prefixLines(&wg, os.Stderr, stderr, prefix)
+	wg.Wait()
+	return cmd.Wait()
+}
+
+var outputMu sync.Mutex
+
+// prefixLines copies r to w line by line, each prefixed with prefix.
+func prefixLines(wg *sync.WaitGroup, w io.Writer, r io.Reader, prefix string) {
+	defer wg.Done()
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		outputMu.Lock()
+		fmt.Fprintf(w, "%s%s\n", prefix, sc.Bytes())
+		outputMu.Unlock()
+	}
+	// keep the pipeline from blocking on a line too long to scan
+	io.Copy(io.Discard, r)
+}
@@ -0,0 +1,74 @@
+// Package sync keys DNS records the way tailscale-dns-sync diffs them and
+// splits the keys wanted and held into those to create, remove and compare.
+// It keeps no state and reads no configuration.
+//
+// It is the first part of the daemon moved out of package main, whose
+// planner (quarantine, renames, ownership markers, TTL policies) builds on
+// Keys and Compare and stays there for now.
+package sync
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Keys decides which wanted and held records are compared with each other.
+type Keys struct {
+	// AllAddresses keys address records by their address too, so a name can
+	// have several.
+	AllAddresses bool
+	// RoundRobin are names whose address records are keyed by address, like
+	// with AllAddresses.
+	RoundRobin []string
+}
+
+// MultiAddress reports whether name may have several records of type typ,
+// one per address.
+func (k Keys) MultiAddress(typ, name string) bool {
+	return (typ == "A" || typ == "AAAA") && (k.AllAddresses || slices.Contains(k.RoundRobin, name))
+}
+
+// Key identifies a record within its zone. A and CNAME records are keyed by
+// name, AAAA and TXT records by name and type, so both address families of
+// a host are diffed independently; SRV records share their owner name
+// between targets, so they are keyed by owner name and target host. Address
+// records of MultiAddress names are keyed by name, type and address.
+func (k Keys) Key(typ, name, content string) string {
+	if k.MultiAddress(typ, name) {
+		return name + " " + typ + " " + content
+	}
+	if typ == "AAAA" || typ == "TXT" {
+		return name + " " + typ
+	}
+	if typ != "SRV" {
+		return name
+	}
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return name
+	}
+	target, _, _ := strings.Cut(strings.ToLower(fields[len(fields)-1]), ".")
+	return name + " " + target
+}
+
+// Compare splits the keys of wanted and held into those only wanted, only
+// held and in both, each sorted.
+func Compare[W, H any](wanted map[string]W, held map[string]H) (create, remove, both []string) {
+	for key := range wanted {
+		if _, ok := held[key]; ok {
+			both = append(both, key)
+		} else {
+			create = append(create, key)
+		}
+	}
+	for key := range held {
+		if _, ok := wanted[key]; !ok {
+			remove = append(remove, key)
+		}
+	}
+	sort.Strings(create)
+	sort.Strings(remove)
+	sort.Strings(both)
+	return create, remove, both
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"sync"
+)
+
+// defaultAllowedRanges are the tailnet's CGNAT and ULA ranges, the only
+// addresses an internal zone should ever carry.
+var defaultAllowedRanges = []string{"100.64.0.0/10", "fd7a:115c:a1e0::/48"}
+
+var (
+	warnedMu sync.Mutex
+	// warned remembers the warnings logged, so each one is only logged once.
+	warned = map[string]bool{}
+)
+
+// allowedRanges returns the ranges addresses published in zoneName must be in.
+func allowedRanges(zoneName string) []netip.Prefix {
+	ranges, ok := cfg().ZoneAllowedRanges[zoneName]
+	if !ok {
+		ranges = cfg().AllowedRanges
+	}
+	out := make([]netip.Prefix, 0, len(ranges))
+	for _, r := range ranges {
+		// validated when the config is loaded
+		p, _ := netip.ParsePrefix(r)
+		out = append(out, p)
+	}
+	return out
+}
+
+// publishable reports whether the address record h may be published in
+// zoneName. Records without an address, like CNAMEs, are always publishable.
+func publishable(zoneName string, h host) bool {
+	addr, err := netip.ParseAddr(h.Content)
+	if err != nil {
+		return true
+	}
+	for _, p := range allowedRanges(zoneName) {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	warnOnce(zoneName+" "+h.Name+" "+h.Content, "not publishing the address, it is outside the allowed ranges", "zone", zoneName, "host", h.Name, "content", h.Content)
+	return false
+}
+
+// warnOnce logs the message for key the first time only.
+func warnOnce(key, msg string, args ...any) {
+	warnedMu.Lock()
+	defer warnedMu.Unlock()
+	if !warned[key] {
+		warned[key] = true
+		slog.Warn(msg, args...)
+	}
+}
+
+func validateRanges(key string, ranges []string) []error {
+	var errs []error
+	for _, r := range ranges {
+		if _, err := netip.ParsePrefix(r); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errs
+}
+
+// allowedByPolicy drops the changes POLICY doesn't allow: upsert-only keeps
+// every record, even from quarantine, and create-only also leaves the
+// existing records as they are.
+func allowedByPolicy(changes []change) []change {
+	if cfg().Policy == PolicySync {
+		return changes
+	}
+	kept := changes[:0]
+	for _, c := range changes {
+		switch {
+		case c.Action == actionDelete, c.Quarantine:
+			continue
+		case c.Action == actionUpdate && cfg().Policy == PolicyCreateOnly:
+			continue
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
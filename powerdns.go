@@ -0,0 +1,205 @@
+//go:build !no_powerdns
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+func init() {
+	registerProvider("powerdns", newPowerDNSProvider)
+}
+
+// powerDNSProvider manages the records in one zone of a PowerDNS
+// Authoritative Server through its HTTP API. Records sharing a name and type
+// form one RRset, which carries the ownership marker as its comment.
+type powerDNSProvider struct {
+	url    string
+	key    string
+	zone   string
+	zoneID string
+	mu     sync.Mutex
+}
+
+type powerDNSRRset struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	TTL        int               `json:"ttl,omitempty"`
+	ChangeType string            `json:"changetype,omitempty"`
+	Records    []powerDNSRecord  `json:"records"`
+	Comments   []powerDNSComment `json:"comments"`
+}
+
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type powerDNSComment struct {
+	Content string `json:"content"`
+	Account string `json:"account"`
+}
+
+// owned reports whether the sync manages rs.
+func (rs powerDNSRRset) owned() bool {
+	return slices.ContainsFunc(rs.Comments, func(c powerDNSComment) bool {
+		return ownedComment(c.Content)
+	})
+}
+
+func newPowerDNSProvider(ctx context.Context, name string) (provider, error) {
+	base := strings.TrimSuffix(os.Getenv("PDNS_API_URL"), "/")
+	if base == "" {
+		return nil, fmt.Errorf("set PDNS_API_URL, e.g. http://pdns:8081")
+	}
+	server := os.Getenv("PDNS_SERVER_ID")
+	if server == "" {
+		server = "localhost"
+	}
+	p := &powerDNSProvider{
+		url:    base + "/api/v1/servers/" + url.PathEscape(server),
+		key:    secretEnv("PDNS_API_KEY"),
+		zone:   name,
+		zoneID: url.PathEscape(name + "."),
+	}
+	// omit the records, only the zone's existence matters here
+	if err := p.call(ctx, http.MethodGet, "/zones/"+p.zoneID+"?rrsets=false", nil, nil); err != nil {
+		if strings.HasPrefix(err.Error(), "404") || strings.HasPrefix(err.Error(), "422") {
+			return nil, fmt.Errorf("%w: %s", errZoneNotFound, name)
+		}
+		return nil, fmt.Errorf("get zone %s: %w", name, err)
+	}
+	return p, nil
+}
+
+func (p *powerDNSProvider) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", p.key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// rrsets returns the RRsets of the zone, only those of name and typ when set.
+func (p *powerDNSProvider) rrsets(ctx context.Context, name, typ string) ([]powerDNSRRset, error) {
+	path := "/zones/" + p.zoneID
+	if name != "" {
+		path += "?" + url.Values{"rrset_name": {name + "."}, "rrset_type": {typ}}.Encode()
+	}
+	var zone struct {
+		RRsets []powerDNSRRset `json:"rrsets"`
+	}
+	if err := p.call(ctx, http.MethodGet, path, nil, &zone); err != nil {
+		return nil, err
+	}
+	return zone.RRsets, nil
+}
+
+func (p *powerDNSProvider) List(ctx context.Context) ([]record, error) {
+	sets, err := p.rrsets(ctx, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("list RRsets: %w", err)
+	}
+	var out []record
+	for _, rs := range sets {
+		if !rs.owned() {
+			continue
+		}
+		name := strings.TrimSuffix(rs.Name, ".")
+		for _, r := range rs.Records {
+			// the marker belongs to the whole RRset, so no per-record comment
+			out = append(out, record{ID: name + " " + rs.Type, Name: name, Type: rs.Type, Content: strings.TrimSuffix(r.Content, "."), TTL: rs.TTL})
+		}
+	}
+	return out, nil
+}
+
+func (p *powerDNSProvider) StoredTTL(ttl int) int {
+	if ttl <= 1 {
+		// PowerDNS has no automatic TTL
+		return 300
+	}
+	return ttl
+}
+
+func (p *powerDNSProvider) Create(ctx context.Context, c change) (string, error) {
+	return c.fqdn() + " " + c.Type, p.edit(ctx, c)
+}
+
+func (p *powerDNSProvider) Update(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+func (p *powerDNSProvider) Delete(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+// edit applies c to its RRset, refusing to touch RRsets the sync doesn't own.
+func (p *powerDNSProvider) edit(ctx context.Context, c change) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sets, err := p.rrsets(ctx, c.fqdn(), c.Type)
+	if err != nil {
+		return fmt.Errorf("get RRset %s %s: %w", c.fqdn(), c.Type, err)
+	}
+	var values []string
+	for _, rs := range sets {
+		if rs.Name != c.fqdn()+"." || rs.Type != c.Type || len(rs.Records) == 0 {
+			continue
+		}
+		if !rs.owned() {
+			return fmt.Errorf("%s %s exists and isn't managed by the sync", c.fqdn(), c.Type)
+		}
+		for _, r := range rs.Records {
+			values = append(values, strings.TrimSuffix(r.Content, "."))
+		}
+	}
+	if c.Action == actionDelete && !slices.Contains(values, c.Content) {
+		// already gone
+		return nil
+	}
+	rs := powerDNSRRset{Name: c.fqdn() + ".", Type: c.Type, ChangeType: "DELETE", Records: []powerDNSRecord{}, Comments: []powerDNSComment{}}
+	if values = editRecordSet(values, c); len(values) > 0 {
+		rs.ChangeType, rs.TTL = "REPLACE", p.StoredTTL(c.TTL)
+		for _, v := range values {
+			if c.Type == "CNAME" || c.Type == "SRV" || c.Type == "PTR" {
+				v += "."
+			}
+			rs.Records = append(rs.Records, powerDNSRecord{Content: v})
+		}
+		rs.Comments = []powerDNSComment{{Content: cfg().OwnershipMarker, Account: "tailscale-dns-sync"}}
+	}
+	return p.call(ctx, http.MethodPatch, "/zones/"+p.zoneID, map[string]any{"rrsets": []powerDNSRRset{rs}}, nil)
+}
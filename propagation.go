@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// verifyAuthoritative in VERIFY_RESOLVERS stands for the zone's own
+// nameservers, looked up from its NS records.
+const verifyAuthoritative = "authoritative"
+
+// verifyPollInterval is how often a resolver is asked again while it
+// doesn't answer with a changed record yet.
+const verifyPollInterval = 5 * time.Second
+
+var (
+	metricPropagationFailures = newMetric("counter", "propagation_failures_total", "Created or updated records a resolver of VERIFY_RESOLVERS didn't answer with within VERIFY_TIMEOUT, by zone and resolver.")
+	metricPropagationSeconds  = newHistogram("propagation_seconds", "Time from applying a record until a resolver of VERIFY_RESOLVERS answered with it, by resolver.", []float64{1, 5, 15, 30, 60, 120, 300})
+	// propagationWG tracks the verifications running, for `once` to wait.
+	propagationWG sync.WaitGroup
+)
+
+// verifyPropagation checks in the background that every resolver of
+// VERIFY_RESOLVERS answers the address and CNAME records created or updated
+// in z with their new content within VERIFY_TIMEOUT, logging and counting
+// those that don't. Proxied records are skipped since they resolve to the
+// proxy.
+func verifyPropagation(ctx context.Context, z *zone, applied []change) {
+	if len(cfg().VerifyResolvers) == 0 {
+		return
+	}
+	var changes []change
+	for _, c := range applied {
+		if (c.Action == actionCreate || c.Action == actionUpdate) && !c.Proxied && !c.Quarantine &&
+			(c.Type == "A" || c.Type == "AAAA" || c.Type == "CNAME") {
+			changes = append(changes, c)
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	for _, resolver := range cfg().VerifyResolvers {
+		propagationWG.Add(1)
+		go func(resolver string) {
+			defer propagationWG.Done()
+			verifyResolver(ctx, z, resolver, changes)
+		}(resolver)
+	}
+}
+
+func verifyResolver(ctx context.Context, z *zone, resolver string, changes []change) {
+	ctx, cancel := context.WithTimeout(ctx, cfg().VerifyTimeout)
+	defer cancel()
+	start := time.Now()
+	server, recursive := resolver, true
+	if resolver == verifyAuthoritative {
+		ns, err := net.DefaultResolver.LookupNS(ctx, z.Name)
+		if err != nil || len(ns) == 0 {
+			slog.Warn("looking up the zone's nameservers to verify the changes failed", "zone", z.Name, logErr(err))
+			return
+		}
+		server, recursive = ns[0].Host, false
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.TrimSuffix(server, "."), "53")
+	}
+	pending := changes
+	for {
+		var left []change
+		for _, c := range pending {
+			if ok, err := resolvesTo(ctx, server, recursive, c); !ok {
+				if err != nil {
+					slog.Debug("verifying the change", "change", c.String(), "resolver", resolver, logErr(err))
+				}
+				left = append(left, c)
+				continue
+			}
+			metricPropagationSeconds.Observe(time.Since(start).Seconds(), "resolver", resolver)
+		}
+		if pending = left; len(pending) == 0 {
+			return
+		}
+		select {
+		case <-time.After(verifyPollInterval):
+		case <-ctx.Done():
+			for _, c := range pending {
+				slog.Warn("record doesn't resolve to its new content", "record", c.fqdn(), "type", c.Type, "content", c.Content, "resolver", resolver, "waited", cfg().VerifyTimeout.String())
+				metricPropagationFailures.Inc("zone", z.Name, "resolver", resolver)
+			}
+			return
+		}
+	}
+}
+
+// resolvesTo reports whether server answers the name of c with its content
+// among the records of its type.
+func resolvesTo(ctx context.Context, server string, recursive bool, c change) (bool, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(c.fqdn()), dns.StringToType[c.Type])
+	m.RecursionDesired = recursive
+	r, _, err := new(dns.Client).ExchangeContext(ctx, m, server)
+	if err != nil {
+		return false, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return false, fmt.Errorf("%s", dns.RcodeToString[r.Rcode])
+	}
+	want := strings.ToLower(strings.TrimSuffix(c.Content, "."))
+	for _, rr := range r.Answer {
+		var got string
+		switch rr := rr.(type) {
+		case *dns.A:
+			got = rr.A.String()
+		case *dns.AAAA:
+			got = rr.AAAA.String()
+		case *dns.CNAME:
+			got = strings.ToLower(strings.TrimSuffix(rr.Target, "."))
+		}
+		if got == want || c.Type != "CNAME" && net.ParseIP(got).Equal(net.ParseIP(want)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// record is a managed DNS record as reported by a provider.
+type record struct {
+	ID      string
+	Name    string
+	Type    string
+	Content string
+	// TTL is 0 for providers that don't keep one.
+	TTL int
+	// Comment is the full record comment, for providers that have them.
+	Comment string
+	Proxied bool
+	// ModifiedOn is when the provider last changed the record, if it says.
+	ModifiedOn time.Time
+}
+
+// provider manages the records we own in a single zone.
+type provider interface {
+	// List returns every record carrying our ownership marker.
+	List(ctx context.Context) ([]record, error)
+	// Create creates the record described by c and returns its ID.
+	Create(ctx context.Context, c change) (string, error)
+	// Update replaces the content of the record c.RecordID.
+	Update(ctx context.Context, c change) error
+	// Delete removes the record c.RecordID.
+	Delete(ctx context.Context, c change) error
+}
+
+// pager is implemented by providers that can narrow listings to the names
+// ending in suffix and deliver them a page at a time.
+type pager interface {
+	ListPages(ctx context.Context, suffix string, fn func([]record) error) error
+}
+
+// listPages passes the records we manage under z's suffix to fn, page by
+// page when the provider supports it.
+func listPages(ctx context.Context, z *zone, fn func([]record) error) (err error) {
+	ctx, sp := startSpan(ctx, "provider list", "zone", z.Name, "provider", z.providerLabel())
+	defer func() { sp.finish(err) }()
+	if err := throttle(ctx); err != nil {
+		return err
+	}
+	if z.registry != nil {
+		// a record and its TXT record may come on different pages
+		var all []record
+		inner := fn
+		defer func() {
+			if err == nil {
+				var foreign []record
+				if foreign, err = foreignRegistry(ctx, z, z.Suffix); err == nil {
+					err = inner(z.registry.filter(z, append(all, foreign...)))
+				}
+			}
+		}()
+		fn = func(page []record) error {
+			all = append(all, page...)
+			return nil
+		}
+	}
+	if p, ok := z.provider.(pager); ok {
+		// errors of fn aren't the provider's
+		var fnErr bool
+		err := p.ListPages(ctx, z.Suffix, func(page []record) error {
+			countAPICall(apiCallList)
+			err := fn(page)
+			fnErr = err != nil
+			return err
+		})
+		if err != nil && !fnErr {
+			countAPIError(z, apiCallList)
+		}
+		return err
+	}
+	countAPICall(apiCallList)
+	records, err := z.provider.List(ctx)
+	if err != nil {
+		countAPIError(z, apiCallList)
+		return err
+	}
+	return fn(records)
+}
+
+// batcher is implemented by providers with a bulk endpoint. ApplyBatch
+// applies chunk atomically and returns it with record IDs filled in.
+type batcher interface {
+	ApplyBatch(ctx context.Context, chunk []change) ([]change, error)
+}
+
+// registryLister is implemented by providers listing only marked records.
+// ListRegistry returns the TXT records under suffix their listing leaves
+// out, so REGISTRY=txt sees the claims of other owners.
+type registryLister interface {
+	ListRegistry(ctx context.Context, suffix string) ([]record, error)
+}
+
+// ttlMapper is implemented by providers that store another TTL than the one
+// asked for, such as 300 seconds for the automatic TTL they don't have.
+type ttlMapper interface {
+	// StoredTTL returns the TTL a record written with ttl lists with.
+	StoredTTL(ttl int) int
+}
+
+// storedTTL returns the TTL a record of z written with ttl lists with.
+func storedTTL(z *zone, ttl int) int {
+	if m, ok := z.provider.(ttlMapper); ok {
+		return m.StoredTTL(ttl)
+	}
+	return ttl
+}
+
+// adopter is implemented by providers that can take over the unmarked
+// records ADOPT_UNMARKED migrates.
+type adopter interface {
+	Adopt(ctx context.Context, c change) (string, error)
+}
+
+// errZoneNotFound is returned by providers asked for a zone that doesn't exist.
+var errZoneNotFound = errors.New("zone not found")
+
+// providerFactory opens the provider for the zone called name.
+type providerFactory func(ctx context.Context, name string) (provider, error)
+
+// zoneCreator creates the empty zone called name.
+type zoneCreator func(ctx context.Context, name string) error
+
+var (
+	providerFactories = map[string]providerFactory{}
+	zoneCreators      = map[string]zoneCreator{}
+	shutdownHooks     []func()
+)
+
+// onShutdown registers fn to run when the daemon exits, e.g. to stop plugin
+// processes started by a provider.
+func onShutdown(fn func()) {
+	shutdownHooks = append(shutdownHooks, fn)
+}
+
+func runShutdownHooks() {
+	for _, fn := range shutdownHooks {
+		fn()
+	}
+}
+
+// registerProvider makes a provider selectable with PROVIDER. Every provider
+// registers itself from a file guarded by a no_<name> build tag, so backends
+// that aren't needed can be left out of the binary.
+func registerProvider(name string, f providerFactory) {
+	providerFactories[name] = f
+}
+
+// registerZoneCreator lets CREATE_ZONES create missing zones for the
+// provider called name.
+func registerZoneCreator(name string, f zoneCreator) {
+	zoneCreators[name] = f
+}
+
+func providerNames() string {
+	names := make([]string, 0, len(providerFactories))
+	for name := range providerFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// configuredZones returns the zones from DOMAIN and SUFFIXES without their
+// providers.
+func configuredZones() []*zone {
+	var out []*zone
+	for _, name := range cfg().Domains {
+		out = append(out, &zone{Name: name, Suffix: domainSuffix(name)})
+	}
+	for _, suffix := range cfg().Suffixes {
+		out = append(out, &zone{Name: suffix, Suffix: suffix})
+	}
+	return out
+}
+
+// domainSuffix returns the suffix hosts are published under in the DOMAIN
+// zone name.
+func domainSuffix(name string) string {
+	return cfg().domainSuffix(name)
+}
+
+func (c *config) domainSuffix(name string) string {
+	suffix := c.DomainSuffix
+	if s, ok := c.ZoneSettings[name]; ok && s.Suffix != nil {
+		suffix = *s.Suffix
+	}
+	if suffix == "" {
+		return name
+	}
+	return suffix + "." + name
+}
+
+// openZones opens the configured provider for every configured zone.
+func openZones(ctx context.Context) ([]*zone, error) {
+	factory, ok := providerFactories[cfg().Provider]
+	if !ok {
+		return nil, fmt.Errorf("provider %q is not compiled in (available: %s)", cfg().Provider, providerNames())
+	}
+	var out []*zone
+	for _, name := range cfg().Domains {
+		p, err := factory(ctx, name)
+		if create := zoneCreators[cfg().Provider]; errors.Is(err, errZoneNotFound) && cfg().CreateZones && create != nil {
+			slog.Info("zone doesn't exist, creating it", "zone", name)
+			if err := create(ctx, name); err != nil {
+				return nil, fmt.Errorf("create %s zone %s: %w", cfg().Provider, name, err)
+			}
+			p, err = factory(ctx, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open %s zone %s: %w", cfg().Provider, name, err)
+		}
+		out = append(out, &zone{Name: name, Suffix: domainSuffix(name), provider: p})
+	}
+	for _, suffix := range cfg().Suffixes {
+		p, zoneName, err := findZone(ctx, factory, suffix)
+		if err != nil {
+			return nil, fmt.Errorf("find %s zone for %s: %w", cfg().Provider, suffix, err)
+		}
+		slog.Info("publishing the suffix", "suffix", "*."+suffix, "zone", zoneName)
+		out = append(out, &zone{Name: suffix, Suffix: suffix, provider: p})
+	}
+	for _, name := range cfg().ReverseZones {
+		p, err := factory(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("open %s reverse zone %s: %w", cfg().Provider, name, err)
+		}
+		out = append(out, &zone{Name: name, Suffix: name, provider: p, reverse: true})
+	}
+	for i := range cfg().FunnelNames {
+		f := &cfg().FunnelNames[i]
+		_, parent, _ := strings.Cut(f.Name, ".")
+		p, zoneName, err := findZone(ctx, factory, parent)
+		if err != nil {
+			return nil, fmt.Errorf("find %s zone for %s: %w", cfg().Provider, f.Name, err)
+		}
+		slog.Info("publishing a Funnel name", "host", f.Name, "node", f.Node, "zone", zoneName)
+		out = append(out, &zone{Name: f.Name, Suffix: parent, provider: p, funnel: f})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no zone configured, set DOMAIN or SUFFIXES")
+	}
+	if cfg().Registry == RegistryTXT {
+		for _, z := range out {
+			// funnel zones share their parent with other records
+			if z.funnel == nil {
+				z.registry = newTXTRegistry()
+			}
+		}
+	}
+	return out, nil
+}
+
+// findZone opens the zone suffix belongs to: the longest of suffix and its
+// parent domains the provider has a zone for.
+func findZone(ctx context.Context, factory providerFactory, suffix string) (provider, string, error) {
+	labels := strings.Split(suffix, ".")
+	// stop before the TLD
+	for i := 0; i < len(labels)-1; i++ {
+		name := strings.Join(labels[i:], ".")
+		p, err := factory(ctx, name)
+		if errors.Is(err, errZoneNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return p, name, nil
+	}
+	return nil, "", errZoneNotFound
+}
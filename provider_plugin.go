@@ -0,0 +1,102 @@
+//go:build !no_plugin
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+
+	"tailscale-dns-sync/providerplugin"
+)
+
+var (
+	pluginOnce sync.Once
+	pluginImpl providerplugin.Provider
+	pluginErr  error
+)
+
+func init() {
+	registerProvider("plugin", newPluginProvider)
+}
+
+// pluginProvider forwards a zone's operations to an out-of-tree plugin binary.
+type pluginProvider struct {
+	impl providerplugin.Provider
+	zone string
+}
+
+func newPluginProvider(ctx context.Context, name string) (provider, error) {
+	pluginOnce.Do(func() {
+		if cfg().PluginPath == "" {
+			pluginErr = fmt.Errorf("PROVIDER_PLUGIN is not set")
+			return
+		}
+		c := plugin.NewClient(&plugin.ClientConfig{
+			HandshakeConfig:  providerplugin.Handshake,
+			Plugins:          plugin.PluginSet{providerplugin.PluginName: &providerplugin.GRPCPlugin{}},
+			Cmd:              exec.Command(cfg().PluginPath),
+			AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+			Logger:           hclog.New(&hclog.LoggerOptions{Name: "plugin", Level: hclog.Warn}),
+		})
+		onShutdown(c.Kill)
+		rpc, err := c.Client()
+		if err != nil {
+			pluginErr = fmt.Errorf("start plugin %s: %w", cfg().PluginPath, err)
+			return
+		}
+		raw, err := rpc.Dispense(providerplugin.PluginName)
+		if err != nil {
+			pluginErr = fmt.Errorf("dispense plugin %s: %w", cfg().PluginPath, err)
+			return
+		}
+		pluginImpl = raw.(providerplugin.Provider)
+	})
+	if pluginErr != nil {
+		return nil, pluginErr
+	}
+	return &pluginProvider{impl: pluginImpl, zone: name}, nil
+}
+
+func (p *pluginProvider) toPlugin(c change) providerplugin.Change {
+	return providerplugin.Change{
+		Action:     c.Action,
+		Name:       strings.TrimSuffix(c.fqdn(), "."+p.zone),
+		Type:       c.Type,
+		Content:    c.Content,
+		OldContent: c.OldContent,
+		TTL:        c.TTL,
+		RecordID:   c.RecordID,
+		FQDN:       c.fqdn(),
+		Marker:     cfg().OwnershipMarker,
+	}
+}
+
+func (p *pluginProvider) List(ctx context.Context) ([]record, error) {
+	records, err := p.impl.List(ctx, p.zone)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]record, 0, len(records))
+	for _, r := range records {
+		out = append(out, record{ID: r.ID, Name: r.Name, Type: r.Type, Content: r.Content, TTL: r.TTL})
+	}
+	return out, nil
+}
+
+func (p *pluginProvider) Create(ctx context.Context, c change) (string, error) {
+	return p.impl.Create(ctx, p.zone, p.toPlugin(c))
+}
+
+func (p *pluginProvider) Update(ctx context.Context, c change) error {
+	return p.impl.Update(ctx, p.zone, p.toPlugin(c))
+}
+
+func (p *pluginProvider) Delete(ctx context.Context, c change) error {
+	return p.impl.Delete(ctx, p.zone, p.toPlugin(c))
+}
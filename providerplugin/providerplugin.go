@@ -0,0 +1,211 @@
+// Package providerplugin is the contract between tailscale-dns-sync and
+// out-of-tree DNS provider plugins. Plugins are separate binaries served over
+// gRPC with hashicorp/go-plugin; a Go plugin only has to implement Provider
+// and call Serve from its main function. Plugins in other languages implement
+// the service described in provider.proto.
+package providerplugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hashicorp/go-plugin"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ProtocolVersion is bumped on incompatible changes to the contract;
+// go-plugin refuses to start a plugin built for another version.
+const ProtocolVersion = 1
+
+// PluginName is the name the provider is dispensed under.
+const PluginName = "provider"
+
+// Handshake is shared by the host and every plugin.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  ProtocolVersion,
+	MagicCookieKey:   "TAILSCALE_DNS_SYNC_PLUGIN",
+	MagicCookieValue: "provider",
+}
+
+// Record is a managed DNS record.
+type Record struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Content of SRV records is "priority weight port target".
+	Content string `json:"content"`
+	TTL     int    `json:"ttl,omitempty"`
+}
+
+// Change is a single mutation requested by the host.
+type Change struct {
+	Action     string `json:"action"`
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Content    string `json:"content,omitempty"`
+	OldContent string `json:"old_content,omitempty"`
+	// TTL is in seconds, 1 meaning the provider's automatic TTL.
+	TTL      int    `json:"ttl,omitempty"`
+	RecordID string `json:"record_id,omitempty"`
+	// FQDN is the full record name, for providers that don't take names
+	// relative to the zone.
+	FQDN string `json:"fqdn"`
+	// Marker is the ownership marker the record must carry so List returns it.
+	Marker string `json:"marker"`
+}
+
+// Provider is implemented by plugins. Every call names the zone it applies
+// to, so one plugin process serves all configured zones.
+type Provider interface {
+	// List returns every record carrying the ownership marker in zone.
+	List(ctx context.Context, zone string) ([]Record, error)
+	// Create creates the record and returns its ID.
+	Create(ctx context.Context, zone string, c Change) (string, error)
+	// Update replaces the content of c.RecordID.
+	Update(ctx context.Context, zone string, c Change) error
+	// Delete removes c.RecordID.
+	Delete(ctx context.Context, zone string, c Change) error
+}
+
+// Serve runs impl as a plugin and blocks until the host disconnects.
+func Serve(impl Provider) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         plugin.PluginSet{PluginName: &GRPCPlugin{Impl: impl}},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+}
+
+// GRPCPlugin adapts Provider to go-plugin.
+type GRPCPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	Impl Provider
+}
+
+func (p *GRPCPlugin) GRPCServer(_ *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&serviceDesc, p.Impl)
+	return nil
+}
+
+func (p *GRPCPlugin) GRPCClient(_ context.Context, _ *plugin.GRPCBroker, c *grpc.ClientConn) (interface{}, error) {
+	return &client{conn: c}, nil
+}
+
+const serviceName = "tailscalednssync.provider.v1.Provider"
+
+// request and response travel as google.protobuf.Struct, see provider.proto.
+type request struct {
+	Zone   string  `json:"zone"`
+	Change *Change `json:"change,omitempty"`
+}
+
+type response struct {
+	Records []Record `json:"records,omitempty"`
+	ID      string   `json:"id,omitempty"`
+}
+
+func toStruct(v any) (*structpb.Struct, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+func fromStruct(s *structpb.Struct, v any) error {
+	b, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func method(name string, call func(ctx context.Context, p Provider, req request) (response, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, _ grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(structpb.Struct)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			var req request
+			if err := fromStruct(in, &req); err != nil {
+				return nil, err
+			}
+			if req.Change == nil {
+				req.Change = &Change{}
+			}
+			resp, err := call(ctx, srv.(Provider), req)
+			if err != nil {
+				return nil, err
+			}
+			return toStruct(resp)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*Provider)(nil),
+	Methods: []grpc.MethodDesc{
+		method("List", func(ctx context.Context, p Provider, req request) (response, error) {
+			records, err := p.List(ctx, req.Zone)
+			return response{Records: records}, err
+		}),
+		method("Create", func(ctx context.Context, p Provider, req request) (response, error) {
+			id, err := p.Create(ctx, req.Zone, *req.Change)
+			return response{ID: id}, err
+		}),
+		method("Update", func(ctx context.Context, p Provider, req request) (response, error) {
+			return response{}, p.Update(ctx, req.Zone, *req.Change)
+		}),
+		method("Delete", func(ctx context.Context, p Provider, req request) (response, error) {
+			return response{}, p.Delete(ctx, req.Zone, *req.Change)
+		}),
+	},
+	Metadata: "provider.proto",
+}
+
+// client is the host side of Provider.
+type client struct {
+	conn *grpc.ClientConn
+}
+
+func (c *client) call(ctx context.Context, name string, req request) (response, error) {
+	in, err := toStruct(req)
+	if err != nil {
+		return response{}, err
+	}
+	out := new(structpb.Struct)
+	if err := c.conn.Invoke(ctx, "/"+serviceName+"/"+name, in, out); err != nil {
+		return response{}, err
+	}
+	var resp response
+	err = fromStruct(out, &resp)
+	return resp, err
+}
+
+func (c *client) List(ctx context.Context, zone string) ([]Record, error) {
+	resp, err := c.call(ctx, "List", request{Zone: zone})
+	return resp.Records, err
+}
+
+func (c *client) Create(ctx context.Context, zone string, ch Change) (string, error) {
+	resp, err := c.call(ctx, "Create", request{Zone: zone, Change: &ch})
+	return resp.ID, err
+}
+
+func (c *client) Update(ctx context.Context, zone string, ch Change) error {
+	_, err := c.call(ctx, "Update", request{Zone: zone, Change: &ch})
+	return err
+}
+
+func (c *client) Delete(ctx context.Context, zone string, ch Change) error {
+	_, err := c.call(ctx, "Delete", request{Zone: zone, Change: &ch})
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ptrProviders are the providers that can hold the PTR records of
+// REVERSE_ZONES.
+var ptrProviders = []string{"builtin", "cloudflare", "memory", "powerdns", "rfc2136", "route53", "webhook"}
+
+// validateReverseZone checks that name is an in-addr.arpa or ip6.arpa zone.
+func validateReverseZone(name string) error {
+	if !strings.HasSuffix(name, ".in-addr.arpa") && !strings.HasSuffix(name, ".ip6.arpa") {
+		return fmt.Errorf("reverse_zones: %q is not an in-addr.arpa or ip6.arpa zone", name)
+	}
+	return nil
+}
+
+// reverseName returns the name of the PTR record of addr, e.g.
+// 1.0.64.100.in-addr.arpa for 100.64.0.1.
+func reverseName(addr netip.Addr) string {
+	var labels []string
+	if addr.Is4() {
+		b := addr.As4()
+		for i := len(b) - 1; i >= 0; i-- {
+			labels = append(labels, strconv.Itoa(int(b[i])))
+		}
+		return strings.Join(labels, ".") + ".in-addr.arpa"
+	}
+	b := addr.As16()
+	for i := len(b) - 1; i >= 0; i-- {
+		labels = append(labels, strconv.FormatUint(uint64(b[i]&0xf), 16), strconv.FormatUint(uint64(b[i]>>4), 16))
+	}
+	return strings.Join(labels, ".") + ".ip6.arpa"
+}
+
+// ptrHosts returns the PTR records of the reverse zone z: one per published
+// address inside it, pointing at the host's name in the first forward zone
+// publishing it. An address published under several names, like tag
+// subdomain copies, points at its plain name.
+func ptrHosts(z *zone, hosts map[string]host) map[string]host {
+	keys := make([]string, 0, len(hosts))
+	for key := range hosts {
+		keys = append(keys, key)
+	}
+	// plain names before subdomain copies and wildcards, then by name
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := hosts[keys[i]].Name, hosts[keys[j]].Name
+		if da, db := strings.Count(a, "."), strings.Count(b, "."); da != db {
+			return da < db
+		}
+		return a < b
+	})
+	out := map[string]host{}
+	for _, key := range keys {
+		h := hosts[key]
+		if h.Type != "A" && h.Type != "AAAA" || strings.HasPrefix(h.Name, "*.") {
+			continue
+		}
+		addr, err := netip.ParseAddr(h.Content)
+		if err != nil {
+			continue
+		}
+		name, ok := strings.CutSuffix(reverseName(addr), "."+z.Suffix)
+		if !ok {
+			continue
+		}
+		if _, ok := out[name]; ok {
+			continue
+		}
+		for _, fz := range zones {
+			if fz.forward() && publishable(fz.Name, h) {
+				ptr := h
+				ptr.Name, ptr.Type, ptr.Content = name, "PTR", h.Name+"."+fz.Suffix
+				ptr.Proxied = false
+				out[name] = ptr
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pushMetrics replaces the metrics of the job PUSHGATEWAY_JOB on the
+// Prometheus Pushgateway at PUSHGATEWAY_URL with the current ones, for runs
+// that exit before anything scrapes /metrics. Pipelines push under a
+// grouping key of their own.
+func pushMetrics(ctx context.Context) {
+	if cfg().PushgatewayURL == "" {
+		return
+	}
+	var body bytes.Buffer
+	writeMetrics(&body)
+	endpoint := strings.TrimSuffix(cfg().PushgatewayURL, "/") + "/metrics/job/" + url.PathEscape(cfg().PushgatewayJob)
+	if pipelineName != "" {
+		endpoint += "/pipeline/" + url.PathEscape(pipelineName)
+	}
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(cctx, http.MethodPut, endpoint, &body)
+	if err != nil {
+		slog.Warn("pushgateway request failed", logErr(err))
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	if err := doRequest(req); err != nil {
+		slog.Warn("pushgateway push failed", logErr(err))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// quarantineMarker is appended to the comment of quarantined records.
+const quarantineMarker = " pending removal"
+
+// quarantinedSince returns when the record key of z was quarantined.
+func quarantinedSince(z *zone, key string) (time.Time, bool) {
+	since, ok := syncState.Quarantined[z.Name][key]
+	return since, ok
+}
+
+// quarantineChange returns the update moving r, about to be deleted for
+// reason, into quarantine: a short TTL and a marked comment, so resolvers
+// forget it quickly and the record shows what is about to happen.
+func quarantineChange(z *zone, key string, r record, reason string) change {
+	name, _, _ := strings.Cut(key, " ")
+	c := change{Action: actionUpdate, Zone: z.Name, Suffix: z.Suffix, Name: name, Type: r.Type,
+		Content: r.Content, OldContent: r.Content, TTL: cfg().QuarantineTTL, OldTTL: r.TTL, RecordID: r.ID, observedAt: r.ModifiedOn,
+		Proxied: r.Proxied, Quarantine: true, Reason: fmt.Sprintf("%s, quarantined for %s", reason, cfg().Quarantine)}
+	if r.Comment != "" {
+		c.Comment = strings.TrimSuffix(r.Comment, quarantineMarker) + quarantineMarker
+	}
+	return c
+}
+
+// trackQuarantine records the quarantines applied in z and forgets records
+// that were restored, deleted or are no longer listed.
+func trackQuarantine(z *zone, applied []change, records map[string]record, now time.Time) {
+	q := syncState.Quarantined[z.Name]
+	for _, c := range applied {
+		if c.Quarantine {
+			if q == nil {
+				q = map[string]time.Time{}
+			}
+			q[c.key()] = now
+		} else {
+			delete(q, c.key())
+		}
+	}
+	if records != nil {
+		for key := range q {
+			if _, ok := records[key]; !ok {
+				delete(q, key)
+			}
+		}
+	}
+	if len(q) == 0 {
+		delete(syncState.Quarantined, z.Name)
+		return
+	}
+	if syncState.Quarantined == nil {
+		syncState.Quarantined = map[string]map[string]time.Time{}
+	}
+	syncState.Quarantined[z.Name] = q
+}
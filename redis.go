@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisLock is a leader lock held with SET NX PX and renewed only by its owner.
+// Its keys are named after LEASE_NAME, <name>:leader for the lock and
+// <name>:sync for the requested sync.
+type redisLock struct {
+	client  *redis.Client
+	key     string
+	syncKey string
+	id      string
+	ttl     time.Duration
+}
+
+var (
+	redisRenew = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	redisRelease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func newRedisClient(rawURL string) (*redis.Client, error) {
+	opt, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(opt), nil
+}
+
+func newRedisLock(rawURL, name string, ttl time.Duration) (*redisLock, error) {
+	c, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &redisLock{
+		client:  c,
+		key:     name + ":leader",
+		syncKey: name + ":sync",
+		id:      instanceID(),
+		ttl:     ttl,
+	}, nil
+}
+
+func (l *redisLock) TryLock(ctx context.Context) (bool, error) {
+	ok, err := l.client.SetNX(ctx, l.key, l.id, l.ttl).Result()
+	if err != nil || ok {
+		return ok, err
+	}
+	n, err := redisRenew.Run(ctx, l.client, []string{l.key}, l.id, l.ttl.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (l *redisLock) Unlock(ctx context.Context) error {
+	return redisRelease.Run(ctx, l.client, []string{l.key}, l.id).Err()
+}
+
+func (l *redisLock) QueueSync(ctx context.Context, a *annotation, ttl time.Duration) (bool, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	return l.client.SetNX(ctx, l.syncKey, b, ttl).Result()
+}
+
+func (l *redisLock) TakeSync(ctx context.Context) (*annotation, error) {
+	b, err := l.client.GetDel(ctx, l.syncKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a annotation
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, fmt.Errorf("decode the requested sync: %w", err)
+	}
+	return &a, nil
+}
+
+// splitRedisLocation splits redis://host:port/db?key=name into the client URL
+// and the key, defaulting the key to the shared state key, <LEASE_NAME>:state.
+func splitRedisLocation(location string) (string, string, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+	key := q.Get("key")
+	if key == "" {
+		key = cfg().LeaseName + ":state"
+	}
+	q.Del("key")
+	u.RawQuery = q.Encode()
+	return u.String(), key, nil
+}
+
+func readRedisObject(ctx context.Context, location string) ([]byte, error) {
+	rawURL, key, err := splitRedisLocation(location)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newRedisClient(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	b, err := c.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errObjectNotFound
+	}
+	return b, err
+}
+
+func writeRedisObject(ctx context.Context, location string, data []byte) error {
+	rawURL, key, err := splitRedisLocation(location)
+	if err != nil {
+		return err
+	}
+	c, err := newRedisClient(rawURL)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return c.Set(ctx, key, data, 0).Err()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// registration is what a node asked to have published for itself through
+// POST /register: extra names for its addresses and SRV records.
+type registration struct {
+	Names    []string              `json:"names"`
+	Services []registrationService `json:"services,omitempty"`
+	// By is the name of the node that registered.
+	By string `json:"by,omitempty"`
+	// Since is when the node first registered; the earliest registration
+	// of a name wins.
+	Since time.Time `json:"since,omitempty"`
+}
+
+// registrationService is an SRV record pointing at the registering node,
+// e.g. _https._tcp.grafana on port 443.
+type registrationService struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+// registrationGrant is the value of REGISTER_CAPABILITY granted to a node:
+// the names it may register, shell patterns such as "app-*", and the ports
+// its services may use.
+type registrationGrant struct {
+	Names []string `json:"names"`
+	Ports []int    `json:"ports"`
+}
+
+var (
+	labelRe        = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	serviceLabelRe = regexp.MustCompile(`^_[a-z0-9-]+$`)
+
+	registrationsMu sync.Mutex
+	// pendingRegistrations are registrations received since the last cycle
+	// by node ID, nil for a withdrawn one.
+	pendingRegistrations = map[string]*registration{}
+)
+
+func init() {
+	handleTailnet("/register", serveRegister)
+}
+
+// serveRegister lets a tailnet node register names for itself with POST, as
+// JSON {"names": [...], "services": [{"name": ..., "port": ...}]}, replacing
+// its previous registration, or withdraw them with DELETE. The node must hold
+// REGISTER_CAPABILITY in a grant to this node, and only gets what it allows.
+func serveRegister(w http.ResponseWriter, r *http.Request) {
+	if cfg().RegisterCapability == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "use POST or DELETE", http.StatusMethodNotAllowed)
+		return
+	}
+	who, err := lc().WhoIs(r.Context(), r.RemoteAddr)
+	if err != nil || who.Node == nil {
+		http.Error(w, "only tailnet nodes can register", http.StatusForbidden)
+		return
+	}
+	node := string(who.Node.StableID)
+	by := getName(who.Node.Name)
+	if r.Method == http.MethodDelete {
+		registrationsMu.Lock()
+		pendingRegistrations[node] = nil
+		registrationsMu.Unlock()
+		slog.Info("registration withdrawn", "by", by)
+		requestSync("registration withdrawn by "+by, by)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	grants, err := tailcfg.UnmarshalCapJSON[registrationGrant](who.CapMap, tailcfg.PeerCapability(cfg().RegisterCapability))
+	if err != nil {
+		slog.Warn("register: invalid grant", "by", by, "capability", cfg().RegisterCapability, logErr(err))
+	}
+	if len(grants) == 0 {
+		http.Error(w, fmt.Sprintf("%s holds no %s grant", by, cfg().RegisterCapability), http.StatusForbidden)
+		return
+	}
+	var reg registration
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&reg); err != nil {
+		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := reg.check(grants); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	reg.By, reg.Since = by, time.Now().UTC()
+	registrationsMu.Lock()
+	pendingRegistrations[node] = &reg
+	registrationsMu.Unlock()
+	slog.Info("names registered", "by", by, "names", strings.Join(reg.Names, ","))
+	requestSync("registration by "+by, by)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// check normalizes reg and verifies that grants allow all of it.
+func (reg *registration) check(grants []registrationGrant) error {
+	allowed := func(name string) bool {
+		for _, g := range grants {
+			for _, pattern := range g.Names {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	for i, name := range reg.Names {
+		name = strings.ToLower(name)
+		if !labelRe.MatchString(name) {
+			return fmt.Errorf("name %q is not a single DNS label", name)
+		}
+		if !allowed(name) {
+			return fmt.Errorf("name %q is not granted", name)
+		}
+		reg.Names[i] = name
+	}
+	for i, s := range reg.Services {
+		s.Name = strings.ToLower(s.Name)
+		labels := strings.Split(s.Name, ".")
+		if len(labels) != 3 || !serviceLabelRe.MatchString(labels[0]) || !serviceLabelRe.MatchString(labels[1]) || !labelRe.MatchString(labels[2]) {
+			return fmt.Errorf("service %q is not _service._proto.name", s.Name)
+		}
+		if !allowed(labels[2]) {
+			return fmt.Errorf("service %q: name %q is not granted", s.Name, labels[2])
+		}
+		granted := false
+		for _, g := range grants {
+			for _, p := range g.Ports {
+				granted = granted || p == s.Port
+			}
+		}
+		if !granted {
+			return fmt.Errorf("service %q: port %d is not granted", s.Name, s.Port)
+		}
+		reg.Services[i] = s
+	}
+	return nil
+}
+
+// requestSync queues a sync unless one is queued already.
+func requestSync(reason, by string) {
+	if _, err := queueSync(ctx, &annotation{Reason: reason, By: by}); err != nil {
+		slog.Warn("queue the sync failed", logErr(err))
+	}
+}
+
+// mergeRegistrations adds the names registered by nodes to hosts, as copies
+// of their own records. Registrations of nodes that left the tailnet are
+// dropped, and names of nodes or from the file source win over them.
+func mergeRegistrations(st *ipnstate.Status, hosts map[string]host) {
+	registrationsMu.Lock()
+	for node, reg := range pendingRegistrations {
+		if reg == nil {
+			delete(syncState.Registrations, node)
+			continue
+		}
+		if syncState.Registrations == nil {
+			syncState.Registrations = map[string]registration{}
+		}
+		if old, ok := syncState.Registrations[node]; ok {
+			reg.Since = old.Since
+		}
+		syncState.Registrations[node] = *reg
+	}
+	clear(pendingRegistrations)
+	registrationsMu.Unlock()
+	if len(syncState.Registrations) == 0 {
+		return
+	}
+	present := map[string]bool{string(st.Self.ID): true}
+	for _, ps := range st.Peer {
+		present[string(ps.ID)] = true
+	}
+	// the node's own records, named by NAME_TEMPLATE
+	own := map[string][]host{}
+	for _, h := range hosts {
+		if h.NodeID != "" && h.Type != "SRV" {
+			own[h.NodeID] = append(own[h.NodeID], h)
+		}
+	}
+	nodes := make([]string, 0, len(syncState.Registrations))
+	for node := range syncState.Registrations {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return syncState.Registrations[nodes[i]].Since.Before(syncState.Registrations[nodes[j]].Since)
+	})
+	for _, node := range nodes {
+		reg := syncState.Registrations[node]
+		if !present[node] {
+			slog.Info("dropping the registration, its node left the tailnet", "by", reg.By)
+			delete(syncState.Registrations, node)
+			continue
+		}
+		for _, name := range reg.Names {
+			if syncState.Excluded[name] {
+				continue
+			}
+			taken := map[string]bool{}
+			for _, h := range own[node] {
+				_, taken[h.Type] = findHost(hosts, h.Type, name)
+			}
+			for _, h := range own[node] {
+				if taken[h.Type] {
+					continue
+				}
+				h.Name = name
+				hosts[recordKey(h.Type, name, h.Content)] = h
+			}
+		}
+	}
+}
+
+// registeredServices returns the SRV records registered by nodes for z,
+// keyed like recordKey, pointing at the nodes' own names.
+func registeredServices(z *zone, hosts map[string]host) map[string]host {
+	out := map[string]host{}
+	for node, reg := range syncState.Registrations {
+		// the node's own record, not one of its registered names, and
+		// its A or CNAME record over AAAA
+		var h host
+		for _, c := range hosts {
+			if c.NodeID != node || c.Type == "SRV" || c.Content == "" || slices.Contains(reg.Names, c.Name) {
+				continue
+			}
+			if h.Content == "" || h.Type == "AAAA" {
+				h = c
+			}
+		}
+		if h.Content == "" {
+			continue
+		}
+		target := h.Name + "." + z.Suffix
+		if h.Type == "CNAME" {
+			target = h.Content
+		}
+		for _, s := range reg.Services {
+			content := fmt.Sprintf("10 10 %d %s", s.Port, target)
+			out[recordKey("SRV", s.Name, content)] = host{Name: s.Name, Type: "SRV", Content: content, Online: h.Online, TTL: h.TTL, NodeID: node}
+		}
+	}
+	return out
+}
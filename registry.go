@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// Ownership registries of REGISTRY.
+const (
+	RegistryComment = "comment"
+	RegistryTXT     = "txt"
+)
+
+// registryHeritage marks the TXT ownership records of the sync, like
+// external-dns marks its own with heritage=external-dns.
+const registryHeritage = "tailscale-dns-sync"
+
+// registryProviders are the providers that can hold the TXT records of
+// REGISTRY=txt.
+var registryProviders = []string{"cloudflare", "digitalocean", "route53", "rfc2136", "webhook"}
+
+// txtRegistry keeps, with REGISTRY=txt, a TXT record next to every name and
+// type the sync owns, holding the owner ID. Records another owner claims
+// are never touched, so several instances, or external-dns, can share a
+// zone; records without any TXT record are ours as far as the provider's own
+// marker goes and get one on the next cycle.
+type txtRegistry struct {
+	mu sync.Mutex
+	// txt are our TXT records by "type fqdn" of the records they own.
+	txt map[string]record
+	// owned counts our records by "type fqdn"; adopt lists those without a
+	// TXT record yet.
+	owned map[string]int
+	adopt []record
+	// foreign names the owner of the records we must leave alone.
+	foreign map[string]string
+}
+
+func newTXTRegistry() *txtRegistry {
+	return &txtRegistry{txt: map[string]record{}, owned: map[string]int{}, foreign: map[string]string{}}
+}
+
+// registryName returns the name of the TXT record owning the records of type
+// typ at name, e.g. a-web for the A record of web.
+func registryName(typ, name string) string {
+	return strings.ToLower(cfg().RegistryPrefix+typ) + "-" + name
+}
+
+func registryKey(typ, fqdn string) string {
+	return typ + " " + strings.ToLower(strings.TrimSuffix(fqdn, "."))
+}
+
+// registryContent returns the content of our TXT records.
+func registryContent() string {
+	return fmt.Sprintf("heritage=%s,%s/owner=%s,%s/resource=tailnet", registryHeritage, registryHeritage, cfg().RegistryOwnerID, registryHeritage)
+}
+
+// parseRegistry returns the heritage and owner of a TXT record content,
+// ok only for ownership records.
+func parseRegistry(content string) (heritage, owner string, ok bool) {
+	for _, kv := range strings.Split(strings.Trim(content, `"`), ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch {
+		case k == "heritage":
+			heritage, ok = v, true
+		case strings.HasSuffix(k, "/owner"):
+			owner = v
+		}
+	}
+	return heritage, owner, ok
+}
+
+// filter drops the TXT records from a listing of z and the records owned by
+// someone else, and notes ours for the changes made next.
+func (r *txtRegistry) filter(z *zone, records []record) []record {
+	if r == nil {
+		return records
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.txt, r.owned, r.adopt, r.foreign = map[string]record{}, map[string]int{}, nil, map[string]string{}
+	claims := map[string]string{}
+	for _, rec := range records {
+		if rec.Type != "TXT" {
+			continue
+		}
+		heritage, owner, ok := parseRegistry(rec.Content)
+		if !ok {
+			continue
+		}
+		for _, typ := range []string{"A", "AAAA", "CNAME", "SRV", "PTR"} {
+			prefix := registryName(typ, "")
+			if !strings.HasPrefix(strings.ToLower(rec.Name), prefix) {
+				continue
+			}
+			key := registryKey(typ, strings.TrimPrefix(strings.ToLower(rec.Name), prefix))
+			if heritage == registryHeritage && owner == cfg().RegistryOwnerID {
+				r.txt[key] = rec
+			} else {
+				claims[key] = heritage + " owner " + owner
+			}
+		}
+	}
+	// claimed names stay off limits even when the provider lists only our
+	// records, so the sync never creates one next to another owner's
+	for key, owner := range claims {
+		if _, ours := r.txt[key]; !ours {
+			r.foreign[key] = owner
+		}
+	}
+	out := make([]record, 0, len(records))
+	for _, rec := range records {
+		if rec.Type == "TXT" {
+			continue
+		}
+		key := registryKey(rec.Type, rec.Name)
+		_, ours := r.txt[key]
+		if _, ok := r.foreign[key]; ok {
+			continue
+		}
+		if !ours && r.owned[key] == 0 {
+			r.adopt = append(r.adopt, rec)
+		}
+		r.owned[key]++
+		out = append(out, rec)
+	}
+	return out
+}
+
+// adoptRecords creates the missing TXT records of the records of z that had
+// none in the last listing.
+func (r *txtRegistry) adoptRecords(ctx context.Context, z *zone) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	adopt := r.adopt
+	r.adopt = nil
+	r.mu.Unlock()
+	for _, rec := range adopt {
+		name := strings.TrimSuffix(strings.ToLower(strings.TrimSuffix(rec.Name, ".")), "."+z.Suffix)
+		c := change{Action: actionCreate, Zone: z.Name, Suffix: z.Suffix, Name: name, Type: rec.Type, TTL: rec.TTL}
+		if err := r.claim(ctx, z, c); err != nil {
+			slog.Warn("adding the ownership record failed", append(c.logAttrs(z), logErr(err))...)
+		}
+	}
+}
+
+// removeOrphans deletes our TXT records of z owning no record anymore, such
+// as those whose removal failed when the last record of their name went.
+func (r *txtRegistry) removeOrphans(ctx context.Context, z *zone) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, txt := range r.txt {
+		if r.owned[key] > 0 {
+			continue
+		}
+		name := strings.TrimSuffix(strings.ToLower(strings.TrimSuffix(txt.Name, ".")), "."+z.Suffix)
+		c := change{Action: actionDelete, Zone: z.Name, Suffix: z.Suffix, Name: name, Type: "TXT", Content: txt.Content, RecordID: txt.ID}
+		countAPICall(apiCallMutate)
+		if err := z.provider.Delete(ctx, c); err != nil {
+			countAPIError(z, apiCallMutate)
+			slog.Warn("removing the orphaned ownership record failed", append(c.logAttrs(z), logErr(err))...)
+			continue
+		}
+		delete(r.txt, key)
+	}
+}
+
+// claim makes sure the TXT record owning c exists before c is created, and
+// fails when another owner holds its name.
+func (r *txtRegistry) claim(ctx context.Context, z *zone, c change) error {
+	if r == nil {
+		return nil
+	}
+	key := registryKey(c.Type, c.fqdn())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if owner, ok := r.foreign[key]; ok {
+		return fmt.Errorf("%s %s is owned by %s", c.fqdn(), c.Type, owner)
+	}
+	if _, ok := r.txt[key]; ok {
+		return nil
+	}
+	txt := change{Action: actionCreate, Zone: z.Name, Suffix: z.Suffix, Name: registryName(c.Type, c.Name), Type: "TXT", Content: registryContent(), TTL: c.TTL}
+	countAPICall(apiCallMutate)
+	id, err := z.provider.Create(ctx, txt)
+	if err != nil {
+		countAPIError(z, apiCallMutate)
+		return fmt.Errorf("create the ownership record %s: %w", txt.fqdn(), err)
+	}
+	r.txt[key] = record{ID: id, Name: txt.fqdn(), Type: "TXT", Content: txt.Content}
+	return nil
+}
+
+// created and deleted count the records of c's name and type; the TXT
+// record goes with the last of them.
+func (r *txtRegistry) created(c change) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.owned[registryKey(c.Type, c.fqdn())]++
+	r.mu.Unlock()
+}
+
+func (r *txtRegistry) deleted(ctx context.Context, z *zone, c change) {
+	if r == nil {
+		return
+	}
+	key := registryKey(c.Type, c.fqdn())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.owned[key] > 1 {
+		r.owned[key]--
+		return
+	}
+	delete(r.owned, key)
+	txt, ok := r.txt[key]
+	if !ok {
+		return
+	}
+	countAPICall(apiCallMutate)
+	err := z.provider.Delete(ctx, change{Action: actionDelete, Zone: z.Name, Suffix: z.Suffix, Name: registryName(c.Type, c.Name), Type: "TXT", Content: txt.Content, RecordID: txt.ID})
+	if err != nil {
+		countAPIError(z, apiCallMutate)
+		// left for the next adopting create to reuse, or removeOrphans
+		slog.Warn("removing the ownership record failed", append(c.logAttrs(z), logErr(err))...)
+		return
+	}
+	delete(r.txt, key)
+}
+
+// foreignRegistry returns the TXT records under suffix the listing of z left
+// out, those of other owners, for the providers listing only marked records.
+func foreignRegistry(ctx context.Context, z *zone, suffix string) ([]record, error) {
+	l, ok := z.provider.(registryLister)
+	if z.registry == nil || !ok {
+		return nil, nil
+	}
+	countAPICall(apiCallList)
+	records, err := l.ListRegistry(ctx, suffix)
+	if err != nil {
+		countAPIError(z, apiCallList)
+		return nil, fmt.Errorf("list the ownership records: %w", err)
+	}
+	return records, nil
+}
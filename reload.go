@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"sort"
+)
+
+// restartOnly are the settings the daemon sets up once at start: zones,
+// providers, the registry, the listener and coordination. A reload keeps them
+// and logs that they need a restart.
+func restartOnly(c *config) map[string]any {
+	return map[string]any{
+		"source":                 &c.Source,
+		"file_source":            &c.FileSource,
+		"tailnet":                &c.Tailnet,
+		"tailscaled_socket":      &c.TailscaledSocket,
+		"tailscaled_socket_only": &c.TailscaledSocketOnly,
+		"headscale_url":          &c.HeadscaleURL,
+		"tsnet":                  &c.TSNet,
+		"tsnet_hostname":         &c.TSNetHostname,
+		"tsnet_state_dir":        &c.TSNetStateDir,
+		"provider":               &c.Provider,
+		"provider_plugin":        &c.PluginPath,
+		"domains":                &c.Domains,
+		"suffixes":               &c.Suffixes,
+		"reverse_zones":          &c.ReverseZones,
+		"domain_suffix":          &c.DomainSuffix,
+		"funnel_names":           &c.FunnelNames,
+		"secondary_provider":     &c.SecondaryProvider,
+		"create_zones":           &c.CreateZones,
+		"listen":                 &c.Listen,
+		"metrics_addr":           &c.MetricsAddr,
+		"register_capability":    &c.RegisterCapability,
+		"proxy_url":              &c.ProxyURL,
+		"ca_bundle":              &c.CABundle,
+		"coordination":           &c.Coordination,
+		"redis_url":              &c.RedisURL,
+		"lease_name":             &c.LeaseName,
+		"lease_namespace":        &c.LeaseNamespace,
+		"lease_duration":         &c.LeaseDuration,
+		"state_path":             &c.StatePath,
+		"status_path":            &c.StatusPath,
+		"audit_path":             &c.AuditPath,
+		"sentry_dsn":             &c.SentryDSN,
+		"statsd_addr":            &c.StatsdAddr,
+		"registry":               &c.Registry,
+		"registry_owner_id":      &c.RegistryOwnerID,
+		"registry_prefix":        &c.RegistryPrefix,
+	}
+}
+
+// reloadConfig rereads the config file and applies it from the next cycle
+// on. It runs between cycles in the daemon loop; an invalid file keeps the
+// running config.
+func reloadConfig(path, profile string) {
+	c, err := readConfig(path, profile)
+	if err != nil {
+		slog.Error("config reload failed, keeping the running config", logErr(err))
+		return
+	}
+	running, reloaded := restartOnly(cfg()), restartOnly(&c)
+	names := make([]string, 0, len(reloaded))
+	for name := range reloaded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		old := reflect.ValueOf(running[name]).Elem()
+		v := reflect.ValueOf(reloaded[name]).Elem()
+		if !reflect.DeepEqual(old.Interface(), v.Interface()) {
+			slog.Warn("config reload: setting changed, restart to apply it", "setting", name)
+			v.Set(old)
+		}
+	}
+	keepZoneSuffixes(cfg(), &c)
+	liveConfig.Store(&c)
+	forgetFingerprints()
+	setupLogging(c.LogFormat, c.LogLevel)
+	slog.Info("config reloaded")
+}
+
+// keepZoneSuffixes keeps the zones.*.suffix of running in reloaded: like
+// DOMAIN_SUFFIX, the zones are set up with it once at start.
+func keepZoneSuffixes(running, reloaded *config) {
+	zones := map[string]bool{}
+	for zone := range running.ZoneSettings {
+		zones[zone] = true
+	}
+	for zone := range reloaded.ZoneSettings {
+		zones[zone] = true
+	}
+	names := make([]string, 0, len(zones))
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+	for _, zone := range names {
+		old, s := running.ZoneSettings[zone].Suffix, reloaded.ZoneSettings[zone]
+		if reflect.DeepEqual(old, s.Suffix) {
+			continue
+		}
+		slog.Warn("config reload: setting changed, restart to apply it", "setting", "zones."+zone+".suffix")
+		if reloaded.ZoneSettings == nil {
+			reloaded.ZoneSettings = map[string]zoneSettings{}
+		}
+		s.Suffix = old
+		reloaded.ZoneSettings[zone] = s
+	}
+}
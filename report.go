@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// runReport is the machine-readable record of one sync cycle.
+type runReport struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Mode   string    `json:"mode"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+	// ZoneErrors maps zone names to the error that stopped their reconcile.
+	ZoneErrors map[string]string `json:"zone_errors,omitempty"`
+	Planned    []change          `json:"planned"`
+	Applied    []change          `json:"applied"`
+	Failed     []failedChange    `json:"failed"`
+	Deferred   []change          `json:"deferred,omitempty"`
+	// Stale changes were skipped because their record changed after planning.
+	Stale     []change           `json:"stale,omitempty"`
+	Durations map[string]float64 `json:"durations_seconds"`
+	// APICalls counts the provider calls of the cycle by kind.
+	APICalls map[string]int `json:"api_calls,omitempty"`
+	// Annotation is the operator's note on a manually triggered sync.
+	Annotation *annotation `json:"annotation,omitempty"`
+	// tailnetFailed is set when the cycle couldn't read the tailnet.
+	tailnetFailed bool
+}
+
+// failedChange is a planned change the provider rejected.
+type failedChange struct {
+	change
+	Error string `json:"error"`
+}
+
+// unapplied returns the planned changes the cycle didn't apply: all of them
+// in monitor mode or under a freeze, the failed and skipped ones otherwise.
+func (r *runReport) unapplied() []change {
+	applied := map[string]int{}
+	for _, c := range r.Applied {
+		applied[c.Zone+" "+c.String()]++
+	}
+	var out []change
+	for _, c := range r.Planned {
+		if key := c.Zone + " " + c.String(); applied[key] > 0 {
+			applied[key]--
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		Start:     time.Now(),
+		Mode:      cfg().Mode,
+		Durations: map[string]float64{},
+	}
+}
+
+// phase records how long fn took under name.
+func (r *runReport) phase(name string, fn func()) {
+	start := time.Now()
+	fn()
+	r.Durations[name] = time.Since(start).Seconds()
+}
+
+func (r *runReport) fail(err error) {
+	r.Result = "error"
+	r.Error = err.Error()
+}
+
+// firstError returns the most significant error of the run, if any.
+func (r *runReport) firstError() string {
+	if r.Error != "" {
+		return r.Error
+	}
+	for _, msg := range r.ZoneErrors {
+		return msg
+	}
+	for _, f := range r.Failed {
+		return f.Error
+	}
+	return ""
+}
+
+// zoneError records that z failed; the run only fails as a whole when every
+// zone did.
+func (r *runReport) zoneError(z *zone, err error) {
+	if r.ZoneErrors == nil {
+		r.ZoneErrors = map[string]string{}
+	}
+	r.ZoneErrors[z.Name] = err.Error()
+	if len(r.ZoneErrors) == len(zones) {
+		r.fail(err)
+	}
+}
+
+// reportLocation returns where the report is written; a location ending in
+// "/" is treated as a prefix and gets one timestamped object per run.
+func reportLocation(base string, t time.Time) string {
+	if strings.HasSuffix(base, "/") {
+		return base + "report-" + t.UTC().Format("20060102T150405Z") + ".json"
+	}
+	return base
+}
+
+func (r *runReport) write(ctx context.Context) {
+	r.End = time.Now()
+	r.Durations["total"] = r.End.Sub(r.Start).Seconds()
+	if r.Result == "" {
+		r.Result = "success"
+		if len(r.Failed) > 0 || len(r.ZoneErrors) > 0 {
+			r.Result = "partial"
+		}
+	}
+	if cfg().ReportPath == "" {
+		return
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		slog.Error("marshal report failed", logErr(err))
+		return
+	}
+	if err := writeObject(ctx, reportLocation(cfg().ReportPath, r.Start), b); err != nil {
+		slog.Error("write report failed", "path", cfg().ReportPath, logErr(err))
+	}
+}
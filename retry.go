@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// errRateLimited is returned once a provider's rate limit asks to wait
+// longer than RETRY_MAX_DELAY or the cycle has left. The rest of the cycle
+// leaves its changes to the next one.
+var errRateLimited = errors.New("rate limited")
+
+// rateLimited is set when a request of the current cycle ran into
+// errRateLimited, and cleared when the next cycle starts.
+var rateLimited atomic.Bool
+
+// providerClient is the HTTP client of the providers speaking HTTP.
+var providerClient = &http.Client{Transport: retryTransport{base: http.DefaultTransport}}
+
+// retryTransport retries requests failing with a 429 or 5xx status, and
+// those that didn't reach the provider, RETRY_MAX times with exponential
+// backoff and jitter from RETRY_BASE_DELAY up to RETRY_MAX_DELAY. A 429
+// waits as long as its Retry-After asks.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	delay := cfg().RetryBaseDelay
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.Body != nil {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("%s %s: request body can't be resent", req.Method, req.URL.Path)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		resp, err := t.base.RoundTrip(req)
+		last := attempt >= cfg().RetryMax
+		var wait time.Duration
+		var cause string
+		switch {
+		case err != nil:
+			// a create may have gone through before the connection broke
+			if last || ctx.Err() != nil || req.Method == http.MethodPost {
+				return nil, err
+			}
+			wait, cause = jitter(delay), err.Error()
+		case resp.StatusCode == http.StatusTooManyRequests:
+			wait, cause = retryAfter(resp.Header.Get("Retry-After"), jitter(delay)), resp.Status
+			deadline, ok := ctx.Deadline()
+			if wait > cfg().RetryMaxDelay || ok && time.Now().Add(wait).After(deadline) {
+				resp.Body.Close()
+				rateLimited.Store(true)
+				return nil, fmt.Errorf("%w: %s %s asks to wait %s", errRateLimited, req.Method, req.URL.Path, wait)
+			}
+			if last {
+				return resp, nil
+			}
+		case resp.StatusCode >= 500:
+			if last {
+				return resp, nil
+			}
+			wait, cause = jitter(delay), resp.Status
+		default:
+			return resp, nil
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		countAPICall(apiCallRetry)
+		slog.Warn("retrying provider request", "method", req.Method, "path", req.URL.Path, "attempt", attempt+1, "wait", wait.String(), "cause", cause)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay = min(2*delay, cfg().RetryMaxDelay)
+	}
+}
+
+// jitter returns a random duration between half of d and d.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as a date,
+// falling back to def.
+func retryAfter(h string, def time.Duration) time.Duration {
+	if s, err := strconv.Atoi(h); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return def
+}
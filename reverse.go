@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"log/slog"
+	"net/netip"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reverseEntry maps a published address back to its name.
+type reverseEntry struct {
+	IP        string    `json:"ip"`
+	Name      string    `json:"name"`
+	NodeID    string    `json:"node_id,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// updateReverseMap records the address of every published host and writes
+// REVERSE_MAP_PATH, for log pipelines that can't look names up in the
+// tailnet. Mappings no longer published stay for REVERSE_MAP_RETENTION, so
+// older logs still resolve.
+func updateReverseMap(hosts map[string]host, now time.Time) {
+	if cfg().ReverseMapPath == "" {
+		return
+	}
+	if syncState.Reverse == nil {
+		syncState.Reverse = map[string]reverseEntry{}
+	}
+	for _, h := range hosts {
+		if (h.Type != "A" && h.Type != "AAAA") || h.Content == "" {
+			continue
+		}
+		// named after the first zone publishing it
+		name := ""
+		for _, z := range zones {
+			if z.forward() && publishable(z.Name, h) {
+				name = h.Name + "." + z.Suffix
+				break
+			}
+		}
+		if name == "" {
+			continue
+		}
+		key := h.Content + " " + name
+		e, ok := syncState.Reverse[key]
+		if !ok {
+			e = reverseEntry{IP: h.Content, Name: name, FirstSeen: now}
+		}
+		e.NodeID, e.LastSeen = h.NodeID, now
+		syncState.Reverse[key] = e
+	}
+	entries := make([]reverseEntry, 0, len(syncState.Reverse))
+	for key, e := range syncState.Reverse {
+		if now.Sub(e.LastSeen) > cfg().ReverseMapRetention {
+			delete(syncState.Reverse, key)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	// by address, the most recent name first
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IP != b.IP {
+			ai, aerr := netip.ParseAddr(a.IP)
+			bi, berr := netip.ParseAddr(b.IP)
+			if aerr != nil || berr != nil {
+				return a.IP < b.IP
+			}
+			return ai.Less(bi)
+		}
+		if !a.LastSeen.Equal(b.LastSeen) {
+			return a.LastSeen.After(b.LastSeen)
+		}
+		return a.Name < b.Name
+	})
+	var (
+		b   []byte
+		err error
+	)
+	if strings.EqualFold(filepath.Ext(cfg().ReverseMapPath), ".csv") {
+		b, err = reverseCSV(entries)
+	} else {
+		b, err = json.MarshalIndent(entries, "", "  ")
+	}
+	if err != nil {
+		slog.Warn("marshal reverse map failed", logErr(err))
+		return
+	}
+	if err := writeFileAtomic(cfg().ReverseMapPath, b); err != nil {
+		slog.Warn("write reverse map failed", logErr(err))
+	}
+}
+
+func reverseCSV(entries []reverseEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"ip", "name", "node_id", "first_seen", "last_seen"})
+	for _, e := range entries {
+		w.Write([]string{e.IP, e.Name, e.NodeID, e.FirstSeen.UTC().Format(time.RFC3339), e.LastSeen.UTC().Format(time.RFC3339)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,237 @@
+//go:build !no_rfc2136
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// rfc2136DefaultTTL replaces the automatic TTL, which plain DNS doesn't have.
+const rfc2136DefaultTTL = 300
+
+func init() {
+	registerProvider("rfc2136", newRFC2136Provider)
+}
+
+// rfc2136Provider manages the records in one zone of a DNS server accepting
+// RFC 2136 dynamic updates, e.g. BIND or Knot. Records are read with a zone
+// transfer and, as DNS has no comments, every A, AAAA, CNAME and SRV record
+// under the managed suffix is considered ours: the suffix must be dedicated
+// to the sync. Updates and transfers are signed when a TSIG key is set.
+type rfc2136Provider struct {
+	server string
+	zone   string
+	// key is the TSIG key name, empty when unsigned.
+	key    string
+	secret string
+	alg    string
+}
+
+func newRFC2136Provider(ctx context.Context, name string) (provider, error) {
+	server := os.Getenv("RFC2136_SERVER")
+	if server == "" {
+		return nil, fmt.Errorf("set RFC2136_SERVER to the primary server of %s", name)
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	p := &rfc2136Provider{
+		server: server,
+		zone:   dns.Fqdn(name),
+		key:    os.Getenv("RFC2136_TSIG_KEY"),
+		secret: secretEnv("RFC2136_TSIG_SECRET"),
+		alg:    os.Getenv("RFC2136_TSIG_ALGORITHM"),
+	}
+	if p.key != "" {
+		p.key = dns.Fqdn(p.key)
+		if p.secret == "" {
+			return nil, fmt.Errorf("RFC2136_TSIG_KEY is set without RFC2136_TSIG_SECRET")
+		}
+		if p.alg == "" {
+			p.alg = "hmac-sha256"
+		}
+		p.alg = dns.Fqdn(p.alg)
+		if !slices.Contains([]string{dns.HmacSHA1, dns.HmacSHA224, dns.HmacSHA256, dns.HmacSHA384, dns.HmacSHA512}, p.alg) {
+			return nil, fmt.Errorf("unsupported RFC2136_TSIG_ALGORITHM %q", strings.TrimSuffix(p.alg, "."))
+		}
+	}
+	// the server must be authoritative for the zone
+	m := new(dns.Msg)
+	m.SetQuestion(p.zone, dns.TypeSOA)
+	in, err := p.exchange(ctx, m)
+	if err != nil {
+		return nil, fmt.Errorf("query SOA of %s: %w", name, err)
+	}
+	if in.Rcode != dns.RcodeSuccess || !slices.ContainsFunc(in.Answer, func(rr dns.RR) bool { _, ok := rr.(*dns.SOA); return ok }) {
+		return nil, fmt.Errorf("%w: %s on %s", errZoneNotFound, name, server)
+	}
+	return p, nil
+}
+
+// sign adds the TSIG record to m when a key is set.
+func (p *rfc2136Provider) sign(m *dns.Msg) {
+	if p.key != "" {
+		m.SetTsig(p.key, p.alg, 300, time.Now().Unix())
+	}
+}
+
+func (p *rfc2136Provider) client(network string) *dns.Client {
+	c := &dns.Client{Net: network}
+	if p.key != "" {
+		c.TsigSecret = map[string]string{p.key: p.secret}
+	}
+	return c
+}
+
+func (p *rfc2136Provider) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	p.sign(m)
+	// TCP, as updates and their answers may not fit a datagram
+	in, _, err := p.client("tcp").ExchangeContext(ctx, m, p.server)
+	return in, err
+}
+
+func (p *rfc2136Provider) List(ctx context.Context) ([]record, error) {
+	var out []record
+	err := p.ListPages(ctx, strings.TrimSuffix(p.zone, "."), func(page []record) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// ListPages transfers the whole zone and keeps the names under suffix, in a
+// single page.
+func (p *rfc2136Provider) ListPages(ctx context.Context, suffix string, fn func([]record) error) error {
+	if suffix == "" {
+		suffix = strings.TrimSuffix(p.zone, ".")
+	}
+	m := new(dns.Msg)
+	m.SetAxfr(p.zone)
+	p.sign(m)
+	t := &dns.Transfer{}
+	if p.key != "" {
+		t.TsigSecret = map[string]string{p.key: p.secret}
+	}
+	if d, ok := ctx.Deadline(); ok {
+		t.ReadTimeout = time.Until(d)
+	}
+	envs, err := t.In(m, p.server)
+	if err != nil {
+		return fmt.Errorf("transfer %s: %w", p.zone, err)
+	}
+	var page []record
+	for env := range envs {
+		if env.Error != nil {
+			return fmt.Errorf("transfer %s: %w", p.zone, env.Error)
+		}
+		for _, rr := range env.RR {
+			h := rr.Header()
+			name := strings.TrimSuffix(h.Name, ".")
+			if name != suffix && !strings.HasSuffix(name, "."+suffix) {
+				continue
+			}
+			var content string
+			switch rr := rr.(type) {
+			case *dns.A:
+				content = rr.A.String()
+			case *dns.AAAA:
+				content = rr.AAAA.String()
+			case *dns.CNAME:
+				content = strings.TrimSuffix(rr.Target, ".")
+			case *dns.SRV:
+				content = fmt.Sprintf("%d %d %d %s", rr.Priority, rr.Weight, rr.Port, strings.TrimSuffix(rr.Target, "."))
+			case *dns.PTR:
+				content = strings.TrimSuffix(rr.Ptr, ".")
+			case *dns.TXT:
+				if !managedTXT() {
+					continue
+				}
+				content = strings.Join(rr.Txt, "")
+			default:
+				continue
+			}
+			typ := dns.TypeToString[h.Rrtype]
+			page = append(page, record{ID: name + " " + typ, Name: name, Type: typ, Content: content, TTL: int(h.Ttl)})
+		}
+	}
+	return fn(page)
+}
+
+func (p *rfc2136Provider) StoredTTL(ttl int) int {
+	if ttl <= 1 {
+		return rfc2136DefaultTTL
+	}
+	return ttl
+}
+
+// rr returns the resource record for content as c's type.
+func (p *rfc2136Provider) rr(c change, content string) (dns.RR, error) {
+	ttl := p.StoredTTL(c.TTL)
+	switch c.Type {
+	case "CNAME", "SRV", "PTR":
+		content = dns.Fqdn(content)
+	case "TXT":
+		content = `"` + content + `"`
+	}
+	return dns.NewRR(fmt.Sprintf("%s %d IN %s %s", dns.Fqdn(c.fqdn()), ttl, c.Type, content))
+}
+
+// update sends one dynamic update removing and inserting the given records.
+func (p *rfc2136Provider) update(ctx context.Context, remove, insert []dns.RR) error {
+	m := new(dns.Msg)
+	m.SetUpdate(p.zone)
+	m.Remove(remove)
+	m.Insert(insert)
+	in, err := p.exchange(ctx, m)
+	if err != nil {
+		return err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update refused: %s", dns.RcodeToString[in.Rcode])
+	}
+	return nil
+}
+
+func (p *rfc2136Provider) Create(ctx context.Context, c change) (string, error) {
+	rr, err := p.rr(c, c.Content)
+	if err != nil {
+		return "", err
+	}
+	return c.fqdn() + " " + c.Type, p.update(ctx, nil, []dns.RR{rr})
+}
+
+// Update swaps the old content for the new one in a single update, which
+// the server applies atomically.
+func (p *rfc2136Provider) Update(ctx context.Context, c change) error {
+	rr, err := p.rr(c, c.Content)
+	if err != nil {
+		return err
+	}
+	var remove []dns.RR
+	if c.OldContent != "" && c.OldContent != c.Content {
+		old, err := p.rr(c, c.OldContent)
+		if err != nil {
+			return err
+		}
+		remove = append(remove, old)
+	}
+	return p.update(ctx, remove, []dns.RR{rr})
+}
+
+// Delete removes the single record of c, leaving others of its name and
+// type in place. Removing a record that is already gone is not an error.
+func (p *rfc2136Provider) Delete(ctx context.Context, c change) error {
+	rr, err := p.rr(c, c.Content)
+	if err != nil {
+		return err
+	}
+	return p.update(ctx, []dns.RR{rr}, nil)
+}
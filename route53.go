@@ -0,0 +1,246 @@
+//go:build !no_route53
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// route53DefaultTTL replaces the automatic TTL, which Route53 doesn't have.
+const route53DefaultTTL = 300
+
+var (
+	r53Once   sync.Once
+	r53Client *route53.Client
+	r53Err    error
+)
+
+func init() {
+	registerProvider("route53", newRoute53Provider)
+}
+
+// route53Provider manages the records in one Route53 hosted zone. Route53
+// records carry no comments, so every A, AAAA, CNAME and SRV record under
+// the managed suffix is considered ours: the suffix must be dedicated to the
+// sync. Records sharing a name and type form one record set, which is
+// rewritten as a whole.
+type route53Provider struct {
+	api    *route53.Client
+	zone   string
+	zoneID string
+	// mu serializes the read-modify-write of record sets.
+	mu sync.Mutex
+}
+
+func newRoute53Provider(ctx context.Context, name string) (provider, error) {
+	r53Once.Do(func() {
+		var c aws.Config
+		c, r53Err = awsconfig.LoadDefaultConfig(ctx)
+		if r53Err == nil {
+			r53Client = route53.NewFromConfig(c)
+		}
+	})
+	if r53Err != nil {
+		return nil, r53Err
+	}
+	id, err := resolveHostedZoneID(ctx, r53Client, name)
+	if err != nil {
+		return nil, err
+	}
+	return &route53Provider{api: r53Client, zone: name, zoneID: id}, nil
+}
+
+// resolveHostedZoneID looks up the hosted zone called name. ROUTE53_ZONE_ID
+// picks one when a public and a private zone share the name.
+func resolveHostedZoneID(ctx context.Context, api *route53.Client, name string) (string, error) {
+	var ids []string
+	var marker *string
+	for {
+		out, err := api.ListHostedZones(ctx, &route53.ListHostedZonesInput{Marker: marker})
+		if err != nil {
+			return "", fmt.Errorf("list hosted zones: %w", err)
+		}
+		for _, z := range out.HostedZones {
+			if strings.TrimSuffix(aws.ToString(z.Name), ".") == name {
+				ids = append(ids, strings.TrimPrefix(aws.ToString(z.Id), "/hostedzone/"))
+			}
+		}
+		if !out.IsTruncated {
+			break
+		}
+		marker = out.NextMarker
+	}
+	if want := os.Getenv("ROUTE53_ZONE_ID"); want != "" {
+		if slices.Contains(ids, want) {
+			return want, nil
+		}
+		return "", fmt.Errorf("%w: %s with ID %s", errZoneNotFound, name, want)
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("%w: %s", errZoneNotFound, name)
+	case 1:
+		return ids[0], nil
+	}
+	return "", fmt.Errorf("several hosted zones are called %s, set ROUTE53_ZONE_ID to one of: %s", name, strings.Join(ids, ", "))
+}
+
+// route53Managed are the record types the sync publishes.
+var route53Managed = []types.RRType{types.RRTypeA, types.RRTypeAaaa, types.RRTypeCname, types.RRTypeSrv, types.RRTypePtr}
+
+func (p *route53Provider) List(ctx context.Context) ([]record, error) {
+	var out []record
+	err := p.ListPages(ctx, p.zone, func(page []record) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// ListPages starts listing at suffix. Route53 orders record sets by their
+// labels read right to left, so the names under suffix come first and the
+// listing stops at the first name outside of it.
+func (p *route53Provider) ListPages(ctx context.Context, suffix string, fn func([]record) error) error {
+	if suffix == "" {
+		suffix = p.zone
+	}
+	in := &route53.ListResourceRecordSetsInput{HostedZoneId: &p.zoneID, StartRecordName: aws.String(suffix + ".")}
+	for {
+		out, err := p.api.ListResourceRecordSets(ctx, in)
+		if err != nil {
+			return fmt.Errorf("list record sets: %w", err)
+		}
+		var page []record
+		for _, rs := range out.ResourceRecordSets {
+			name := route53Name(aws.ToString(rs.Name))
+			if name != suffix && !strings.HasSuffix(name, "."+suffix) {
+				return fn(page)
+			}
+			registry := rs.Type == types.RRTypeTxt && managedTXT()
+			if !slices.Contains(route53Managed, rs.Type) && !registry || rs.AliasTarget != nil {
+				continue
+			}
+			for _, v := range rs.ResourceRecords {
+				page = append(page, record{ID: name + " " + string(rs.Type), Name: name, Type: string(rs.Type),
+					Content: route53Content(string(rs.Type), aws.ToString(v.Value)), TTL: int(aws.ToInt64(rs.TTL))})
+			}
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		if !out.IsTruncated {
+			return nil
+		}
+		in.StartRecordName, in.StartRecordType, in.StartRecordIdentifier = out.NextRecordName, out.NextRecordType, out.NextRecordIdentifier
+	}
+}
+
+// route53Name undoes the escaping and trailing dot of a record set name.
+func route53Name(name string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(name, "."), `\052`, "*")
+}
+
+// route53Content strips the trailing dot of the names in a record value, and
+// the quotes of a TXT record.
+func route53Content(typ, v string) string {
+	if typ == "TXT" {
+		return strings.Trim(v, `"`)
+	}
+	return strings.TrimSuffix(v, ".")
+}
+
+// route53Value returns the record value for content.
+func route53Value(typ, content string) string {
+	switch typ {
+	case "CNAME", "SRV", "PTR":
+		return content + "."
+	case "TXT":
+		return `"` + content + `"`
+	}
+	return content
+}
+
+// recordSet returns the values and TTL of the record set of c.
+func (p *route53Provider) recordSet(ctx context.Context, c change) ([]string, int64, error) {
+	name := c.fqdn() + "."
+	out, err := p.api.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId: &p.zoneID, StartRecordName: &name, StartRecordType: types.RRType(c.Type), MaxItems: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("get record set %s %s: %w", c.fqdn(), c.Type, err)
+	}
+	if len(out.ResourceRecordSets) == 0 {
+		return nil, 0, nil
+	}
+	rs := out.ResourceRecordSets[0]
+	if route53Name(aws.ToString(rs.Name)) != c.fqdn() || string(rs.Type) != c.Type {
+		return nil, 0, nil
+	}
+	var values []string
+	for _, v := range rs.ResourceRecords {
+		values = append(values, route53Content(c.Type, aws.ToString(v.Value)))
+	}
+	return values, aws.ToInt64(rs.TTL), nil
+}
+
+func (p *route53Provider) StoredTTL(ttl int) int {
+	if ttl <= 1 {
+		return route53DefaultTTL
+	}
+	return ttl
+}
+
+// write replaces the record set of c with values, deleting it when empty.
+func (p *route53Provider) write(ctx context.Context, c change, values []string, oldTTL int64) error {
+	action, ttl := types.ChangeActionUpsert, int64(p.StoredTTL(c.TTL))
+	if len(values) == 0 {
+		// a deletion must match the current set exactly
+		action, ttl, values = types.ChangeActionDelete, oldTTL, []string{c.Content}
+	}
+	rs := &types.ResourceRecordSet{Name: aws.String(c.fqdn() + "."), Type: types.RRType(c.Type), TTL: &ttl}
+	for _, v := range values {
+		rs.ResourceRecords = append(rs.ResourceRecords, types.ResourceRecord{Value: aws.String(route53Value(c.Type, v))})
+	}
+	_, err := p.api.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: &p.zoneID,
+		ChangeBatch:  &types.ChangeBatch{Changes: []types.Change{{Action: action, ResourceRecordSet: rs}}},
+	})
+	return err
+}
+
+func (p *route53Provider) Create(ctx context.Context, c change) (string, error) {
+	return c.fqdn() + " " + c.Type, p.edit(ctx, c)
+}
+
+func (p *route53Provider) Update(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+func (p *route53Provider) Delete(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+// edit applies c to the record set it belongs to.
+func (p *route53Provider) edit(ctx context.Context, c change) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	values, ttl, err := p.recordSet(ctx, c)
+	if err != nil {
+		return err
+	}
+	if c.Action == actionDelete && !slices.Contains(values, c.Content) {
+		// already gone
+		return nil
+	}
+	return p.write(ctx, c, editRecordSet(values, c), ttl)
+}
@@ -0,0 +1,26 @@
+package main
+
+import "slices"
+
+// editRecordSet returns the values of a record set after applying c, for
+// providers that store all records of a name and type as one set.
+func editRecordSet(values []string, c change) []string {
+	values = slices.Clone(values)
+	switch c.Action {
+	case actionCreate:
+		if !slices.Contains(values, c.Content) {
+			values = append(values, c.Content)
+		}
+	case actionUpdate:
+		if i := slices.Index(values, c.OldContent); i >= 0 {
+			values[i] = c.Content
+		} else if !slices.Contains(values, c.Content) {
+			values = append(values, c.Content)
+		}
+		// an update may leave a duplicate behind when both values were set
+		values = slices.Compact(values)
+	case actionDelete:
+		values = slices.DeleteFunc(values, func(v string) bool { return v == c.Content })
+	}
+	return values
+}
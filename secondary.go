@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+var (
+	metricSecondarySeeded = newMetric("gauge", "secondary_seeded_timestamp_seconds", "When the secondary copy of a zone was last brought up to date.")
+
+	// secondaryZones are the zones at SECONDARY_PROVIDER, by primary zone.
+	secondaryZones = map[*zone]*zone{}
+	// secondarySeeded is when the secondary zones were last seeded.
+	secondarySeeded time.Time
+)
+
+// openSecondaryZones opens every zone at SECONDARY_PROVIDER, if set. The
+// secondary zones have the same names as the primary ones, so that pointing
+// the registrar at the secondary's nameservers fails the zone over.
+func openSecondaryZones(ctx context.Context) error {
+	if cfg().SecondaryProvider == "" {
+		return nil
+	}
+	factory, ok := providerFactories[cfg().SecondaryProvider]
+	if !ok {
+		return fmt.Errorf("secondary provider %q is not compiled in (available: %s)", cfg().SecondaryProvider, providerNames())
+	}
+	secondaryZones = map[*zone]*zone{}
+	for _, z := range zones {
+		if !z.forward() {
+			continue
+		}
+		var (
+			p   provider
+			err error
+		)
+		if z.Name == z.Suffix {
+			p, _, err = findZone(ctx, factory, z.Suffix)
+		} else {
+			p, err = factory(ctx, z.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("open secondary %s zone %s: %w", cfg().SecondaryProvider, z.Name, err)
+		}
+		secondaryZones[z] = &zone{Name: z.Name + "@" + cfg().SecondaryProvider, Suffix: z.Suffix, provider: p, providerName: cfg().SecondaryProvider}
+	}
+	return nil
+}
+
+// seedSecondaries mirrors the records of every zone into its secondary every
+// SECONDARY_INTERVAL. The secondaries follow the same plan as the primaries,
+// quarantine included, but nothing about them is reported as drift: they
+// aren't serving until a failover.
+func seedSecondaries(ctx context.Context, st *ipnstate.Status, hosts map[string]host) {
+	if len(secondaryZones) == 0 || !applying() || time.Since(secondarySeeded) < cfg().SecondaryInterval {
+		return
+	}
+	if apiBudgetLeft() == 0 {
+		slog.Warn("API budget spent, seeding the secondary zones next cycle")
+		return
+	}
+	secondarySeeded = time.Now()
+	for _, z := range zones {
+		sz, ok := secondaryZones[z]
+		if !ok {
+			continue
+		}
+		records, err := currentRecords(ctx, sz)
+		if err != nil {
+			slog.Warn("list secondary failed", "zone", sz.Name, logErr(err))
+			continue
+		}
+		changes, deferred := paceDeletes(plan(sz, zoneRecords(st, z, hosts), records), cfg().MaxDeletes)
+		changes, overBudget, release := budgetChanges(sz, changes)
+		deferred = append(deferred, overBudget...)
+		applied, failed := applyChanges(ctx, sz, changes)
+		release()
+		trackQuarantine(sz, applied, records, time.Now())
+		if len(failed) > 0 {
+			slog.Warn("changes to the secondary failed", "zone", sz.Name, "failed", len(failed), "changes", len(changes))
+			continue
+		}
+		if len(deferred) == 0 {
+			metricSecondarySeeded.Set(float64(time.Now().Unix()), "zone", z.Name)
+		}
+	}
+}
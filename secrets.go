@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// secretFile is what a secret file held when it was last read.
+type secretFile struct {
+	value   string
+	modTime time.Time
+	size    int64
+}
+
+var (
+	secretsMu sync.Mutex
+	// secretFiles are the files read for <NAME>_FILE variables, by path.
+	secretFiles = map[string]secretFile{}
+	// secretResets drop what providers built once from their secrets, so
+	// the next open reads them again.
+	secretResets []func()
+)
+
+// vaultSecret returns the secret VAULT_SECRETS maps name to, ok when it
+// maps it, and vaultRotated refreshes the secrets and the Vault token when
+// due and reports whether a secret changed. Both are nil when built with
+// no_vault.
+var (
+	vaultSecret  func(name string) (string, bool)
+	vaultRotated func(ctx context.Context) bool
+)
+
+// secretEnv returns the secret in the environment variable name or, with
+// name_FILE set instead, the contents of that file without surrounding
+// whitespace, e.g. a Docker or Kubernetes secret mount. The file is read
+// again when it changes. Secrets VAULT_SECRETS maps come from Vault.
+func secretEnv(name string) string {
+	if vaultSecret != nil {
+		if v, ok := vaultSecret(name); ok {
+			return v
+		}
+	}
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return ""
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	fi, err := os.Stat(path)
+	if f, ok := secretFiles[path]; ok && err == nil && f.modTime.Equal(fi.ModTime()) && f.size == fi.Size() {
+		return f.value
+	}
+	f, err := readSecretFile(path)
+	if err != nil {
+		// keep the last one read rather than none
+		slog.Warn("reading the secret file failed", "variable", name+"_FILE", "path", path, logErr(err))
+		return secretFiles[path].value
+	}
+	secretFiles[path] = f
+	return f.value
+}
+
+func readSecretFile(path string) (secretFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return secretFile{}, err
+	}
+	f := secretFile{value: strings.TrimSpace(string(b))}
+	// stat after reading, so a write in between is caught the next time
+	if fi, err := os.Stat(path); err == nil {
+		f.modTime, f.size = fi.ModTime(), fi.Size()
+	}
+	return f, nil
+}
+
+// secretsRotated reports whether a secret file read before, or a secret
+// from Vault, holds something else now.
+func secretsRotated(ctx context.Context) bool {
+	rotated := vaultRotated != nil && vaultRotated(ctx)
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for path, old := range secretFiles {
+		f, err := readSecretFile(path)
+		if err != nil || f.value == old.value {
+			continue
+		}
+		slog.Info("secret file changed", "path", path)
+		secretFiles[path] = f
+		rotated = true
+	}
+	return rotated
+}
+
+// rotateSecrets reopens the zones with the new secrets when a secret file
+// changed. Opening them may fail, e.g. with a token not valid yet; the
+// zones keep the old one then and are reopened on the next change.
+func rotateSecrets(ctx context.Context) {
+	if !secretsRotated(ctx) {
+		return
+	}
+	for _, reset := range secretResets {
+		reset()
+	}
+	reopened, err := openZones(ctx)
+	if err != nil {
+		slog.Error("reopening the zones with the new secrets failed, keeping the old ones", logErr(err))
+		return
+	}
+	zones = reopened
+	if err := openSecondaryZones(ctx); err != nil {
+		slog.Error("reopening the secondary zones with the new secrets failed", logErr(err))
+	}
+	slog.Info("reopened the zones with the new secrets")
+}
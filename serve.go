@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var (
+	serveMu sync.Mutex
+	// serveServices are the services of this node's Serve setup, e.g.
+	// _https._tcp on 443, kept from the last read that worked.
+	serveServices []registrationService
+	// serveFunnel is whether this node's Serve setup allows Funnel.
+	serveFunnel bool
+)
+
+// readServe reads this node's Serve configuration for SERVE_SRV and
+// FUNNEL_NAMES. Peers' Serve setups aren't visible through the LocalAPI;
+// they register their services through POST /register instead.
+func readServe(ctx context.Context) {
+	if !cfg().ServeSRV && len(cfg().FunnelNames) == 0 || !features.Serve {
+		return
+	}
+	sc, err := lc().GetServeConfig(ctx)
+	if err != nil {
+		slog.Warn("reading the Serve configuration failed, keeping the last one", logErr(err))
+		return
+	}
+	services := serveConfigServices(sc)
+	serveMu.Lock()
+	serveServices = services
+	serveFunnel = sc != nil && len(sc.AllowFunnel) > 0
+	serveMu.Unlock()
+}
+
+// serveConfigServices returns the HTTP and HTTPS services of sc, including
+// those of foreground `tailscale serve` sessions. Plain TCP forwards carry
+// no service name and are left out.
+func serveConfigServices(sc *ipn.ServeConfig) []registrationService {
+	var out []registrationService
+	add := func(sc *ipn.ServeConfig) {
+		if sc == nil {
+			return
+		}
+		for port, h := range sc.TCP {
+			s := registrationService{Port: int(port)}
+			switch {
+			case h == nil:
+				continue
+			case h.HTTPS:
+				s.Name = "_https._tcp"
+			case h.HTTP:
+				s.Name = "_http._tcp"
+			default:
+				continue
+			}
+			if !slices.Contains(out, s) {
+				out = append(out, s)
+			}
+		}
+	}
+	add(sc)
+	if sc != nil {
+		for _, fg := range sc.Foreground {
+			add(fg)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Name != out[j].Name {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Port < out[j].Port
+	})
+	return out
+}
+
+// serveRecords returns the SRV records of z for this node's Serve services,
+// e.g. _https._tcp.gateway, keyed like recordKey.
+func serveRecords(st *ipnstate.Status, z *zone, hosts map[string]host) map[string]host {
+	out := map[string]host{}
+	if !cfg().ServeSRV {
+		return out
+	}
+	serveMu.Lock()
+	services := serveServices
+	serveMu.Unlock()
+	if len(services) == 0 || st.Self == nil {
+		return out
+	}
+	name := hostName(st, st.Self)
+	h, ok := findHost(hosts, cfg().RecordType, name)
+	if !ok {
+		h, ok = findHost(hosts, "AAAA", name)
+	}
+	if !ok || h.Content == "" {
+		return out
+	}
+	target := name + "." + z.Suffix
+	if h.Type == "CNAME" {
+		target = h.Content
+	}
+	priority, weight := srvPreference(st.Self)
+	for _, s := range services {
+		srv := s.Name + "." + name
+		content := fmt.Sprintf("%d %d %d %s", priority, weight, s.Port, target)
+		out[recordKey("SRV", srv, content)] = host{Name: srv, Type: "SRV", Content: content, Online: h.Online, TTL: h.TTL, NodeID: h.NodeID}
+	}
+	return out
+}
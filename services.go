@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// service publishes an SRV record pointing at every node carrying Tag.
+type service struct {
+	// Name is the owner name below the managed suffix, e.g. _http._tcp.web.
+	Name string `yaml:"name"`
+	Tag  string `yaml:"tag"`
+	Port int    `yaml:"port"`
+}
+
+const (
+	defaultSRVPriority = 10
+	defaultSRVWeight   = 10
+)
+
+var (
+	serviceNameRe = regexp.MustCompile(`^_[a-z0-9-]+\._(tcp|udp|tls)(\.[a-z0-9-]+)*$`)
+	srvAttrRe     = regexp.MustCompile(`srv-(priority|weight)-(\d+)$`)
+)
+
+// parseServices parses SERVICES, "_http._tcp.web=tag:web:8080,...".
+func parseServices(v string) ([]service, error) {
+	var out []service
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		name, rest, ok := strings.Cut(part, "=")
+		i := strings.LastIndex(rest, ":")
+		if !ok || i < 0 {
+			return nil, fmt.Errorf("invalid service %q, want name=tag:port", part)
+		}
+		port, err := strconv.Atoi(rest[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid service %q: %w", part, err)
+		}
+		out = append(out, service{Name: name, Tag: rest[:i], Port: port})
+	}
+	return out, nil
+}
+
+func (s service) validate() error {
+	switch {
+	case !serviceNameRe.MatchString(s.Name):
+		return fmt.Errorf("services: name %q must look like _service._proto[.label]", s.Name)
+	case !strings.HasPrefix(s.Tag, "tag:"):
+		return fmt.Errorf("services: %s: tag %q must start with tag:", s.Name, s.Tag)
+	case s.Port < 1 || s.Port > 65535:
+		return fmt.Errorf("services: %s: port %d out of range", s.Name, s.Port)
+	}
+	return nil
+}
+
+// srvPreference returns the SRV priority and weight of a node. They are
+// taken from tags or node attributes ending in srv-priority-<n> and
+// srv-weight-<n>, e.g. tag:srv-priority-20 makes a node a fallback for
+// nodes left at the default priority of 10.
+func srvPreference(ps *ipnstate.PeerStatus) (priority, weight int) {
+	priority, weight = defaultSRVPriority, defaultSRVWeight
+	var attrs []string
+	if ps.Tags != nil {
+		attrs = ps.Tags.AsSlice()
+	}
+	for capability := range ps.CapMap {
+		attrs = append(attrs, string(capability))
+	}
+	for _, a := range attrs {
+		m := srvAttrRe.FindStringSubmatch(a)
+		if m == nil {
+			continue
+		}
+		n, err := strconv.Atoi(m[2])
+		if err != nil || n > 65535 {
+			continue
+		}
+		if m[1] == "priority" {
+			priority = n
+		} else {
+			weight = n
+		}
+	}
+	return priority, weight
+}
+
+func hasTag(ps *ipnstate.PeerStatus, tag string) bool {
+	if ps.Tags == nil {
+		return false
+	}
+	for _, t := range ps.Tags.AsSlice() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// desiredServices returns the SRV records of z keyed like recordKey, one per
+// service and published node carrying the service's tag.
+func desiredServices(st *ipnstate.Status, z *zone, hosts map[string]host) map[string]host {
+	out := map[string]host{}
+	if len(cfg().Services) == 0 {
+		return out
+	}
+	peers := []*ipnstate.PeerStatus{st.Self}
+	for _, ps := range st.Peer {
+		peers = append(peers, ps)
+	}
+	for _, ps := range peers {
+		name := hostName(st, ps)
+		h, ok := findHost(hosts, cfg().RecordType, name)
+		if !ok {
+			// published over IPv6 only
+			h, ok = findHost(hosts, "AAAA", name)
+		}
+		if !ok || h.Content == "" {
+			continue
+		}
+		target := name + "." + z.Suffix
+		if h.Type == "CNAME" {
+			// SRV targets must not be aliases, point at MagicDNS directly
+			target = h.Content
+		}
+		priority, weight := srvPreference(ps)
+		for _, s := range cfg().Services {
+			if !hasTag(ps, s.Tag) {
+				continue
+			}
+			content := fmt.Sprintf("%d %d %d %s", priority, weight, s.Port, target)
+			out[recordKey("SRV", s.Name, content)] = host{Name: s.Name, Type: "SRV", Content: content, Online: h.Online, TTL: h.TTL, NodeID: h.NodeID}
+		}
+	}
+	return out
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// shutdownSignals stop the daemon gracefully.
+var shutdownSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+
+// notifyControlSignals relays the signals that make the daemon sync right
+// away, SIGHUP after rereading the config file, to c.
+func notifyControlSignals(c chan<- os.Signal) {
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGUSR1)
+}
+
+// terminate asks p to shut down gracefully.
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+// signalReload asks the process pid to reload its configuration.
+func signalReload(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(syscall.SIGHUP)
+}
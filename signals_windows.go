@@ -0,0 +1,27 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// shutdownSignals stop the daemon gracefully: Ctrl-C, or the console
+// closing or the service stopping.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// notifyControlSignals does nothing: Windows has no SIGHUP or SIGUSR1, use
+// POST /sync or restart the service instead.
+func notifyControlSignals(c chan<- os.Signal) {}
+
+// terminate stops p; Windows can't signal other processes.
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
+
+// signalReload can't signal the process pid on Windows.
+func signalReload(pid int) error {
+	return errors.New("signals are not supported on Windows")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand("rollback", runRollback)
+}
+
+// snapshot is the record set wanted in every zone after a cycle that
+// applied everything it planned, for `rollback`.
+type snapshot struct {
+	Time time.Time `json:"time"`
+	// Zones holds the records by zone name, a zone's suffixes together.
+	Zones map[string][]snapshotRecord `json:"zones"`
+}
+
+// snapshotRecord is a record of a snapshot; Name is relative to Suffix, and
+// an empty Content leaves whatever the provider holds alone, like the host
+// it was taken from.
+type snapshotRecord struct {
+	Suffix  string `json:"suffix"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	Proxied bool   `json:"proxied,omitempty"`
+	NodeID  string `json:"node_id,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// takeSnapshot adds the records wanted in zones to SNAPSHOT_PATH when they
+// differ from the latest snapshot, keeping the SNAPSHOT_KEEP most recent.
+func takeSnapshot(ctx context.Context, wanted map[*zone]map[string]host, now time.Time) {
+	if cfg().SnapshotPath == "" {
+		return
+	}
+	s := snapshot{Time: now, Zones: map[string][]snapshotRecord{}}
+	for z, hosts := range wanted {
+		if s.Zones[z.Name] == nil {
+			s.Zones[z.Name] = []snapshotRecord{}
+		}
+		for _, h := range hosts {
+			s.Zones[z.Name] = append(s.Zones[z.Name], snapshotRecord{Suffix: z.Suffix, Name: h.Name, Type: h.Type,
+				Content: h.Content, TTL: h.TTL, Proxied: h.Proxied, NodeID: h.NodeID, Comment: h.Comment})
+		}
+	}
+	for _, records := range s.Zones {
+		sort.Slice(records, func(i, j int) bool {
+			a, b := records[i], records[j]
+			return a.Suffix+" "+a.Name+" "+a.Type+" "+a.Content < b.Suffix+" "+b.Name+" "+b.Type+" "+b.Content
+		})
+	}
+	snapshots, err := readSnapshots(ctx)
+	if err != nil {
+		slog.Warn("reading the snapshots failed, not taking one", logErr(err))
+		return
+	}
+	if n := len(snapshots); n > 0 {
+		last, _ := json.Marshal(snapshots[n-1].Zones)
+		next, _ := json.Marshal(s.Zones)
+		if bytes.Equal(last, next) {
+			return
+		}
+	}
+	snapshots = append(snapshots, s)
+	if len(snapshots) > cfg().SnapshotKeep {
+		snapshots = snapshots[len(snapshots)-cfg().SnapshotKeep:]
+	}
+	b, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		slog.Warn("marshal snapshots failed", logErr(err))
+		return
+	}
+	if err := writeObject(ctx, cfg().SnapshotPath, b); err != nil {
+		slog.Warn("writing the snapshot failed", "path", cfg().SnapshotPath, logErr(err))
+	}
+}
+
+// readSnapshots returns the snapshots at SNAPSHOT_PATH, oldest first.
+func readSnapshots(ctx context.Context) ([]snapshot, error) {
+	b, err := readObject(ctx, cfg().SnapshotPath)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read snapshots %s: %w", cfg().SnapshotPath, err)
+	}
+	var snapshots []snapshot
+	if err := json.Unmarshal(b, &snapshots); err != nil {
+		return nil, fmt.Errorf("decode snapshots %s: %w", cfg().SnapshotPath, err)
+	}
+	return snapshots, nil
+}
+
+// hosts returns the records of s in z as the hosts a cycle would want.
+func (s snapshot) hosts(z *zone) map[string]host {
+	out := map[string]host{}
+	for _, r := range s.Zones[z.Name] {
+		if r.Suffix == z.Suffix {
+			out[recordKey(r.Type, r.Name, r.Content)] = host{Name: r.Name, Type: r.Type, Content: r.Content,
+				TTL: r.TTL, Proxied: r.Proxied, NodeID: r.NodeID, Comment: r.Comment}
+		}
+	}
+	return out
+}
+
+// runRollback implements `tailscale-dns-sync rollback`: it lists the
+// snapshots, or plans the changes bringing the zones back to one of them
+// and with -yes applies them.
+func runRollback(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	n := fs.Int("snapshot", 0, "snapshot to roll back to, 1 for the latest, 2 for the one before, and so on; lists them when 0")
+	yes := fs.Bool("yes", false, "apply the changes instead of listing them")
+	if err := openCommand(ctx, fs, args); err != nil {
+		return err
+	}
+	if cfg().SnapshotPath == "" {
+		return fmt.Errorf("set SNAPSHOT_PATH to where the daemon keeps its snapshots")
+	}
+	snapshots, err := readSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	if *n == 0 {
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			s := snapshots[i]
+			var counts []string
+			for name, records := range s.Zones {
+				counts = append(counts, fmt.Sprintf("%s=%d", name, len(records)))
+			}
+			sort.Strings(counts)
+			fmt.Printf("%d\t%s\t%s\n", len(snapshots)-i, s.Time.Format(time.RFC3339), strings.Join(counts, " "))
+		}
+		return nil
+	}
+	if *n < 0 || *n > len(snapshots) {
+		return fmt.Errorf("-snapshot %d: there are %d snapshots", *n, len(snapshots))
+	}
+	s := snapshots[len(snapshots)-*n]
+	failed := 0
+	for _, z := range zones {
+		if _, ok := s.Zones[z.Name]; !ok {
+			fmt.Printf("%s\tnot in the snapshot, left alone\n", z.Name)
+			continue
+		}
+		records, err := currentRecords(ctx, z)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", z.Name, err)
+		}
+		changes := plan(z, s.hosts(z), records)
+		for i := range changes {
+			changes[i].Reason = "rollback to " + s.Time.Format(time.RFC3339)
+		}
+		if !*yes {
+			for _, c := range changes {
+				fmt.Printf("%s\t%s\n", z.Name, c)
+			}
+			continue
+		}
+		applied, f := applyChanges(ctx, z, changes)
+		var entries []auditEntry
+		for _, c := range applied {
+			entries = append(entries, newAuditEntry(c, ""))
+		}
+		for _, c := range f {
+			entries = append(entries, newAuditEntry(c.change, c.Error))
+		}
+		writeAudit(ctx, entries)
+		failed += len(f)
+		// the next cycle lists the zone again
+		delete(syncState.Records, z.Name)
+	}
+	if !*yes {
+		fmt.Println("run again with -yes to apply these changes")
+		return nil
+	}
+	saveState(ctx)
+	if failed > 0 {
+		return fmt.Errorf("%d change(s) failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn/ipnstate"
+)
+
+const (
+	// SourceTailscaled reads the tailnet from the local tailscaled.
+	SourceTailscaled = "tailscaled"
+	// SourceAPI reads the tailnet from the Tailscale Admin API, so no
+	// tailscaled is needed on the host running the sync.
+	SourceAPI = "api"
+	// SourceFile publishes FILE_SOURCE only, without any tailnet.
+	SourceFile = "file"
+	// SourceStatus reads the tailnet from STATUS_JSON, a saved `tailscale
+	// status --json`, for simulations and tests without a tailscaled.
+	SourceStatus = "status"
+)
+
+// startEmbeddedNode joins the tailnet with an embedded tailscaled and
+// returns its client; nil unless built with -tags tsnet.
+var startEmbeddedNode func(ctx context.Context) (*tailscale.LocalClient, error)
+
+// embeddedClient is the client of the node started for TSNET.
+var embeddedClient *tailscale.LocalClient
+
+// localClient returns a LocalAPI client for TSNET's embedded node, or for
+// TAILSCALED_SOCKET, or for the platform's default socket when unset.
+func localClient() *tailscale.LocalClient {
+	if embeddedClient != nil {
+		return embeddedClient
+	}
+	return &tailscale.LocalClient{Socket: cfg().TailscaledSocket, UseSocketOnly: cfg().TailscaledSocket != "" || cfg().TailscaledSocketOnly}
+}
+
+// syntheticStatus replaces the source with a generated tailnet in benchmarks.
+var syntheticStatus func() *ipnstate.Status
+
+// fetchStatus returns the tailnet as seen by the configured source. The
+// Admin API source fills in the same fields tailscaled reports, with an
+// empty Self since the sync isn't a node of the tailnet then.
+func fetchStatus(ctx context.Context) (*ipnstate.Status, error) {
+	if syntheticStatus != nil {
+		return syntheticStatus(), nil
+	}
+	switch cfg().Source {
+	case SourceAPI:
+		return apiStatus(ctx)
+	case SourceHeadscale:
+		return headscaleStatus(ctx)
+	case SourceFile:
+		return &ipnstate.Status{Self: &ipnstate.PeerStatus{}}, nil
+	case SourceStatus:
+		return readStatusJSON(cfg().StatusJSON)
+	}
+	return lc().Status(ctx)
+}
+
+// readStatusJSON reads the status saved at path, re-read every cycle so a
+// simulation can swap it between them.
+func readStatusJSON(path string) (*ipnstate.Status, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st ipnstate.Status
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, fmt.Errorf("status_json %s: %w", path, err)
+	}
+	if st.Self == nil {
+		st.Self = &ipnstate.PeerStatus{}
+	}
+	return &st, nil
+}
+
+var (
+	metricStatusAge = newMetric("gauge", "status_age_seconds", "Age of the tailnet status the last cycle used, above 0 when it fell back to the cached one.")
+
+	// lastStatus is the last status fetched successfully and when.
+	lastStatus   *ipnstate.Status
+	lastStatusAt time.Time
+)
+
+// cycleStatus fetches the status for a sync cycle. When that fails, e.g.
+// while tailscaled restarts, the last good status is used instead as long as
+// it is at most STATUS_MAX_STALE old, so the cycle still repairs the zones.
+func cycleStatus(ctx context.Context) (*ipnstate.Status, error) {
+	st, err := fetchStatus(ctx)
+	setHealth("tailnet", err)
+	if err == nil {
+		lastStatus, lastStatusAt = st, time.Now()
+		metricStatusAge.Set(0)
+		return st, nil
+	}
+	age := time.Since(lastStatusAt)
+	if lastStatus == nil || age > cfg().StatusMaxStale || ctx.Err() != nil {
+		return nil, err
+	}
+	slog.Warn("get status failed, using the last status", logErr(err), "age", age.Round(time.Second).String())
+	metricStatusAge.Set(age.Seconds())
+	return lastStatus, nil
+}
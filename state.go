@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// state is persisted between runs at STATE_PATH so restarts, including
+// stateless container restarts with object storage, keep continuity.
+type state struct {
+	// LastDrift is the fingerprint of the drift last notified.
+	LastDrift string `json:"last_drift,omitempty"`
+	// TailnetSuffix is the MagicDNS suffix seen in the last cycle.
+	TailnetSuffix string `json:"tailnet_suffix,omitempty"`
+	// LastSuccess is the end of the last cycle that completed without error.
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	// Started is when the process that last saved the state started, so
+	// `wait` can tell a success of the running daemon from an older one.
+	Started time.Time `json:"started,omitempty"`
+	// LastError is the most recent sync error and when it happened.
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+	// Runs and Changes are cumulative counters by result and by action, kept
+	// here so restarting doesn't reset the exported counters.
+	Runs    map[string]float64 `json:"runs,omitempty"`
+	Changes map[string]float64 `json:"changes,omitempty"`
+	// Excluded hosts are never published, set from the TUI.
+	Excluded map[string]bool `json:"excluded,omitempty"`
+	// Hosts tracks since when every host has been stable.
+	Hosts map[string]hostState `json:"hosts,omitempty"`
+	// Records caches the managed records observed after the last cycle,
+	// keyed by zone and then by name.
+	Records map[string]map[string]stateRecord `json:"records,omitempty"`
+	// Checkpoints are the changes interrupted runs left, keyed by zone.
+	Checkpoints map[string]checkpoint `json:"checkpoints,omitempty"`
+	// Quarantined records are waiting for deletion since the given time,
+	// keyed by zone and then by name.
+	Quarantined map[string]map[string]time.Time `json:"quarantined,omitempty"`
+	// LastFullAudit is when the last full audit covered every zone.
+	LastFullAudit time.Time `json:"last_full_audit,omitempty"`
+	// Registrations are the names nodes registered for themselves, keyed
+	// by node ID.
+	Registrations map[string]registration `json:"registrations,omitempty"`
+	// Reverse holds the mappings of REVERSE_MAP_PATH, keyed by address and
+	// name.
+	Reverse map[string]reverseEntry `json:"reverse,omitempty"`
+	// Frozen is the freeze in effect, if any.
+	Frozen *freeze `json:"frozen,omitempty"`
+}
+
+type hostState struct {
+	Content string    `json:"content"`
+	Online  bool      `json:"online"`
+	Since   time.Time `json:"since"`
+	// OfflineSince is when the host went offline, zero while online.
+	OfflineSince time.Time `json:"offline_since,omitempty"`
+}
+
+type stateRecord struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type,omitempty"`
+	Content    string    `json:"content"`
+	ModifiedOn time.Time `json:"modified_on,omitempty"`
+	// Verified and Updated are when the sync last saw the record matching
+	// the tailnet and last wrote it.
+	Verified time.Time `json:"verified,omitempty"`
+	Updated  time.Time `json:"updated,omitempty"`
+}
+
+var (
+	syncState    = state{Records: map[string]map[string]stateRecord{}}
+	processStart = time.Now()
+)
+
+func loadState(ctx context.Context) error {
+	if cfg().StatePath == "" {
+		return nil
+	}
+	b, err := readObject(ctx, cfg().StatePath)
+	if errors.Is(err, errObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load state %s: %w", cfg().StatePath, err)
+	}
+	var s state
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("decode state %s: %w", cfg().StatePath, err)
+	}
+	if s.Records == nil {
+		s.Records = map[string]map[string]stateRecord{}
+	}
+	syncState = s
+	restoreMetrics()
+	return nil
+}
+
+// restoreMetrics seeds the exported metrics from the persisted counters.
+func restoreMetrics() {
+	for result, n := range syncState.Runs {
+		metricRuns.Set(n, "result", result)
+	}
+	for action, n := range syncState.Changes {
+		metricChanges.Set(n, "action", action)
+	}
+	if !syncState.LastSuccess.IsZero() {
+		metricLastSuccess.Set(float64(syncState.LastSuccess.Unix()))
+	}
+	exportManaged()
+	exportFreshness()
+}
+
+// countRun records the outcome of a cycle in both the metrics and the state.
+func countRun(r *runReport) {
+	if syncState.Runs == nil {
+		syncState.Runs = map[string]float64{}
+	}
+	if syncState.Changes == nil {
+		syncState.Changes = map[string]float64{}
+	}
+	syncState.Runs[r.Result]++
+	metricRuns.Set(syncState.Runs[r.Result], "result", r.Result)
+	for _, c := range r.Applied {
+		syncState.Changes[c.Action]++
+		metricChanges.Set(syncState.Changes[c.Action], "action", c.Action)
+	}
+	if len(r.Failed) > 0 {
+		syncState.Changes["failed"] += float64(len(r.Failed))
+		metricChanges.Set(syncState.Changes["failed"], "action", "failed")
+	}
+	metricDuration.Observe(r.End.Sub(r.Start).Seconds())
+	exportManaged()
+	if r.Result != "error" {
+		syncState.LastSuccess = r.End
+		metricLastSuccess.Set(float64(r.End.Unix()))
+	}
+	if msg := r.firstError(); msg != "" {
+		syncState.LastError = msg
+		syncState.LastErrorTime = r.End
+	}
+}
+
+// exportManaged exports the number of records managed in each zone.
+func exportManaged() {
+	metricManaged.Reset()
+	for zoneName, records := range syncState.Records {
+		metricManaged.Set(float64(len(records)), "zone", zoneName)
+	}
+}
+
+func saveState(ctx context.Context) {
+	if cfg().StatePath == "" {
+		return
+	}
+	syncState.Started = processStart
+	b, err := json.MarshalIndent(syncState, "", "  ")
+	if err != nil {
+		slog.Warn("marshal state failed", logErr(err))
+		return
+	}
+	if err := writeObject(ctx, cfg().StatePath, b); err != nil {
+		slog.Warn("save state failed", logErr(err))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	statsdOnce sync.Once
+	statsdConn net.Conn
+)
+
+// statsdSend writes metric lines to STATSD_ADDR over UDP. With
+// STATSD_DOGSTATSD the tags are sent as DogStatsD tags, otherwise their
+// values are folded into the metric name, e.g. runs.success.
+func statsdSend(lines []string) {
+	if cfg().StatsdAddr == "" || len(lines) == 0 {
+		return
+	}
+	statsdOnce.Do(func() {
+		var err error
+		if statsdConn, err = net.Dial("udp", cfg().StatsdAddr); err != nil {
+			slog.Warn("statsd failed", logErr(err))
+		}
+	})
+	if statsdConn == nil {
+		return
+	}
+	// keep datagrams well below common MTUs
+	var packet strings.Builder
+	for _, l := range lines {
+		if packet.Len() > 0 && packet.Len()+len(l) > 1400 {
+			statsdConn.Write([]byte(packet.String()))
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(l)
+	}
+	if _, err := statsdConn.Write([]byte(packet.String())); err != nil {
+		slog.Warn("statsd failed", logErr(err))
+	}
+}
+
+// statsdLine formats one metric; tags are "key", "value" pairs.
+func statsdLine(name string, value float64, kind string, tags ...string) string {
+	name = cfg().StatsdPrefix + name
+	var dogTags []string
+	for i := 0; i+1 < len(tags); i += 2 {
+		if cfg().StatsdDogStatsD {
+			dogTags = append(dogTags, tags[i]+":"+tags[i+1])
+		} else {
+			name += "." + strings.NewReplacer(".", "_", ":", "_").Replace(tags[i+1])
+		}
+	}
+	line := fmt.Sprintf("%s:%g|%s", name, value, kind)
+	if cfg().StatsdDogStatsD {
+		dogTags = append(dogTags, cfg().StatsdTags...)
+		if pipelineName != "" {
+			dogTags = append(dogTags, "pipeline:"+pipelineName)
+		}
+		if len(dogTags) > 0 {
+			line += "|#" + strings.Join(dogTags, ",")
+		}
+	}
+	return line
+}
+
+// emitStatsD sends the counters and timings of the cycle r.
+func emitStatsD(r *runReport) {
+	if cfg().StatsdAddr == "" {
+		return
+	}
+	lines := []string{statsdLine("runs", 1, "c", "result", r.Result)}
+	counts := map[string]int{}
+	for _, c := range r.Applied {
+		counts[c.Action]++
+	}
+	for _, action := range []string{actionCreate, actionUpdate, actionDelete} {
+		lines = append(lines, statsdLine("changes", float64(counts[action]), "c", "action", action))
+	}
+	deferredDeletes := 0
+	for _, c := range r.Deferred {
+		if c.Action == actionDelete {
+			deferredDeletes++
+		}
+	}
+	lines = append(lines,
+		statsdLine("failed", float64(len(r.Failed)), "c"),
+		statsdLine("drift", float64(len(r.Planned)), "g"),
+		statsdLine("deferred_deletes", float64(deferredDeletes), "g"),
+	)
+	for _, kind := range []string{apiCallList, apiCallMutate, apiCallRetry, apiCallOther} {
+		lines = append(lines, statsdLine("api_calls", float64(r.APICalls[kind]), "c", "kind", kind))
+	}
+	phases := make([]string, 0, len(r.Durations))
+	for phase := range r.Durations {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+	for _, phase := range phases {
+		// phases are "name" or "name:zone"
+		name, zoneName, _ := strings.Cut(phase, ":")
+		tags := []string{"phase", name}
+		if zoneName != "" {
+			tags = append(tags, "zone", zoneName)
+		}
+		lines = append(lines, statsdLine("duration", r.Durations[phase]*1000, "ms", tags...))
+	}
+	statsdSend(lines)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// statusFile is the snapshot written to STATUS_PATH after every cycle.
+type statusFile struct {
+	Updated       time.Time `json:"updated"`
+	Instance      string    `json:"instance"`
+	Leader        bool      `json:"leader"`
+	Result        string    `json:"result"`
+	LastSync      time.Time `json:"last_sync"`
+	LastSuccess   time.Time `json:"last_success,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+	Planned       int       `json:"planned"`
+	Applied       int       `json:"applied"`
+	Failed        int       `json:"failed"`
+	Deferred      int       `json:"deferred"`
+	Frozen        *freeze   `json:"frozen,omitempty"`
+	// Records maps zone => name => content of the published records.
+	Records map[string]map[string]string `json:"records"`
+}
+
+// writeStatus writes the outcome of the cycle r and the published records
+// to STATUS_PATH for local monitoring agents, and keeps it for GET /status.
+func writeStatus(r *runReport) {
+	s := statusFile{
+		Updated:       time.Now(),
+		Instance:      instanceID(),
+		Leader:        leader.Load() || leaderLock == nil,
+		Result:        r.Result,
+		LastSync:      r.End,
+		LastSuccess:   syncState.LastSuccess,
+		LastError:     syncState.LastError,
+		LastErrorTime: syncState.LastErrorTime,
+		Planned:       len(r.Planned),
+		Applied:       len(r.Applied),
+		Failed:        len(r.Failed),
+		Deferred:      len(r.Deferred),
+		Frozen:        syncState.Frozen,
+		Records:       map[string]map[string]string{},
+	}
+	for zoneName, records := range syncState.Records {
+		m := make(map[string]string, len(records))
+		for key, rec := range records {
+			m[key] = rec.Content
+		}
+		s.Records[zoneName] = m
+	}
+	adminMu.Lock()
+	adminStatus = &s
+	adminMu.Unlock()
+	if cfg().StatusPath == "" {
+		return
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		slog.Warn("marshal status failed", logErr(err))
+		return
+	}
+	if err := writeFileAtomic(cfg().StatusPath, b); err != nil {
+		slog.Warn("write status failed", logErr(err))
+	}
+}
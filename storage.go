@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"golang.org/x/oauth2/google"
+)
+
+// errObjectNotFound is returned by readObject when the object doesn't exist.
+var errObjectNotFound = errors.New("object not found")
+
+var (
+	s3Once   sync.Once
+	s3Client *s3.Client
+	s3Err    error
+
+	gcsOnce   sync.Once
+	gcsClient *http.Client
+	gcsErr    error
+)
+
+// readObject reads a local path, s3://bucket/key, gs://bucket/key or
+// redis://host:port/db?key=name.
+func readObject(ctx context.Context, location string) ([]byte, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return readRedisObject(ctx, location)
+	case "s3":
+		c, err := getS3Client(ctx)
+		if err != nil {
+			return nil, err
+		}
+		out, err := c.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(u.Host),
+			Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+		})
+		if err != nil {
+			var nsk *s3types.NoSuchKey
+			if errors.As(err, &nsk) {
+				return nil, errObjectNotFound
+			}
+			return nil, err
+		}
+		defer out.Body.Close()
+		return io.ReadAll(out.Body)
+	case "gs":
+		c, err := getGCSClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		endpoint := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media",
+			u.Host, url.PathEscape(strings.TrimPrefix(u.Path, "/")))
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, errObjectNotFound
+		}
+		if resp.StatusCode >= 300 {
+			return nil, fmt.Errorf("gcs get %s: %s", location, resp.Status)
+		}
+		return io.ReadAll(resp.Body)
+	default:
+		b, err := os.ReadFile(location)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errObjectNotFound
+		}
+		return b, err
+	}
+}
+
+// writeObject replaces the object at location with data.
+func writeObject(ctx context.Context, location string, data []byte) error {
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		return writeRedisObject(ctx, location, data)
+	case "s3":
+		c, err := getS3Client(ctx)
+		if err != nil {
+			return err
+		}
+		_, err = c.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(u.Host),
+			Key:         aws.String(strings.TrimPrefix(u.Path, "/")),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/json"),
+		})
+		return err
+	case "gs":
+		c, err := getGCSClient(ctx)
+		if err != nil {
+			return err
+		}
+		endpoint := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=media&name=%s",
+			u.Host, url.QueryEscape(strings.TrimPrefix(u.Path, "/")))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("gcs put %s: %s", location, resp.Status)
+		}
+		return nil
+	default:
+		return writeFileAtomic(location, data)
+	}
+}
+
+// writeFileAtomic writes data next to path and renames it into place, so
+// readers never observe a partially written file. An existing file keeps its
+// permissions.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if fi, err := os.Stat(path); err == nil {
+		if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func getS3Client(ctx context.Context) (*s3.Client, error) {
+	s3Once.Do(func() {
+		var c aws.Config
+		c, s3Err = awsconfig.LoadDefaultConfig(ctx)
+		if s3Err == nil {
+			s3Client = s3.NewFromConfig(c)
+		}
+	})
+	return s3Client, s3Err
+}
+
+func getGCSClient(ctx context.Context) (*http.Client, error) {
+	gcsOnce.Do(func() {
+		gcsClient, gcsErr = google.DefaultClient(context.Background(),
+			"https://www.googleapis.com/auth/devstorage.read_write")
+	})
+	return gcsClient, gcsErr
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+)
+
+// subnetHost is a LAN host reached through a subnet router, published under
+// its own name while a peer routes its address.
+type subnetHost struct {
+	Name    string `yaml:"name"`
+	Address string `yaml:"address"`
+}
+
+// parseSubnetHosts parses SUBNET_HOSTS, "name=address,...".
+func parseSubnetHosts(v string) ([]subnetHost, error) {
+	var out []subnetHost
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, addr, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid subnet host %q, want name=address", part)
+		}
+		out = append(out, subnetHost{Name: strings.TrimSpace(name), Address: strings.TrimSpace(addr)})
+	}
+	return out, nil
+}
+
+func (h subnetHost) validate() error {
+	if h.Name == "" || strings.Contains(h.Name, ".") {
+		return fmt.Errorf("subnet_hosts: name %q must be a single label", h.Name)
+	}
+	if _, err := netip.ParseAddr(h.Address); err != nil {
+		return fmt.Errorf("subnet_hosts: %s: %q is not an address", h.Name, h.Address)
+	}
+	return nil
+}
+
+var (
+	leasesMu sync.Mutex
+	// lastLeases are the hosts of the last DHCP_LEASES read that worked,
+	// kept when a read fails so a passing error doesn't remove them.
+	lastLeases []subnetHost
+)
+
+// readLeases returns the hosts of the dnsmasq lease file DHCP_LEASES
+// ("expiry mac address hostname client-id" per line) whose lease hasn't
+// expired. Leases without a hostname are skipped.
+func readLeases(now time.Time) []subnetHost {
+	leasesMu.Lock()
+	defer leasesMu.Unlock()
+	b, err := os.ReadFile(cfg().DHCPLeases)
+	if err != nil {
+		slog.Warn("reading the DHCP leases failed, keeping the last ones", "path", cfg().DHCPLeases, logErr(err))
+		return lastLeases
+	}
+	var out []subnetHost
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		f := strings.Fields(s.Text())
+		if len(f) < 4 || f[3] == "*" {
+			continue
+		}
+		if expiry, err := strconv.ParseInt(f[0], 10, 64); err != nil || expiry != 0 && time.Unix(expiry, 0).Before(now) {
+			continue
+		}
+		if _, err := netip.ParseAddr(f[2]); err != nil {
+			continue
+		}
+		label, _, _ := strings.Cut(f[3], ".")
+		name := strings.Trim(labelUnsafe.ReplaceAllString(strings.ToLower(label), "-"), "-")
+		if name == "" || len(name) > 63 {
+			continue
+		}
+		out = append(out, subnetHost{Name: name, Address: f[2]})
+	}
+	lastLeases = out
+	return out
+}
+
+// addSubnetHosts adds SUBNET_HOSTS and then the DHCP_LEASES hosts whose
+// address is inside a subnet route some peer is the primary router for. The
+// record follows the router's online state. Tailnet nodes of the same name
+// win, and so do earlier entries.
+func addSubnetHosts(st *ipnstate.Status, hosts map[string]host) {
+	if len(cfg().SubnetHosts) == 0 && cfg().DHCPLeases == "" {
+		return
+	}
+	candidates := cfg().SubnetHosts
+	if cfg().DHCPLeases != "" {
+		candidates = append(candidates[:len(candidates):len(candidates)], readLeases(time.Now())...)
+	}
+	peers := []*ipnstate.PeerStatus{st.Self}
+	for _, ps := range st.Peer {
+		peers = append(peers, ps)
+	}
+	for _, sh := range candidates {
+		addr, err := netip.ParseAddr(sh.Address)
+		if err != nil {
+			continue
+		}
+		typ := "A"
+		if addr.Is6() {
+			typ = "AAAA"
+		}
+		if _, ok := findHost(hosts, typ, sh.Name); ok || syncState.Excluded[sh.Name] || !nameAllowed(sh.Name) {
+			continue
+		}
+		for _, ps := range peers {
+			if routesSubnet(ps, addr) {
+				hosts[recordKey(typ, sh.Name, addr.String())] = host{Name: sh.Name, Type: typ, Content: addr.String(), Online: ps.Online || ps == st.Self, TTL: cfg().TTL, Proxied: cfg().Proxied}
+				break
+			}
+		}
+	}
+}
+
+// routesSubnet reports whether ps is the primary router of a subnet route,
+// other than an exit or 4via6 route, containing addr.
+func routesSubnet(ps *ipnstate.PeerStatus, addr netip.Addr) bool {
+	if ps == nil || ps.PrimaryRoutes == nil {
+		return false
+	}
+	for i := 0; i < ps.PrimaryRoutes.Len(); i++ {
+		r := ps.PrimaryRoutes.At(i)
+		if r.Bits() > 0 && !tsaddr.IsViaPrefix(r) && r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
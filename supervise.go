@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// cyclePanicked is set when anything in the current cycle panicked.
+	cyclePanicked     atomic.Bool
+	consecutivePanics int
+
+	metricPanics = newMetric("counter", "panics_total", "Panics recovered by the supervisor, by where they happened.")
+)
+
+// recoverPanic turns the panic value r into an error after logging its stack,
+// counting it and reporting it to Sentry and the notification webhook, so a
+// single bad peer entry fails one cycle instead of the whole daemon.
+func recoverPanic(ctx context.Context, where string, r any) error {
+	stack := debug.Stack()
+	cyclePanicked.Store(true)
+	err := fmt.Errorf("panic in %s: %v", where, r)
+	slog.Error("panic", "where", where, "panic", fmt.Sprint(r), "stack", string(stack))
+	metricPanics.Inc("where", where)
+	captureSentry(ctx, err, stack)
+	notify(ctx, event{Type: "panic", Message: err.Error()})
+	return err
+}
+
+// endCycle escalates to exiting the process once MAX_CONSECUTIVE_PANICS
+// cycles in a row panicked, leaving the restart to the service manager.
+func endCycle() {
+	if !cyclePanicked.Swap(false) {
+		consecutivePanics = 0
+		return
+	}
+	consecutivePanics++
+	if cfg().MaxPanics > 0 && consecutivePanics >= cfg().MaxPanics {
+		runShutdownHooks()
+		log.Fatalf("%d consecutive sync cycles panicked, exiting", consecutivePanics)
+	}
+	slog.Error("sync cycle panicked, retrying next cycle", "in_a_row", consecutivePanics)
+}
+
+// syncStuckGrace is how long a cycle may outlive SYNC_TIMEOUT, e.g. in a
+// call that doesn't honor its context, before the process gives up on it.
+const syncStuckGrace = time.Minute
+
+// watchStuckCycle exits the process when the cycle started doesn't end
+// within SYNC_TIMEOUT and syncStuckGrace, leaving the restart to the service
+// manager rather than staying wedged. Call the func returned when it ends.
+func watchStuckCycle() (stop func()) {
+	limit := cfg().SyncTimeout + syncStuckGrace
+	t := time.AfterFunc(limit, func() {
+		runShutdownHooks()
+		log.Fatalf("sync cycle still running %s after it started, exiting", limit)
+	})
+	return func() { t.Stop() }
+}
+
+// captureSentry sends err to SENTRY_DSN through the store endpoint.
+func captureSentry(ctx context.Context, err error, stack []byte) {
+	if cfg().SentryDSN == "" {
+		return
+	}
+	dsn, perr := url.Parse(cfg().SentryDSN)
+	if perr != nil || dsn.User == nil {
+		slog.Warn("invalid SENTRY_DSN")
+		return
+	}
+	project := strings.TrimPrefix(dsn.Path, "/")
+	q := url.Values{"sentry_version": {"7"}, "sentry_key": {dsn.User.Username()}, "sentry_client": {"tailscale-dns-sync/1"}}
+	endpoint := fmt.Sprintf("%s://%s/api/%s/store/?%s", dsn.Scheme, dsn.Host, project, q.Encode())
+	id := make([]byte, 16)
+	rand.Read(id)
+	host, _ := os.Hostname()
+	ev := map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       "fatal",
+		"platform":    "go",
+		"server_name": host,
+		"message":     err.Error(),
+		"extra":       map[string]any{"stack": string(stack), "instance": instanceID()},
+	}
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := postJSON(cctx, endpoint, ev); err != nil {
+		slog.Warn("sentry report failed", logErr(err))
+	}
+}
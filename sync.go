@@ -0,0 +1,981 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+
+	dnssync "tailscale-dns-sync/pkg/sync"
+)
+
+const (
+	actionCreate = "create"
+	actionUpdate = "update"
+	actionDelete = "delete"
+)
+
+// zone is a provider zone the tailnet is synced into.
+type zone struct {
+	// Name identifies the zone in state, reports and metrics: the zone name
+	// for DOMAIN entries, the suffix itself for SUFFIXES entries.
+	Name string
+	// Suffix is the domain the hosts are published under, name.<Suffix>.
+	Suffix   string
+	provider provider
+	// providerName names the provider in logs, cfg().Provider when empty.
+	providerName string
+	// registry holds the TXT ownership records with REGISTRY=txt, nil
+	// otherwise.
+	registry *txtRegistry
+	// reverse is set for the REVERSE_ZONES, which hold PTR records.
+	reverse bool
+	// funnel is set for a FUNNEL_NAMES entry, named after it, which holds
+	// its CNAME record.
+	funnel *funnelName
+}
+
+// forward reports whether z publishes the hosts, unlike reverse and funnel
+// zones.
+func (z *zone) forward() bool {
+	return !z.reverse && z.funnel == nil
+}
+
+// providerLabel returns the name of the provider of z.
+func (z *zone) providerLabel() string {
+	if z.providerName != "" {
+		return z.providerName
+	}
+	return cfg().Provider
+}
+
+// change is a single planned mutation of the managed record set.
+type change struct {
+	Action  string `json:"action"`
+	Zone    string `json:"zone"`
+	Suffix  string `json:"suffix"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content,omitempty"`
+	TTL     int    `json:"ttl,omitempty"`
+	// Comment and Proxied are set by tag policies.
+	Comment string `json:"comment,omitempty"`
+	Proxied bool   `json:"proxied,omitempty"`
+	// OldContent and OldTTL are the values an update replaces.
+	OldContent string `json:"old_content,omitempty"`
+	OldTTL     int    `json:"old_ttl,omitempty"`
+	RecordID   string `json:"record_id,omitempty"`
+	// Reason explains why the change was planned.
+	Reason string `json:"reason,omitempty"`
+	// Quarantine marks the update moving a record into quarantine.
+	Quarantine bool `json:"quarantine,omitempty"`
+	// observedAt is the modification time of the record when it was planned.
+	observedAt time.Time
+	// renamed marks the removal of a renamed device's old name, and
+	// renamedFrom is that old name on the create of its new one.
+	renamed     bool
+	renamedFrom string
+}
+
+// fqdn returns the full record name of c.
+func (c change) fqdn() string {
+	return c.Name + "." + c.Suffix
+}
+
+func (c change) String() string {
+	var s string
+	switch c.Action {
+	case actionCreate:
+		s = fmt.Sprintf("+ %s %s %s", c.fqdn(), c.Type, c.Content)
+	case actionUpdate:
+		if c.Content == c.OldContent {
+			s = fmt.Sprintf("~ %s %s %s ttl %d -> %d", c.fqdn(), c.Type, c.Content, c.OldTTL, c.TTL)
+		} else {
+			s = fmt.Sprintf("~ %s %s %s -> %s", c.fqdn(), c.Type, c.OldContent, c.Content)
+		}
+	default:
+		s = fmt.Sprintf("- %s %s %s", c.fqdn(), c.Type, c.Content)
+	}
+	if c.Reason != "" {
+		s += " (" + c.Reason + ")"
+	}
+	return s
+}
+
+// findHost returns the host published as name with type typ, the one with
+// the lowest address when ALL_ADDRESSES publishes several.
+func findHost(hosts map[string]host, typ, name string) (host, bool) {
+	if !multiAddress(typ, name) {
+		h, ok := hosts[recordKey(typ, name, "")]
+		return h, ok
+	}
+	var found host
+	ok := false
+	for _, h := range hosts {
+		if h.Name == name && h.Type == typ && (!ok || h.Content < found.Content) {
+			found, ok = h, true
+		}
+	}
+	return found, ok
+}
+
+// key returns the recordKey of the record c applies to.
+func (c change) key() string {
+	content := c.Content
+	if c.Action == actionDelete || content == "" {
+		content = c.OldContent
+	}
+	return recordKey(c.Type, c.Name, content)
+}
+
+// recordKeys returns the keys the diff engine compares records by, with
+// ALL_ADDRESSES and the round-robin EXIT_NODE_NAME.
+func recordKeys() dnssync.Keys {
+	k := dnssync.Keys{AllAddresses: cfg().AllAddresses}
+	if cfg().ExitNodeName != "" {
+		k.RoundRobin = []string{cfg().ExitNodeName}
+	}
+	return k
+}
+
+// recordKey identifies a managed record within its zone (see
+// dnssync.Keys.Key).
+func recordKey(typ, name, content string) string {
+	return recordKeys().Key(typ, name, content)
+}
+
+// multiAddress reports whether name may have several records of type typ,
+// one per address.
+func multiAddress(typ, name string) bool {
+	return recordKeys().MultiAddress(typ, name)
+}
+
+// currentRecords returns recordKey => record for every record we manage in z.
+func currentRecords(ctx context.Context, z *zone) (map[string]record, error) {
+	out := map[string]record{}
+	err := listPages(ctx, z, func(page []record) error {
+		keepManaged(out, z, page)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// keepManaged adds the records of z's suffix to out by recordKey.
+func keepManaged(out map[string]record, z *zone, records []record) {
+	for _, r := range records {
+		// other suffixes may share the provider zone
+		name, ok := strings.CutSuffix(strings.TrimSuffix(strings.ToLower(r.Name), "."), "."+z.Suffix)
+		if z.funnel != nil {
+			if ok && r.Type == "CNAME" && name+"."+z.Suffix == z.funnel.Name {
+				out[recordKey(r.Type, name, r.Content)] = r
+			}
+			continue
+		}
+		if z.reverse {
+			if ok && name != "" && r.Type == "PTR" {
+				out[recordKey(r.Type, name, r.Content)] = r
+			}
+			continue
+		}
+		if short := wildcardName(name); !ok || short == "" || (r.Type != "SRV" && strings.Contains(short, ".") && !inTagSubdomain(short)) {
+			continue
+		}
+		out[recordKey(r.Type, name, r.Content)] = r
+	}
+}
+
+// plan computes the changes needed to make the provider match the tailnet.
+func plan(z *zone, hosts map[string]host, records map[string]record) []change {
+	created, stale, kept := dnssync.Compare(hosts, records)
+	var changes []change
+	create := func(key, reason string) {
+		if h := hosts[key]; h.Content != "" {
+			changes = append(changes, change{Action: actionCreate, Zone: z.Name, Suffix: z.Suffix, Name: h.Name, Type: h.Type, Content: h.Content, TTL: h.TTL, Comment: h.comment(), Proxied: h.Proxied, Reason: reason})
+		}
+	}
+	remove := func(key, reason string) {
+		r := records[key]
+		name, _, _ := strings.Cut(key, " ")
+		changes = append(changes, change{Action: actionDelete, Zone: z.Name, Suffix: z.Suffix, Name: name, Type: r.Type, Content: r.Content, RecordID: r.ID, observedAt: r.ModifiedOn, Reason: reason})
+	}
+	renames := renamedRecords(hosts, records, created, stale)
+	for _, name := range created {
+		create(name, "new in the tailnet")
+	}
+	for _, key := range stale {
+		if to, ok := renames[key]; ok {
+			// retire the old name now rather than after a quarantine, so
+			// both names never resolve at once
+			remove(key, fmt.Sprintf("device renamed to %s", to))
+			old := &changes[len(changes)-1]
+			old.renamed = true
+			for i, c := range changes {
+				if c.Action == actionCreate && c.Name == to && c.renamedFrom == "" {
+					changes[i].renamedFrom = old.Name
+					changes[i].Reason = fmt.Sprintf("device renamed from %s", old.Name)
+				}
+			}
+			continue
+		}
+		reason := "gone from the tailnet or filtered out"
+		if name, _, _ := strings.Cut(key, " "); syncState.Excluded[name] {
+			reason = "excluded"
+		} else if !nameAllowed(name) {
+			reason = "name filtered out"
+		} else if cfg().OnlineOnly && offlineTooLong(key, time.Now()) {
+			reason = fmt.Sprintf("offline since %s", syncState.Hosts[key].OfflineSince.Format(time.RFC3339))
+		}
+		since, quarantined := quarantinedSince(z, key)
+		if cfg().Quarantine > 0 && (!quarantined || time.Since(since) < cfg().Quarantine) {
+			if !quarantined {
+				changes = append(changes, quarantineChange(z, key, records[key], reason))
+			}
+			continue
+		}
+		if quarantined {
+			reason = fmt.Sprintf("%s, quarantined since %s", reason, since.Format(time.RFC3339))
+		}
+		remove(key, reason)
+	}
+	for _, key := range kept {
+		r, h := records[key], hosts[key]
+		update := change{Action: actionUpdate, Zone: z.Name, Suffix: z.Suffix, Name: h.Name, Type: r.Type,
+			Content: h.Content, OldContent: r.Content, TTL: h.TTL, OldTTL: r.TTL, RecordID: r.ID, observedAt: r.ModifiedOn,
+			Comment: h.comment(), Proxied: h.Proxied}
+		_, quarantined := quarantinedSince(z, key)
+		switch {
+		case h.Content == "":
+		case r.Type != h.Type:
+			// records left over from the other publishing mode are replaced
+			reason := fmt.Sprintf("record type changed from %s to %s", r.Type, h.Type)
+			remove(key, reason)
+			create(key, reason)
+		case quarantined:
+			// the host came back before the quarantine ended
+			update.Reason = "back in the tailnet, leaving quarantine"
+			changes = append(changes, update)
+		case replacedNode(r, h):
+			// the name now belongs to another device, take it over
+			update.Reason = fmt.Sprintf("name reused by node %s, was %s", h.NodeID, commentNodeID(r.Comment))
+			changes = append(changes, update)
+		case r.Content != h.Content:
+			// the node got a new address, the MagicDNS target moved, e.g.
+			// after a tailnet rename, or an SRV target's priority, weight or
+			// port changed
+			update.Reason = "target changed"
+			if r.Type == "A" || r.Type == "AAAA" {
+				update.Reason = "address changed"
+			}
+			changes = append(changes, update)
+		case (len(cfg().DynamicTTL) > 0 || len(cfg().TagPolicies) > 0 || len(cfg().HostPolicies) > 0 || cfg().TTL != 1) && r.TTL != 0 && r.TTL != storedTTL(z, h.TTL):
+			// the host moved to another stability tier or policy, or TTL
+			// changed
+			update.Content = r.Content
+			update.Reason = "TTL tier, tag or host policy changed"
+			changes = append(changes, update)
+		case r.Proxied != h.Proxied:
+			update.Content = r.Content
+			update.Reason = "proxied flag changed"
+			changes = append(changes, update)
+		case r.Comment != "" && r.Comment != h.comment():
+			// providers without comments report none and are left alone,
+			// records without a node ID get one
+			update.Content = r.Content
+			switch {
+			case legacyComment(r.Comment):
+				update.Reason = "migrating the legacy ownership marker"
+			case commentNodeID(r.Comment) == "" && h.NodeID != "":
+				update.Reason = "adding the node ID to the ownership marker"
+			default:
+				update.Reason = "tag policy comment changed"
+			}
+			changes = append(changes, update)
+		}
+	}
+	changes = allowedByPolicy(changes)
+	sortChanges(changes)
+	return changes
+}
+
+// renamedRecords returns the stale records, by key, whose device publishes
+// under a new name among created, mapped to that name. Records carrying a
+// node ID match on it, others on their type and content.
+func renamedRecords(hosts map[string]host, records map[string]record, created, stale []string) map[string]string {
+	sort.Strings(created)
+	sort.Strings(stale)
+	out := map[string]string{}
+	for _, key := range stale {
+		r := records[key]
+		id := commentNodeID(r.Comment)
+		for _, newKey := range created {
+			h := hosts[newKey]
+			if h.NodeID == "" || h.Content == "" || h.Type != r.Type {
+				continue
+			}
+			if id == h.NodeID || id == "" && r.Content == h.Content {
+				out[key] = h.Name
+				break
+			}
+		}
+	}
+	return out
+}
+
+// applyGroup is the name whose changes c is applied and ordered with: the
+// old name for the create of a renamed device.
+func (c change) applyGroup() string {
+	if c.renamedFrom != "" {
+		return c.renamedFrom
+	}
+	return c.Name
+}
+
+func sortChanges(changes []change) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Zone != changes[j].Zone {
+			return changes[i].Zone < changes[j].Zone
+		}
+		if gi, gj := changes[i].applyGroup(), changes[j].applyGroup(); gi != gj {
+			return gi < gj
+		}
+		// deletes go first so a replacement never collides with the old record
+		return changes[i].Action == actionDelete && changes[j].Action != actionDelete
+	})
+}
+
+// logAttrs are the fields logged with c, applied to z.
+func (c change) logAttrs(z *zone) []any {
+	attrs := []any{"host", c.fqdn(), "zone", z.Name, "provider", z.providerLabel(), "action", c.Action, "type", c.Type, "content", c.Content}
+	if c.RecordID != "" {
+		attrs = append(attrs, "record_id", c.RecordID)
+	}
+	return attrs
+}
+
+// apply performs c against the provider and returns it with the record ID
+// filled in for creates.
+func apply(ctx context.Context, z *zone, c change) (change, error) {
+	var err error
+	countAPICall(apiCallMutate)
+	ctx, sp := startSpan(ctx, "provider "+c.Action, "zone", z.Name, "provider", z.providerLabel(), "name", c.fqdn(), "type", c.Type)
+	defer func() { sp.finish(err) }()
+	start := time.Now()
+	switch c.Action {
+	case actionCreate:
+		slog.Info("adding record", c.logAttrs(z)...)
+		if err = z.registry.claim(ctx, z, c); err == nil {
+			c.RecordID, err = createRecord(ctx, z, c)
+		}
+	case actionUpdate:
+		slog.Info("updating record", append(c.logAttrs(z), "old_content", c.OldContent)...)
+		err = z.provider.Update(ctx, c)
+	case actionDelete:
+		slog.Info("removing record", c.logAttrs(z)...)
+		err = z.provider.Delete(ctx, c)
+	}
+	if err != nil {
+		countAPIError(z, apiCallMutate)
+		return c, fmt.Errorf("%s %s: %w", c.Action, c.fqdn(), err)
+	}
+	switch c.Action {
+	case actionCreate:
+		z.registry.created(c)
+	case actionDelete:
+		z.registry.deleted(ctx, z, c)
+	}
+	slog.Info("record "+c.Action+" done", append(c.logAttrs(z), logDuration(start))...)
+	return c, nil
+}
+
+// applyBatched sends changes through the provider's bulk endpoint in chunks
+// of BATCH_SIZE. Each chunk is retried on its own; a chunk that keeps failing
+// falls back to per-record calls because batches are applied atomically and
+// one bad record would otherwise block the rest of the chunk.
+func applyBatched(ctx context.Context, z *zone, b batcher, changes []change) (applied []change, failed []failedChange) {
+	for start := 0; start < len(changes); start += cfg().BatchSize {
+		chunk := changes[start:min(start+cfg().BatchSize, len(changes))]
+		if rateLimited.Load() {
+			for _, c := range chunk {
+				failed = append(failed, failedChange{change: c, Error: errRateLimited.Error() + ", left for the next cycle"})
+			}
+			continue
+		}
+		var (
+			done []change
+			err  error
+		)
+		for attempt := 0; attempt <= cfg().BatchRetries; attempt++ {
+			if attempt == 0 {
+				countAPICall(apiCallMutate)
+			} else {
+				countAPICall(apiCallRetry)
+			}
+			if err = throttle(ctx); err != nil {
+				break
+			}
+			bctx, sp := startSpan(ctx, "provider batch", "zone", z.Name, "provider", z.providerLabel(), "changes", strconv.Itoa(len(chunk)))
+			done, err = b.ApplyBatch(bctx, chunk)
+			sp.finish(err)
+			if err == nil {
+				break
+			}
+			countAPIError(z, apiCallMutate)
+			slog.Warn("batch failed", "zone", z.Name, "changes", len(chunk), "attempt", attempt+1, logErr(err))
+			if errors.Is(err, errRateLimited) {
+				break
+			}
+		}
+		if err == nil {
+			for _, c := range done {
+				slog.Info("record "+c.Action+" done via batch", c.logAttrs(z)...)
+			}
+			applied = append(applied, done...)
+			continue
+		}
+		a, f := applyEach(ctx, z, chunk)
+		applied = append(applied, a...)
+		failed = append(failed, f...)
+	}
+	return applied, failed
+}
+
+// applyEach performs changes one request at a time, on up to CONCURRENCY
+// workers. The changes to one name stay in order on a single worker, so a
+// replacement's delete still goes first, as does a renamed device's.
+func applyEach(ctx context.Context, z *zone, changes []change) (applied []change, failed []failedChange) {
+	var groups [][]int
+	byName := map[string]int{}
+	for i, c := range changes {
+		name := c.applyGroup() + "." + c.Suffix
+		g, ok := byName[name]
+		if !ok {
+			g = len(groups)
+			byName[name] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	done := make([]change, len(changes))
+	errs := make([]error, len(changes))
+	runWorkers(len(groups), cfg().Concurrency, func(g int) {
+		defer func() {
+			if r := recover(); r != nil {
+				err := recoverPanic(ctx, "zone "+z.Name, r)
+				for _, i := range groups[g] {
+					if done[i].Action == "" && errs[i] == nil {
+						done[i], errs[i] = changes[i], err
+					}
+				}
+			}
+		}()
+		for _, i := range groups[g] {
+			if rateLimited.Load() {
+				done[i], errs[i] = changes[i], fmt.Errorf("%w, left for the next cycle", errRateLimited)
+				continue
+			}
+			if err := throttle(ctx); err != nil {
+				done[i], errs[i] = changes[i], err
+				continue
+			}
+			done[i], errs[i] = apply(ctx, z, changes[i])
+			if errs[i] != nil {
+				slog.Error("change failed", append(done[i].logAttrs(z), logErr(errs[i]))...)
+			}
+		}
+	})
+	for i, c := range done {
+		if errs[i] != nil {
+			failed = append(failed, failedChange{change: c, Error: errs[i].Error()})
+			continue
+		}
+		applied = append(applied, c)
+	}
+	return applied, failed
+}
+
+// createRecord creates the record of c or, with ADOPT_UNMARKED, takes over
+// an unmarked record of its name and type already there.
+func createRecord(ctx context.Context, z *zone, c change) (string, error) {
+	if a, ok := z.provider.(adopter); ok && cfg().AdoptUnmarked {
+		id, err := a.Adopt(ctx, c)
+		if err != nil {
+			return "", err
+		}
+		if id != "" {
+			slog.Info("adopted unmarked record", append(c.logAttrs(z), "record_id", id)...)
+			return id, nil
+		}
+	}
+	return z.provider.Create(ctx, c)
+}
+
+// applyChanges performs changes against the provider, in batches when
+// BATCH_SIZE is set, and returns the applied and failed ones.
+func applyChanges(ctx context.Context, z *zone, changes []change) (applied []change, failed []failedChange) {
+	// adopting takes a lookup per create, which batches don't have
+	if b, ok := z.provider.(batcher); ok && cfg().BatchSize > 0 && z.registry == nil && !cfg().AdoptUnmarked {
+		return applyBatched(ctx, z, b, changes)
+	}
+	return applyEach(ctx, z, changes)
+}
+
+// paceDeletes keeps at most max deletions (0 means unlimited) and returns the
+// deferred ones separately, so a bad filter removes records gradually over
+// several cycles instead of all at once. A renamed device's old name is
+// always removed with its new one created.
+func paceDeletes(changes []change, max int) (kept, deferred []change) {
+	if max <= 0 {
+		return changes, nil
+	}
+	deletes := 0
+	for _, c := range changes {
+		if c.Action == actionDelete && !c.renamed {
+			if deletes >= max {
+				deferred = append(deferred, c)
+				continue
+			}
+			deletes++
+		}
+		kept = append(kept, c)
+	}
+	return kept, deferred
+}
+
+// reportDrift exports the pending changes as metrics and notifies once per
+// distinct drift, so a stable mismatch doesn't notify on every cycle.
+func reportDrift(ctx context.Context, changes []change) {
+	lines := make([]string, 0, len(changes))
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	fingerprint := strings.Join(lines, "\n")
+	if fingerprint == syncState.LastDrift {
+		return
+	}
+	syncState.LastDrift = fingerprint
+	if len(changes) == 0 {
+		slog.Info("drift resolved")
+		notify(ctx, event{Type: "drift_resolved", Message: "provider matches the tailnet"})
+		return
+	}
+	for _, c := range changes {
+		slog.Warn("drift", "zone", c.Zone, "host", c.fqdn(), "action", c.Action, "change", c.String())
+	}
+	notify(ctx, event{
+		Type:    "drift",
+		Message: fmt.Sprintf("%d record(s) differ from the tailnet", len(changes)),
+		Changes: changes,
+	})
+}
+
+// detectTailnetRename logs and notifies when the tailnet's MagicDNS suffix
+// changed since the last cycle; plan re-homes the CNAME records pointing at
+// the old suffix with in-place updates instead of deleting and recreating them.
+func detectTailnetRename(ctx context.Context, st *ipnstate.Status) {
+	if st.CurrentTailnet == nil || st.CurrentTailnet.MagicDNSSuffix == "" {
+		return
+	}
+	suffix := st.CurrentTailnet.MagicDNSSuffix
+	old := syncState.TailnetSuffix
+	syncState.TailnetSuffix = suffix
+	if old == "" || old == suffix {
+		return
+	}
+	slog.Warn("tailnet renamed, re-homing managed records", "from", old, "to", suffix)
+	notify(ctx, event{
+		Type:    "tailnet_renamed",
+		Message: fmt.Sprintf("tailnet renamed from %s to %s", old, suffix),
+	})
+}
+
+// replacedNode reports whether r was published for another device than the
+// one now holding its name, e.g. a reinstalled machine reusing a hostname.
+func replacedNode(r record, h host) bool {
+	id := commentNodeID(r.Comment)
+	return id != "" && h.NodeID != "" && id != h.NodeID
+}
+
+// replacedNodes describes the records of z whose name moved to another device.
+func replacedNodes(hosts map[string]host, records map[string]record) []string {
+	var out []string
+	for key, r := range records {
+		if h, ok := hosts[key]; ok && replacedNode(r, h) {
+			out = append(out, fmt.Sprintf("%s moved from node %s to %s", key, commentNodeID(r.Comment), h.NodeID))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// externalChanges compares the listed records of z with the ones cached at the
+// end of the previous cycle. Anything that differs was changed by someone
+// else, since our own changes are written to the cache as they are applied.
+func externalChanges(z *zone, records map[string]record) []string {
+	cached, ok := syncState.Records[z.Name]
+	if !ok {
+		return nil
+	}
+	var out []string
+	for name, c := range cached {
+		r, ok := records[name]
+		switch {
+		case !ok:
+			out = append(out, fmt.Sprintf("%s was deleted", name))
+		case r.ID != c.ID:
+			out = append(out, fmt.Sprintf("%s was replaced", name))
+		case r.Content != c.Content || (c.Type != "" && r.Type != c.Type):
+			out = append(out, fmt.Sprintf("%s was changed from %s %s to %s %s", name, c.Type, c.Content, r.Type, r.Content))
+		case !c.ModifiedOn.IsZero() && r.ModifiedOn.After(c.ModifiedOn):
+			out = append(out, fmt.Sprintf("%s was modified at %s", name, r.ModifiedOn.Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// zoneRecords returns every record desired in z keyed by recordKey: the
+// hosts' address records plus the zone's SRV records. Addresses outside the
+// zone's allowed ranges are left out. A reverse zone gets the PTR records of
+// the addresses inside it.
+func zoneRecords(st *ipnstate.Status, z *zone, hosts map[string]host) map[string]host {
+	if z.reverse {
+		return ptrHosts(z, hosts)
+	}
+	if z.funnel != nil {
+		return funnelHosts(st, z)
+	}
+	allowed := make(map[string]host, len(hosts))
+	for key, h := range hosts {
+		if publishable(z.Name, h) {
+			allowed[key] = h
+		}
+	}
+	applyZoneSettings(z.Name, allowed)
+	out := desiredServices(st, z, allowed)
+	for key, h := range registeredServices(z, allowed) {
+		out[key] = h
+	}
+	for key, h := range serveRecords(st, z, allowed) {
+		out[key] = h
+	}
+	for key, h := range allowed {
+		out[key] = h
+	}
+	return out
+}
+
+// zoneResult is the outcome of reconciling a single zone.
+type zoneResult struct {
+	zone     *zone
+	records  map[string]record
+	planned  []change
+	applied  []change
+	failed   []failedChange
+	deferred []change
+	stale    []change
+	external []string
+	// resumed is set when changes came from a checkpoint, without listing.
+	resumed bool
+	// unchanged is set when the zone wasn't listed because the records
+	// wanted in it are the same as when it was last found in sync.
+	unchanged bool
+	// audit lists the discrepancies a full audit found with the state.
+	audit     []string
+	durations map[string]float64
+	err       error
+}
+
+// reconcileZone lists, plans and applies the changes for z under its own
+// deadline, so one slow or failing zone doesn't hold up the others. A full
+// audit lists the zone completely and checks it against the state first.
+func reconcileZone(ctx context.Context, z *zone, hosts map[string]host, full bool) *zoneResult {
+	ctx, cancel := context.WithTimeout(ctx, cfg().ZoneTimeout)
+	defer cancel()
+	res := &zoneResult{zone: z, durations: map[string]float64{}}
+	timed := func(name string, fn func()) {
+		start := time.Now()
+		fn()
+		res.durations[name+":"+z.Name] = time.Since(start).Seconds()
+	}
+	// the registry needs a listing to know the TXT records
+	if cp, ok := resumeCheckpoint(z); ok && applying() && !full && z.registry == nil {
+		// apply what the interrupted run left without listing and planning
+		// again; the next cycle plans the zone in full
+		slog.Info("resuming from the checkpoint", "zone", z.Name, "changes", len(cp.Changes), "checkpoint", cp.Created.Format(time.RFC3339))
+		res.resumed, res.planned = true, cp.Changes
+		changes, deferred, release := budgetChanges(z, cp.Changes)
+		defer release()
+		res.deferred = deferred
+		timed("apply", func() { res.applied, res.failed = applyCheckpointed(ctx, z, changes, cp.Created) })
+		return res
+	}
+	sum, listed := desiredFingerprint(hosts), time.Now()
+	if !full && unchanged(z, sum, listed) {
+		slog.Debug("records wanted unchanged since the zone was found in sync, not listing it", "zone", z.Name)
+		res.unchanged = true
+		return res
+	}
+	if full {
+		timed("list", func() { res.records, res.err = listAll(ctx, z) })
+	} else {
+		timed("list", func() { res.records, res.err = currentRecords(ctx, z) })
+	}
+	if res.err != nil {
+		res.err = fmt.Errorf("list %s: %w", z.Name, res.err)
+		return res
+	}
+	if applying() {
+		z.registry.adoptRecords(ctx, z)
+		z.registry.removeOrphans(ctx, z)
+	}
+	if full {
+		res.audit = stateDiscrepancies(z, res.records)
+	}
+	// raise external edits before the plan below heals them
+	if res.external = externalChanges(z, res.records); len(res.external) > 0 {
+		for _, msg := range res.external {
+			slog.Warn("external change detected", "zone", z.Name, "change", msg)
+		}
+		metricExternalChanges.Add(float64(len(res.external)), "zone", z.Name)
+		notify(ctx, event{
+			Type:    "external_change",
+			Message: fmt.Sprintf("%s: %d managed record(s) changed outside of the sync: %s", z.Name, len(res.external), strings.Join(res.external, "; ")),
+		})
+	}
+	if replaced := replacedNodes(hosts, res.records); len(replaced) > 0 {
+		for _, msg := range replaced {
+			slog.Warn("name reused by another device", "zone", z.Name, "change", msg)
+		}
+		notify(ctx, event{
+			Type:    "node_replaced",
+			Message: fmt.Sprintf("%s: %d name(s) reused by another device: %s", z.Name, len(replaced), strings.Join(replaced, "; ")),
+		})
+	}
+	res.planned = plan(z, hosts, res.records)
+	rememberFingerprint(z, sum, len(res.planned) == 0, listed)
+	checkCapacity(ctx, z, res.planned)
+	counts := map[string]int{actionCreate: 0, actionUpdate: 0, actionDelete: 0}
+	for _, c := range res.planned {
+		counts[c.Action]++
+	}
+	for action, n := range counts {
+		metricDrift.Set(float64(n), "zone", z.Name, "action", action)
+	}
+	if !applying() {
+		return res
+	}
+	var changes []change
+	changes, res.deferred = paceDeletes(res.planned, cfg().MaxDeletes)
+	metricDeferredDeletes.Set(float64(len(res.deferred)), "zone", z.Name)
+	if len(res.deferred) > 0 {
+		slog.Warn("deletion limit reached, deferring deletions to the next cycle", "zone", z.Name, "limit", cfg().MaxDeletes, "deferred", len(res.deferred))
+	}
+	if len(changes) == 0 {
+		slog.Info("no host need to sync", "zone", z.Name)
+		return res
+	}
+	timed("verify", func() { changes, res.stale = verifyObserved(ctx, z, changes) })
+	changes, overBudget, release := budgetChanges(z, changes)
+	defer release()
+	res.deferred = append(res.deferred, overBudget...)
+	timed("apply", func() { res.applied, res.failed = applyCheckpointed(ctx, z, changes, time.Time{}) })
+	return res
+}
+
+// cacheRecords replaces the state cache of z with the records observed at the provider.
+func cacheRecords(z *zone, records map[string]record) {
+	cached := make(map[string]stateRecord, len(records))
+	for name, r := range records {
+		cached[name] = stateRecord{ID: r.ID, Type: r.Type, Content: r.Content, ModifiedOn: r.ModifiedOn}
+	}
+	syncState.Records[z.Name] = cached
+}
+
+// reconcile runs one sync cycle and returns its report.
+func reconcile(ctx context.Context) (report *runReport) {
+	report = newRunReport()
+	beginAPICycle()
+	ctx, cycleSpan := startSpan(ctx, "sync", "mode", cfg().Mode)
+	// the cycle's calls give up after SYNC_TIMEOUT, wrapping it up in the
+	// deferred func below still has base
+	base := ctx
+	ctx, cancel := context.WithTimeout(ctx, cfg().SyncTimeout)
+	defer cancel()
+	defer watchStuckCycle()()
+	syncState.Frozen = currentFreeze()
+	if report.Annotation = annotationFrom(ctx); report.Annotation != nil {
+		slog.Info("sync start", "annotation", report.Annotation.String())
+	} else {
+		slog.Info("sync start")
+	}
+	rotateSecrets(ctx)
+	defer func() {
+		if r := recover(); r != nil {
+			report.fail(recoverPanic(base, "sync", r))
+		}
+		report.APICalls = cycleAPICalls()
+		report.write(base)
+		var spanErr error
+		if report.Result == "error" {
+			spanErr = errors.New(report.Error)
+		}
+		cycleSpan.finish(spanErr)
+		flushSpans(base)
+		notifyFailure(base, report)
+		countRun(report)
+		recordCycle(report)
+		sdCycle(report)
+		saveState(base)
+		writeStatus(report)
+		noteReport(report)
+		emitStatsD(report)
+		endCycle()
+	}()
+	var (
+		st  *ipnstate.Status
+		err error
+	)
+	report.phase("status", func() {
+		ctx, sp := startSpan(ctx, "tailnet status", "source", cfg().Source)
+		st, err = cycleStatus(ctx)
+		sp.finish(err)
+	})
+	if err != nil {
+		slog.Error("get status failed", "source", cfg().Source, logErr(err))
+		report.fail(err)
+		report.tailnetFailed = true
+		return
+	}
+	hosts := desiredHosts(st)
+	if err := mergeFileHosts(ctx, hosts); err != nil {
+		slog.Error("file source failed", logErr(err))
+		report.fail(err)
+		return
+	}
+	mergeRegistrations(st, hosts)
+	readServe(ctx)
+	trackStability(hosts, time.Now())
+	applyTagPolicies(hosts)
+	updateReverseMap(hosts, time.Now())
+	detectTailnetRename(ctx, st)
+	checkCredentials(ctx, st)
+	full := fullAuditDue(time.Now())
+	if full {
+		slog.Info("running the full audit")
+	}
+	results := make([]*zoneResult, len(zones))
+	wants := make([]map[string]host, len(zones))
+	for i, z := range zones {
+		wants[i] = zoneRecords(st, z, hosts)
+	}
+	var wg sync.WaitGroup
+	for i, z := range zones {
+		wg.Add(1)
+		go func(i int, z *zone) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					results[i] = &zoneResult{zone: z, err: recoverPanic(ctx, "zone "+z.Name, r)}
+				}
+			}()
+			ctx, sp := startSpan(ctx, "zone", "zone", z.Name, "provider", z.providerLabel())
+			results[i] = reconcileZone(ctx, z, wants[i], full)
+			sp.finish(results[i].err)
+		}(i, z)
+	}
+	wg.Wait()
+	var entries []auditEntry
+	for _, res := range results {
+		for k, v := range res.durations {
+			report.Durations[k] = v
+		}
+		setHealth("zone "+res.zone.Name, res.err)
+		if res.err != nil {
+			slog.Error("zone failed", "zone", res.zone.Name, logErr(res.err))
+			report.zoneError(res.zone, res.err)
+			continue
+		}
+		previous := syncState.Records[res.zone.Name]
+		if !res.resumed && !res.unchanged {
+			cacheRecords(res.zone, res.records)
+		} else if previous == nil {
+			syncState.Records[res.zone.Name] = map[string]stateRecord{}
+		}
+		report.Planned = append(report.Planned, res.planned...)
+		report.Applied = append(report.Applied, res.applied...)
+		report.Failed = append(report.Failed, res.failed...)
+		report.Deferred = append(report.Deferred, res.deferred...)
+		report.Stale = append(report.Stale, res.stale...)
+		cached := syncState.Records[res.zone.Name]
+		for _, c := range res.applied {
+			entries = append(entries, newAuditEntry(c, ""))
+			switch c.Action {
+			case actionCreate, actionUpdate:
+				// the provider's modification time is picked up on the next listing
+				cached[c.key()] = stateRecord{ID: c.RecordID, Type: c.Type, Content: c.Content}
+			case actionDelete:
+				delete(cached, c.key())
+			}
+		}
+		for _, f := range res.failed {
+			entries = append(entries, newAuditEntry(f.change, f.Error))
+		}
+		trackQuarantine(res.zone, res.applied, res.records, time.Now())
+		verifyPropagation(base, res.zone, res.applied)
+		if !res.resumed && !res.unchanged {
+			markFresh(res, previous, time.Now())
+		}
+	}
+	exportFreshness()
+	wanted := make(map[string]map[string]host, len(zones))
+	for i, z := range zones {
+		wanted[z.Name] = wants[i]
+	}
+	noteAdminRecords(st, wanted)
+	if applying() && len(report.ZoneErrors) == 0 && len(report.Failed) == 0 && len(report.Deferred) == 0 {
+		snap := make(map[*zone]map[string]host, len(zones))
+		for i, z := range zones {
+			snap[z] = wants[i]
+		}
+		takeSnapshot(ctx, snap, time.Now())
+	}
+	noteHistory(entries)
+	writeAudit(ctx, entries)
+	if len(report.Applied) > 0 {
+		notify(ctx, event{
+			Type:    "records_changed",
+			Message: fmt.Sprintf("%d record(s) changed", len(report.Applied)),
+			Changes: report.Applied,
+		})
+	}
+	if full {
+		reportFullAudit(ctx, results)
+	}
+	seedSecondaries(ctx, st, hosts)
+	if len(report.ZoneErrors) == len(zones) {
+		// nothing was listed, so there is no drift to report
+		return
+	}
+	// what the cycle just applied is no longer drift
+	reportDrift(ctx, report.unapplied())
+	if cfg().Mode == SyncModeMonitor {
+		slog.Info("sync end", "mode", cfg().Mode, "not_applied", len(report.Planned), logDuration(report.Start))
+		return
+	}
+	if f := currentFreeze(); f != nil {
+		slog.Info("sync end", "freeze", f.String(), "not_applied", len(report.Planned), logDuration(report.Start))
+		return
+	}
+	slog.Info("sync end", "planned", len(report.Planned), "applied", len(report.Applied), "failed", len(report.Failed), logDuration(report.Start))
+	return
+}
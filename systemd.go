@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var sdReadyOnce sync.Once
+
+// sdNotify sends state to systemd's NOTIFY_SOCKET, when running as a
+// Type=notify service.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	if addr[0] == '@' {
+		// abstract socket
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		slog.Warn("sd_notify failed", logErr(err))
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Warn("sd_notify failed", logErr(err))
+	}
+}
+
+// sdReady tells systemd the service is up, once.
+func sdReady() {
+	sdReadyOnce.Do(func() { sdNotify("READY=1") })
+}
+
+// sdCycle reports the cycle r to systemd: the first successful one makes
+// the service ready, and each one updates its status line.
+func sdCycle(r *runReport) {
+	if r.Result != "error" {
+		sdReady()
+	}
+	sdNotify(fmt.Sprintf("STATUS=last sync %s at %s, %d change(s) applied, %d failed", r.Result, r.End.Format(time.RFC3339), len(r.Applied), len(r.Failed)))
+}
+
+// sdWatchdog pings systemd's watchdog at half of WatchdogSec while
+// /healthz passes, so systemd restarts the service once syncs stop
+// completing.
+func sdWatchdog(ctx context.Context) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	go func() {
+		t := time.NewTicker(time.Duration(usec) * time.Microsecond / 2)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if h := checkHealth(ctx, false); h.OK {
+					sdNotify("WATCHDOG=1")
+				} else {
+					slog.Warn("unhealthy, not pinging the systemd watchdog", "problems", strings.Join(h.Problems, "; "))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
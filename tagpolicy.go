@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// tagPolicy sets the DNS attributes of the records of hosts carrying Tag.
+// The first policy matching one of a host's tags applies.
+type tagPolicy struct {
+	Tag string `yaml:"tag"`
+	// Comment is a text/template rendered with the host (.Name, .Content,
+	// .Tags) and appended to the ownership marker.
+	Comment string `yaml:"comment"`
+	// TTL overrides the automatic or DYNAMIC_TTL TTL when set.
+	TTL     int   `yaml:"ttl"`
+	Proxied *bool `yaml:"proxied"`
+
+	tmpl *template.Template
+}
+
+func (p *tagPolicy) validate(provider string) error {
+	if !strings.HasPrefix(p.Tag, "tag:") {
+		return fmt.Errorf("tag_policies: tag %q must start with tag:", p.Tag)
+	}
+	if p.TTL != 0 && p.TTL != 1 && (p.TTL < 60 || p.TTL > 86400) {
+		return fmt.Errorf("tag_policies: %s: ttl %d must be 1 (automatic) or between 60 and 86400", p.Tag, p.TTL)
+	}
+	if p.Proxied != nil && provider != "cloudflare" {
+		return fmt.Errorf("tag_policies: %s: proxied is only supported by the cloudflare provider", p.Tag)
+	}
+	tmpl, err := template.New(p.Tag).Option("missingkey=error").Parse(p.Comment)
+	if err != nil {
+		return fmt.Errorf("tag_policies: %s: comment: %w", p.Tag, err)
+	}
+	p.tmpl = tmpl
+	return nil
+}
+
+// policyFor returns the policy for a host with tags, or nil.
+func policyFor(tags []string) *tagPolicy {
+	for i := range cfg().TagPolicies {
+		p := &cfg().TagPolicies[i]
+		for _, t := range tags {
+			if t == p.Tag {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// hostPolicy sets the TTL and proxied flag of the records of the host
+// published as Name, over the tag policy of the host.
+type hostPolicy struct {
+	Name    string `yaml:"name"`
+	TTL     int    `yaml:"ttl"`
+	Proxied *bool  `yaml:"proxied"`
+}
+
+func (p hostPolicy) validate(provider string) error {
+	if !labelRe.MatchString(p.Name) {
+		return fmt.Errorf("host_policies: name %q is not a valid DNS label", p.Name)
+	}
+	if p.TTL != 0 && p.TTL != 1 && (p.TTL < 60 || p.TTL > 86400) {
+		return fmt.Errorf("host_policies: %s: ttl %d must be 1 (automatic) or between 60 and 86400", p.Name, p.TTL)
+	}
+	if p.Proxied != nil && provider != "cloudflare" {
+		return fmt.Errorf("host_policies: %s: proxied is only supported by the cloudflare provider", p.Name)
+	}
+	return nil
+}
+
+// hostPolicyFor returns the policy for the host named name, or nil. Copies
+// in tag subdomains go by the name of the host.
+func hostPolicyFor(name string) *hostPolicy {
+	short, _, _ := strings.Cut(name, ".")
+	for i := range cfg().HostPolicies {
+		if cfg().HostPolicies[i].Name == short {
+			return &cfg().HostPolicies[i]
+		}
+	}
+	return nil
+}
+
+// tagSubdomain publishes the hosts carrying Tag a second time as
+// name.Subdomain, e.g. laptop.prod.int.example.com.
+type tagSubdomain struct {
+	Tag       string `yaml:"tag"`
+	Subdomain string `yaml:"subdomain"`
+}
+
+// parseTagSubdomains parses "tag:prod=prod,tag:k8s=k8s".
+func parseTagSubdomains(v string) ([]tagSubdomain, error) {
+	var out []tagSubdomain
+	for _, part := range strings.Split(v, ",") {
+		tag, sub, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag subdomain %q, want tag=subdomain", part)
+		}
+		out = append(out, tagSubdomain{Tag: tag, Subdomain: sub})
+	}
+	return out, nil
+}
+
+func (s tagSubdomain) validate() error {
+	if !strings.HasPrefix(s.Tag, "tag:") {
+		return fmt.Errorf("tag_subdomains: tag %q must start with tag:", s.Tag)
+	}
+	for _, label := range strings.Split(s.Subdomain, ".") {
+		if !labelRe.MatchString(label) {
+			return fmt.Errorf("tag_subdomains: %s: %q is not a valid subdomain", s.Tag, s.Subdomain)
+		}
+	}
+	return nil
+}
+
+// inTagSubdomain reports whether the record name lies in one of the
+// TAG_SUBDOMAINS, so it's managed despite the dot.
+func inTagSubdomain(name string) bool {
+	_, sub, ok := strings.Cut(name, ".")
+	return ok && slices.ContainsFunc(cfg().TagSubdomains, func(s tagSubdomain) bool { return s.Subdomain == sub })
+}
+
+// addTagSubdomains copies the records of every host carrying a tag of
+// TAG_SUBDOMAINS into its subdomain. A host losing the tag loses the copy.
+func addTagSubdomains(hosts map[string]host) {
+	var copies []host
+	for _, h := range hosts {
+		if h.Type == "SRV" {
+			continue
+		}
+		for _, s := range cfg().TagSubdomains {
+			if slices.Contains(h.Tags, s.Tag) {
+				c := h
+				c.Name = h.Name + "." + s.Subdomain
+				copies = append(copies, c)
+			}
+		}
+	}
+	for _, c := range copies {
+		key := recordKey(c.Type, c.Name, c.Content)
+		if _, ok := hosts[key]; !ok {
+			hosts[key] = c
+		}
+	}
+}
+
+// wildcardName returns name without the leading "*." of a wildcard record.
+func wildcardName(name string) string {
+	return strings.TrimPrefix(name, "*.")
+}
+
+// addWildcards adds a *.name copy of the records of the hosts WILDCARD or
+// WILDCARD_TAGS select, e.g. for a reverse proxy serving many virtual hosts.
+func addWildcards(hosts map[string]host) {
+	if !cfg().Wildcard && len(cfg().WildcardTags) == 0 {
+		return
+	}
+	var copies []host
+	for _, h := range hosts {
+		if h.Type == "SRV" || !cfg().Wildcard && !slices.ContainsFunc(h.Tags, func(t string) bool { return slices.Contains(cfg().WildcardTags, t) }) {
+			continue
+		}
+		c := h
+		c.Name = "*." + h.Name
+		copies = append(copies, c)
+	}
+	for _, c := range copies {
+		key := recordKey(c.Type, c.Name, c.Content)
+		if _, ok := hosts[key]; !ok {
+			hosts[key] = c
+		}
+	}
+}
+
+// tagsAllowed reports whether a node with tags passes INCLUDE_TAGS and
+// EXCLUDE_TAGS.
+func tagsAllowed(tags []string) bool {
+	return tagsMatch(tags, cfg().IncludeTags, cfg().ExcludeTags)
+}
+
+// tagsMatch reports whether tags has none of exclude and, unless include is
+// empty, one of include.
+func tagsMatch(tags, include, exclude []string) bool {
+	for _, t := range tags {
+		if slices.Contains(exclude, t) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, t := range tags {
+		if slices.Contains(include, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTagPolicies sets the comment, TTL and proxied flag of every host from
+// the policy of its tags, then the TTL and proxied flag from its host
+// policy. Hosts without a tag policy keep the bare marker.
+func applyTagPolicies(hosts map[string]host) {
+	if len(cfg().TagPolicies) == 0 && len(cfg().HostPolicies) == 0 {
+		return
+	}
+	for name, h := range hosts {
+		p, hp := policyFor(h.Tags), hostPolicyFor(h.Name)
+		if p == nil && hp == nil {
+			continue
+		}
+		if p != nil && p.Comment != "" {
+			var b bytes.Buffer
+			if err := p.tmpl.Execute(&b, h); err != nil {
+				slog.Warn("tag policy comment template failed", "host", name, "tag", p.Tag, logErr(err))
+			} else {
+				h.Comment = b.String()
+			}
+		}
+		if p != nil && p.TTL != 0 {
+			h.TTL = p.TTL
+		}
+		if p != nil && p.Proxied != nil {
+			h.Proxied = *p.Proxied
+		}
+		if hp != nil && hp.TTL != 0 {
+			h.TTL = hp.TTL
+		}
+		if hp != nil && hp.Proxied != nil {
+			h.Proxied = *hp.Proxied
+		}
+		if h.Proxied {
+			// Cloudflare forces automatic TTLs on proxied records
+			h.TTL = 1
+		}
+		hosts[name] = h
+	}
+}
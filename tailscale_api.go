@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/clientcredentials"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+)
+
+const tailscaleAPIURL = "https://api.tailscale.com"
+
+var (
+	tsAPIOnce   sync.Once
+	tsAPIClient *http.Client
+)
+
+func init() {
+	// the OAuth client holds TAILSCALE_OAUTH_CLIENT_SECRET
+	secretResets = append(secretResets, func() { tsAPIOnce = sync.Once{} })
+}
+
+// apiDevice is the part of a device in the Admin API we use.
+type apiDevice struct {
+	NodeID    string    `json:"nodeId"`
+	Name      string    `json:"name"`
+	Hostname  string    `json:"hostname"`
+	Addresses []string  `json:"addresses"`
+	Tags      []string  `json:"tags"`
+	LastSeen  time.Time `json:"lastSeen"`
+	OS        string    `json:"os"`
+	Version   string    `json:"clientVersion"`
+	// ConnectedToControl is only reported by newer API versions.
+	ConnectedToControl *bool `json:"connectedToControl"`
+}
+
+// tailscaleAPI returns a client authenticated with TAILSCALE_OAUTH_CLIENT_ID
+// and TAILSCALE_OAUTH_CLIENT_SECRET, or TAILSCALE_API_KEY. OAuth clients are
+// preferred as their tokens don't expire with a person's key.
+func tailscaleAPI() *http.Client {
+	tsAPIOnce.Do(func() {
+		if id := os.Getenv("TAILSCALE_OAUTH_CLIENT_ID"); id != "" {
+			cc := clientcredentials.Config{
+				ClientID:     id,
+				ClientSecret: secretEnv("TAILSCALE_OAUTH_CLIENT_SECRET"),
+				TokenURL:     tailscaleAPIURL + "/api/v2/oauth/token",
+			}
+			tsAPIClient = cc.Client(context.Background())
+			return
+		}
+		tsAPIClient = http.DefaultClient
+	})
+	return tsAPIClient
+}
+
+// apiGet decodes the Admin API resource at path into out.
+func apiGet(ctx context.Context, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tailscaleAPIURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if os.Getenv("TAILSCALE_OAUTH_CLIENT_ID") == "" {
+		key := secretEnv("TAILSCALE_API_KEY")
+		if key == "" {
+			return errors.New("set TAILSCALE_API_KEY or TAILSCALE_OAUTH_CLIENT_ID and TAILSCALE_OAUTH_CLIENT_SECRET")
+		}
+		req.SetBasicAuth(key, "")
+	}
+	resp, err := tailscaleAPI().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// apiDevices lists the devices of TAILSCALE_TAILNET.
+func apiDevices(ctx context.Context) ([]apiDevice, error) {
+	var out struct {
+		Devices []apiDevice `json:"devices"`
+	}
+	if err := apiGet(ctx, fmt.Sprintf("/api/v2/tailnet/%s/devices?fields=all", url.PathEscape(cfg().Tailnet)), &out); err != nil {
+		return nil, fmt.Errorf("list devices: %w", err)
+	}
+	return out.Devices, nil
+}
+
+// apiKeyExpiry returns when TAILSCALE_API_KEY expires. OAuth clients don't
+// expire and report the zero time.
+func apiKeyExpiry(ctx context.Context) (time.Time, error) {
+	key := secretEnv("TAILSCALE_API_KEY")
+	if os.Getenv("TAILSCALE_OAUTH_CLIENT_ID") != "" || key == "" {
+		return time.Time{}, nil
+	}
+	// keys look like tskey-api-<id>-<secret>
+	id, _, _ := strings.Cut(strings.TrimPrefix(key, "tskey-api-"), "-")
+	var out struct {
+		Expires time.Time `json:"expires"`
+	}
+	if err := apiGet(ctx, fmt.Sprintf("/api/v2/tailnet/%s/keys/%s", url.PathEscape(cfg().Tailnet), url.PathEscape(id)), &out); err != nil {
+		return time.Time{}, fmt.Errorf("get API key: %w", err)
+	}
+	return out.Expires, nil
+}
+
+// apiStatus builds a tailscaled-style status from the Admin API.
+func apiStatus(ctx context.Context) (*ipnstate.Status, error) {
+	devices, err := apiDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	st := &ipnstate.Status{Self: &ipnstate.PeerStatus{}, Peer: map[key.NodePublic]*ipnstate.PeerStatus{}}
+	for _, d := range devices {
+		ps := &ipnstate.PeerStatus{
+			ID:       tailcfg.StableNodeID(d.NodeID),
+			HostName: d.Hostname,
+			DNSName:  d.Name + ".",
+			LastSeen: d.LastSeen,
+			OS:       d.OS,
+			// the API only tells when a device was last seen
+			Online: time.Since(d.LastSeen) < 5*time.Minute,
+		}
+		if d.ConnectedToControl != nil {
+			ps.Online = *d.ConnectedToControl
+		}
+		for _, a := range d.Addresses {
+			if ip, err := netip.ParseAddr(a); err == nil {
+				ps.TailscaleIPs = append(ps.TailscaleIPs, ip)
+			}
+		}
+		if len(d.Tags) > 0 {
+			tags := views.SliceOf(d.Tags)
+			ps.Tags = &tags
+		}
+		if _, suffix, ok := strings.Cut(d.Name, "."); ok && st.CurrentTailnet == nil {
+			st.CurrentTailnet = &ipnstate.TailnetStatus{MagicDNSSuffix: suffix}
+		}
+		storeNodeVersion(ps.ID, d.Version)
+		// peers are keyed by node key in tailscaled, any unique key will do
+		st.Peer[key.NewNode().Public()] = ps
+	}
+	return st, nil
+}
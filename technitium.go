@@ -0,0 +1,217 @@
+//go:build !no_technitium
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errTechnitiumNoZone is returned for zones the server doesn't host.
+var errTechnitiumNoZone = errors.New("no such zone")
+
+func init() {
+	registerProvider("technitium", newTechnitiumProvider)
+}
+
+// technitiumProvider manages the records of one primary zone of a
+// Technitium DNS Server through its HTTP API. Records carry comments, which
+// hold the ownership marker like Cloudflare's.
+type technitiumProvider struct {
+	url   string
+	token string
+	zone  string
+}
+
+// technitiumRData is the data of the record types the sync publishes.
+type technitiumRData struct {
+	IPAddress string `json:"ipAddress"`
+	CNAME     string `json:"cname"`
+	Priority  int    `json:"priority"`
+	Weight    int    `json:"weight"`
+	Port      int    `json:"port"`
+	Target    string `json:"target"`
+}
+
+func newTechnitiumProvider(ctx context.Context, name string) (provider, error) {
+	base := strings.TrimSuffix(os.Getenv("TECHNITIUM_URL"), "/")
+	if base == "" {
+		return nil, fmt.Errorf("set TECHNITIUM_URL, e.g. http://dns:5380")
+	}
+	token := secretEnv("TECHNITIUM_TOKEN_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name)))
+	if token == "" {
+		token = secretEnv("TECHNITIUM_TOKEN")
+	}
+	p := &technitiumProvider{url: base + "/api/zones/records/", token: token, zone: name}
+	if _, err := p.records(ctx); errors.Is(err, errTechnitiumNoZone) {
+		return nil, fmt.Errorf("%w: %s", errZoneNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("get zone %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// call runs the records API method with params and decodes the response
+// into out.
+func (p *technitiumProvider) call(ctx context.Context, method string, params url.Values, out any) error {
+	params.Set("token", p.token)
+	params.Set("zone", p.zone)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	var res struct {
+		Status       string          `json:"status"`
+		ErrorMessage string          `json:"errorMessage"`
+		Response     json.RawMessage `json:"response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("decode answer: %w", err)
+	}
+	switch {
+	case res.Status == "ok":
+	case strings.HasPrefix(res.ErrorMessage, "No such zone"):
+		return errTechnitiumNoZone
+	case res.Status == "invalid-token":
+		return fmt.Errorf("invalid token, check TECHNITIUM_TOKEN")
+	default:
+		return fmt.Errorf("%s: %s", res.Status, res.ErrorMessage)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(res.Response, out)
+}
+
+type technitiumRecord struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	TTL      int             `json:"ttl"`
+	Disabled bool            `json:"disabled"`
+	Comments string          `json:"comments"`
+	RData    technitiumRData `json:"rData"`
+}
+
+// records returns every record of the zone.
+func (p *technitiumProvider) records(ctx context.Context) ([]technitiumRecord, error) {
+	var res struct {
+		Records []technitiumRecord `json:"records"`
+	}
+	err := p.call(ctx, "get", url.Values{"domain": {p.zone}, "listZone": {"true"}}, &res)
+	return res.Records, err
+}
+
+// technitiumContent returns the record content of r in the sync's format.
+func technitiumContent(r technitiumRecord) (string, bool) {
+	switch r.Type {
+	case "A", "AAAA":
+		return r.RData.IPAddress, true
+	case "CNAME":
+		return strings.TrimSuffix(r.RData.CNAME, "."), true
+	case "SRV":
+		return fmt.Sprintf("%d %d %d %s", r.RData.Priority, r.RData.Weight, r.RData.Port, strings.TrimSuffix(r.RData.Target, ".")), true
+	}
+	return "", false
+}
+
+func (p *technitiumProvider) List(ctx context.Context) ([]record, error) {
+	records, err := p.records(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list records: %w", err)
+	}
+	var out []record
+	for _, r := range records {
+		if !ownedComment(r.Comments) {
+			continue
+		}
+		content, ok := technitiumContent(r)
+		if !ok {
+			continue
+		}
+		out = append(out, record{ID: r.Name + " " + r.Type + " " + content, Name: r.Name, Type: r.Type, Content: content, TTL: r.TTL, Comment: r.Comments})
+	}
+	return out, nil
+}
+
+// technitiumParams sets the record data parameters for content as typ, with
+// their names prefixed by prefix ("new" for the replacement of an update).
+func technitiumParams(params url.Values, prefix, typ, content string) error {
+	name := func(s string) string {
+		if prefix == "" {
+			return s
+		}
+		return prefix + strings.ToUpper(s[:1]) + s[1:]
+	}
+	switch typ {
+	case "A", "AAAA":
+		params.Set(name("ipAddress"), content)
+	case "CNAME":
+		params.Set("cname", content)
+	case "SRV":
+		f := strings.Fields(content)
+		if len(f) != 4 {
+			return fmt.Errorf("malformed SRV content %q", content)
+		}
+		for i, key := range []string{"priority", "weight", "port"} {
+			if _, err := strconv.Atoi(f[i]); err != nil {
+				return fmt.Errorf("malformed SRV content %q", content)
+			}
+			params.Set(name(key), f[i])
+		}
+		params.Set(name("target"), f[3])
+	default:
+		return fmt.Errorf("technitium: unsupported record type %s", typ)
+	}
+	return nil
+}
+
+func (p *technitiumProvider) StoredTTL(ttl int) int {
+	if ttl <= 1 {
+		// Technitium has no automatic TTL
+		return 300
+	}
+	return ttl
+}
+
+func (p *technitiumProvider) Create(ctx context.Context, c change) (string, error) {
+	params := url.Values{"domain": {c.fqdn()}, "type": {c.Type}, "ttl": {strconv.Itoa(p.StoredTTL(c.TTL))}, "comments": {c.Comment}}
+	if err := technitiumParams(params, "", c.Type, c.Content); err != nil {
+		return "", err
+	}
+	return c.fqdn() + " " + c.Type + " " + c.Content, p.call(ctx, "add", params, nil)
+}
+
+func (p *technitiumProvider) Update(ctx context.Context, c change) error {
+	params := url.Values{"domain": {c.fqdn()}, "type": {c.Type}, "ttl": {strconv.Itoa(p.StoredTTL(c.TTL))}, "comments": {c.Comment}}
+	if err := technitiumParams(params, "", c.Type, c.OldContent); err != nil {
+		return err
+	}
+	if err := technitiumParams(params, "new", c.Type, c.Content); err != nil {
+		return err
+	}
+	return p.call(ctx, "update", params, nil)
+}
+
+func (p *technitiumProvider) Delete(ctx context.Context, c change) error {
+	params := url.Values{"domain": {c.fqdn()}, "type": {c.Type}}
+	if err := technitiumParams(params, "", c.Type, c.Content); err != nil {
+		return err
+	}
+	return p.call(ctx, "delete", params, nil)
+}
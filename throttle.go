@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	throttleMu sync.Mutex
+	// throttleNext is the earliest time the next provider request may start.
+	throttleNext time.Time
+)
+
+// throttle waits for the next slot REQUESTS_PER_SECOND allows, shared by the
+// zones and workers of this process.
+func throttle(ctx context.Context) error {
+	if cfg().RequestsPerSecond <= 0 {
+		return nil
+	}
+	throttleMu.Lock()
+	at := time.Now()
+	if throttleNext.After(at) {
+		at = throttleNext
+	}
+	throttleNext = at.Add(time.Second / time.Duration(cfg().RequestsPerSecond))
+	throttleMu.Unlock()
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runWorkers calls fn for 0..n-1 on up to workers goroutines and waits for
+// them.
+func runWorkers(n, workers int, fn func(i int)) {
+	if workers <= 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
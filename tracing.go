@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// span is one timed operation of a sync cycle, exported to OTLP_ENDPOINT.
+type span struct {
+	traceID, spanID, parentID string
+	name                      string
+	start, end                time.Time
+	attrs                     []string
+	err                       error
+}
+
+type spanKey struct{}
+
+var (
+	spansMu sync.Mutex
+	// spans are the finished spans waiting for flushSpans.
+	spans []*span
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startSpan starts a span called name as a child of the one in ctx, if any;
+// attrs are "key", "value" pairs. It's a no-op without OTLP_ENDPOINT.
+func startSpan(ctx context.Context, name string, attrs ...string) (context.Context, *span) {
+	if cfg().OTLPEndpoint == "" {
+		return ctx, nil
+	}
+	s := &span{spanID: randomHex(8), name: name, start: time.Now(), attrs: attrs}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.traceID, s.parentID = parent.traceID, parent.spanID
+	} else {
+		s.traceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// finish ends s with err as its status and queues it for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	spansMu.Lock()
+	spans = append(spans, s)
+	spansMu.Unlock()
+}
+
+// flushSpans sends the queued spans to OTLP_ENDPOINT as OTLP/HTTP JSON, with
+// the headers of OTEL_EXPORTER_OTLP_HEADERS (e.g. "x-api-key=secret").
+func flushSpans(ctx context.Context) {
+	spansMu.Lock()
+	batch := spans
+	spans = nil
+	spansMu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	out := make([]map[string]any, 0, len(batch))
+	for _, s := range batch {
+		o := map[string]any{
+			"traceId":           s.traceID,
+			"spanId":            s.spanID,
+			"name":              s.name,
+			"kind":              1,
+			"startTimeUnixNano": fmt.Sprint(s.start.UnixNano()),
+			"endTimeUnixNano":   fmt.Sprint(s.end.UnixNano()),
+			"attributes":        otlpAttributes(s.attrs...),
+			"status":            map[string]any{"code": 1},
+		}
+		if s.parentID != "" {
+			o["parentSpanId"] = s.parentID
+		}
+		if s.err != nil {
+			o["status"] = map[string]any{"code": 2, "message": s.err.Error()}
+		}
+		out = append(out, o)
+	}
+	host, _ := os.Hostname()
+	payload := map[string]any{"resourceSpans": []any{map[string]any{
+		"resource": map[string]any{"attributes": otlpAttributes(
+			"service.name", cfg().OTLPServiceName,
+			"service.instance.id", instanceID(),
+			"host.name", host,
+		)},
+		"scopeSpans": []any{map[string]any{
+			"scope": map[string]any{"name": "tailscale-dns-sync"},
+			"spans": out,
+		}},
+	}}}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Warn("otlp export failed", logErr(err))
+		return
+	}
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(cctx, http.MethodPost, cfg().OTLPEndpoint, bytes.NewReader(body))
+	if err != nil {
+		slog.Warn("otlp export failed", logErr(err))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for _, h := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(h, "="); ok {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	if err := doRequest(req); err != nil {
+		slog.Warn("otlp export failed", "spans", len(batch), logErr(err))
+	}
+}
+
+// otlpAttributes renders "key", "value" pairs as OTLP string attributes.
+func otlpAttributes(kv ...string) []map[string]any {
+	out := make([]map[string]any, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		out = append(out, map[string]any{"key": kv[i], "value": map[string]any{"stringValue": kv[i+1]}})
+	}
+	return out
+}
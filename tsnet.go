@@ -0,0 +1,55 @@
+//go:build tsnet
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/tsnet"
+)
+
+func init() {
+	startEmbeddedNode = startTSNet
+}
+
+// startTSNet joins the tailnet as TSNET_HOSTNAME with its own userspace
+// tailscaled and waits until it's up, so the sync needs none on the host.
+// The node's state is kept in TSNET_STATE_DIR; the auth key is only needed
+// the first time.
+func startTSNet(ctx context.Context) (*tailscale.LocalClient, error) {
+	authKey := os.Getenv("TS_AUTHKEY")
+	if path := os.Getenv("TS_AUTHKEY_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		authKey = strings.TrimSpace(string(b))
+	}
+	srv := &tsnet.Server{
+		Hostname: cfg().TSNetHostname,
+		Dir:      cfg().TSNetStateDir,
+		AuthKey:  authKey,
+		Logf:     tsnetLogf,
+	}
+	slog.Info("joining the tailnet", "hostname", cfg().TSNetHostname)
+	st, err := srv.Up(ctx)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+	slog.Info("joined the tailnet", "name", st.Self.DNSName, "addresses", fmt.Sprint(st.TailscaleIPs))
+	onShutdown(func() { srv.Close() })
+	return srv.LocalClient()
+}
+
+// tsnetLogf passes on what the embedded node logs about logging in, and
+// everything with TSNET_VERBOSE.
+func tsnetLogf(format string, args ...any) {
+	if os.Getenv("TSNET_VERBOSE") != "" || strings.Contains(format, "TS_AUTHKEY") || strings.Contains(format, "Authkey") {
+		slog.Info("tsnet: " + fmt.Sprintf(format, args...))
+	}
+}
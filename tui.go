@@ -0,0 +1,292 @@
+//go:build !no_tui
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func init() {
+	registerCommand("tui", runTUI)
+}
+
+// runTUI implements `tailscale-dns-sync tui`, an interactive view of the
+// tailnet, the published records and the pending changes. It syncs in
+// process, so it shouldn't run next to a daemon writing the same zones.
+func runTUI(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	var err error
+	if zones, err = openZones(ctx); err != nil {
+		return err
+	}
+	if err := loadState(ctx); err != nil {
+		return err
+	}
+	// log lines would tear the screen, show the latest ones instead
+	logs := &logTail{max: 8}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(&plainHandler{mu: new(sync.Mutex), w: logs, level: logLevel}))
+	_, err = tea.NewProgram(&tuiModel{ctx: ctx, logs: logs, work: new(sync.Mutex)}, tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+	if err == tea.ErrProgramKilled {
+		return nil
+	}
+	return err
+}
+
+// logTail keeps the last max lines written to it.
+type logTail struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func (l *logTail) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.lines = append(l.lines, line)
+	}
+	if len(l.lines) > l.max {
+		l.lines = l.lines[len(l.lines)-l.max:]
+	}
+	return len(p), nil
+}
+
+func (l *logTail) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+type (
+	tuiTickMsg    struct{}
+	tuiRefreshMsg struct {
+		rows    []tuiRow
+		pending []change
+		err     error
+	}
+	tuiSyncedMsg struct{ report *runReport }
+)
+
+// tuiRow is one tailnet host with its record in every zone.
+type tuiRow struct {
+	host     host
+	excluded bool
+	// records holds "zone => content" of the published records.
+	records map[string]string
+}
+
+type tuiModel struct {
+	ctx  context.Context
+	logs *logTail
+	// work serializes the commands touching syncState, which run on
+	// goroutines of their own: refreshes, syncs and exclusion toggles.
+	work    *sync.Mutex
+	rows    []tuiRow
+	pending []change
+	errors  []string
+	cursor  int
+	syncing bool
+	updated time.Time
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return m.refresh
+}
+
+// refresh reads the tailnet and plans every zone without applying anything.
+func (m *tuiModel) refresh() tea.Msg {
+	m.work.Lock()
+	defer m.work.Unlock()
+	return m.refreshLocked()
+}
+
+func (m *tuiModel) refreshLocked() tea.Msg {
+	st, err := fetchStatus(m.ctx)
+	if err != nil {
+		return tuiRefreshMsg{err: fmt.Errorf("tailscale status: %w", err)}
+	}
+	hosts := desiredHosts(st)
+	if err := mergeFileHosts(m.ctx, hosts); err != nil {
+		return tuiRefreshMsg{err: err}
+	}
+	applyTagPolicies(hosts)
+	rows := map[string]*tuiRow{}
+	for name, h := range hosts {
+		rows[name] = &tuiRow{host: h, records: map[string]string{}}
+	}
+	for name := range syncState.Excluded {
+		rows[name] = &tuiRow{host: host{Name: name}, excluded: true, records: map[string]string{}}
+	}
+	var pending []change
+	for _, z := range zones {
+		records, err := currentRecords(m.ctx, z)
+		if err != nil {
+			return tuiRefreshMsg{err: fmt.Errorf("list %s: %w", z.Name, err)}
+		}
+		for key, r := range records {
+			if row, ok := rows[key]; ok {
+				row.records[z.Name] = r.Content
+			}
+		}
+		pending = append(pending, plan(z, zoneRecords(st, z, hosts), records)...)
+	}
+	out := make([]tuiRow, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, *row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].host.Name < out[j].host.Name })
+	return tuiRefreshMsg{rows: out, pending: pending}
+}
+
+// sync runs a cycle, applying the pending changes.
+func (m *tuiModel) sync() tea.Msg {
+	m.work.Lock()
+	defer m.work.Unlock()
+	return tuiSyncedMsg{report: reconcile(m.ctx)}
+}
+
+// toggleExcluded excludes the host name or includes it again, between
+// refreshes and syncs, and refreshes the view.
+func (m *tuiModel) toggleExcluded(name string) tea.Cmd {
+	return func() tea.Msg {
+		m.work.Lock()
+		defer m.work.Unlock()
+		if syncState.Excluded == nil {
+			syncState.Excluded = map[string]bool{}
+		}
+		if syncState.Excluded[name] {
+			delete(syncState.Excluded, name)
+		} else {
+			syncState.Excluded[name] = true
+		}
+		saveState(m.ctx)
+		return m.refreshLocked()
+	}
+}
+
+func (m *tuiModel) tick() tea.Cmd {
+	return tea.Tick(cfg().SyncInterval, func(time.Time) tea.Msg { return tuiTickMsg{} })
+}
+
+func (m *tuiModel) addError(err string) {
+	m.errors = append(m.errors, time.Now().Format("15:04:05")+" "+err)
+	if len(m.errors) > 5 {
+		m.errors = m.errors[len(m.errors)-5:]
+	}
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c":
+			return m, tea.Quit
+		case "up", "k":
+			m.cursor = max(m.cursor-1, 0)
+		case "down", "j":
+			m.cursor = min(m.cursor+1, len(m.rows)-1)
+		case "r":
+			return m, m.refresh
+		case "s":
+			if m.syncing {
+				return m, nil
+			}
+			m.syncing = true
+			return m, m.sync
+		case "x":
+			if m.cursor >= len(m.rows) {
+				return m, nil
+			}
+			return m, m.toggleExcluded(m.rows[m.cursor].host.Name)
+		}
+	case tuiTickMsg:
+		return m, tea.Batch(m.refresh, m.tick())
+	case tuiRefreshMsg:
+		first := m.updated.IsZero()
+		m.updated = time.Now()
+		if msg.err != nil {
+			m.addError(msg.err.Error())
+		} else {
+			m.rows, m.pending = msg.rows, msg.pending
+			m.cursor = min(m.cursor, max(len(m.rows)-1, 0))
+		}
+		if first {
+			return m, m.tick()
+		}
+	case tuiSyncedMsg:
+		m.syncing = false
+		r := msg.report
+		if r.Error != "" {
+			m.addError(r.Error)
+		}
+		for _, f := range r.Failed {
+			m.addError(fmt.Sprintf("%s: %s", f.change, f.Error))
+		}
+		return m, m.refresh
+	}
+	return m, nil
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	status := "idle"
+	if m.syncing {
+		status = "syncing..."
+	}
+	suffixes := make([]string, 0, len(zones))
+	for _, z := range zones {
+		suffixes = append(suffixes, z.Suffix)
+	}
+	fmt.Fprintf(&b, "tailscale-dns-sync  %s -> %s  mode %s  %s  (updated %s)\n\n",
+		cfg().Provider, strings.Join(suffixes, ", "), cfg().Mode, status, m.updated.Format("15:04:05"))
+	fmt.Fprintf(&b, "  %-24s %-7s %-40s %s\n", "HOST", "ONLINE", "CONTENT", "PUBLISHED")
+	for i, row := range m.rows {
+		cursor := " "
+		if i == m.cursor {
+			cursor = ">"
+		}
+		online := "no"
+		if row.host.Online {
+			online = "yes"
+		}
+		published := make([]string, 0, len(zones))
+		for _, z := range zones {
+			if content, ok := row.records[z.Name]; ok && content == row.host.Content {
+				published = append(published, z.Name)
+			}
+		}
+		content := row.host.Content
+		if row.excluded {
+			online, content = "-", "(excluded)"
+		}
+		fmt.Fprintf(&b, "%s %-24s %-7s %-40s %s\n", cursor, row.host.Name, online, content, strings.Join(published, " "))
+	}
+	fmt.Fprintf(&b, "\npending changes (%d)\n", len(m.pending))
+	for _, c := range m.pending {
+		fmt.Fprintf(&b, "  %s\n", c)
+	}
+	if len(m.errors) > 0 {
+		b.WriteString("\nrecent errors\n")
+		for _, e := range m.errors {
+			fmt.Fprintf(&b, "  %s\n", e)
+		}
+	}
+	fmt.Fprintf(&b, "\nlog\n%s\n", m.logs)
+	b.WriteString("\n[s] sync now  [x] exclude/include host  [r] refresh  [q] quit\n")
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// zoneFingerprint is what the last cycle that found a zone in sync wanted
+// in it, and when that cycle listed it.
+type zoneFingerprint struct {
+	sum    string
+	listed time.Time
+}
+
+var (
+	fingerprintsMu sync.Mutex
+	// zoneFingerprints are kept in memory only, so a restart lists every
+	// zone again.
+	zoneFingerprints = map[string]zoneFingerprint{}
+)
+
+// desiredFingerprint hashes the records wanted in a zone.
+func desiredFingerprint(hosts map[string]host) string {
+	keys := make([]string, 0, len(hosts))
+	for k := range hosts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s %+v\n", k, hosts[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// unchanged reports whether z may skip its listing: SKIP_UNCHANGED is set,
+// the zone was found in sync with the same wanted records less than that
+// long ago, and no quarantine is running out in it.
+func unchanged(z *zone, sum string, now time.Time) bool {
+	if cfg().SkipUnchanged <= 0 || len(syncState.Quarantined[z.Name]) > 0 {
+		return false
+	}
+	fingerprintsMu.Lock()
+	defer fingerprintsMu.Unlock()
+	fp, ok := zoneFingerprints[z.Name]
+	return ok && fp.sum == sum && now.Sub(fp.listed) < cfg().SkipUnchanged
+}
+
+// rememberFingerprint notes sum for z when the listing at now found nothing
+// to change, and forgets the zone's fingerprint otherwise.
+func rememberFingerprint(z *zone, sum string, inSync bool, now time.Time) {
+	fingerprintsMu.Lock()
+	defer fingerprintsMu.Unlock()
+	if inSync {
+		zoneFingerprints[z.Name] = zoneFingerprint{sum: sum, listed: now}
+	} else {
+		delete(zoneFingerprints, z.Name)
+	}
+}
+
+// forgetFingerprints makes the next cycle list every zone, e.g. after the
+// config changed what plan does with the same records.
+func forgetFingerprints() {
+	fingerprintsMu.Lock()
+	defer fingerprintsMu.Unlock()
+	zoneFingerprints = map[string]zoneFingerprint{}
+}
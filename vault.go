@@ -0,0 +1,373 @@
+//go:build !no_vault
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// vaultRef is where VAULT_SECRETS reads a secret from: the field of the
+// secret at path, e.g. secret/data/dns#token.
+type vaultRef struct {
+	path, field string
+}
+
+// vaultLease is the secret read at a path, with its lease when it has one.
+type vaultLease struct {
+	data      map[string]any
+	id        string
+	renewable bool
+	// due is when the secret is renewed or read again.
+	due time.Time
+}
+
+// vaultState is the Vault token and the secrets read with it.
+type vaultState struct {
+	mu      sync.Mutex
+	refs    map[string]vaultRef
+	leases  map[string]*vaultLease
+	values  map[string]string
+	loaded  bool
+	token   string
+	renew   bool
+	expires time.Time
+}
+
+var vault vaultState
+
+func init() {
+	if os.Getenv("VAULT_SECRETS") == "" {
+		return
+	}
+	vaultSecret = vault.secret
+	vaultRotated = vault.rotated
+}
+
+// vaultRefresh is how often secrets without a lease, like those of the KV
+// engine, are read again.
+func vaultRefresh() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("VAULT_REFRESH")); err == nil && d > 0 {
+		return d
+	}
+	return 5 * time.Minute
+}
+
+// parseVaultSecrets parses VAULT_SECRETS, `NAME=path#field,...`.
+func parseVaultSecrets(s string) (map[string]vaultRef, error) {
+	refs := map[string]vaultRef{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, ref, ok := strings.Cut(item, "=")
+		path, field, ok2 := strings.Cut(ref, "#")
+		path = strings.Trim(path, "/")
+		if !ok || !ok2 || name == "" || path == "" || field == "" {
+			return nil, fmt.Errorf("VAULT_SECRETS: %q is not NAME=path#field", item)
+		}
+		if strings.HasPrefix(name, "VAULT_") {
+			return nil, fmt.Errorf("VAULT_SECRETS: %s can't come from Vault", name)
+		}
+		refs[name] = vaultRef{path: path, field: field}
+	}
+	return refs, nil
+}
+
+// secret returns the secret VAULT_SECRETS maps name to, logging in and
+// reading them all the first time. It's empty while Vault can't be read.
+func (v *vaultState) secret(name string) (string, bool) {
+	if strings.HasPrefix(name, "VAULT_") {
+		// Vault's own secrets, read while logging in
+		return "", false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.loaded {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := v.load(ctx); err != nil {
+			slog.Error("reading the secrets from Vault failed", logErr(err))
+		}
+	}
+	if v.refs == nil {
+		return "", false
+	}
+	_, ok := v.refs[name]
+	return v.values[name], ok
+}
+
+func (v *vaultState) load(ctx context.Context) error {
+	if v.refs == nil {
+		refs, err := parseVaultSecrets(os.Getenv("VAULT_SECRETS"))
+		if err != nil {
+			return err
+		}
+		v.refs = refs
+		v.leases = map[string]*vaultLease{}
+	}
+	if err := v.ensureToken(ctx); err != nil {
+		return err
+	}
+	values := map[string]string{}
+	for name, ref := range v.refs {
+		l, ok := v.leases[ref.path]
+		if !ok {
+			var err error
+			if l, err = v.read(ctx, ref.path); err != nil {
+				return err
+			}
+			v.leases[ref.path] = l
+		}
+		val, err := vaultField(l.data, ref)
+		if err != nil {
+			return err
+		}
+		values[name] = val
+	}
+	v.values = values
+	v.loaded = true
+	slog.Info("read the secrets from Vault", "secrets", len(values))
+	return nil
+}
+
+// rotated renews the token and the leases that are due, reading again the
+// secrets whose lease can't be renewed, and reports whether a secret
+// changed.
+func (v *vaultState) rotated(ctx context.Context) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if !v.loaded {
+		// the first use of a secret reads them; a failed read is tried again
+		// here, and the zones opened without them reopened once it works
+		if v.refs == nil || v.load(ctx) != nil {
+			return false
+		}
+		return true
+	}
+	if err := v.ensureToken(ctx); err != nil {
+		slog.Error("renewing the Vault token failed", logErr(err))
+		return false
+	}
+	now := time.Now()
+	for path, l := range v.leases {
+		if now.Before(l.due) {
+			continue
+		}
+		if l.renewable && l.id != "" {
+			err := v.renewLease(ctx, l)
+			if err == nil {
+				continue
+			}
+			slog.Warn("renewing the Vault lease failed, reading the secret again", "path", path, logErr(err))
+		}
+		fresh, err := v.read(ctx, path)
+		if err != nil {
+			slog.Error("reading the secret from Vault failed", "path", path, logErr(err))
+			continue
+		}
+		v.leases[path] = fresh
+	}
+	changed := false
+	for name, ref := range v.refs {
+		val, err := vaultField(v.leases[ref.path].data, ref)
+		if err != nil {
+			slog.Error("reading the secret from Vault failed", "variable", name, logErr(err))
+			continue
+		}
+		if val != v.values[name] {
+			slog.Info("secret in Vault changed", "variable", name, "path", ref.path)
+			v.values[name] = val
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ensureToken logs in when there's no token yet, and renews it once two
+// thirds of its TTL have passed, logging in again when that fails.
+func (v *vaultState) ensureToken(ctx context.Context) error {
+	if v.token != "" && (v.expires.IsZero() || time.Now().Before(v.expires)) {
+		return nil
+	}
+	if v.token != "" && v.renew {
+		var resp vaultResponse
+		err := v.do(ctx, http.MethodPost, "auth/token/renew-self", map[string]any{}, &resp)
+		if err == nil && resp.Auth != nil {
+			v.setToken(resp.Auth)
+			return nil
+		}
+		slog.Warn("renewing the Vault token failed, logging in again", logErr(err))
+	}
+	return v.login(ctx)
+}
+
+// login gets a token with VAULT_AUTH_METHOD: `token` uses VAULT_TOKEN as it
+// is, `kubernetes` the pod's service account token with VAULT_ROLE, and
+// `approle` VAULT_ROLE_ID and VAULT_SECRET_ID.
+func (v *vaultState) login(ctx context.Context) error {
+	method := envString("VAULT_AUTH_METHOD", "token")
+	var body map[string]any
+	switch method {
+	case "token":
+		v.token = secretEnv("VAULT_TOKEN")
+		if v.token == "" {
+			return fmt.Errorf("VAULT_TOKEN is required with VAULT_AUTH_METHOD=token")
+		}
+		// look the token up for its TTL, so it's renewed in time
+		var resp vaultResponse
+		if err := v.do(ctx, http.MethodGet, "auth/token/lookup-self", nil, &resp); err != nil {
+			v.token = ""
+			return err
+		}
+		var info struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		}
+		json.Unmarshal(resp.Data, &info)
+		v.setToken(&vaultAuth{ClientToken: v.token, LeaseDuration: info.TTL, Renewable: info.Renewable})
+		return nil
+	case "kubernetes":
+		jwt, err := os.ReadFile(kubeServiceAccountDir + "/token")
+		if err != nil {
+			return err
+		}
+		body = map[string]any{"role": os.Getenv("VAULT_ROLE"), "jwt": strings.TrimSpace(string(jwt))}
+	case "approle":
+		body = map[string]any{"role_id": os.Getenv("VAULT_ROLE_ID"), "secret_id": secretEnv("VAULT_SECRET_ID")}
+	default:
+		return fmt.Errorf("VAULT_AUTH_METHOD: unknown method %q", method)
+	}
+	v.token = ""
+	var resp vaultResponse
+	if err := v.do(ctx, http.MethodPost, "auth/"+envString("VAULT_AUTH_MOUNT", method)+"/login", body, &resp); err != nil {
+		return fmt.Errorf("vault login with %s: %w", method, err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return fmt.Errorf("vault login with %s: no token returned", method)
+	}
+	v.setToken(resp.Auth)
+	slog.Info("logged in to Vault", "method", method, "ttl", time.Duration(resp.Auth.LeaseDuration)*time.Second)
+	return nil
+}
+
+func (v *vaultState) setToken(a *vaultAuth) {
+	v.token, v.renew = a.ClientToken, a.Renewable
+	v.expires = time.Time{}
+	if a.LeaseDuration > 0 {
+		v.expires = time.Now().Add(time.Duration(a.LeaseDuration) * time.Second * 2 / 3)
+	}
+}
+
+// read reads the secret at path.
+func (v *vaultState) read(ctx context.Context, path string) (*vaultLease, error) {
+	var resp vaultResponse
+	if err := v.do(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, err
+	}
+	l := &vaultLease{id: resp.LeaseID, renewable: resp.Renewable}
+	if err := json.Unmarshal(resp.Data, &l.data); err != nil {
+		return nil, fmt.Errorf("vault %s: %w", path, err)
+	}
+	// KV version 2 nests the secret under data, next to its metadata
+	if inner, ok := l.data["data"].(map[string]any); ok {
+		if _, ok := l.data["metadata"]; ok {
+			l.data = inner
+		}
+	}
+	l.due = vaultDue(resp.LeaseID, resp.LeaseDuration)
+	return l, nil
+}
+
+func (v *vaultState) renewLease(ctx context.Context, l *vaultLease) error {
+	var resp vaultResponse
+	if err := v.do(ctx, http.MethodPut, "sys/leases/renew", map[string]any{"lease_id": l.id}, &resp); err != nil {
+		return err
+	}
+	l.due = vaultDue(resp.LeaseID, resp.LeaseDuration)
+	return nil
+}
+
+// vaultDue is when a secret read with the lease is renewed: after two
+// thirds of it, or after VAULT_REFRESH for secrets without one. KV version
+// 1 returns a lease duration without an ID, which is only a hint.
+func vaultDue(id string, seconds int) time.Time {
+	d := vaultRefresh()
+	if id != "" && seconds > 0 {
+		d = time.Duration(seconds) * time.Second * 2 / 3
+	}
+	return time.Now().Add(d)
+}
+
+func vaultField(data map[string]any, ref vaultRef) (string, error) {
+	val, ok := data[ref.field]
+	if !ok {
+		return "", fmt.Errorf("vault %s has no field %s", ref.path, ref.field)
+	}
+	if s, ok := val.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(val), nil
+}
+
+type vaultAuth struct {
+	ClientToken   string `json:"client_token"`
+	LeaseDuration int    `json:"lease_duration"`
+	Renewable     bool   `json:"renewable"`
+}
+
+type vaultResponse struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration int             `json:"lease_duration"`
+	Renewable     bool            `json:"renewable"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *vaultAuth      `json:"auth"`
+}
+
+// do sends in as JSON to the Vault API at VAULT_ADDR, with the token and
+// VAULT_NAMESPACE, and decodes the response into out.
+func (v *vaultState) do(ctx context.Context, method, path string, in, out any) error {
+	addr := strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/")
+	if addr == "" {
+		return fmt.Errorf("VAULT_ADDR is required with VAULT_SECRETS")
+	}
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, addr+"/v1/"+path, body)
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+)
+
+// via6Host is a LAN host behind a subnet router that advertises its site
+// with a 4via6 route. It is published as an AAAA record for the 4via6
+// address, which stays unique when several sites use the same IPv4 subnet.
+type via6Host struct {
+	Name    string `yaml:"name"`
+	Site    uint32 `yaml:"site"`
+	Address string `yaml:"address"`
+}
+
+// parseVia6Hosts parses VIA6_HOSTS, "name=site:ipv4,...".
+func parseVia6Hosts(v string) ([]via6Host, error) {
+	var out []via6Host
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		name, rest, ok := strings.Cut(part, "=")
+		site, addr, ok2 := strings.Cut(rest, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid 4via6 host %q, want name=site:ipv4", part)
+		}
+		n, err := strconv.ParseUint(site, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid 4via6 host %q: %w", part, err)
+		}
+		out = append(out, via6Host{Name: name, Site: uint32(n), Address: addr})
+	}
+	return out, nil
+}
+
+func (h via6Host) validate() error {
+	if h.Name == "" || strings.Contains(h.Name, ".") {
+		return fmt.Errorf("via6_hosts: name %q must be a single label", h.Name)
+	}
+	if _, err := h.viaAddr(); err != nil {
+		return fmt.Errorf("via6_hosts: %s: %w", h.Name, err)
+	}
+	return nil
+}
+
+// viaAddr returns the 4via6 address of h.
+func (h via6Host) viaAddr() (netip.Addr, error) {
+	addr, err := netip.ParseAddr(h.Address)
+	if err != nil || !addr.Is4() {
+		return netip.Addr{}, fmt.Errorf("address %q is not an IPv4 address", h.Address)
+	}
+	via, err := tsaddr.MapVia(h.Site, netip.PrefixFrom(addr, 32))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return via.Addr(), nil
+}
+
+// addVia6Hosts adds the configured 4via6 hosts whose address is inside a
+// 4via6 route some peer is the primary router for. The record follows the
+// router's online state. Tailnet nodes of the same name win.
+func addVia6Hosts(st *ipnstate.Status, hosts map[string]host) {
+	if len(cfg().Via6Hosts) == 0 {
+		return
+	}
+	peers := []*ipnstate.PeerStatus{st.Self}
+	for _, ps := range st.Peer {
+		peers = append(peers, ps)
+	}
+	for _, vh := range cfg().Via6Hosts {
+		if _, ok := findHost(hosts, "AAAA", vh.Name); ok || syncState.Excluded[vh.Name] {
+			continue
+		}
+		// validated when the config is loaded
+		addr, _ := vh.viaAddr()
+		key := recordKey("AAAA", vh.Name, addr.String())
+		for _, ps := range peers {
+			if routesVia(ps, addr) {
+				hosts[key] = host{Name: vh.Name, Type: "AAAA", Content: addr.String(), Online: ps.Online || ps == st.Self, TTL: cfg().TTL, Proxied: cfg().Proxied}
+				break
+			}
+		}
+	}
+}
+
+// routesVia reports whether ps is the primary router of a 4via6 route
+// containing addr.
+func routesVia(ps *ipnstate.PeerStatus, addr netip.Addr) bool {
+	if ps == nil || ps.PrimaryRoutes == nil {
+		return false
+	}
+	for i := 0; i < ps.PrimaryRoutes.Len(); i++ {
+		if r := ps.PrimaryRoutes.At(i); tsaddr.IsViaPrefix(r) && r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+)
+
+func init() {
+	registerCommand("wait", runWait)
+}
+
+// runWait implements `tailscale-dns-sync wait`: it polls the state file until
+// the running daemon has completed a successful sync, for ordering other
+// services after it, and fails after -timeout.
+func runWait(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	timeout := fs.Duration("timeout", 5*time.Minute, "give up after this long")
+	maxAge := fs.Duration("max-age", 5*time.Minute, "ignore successes older than this")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	if cfg().StatePath == "" {
+		return fmt.Errorf("wait reads the daemon's state file, set STATE_PATH")
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		if err := loadState(ctx); err != nil {
+			slog.Warn("load the state failed", logErr(err))
+		} else if s := syncState; !s.LastSuccess.IsZero() && !s.LastSuccess.Before(s.Started) && time.Since(s.LastSuccess) <= *maxAge {
+			slog.Info("synced", "at", s.LastSuccess.Format(time.RFC3339))
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if s := syncState; s.LastError != "" {
+				return fmt.Errorf("no successful sync within %s, last error: %s", *timeout, s.LastError)
+			}
+			return fmt.Errorf("no successful sync within %s", *timeout)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const wakeHeartbeat = 5 * time.Second
+
+// watchWake sends the observed gap whenever the wall clock moved much further
+// than the heartbeat between two ticks. Go's monotonic clock stops while the
+// host is suspended, so comparing wall-clock readings is what reveals a
+// resume from sleep (or a stepped clock).
+func watchWake(ctx context.Context, threshold time.Duration) <-chan time.Duration {
+	ch := make(chan time.Duration, 1)
+	if threshold <= 0 {
+		return ch
+	}
+	go func() {
+		ticker := time.NewTicker(wakeHeartbeat)
+		defer ticker.Stop()
+		// Round(0) strips the monotonic reading so Sub uses the wall clock
+		last := time.Now().Round(0)
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now().Round(0)
+				gap := now.Sub(last) - wakeHeartbeat
+				last = now
+				if gap > threshold || gap < -threshold {
+					select {
+					case ch <- gap:
+					default:
+					}
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
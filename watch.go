@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/types/netmap"
+)
+
+var (
+	// watching is set while the IPN bus subscription is up, which
+	// stretches the sync interval to WATCH_FALLBACK_INTERVAL.
+	watching atomic.Bool
+
+	metricNetmapChanges = newMetric("counter", "netmap_changes_total", "Tailnet changes seen on the IPN bus that triggered a sync.")
+)
+
+// watchNetmap subscribes to tailscaled's IPN bus and sends on the returned
+// channel, WATCH_DEBOUNCE after the last of a burst, whenever a peer joins,
+// leaves or changes its name, addresses, tags or online state. Without the
+// bus the channel never fires and the daemon keeps polling.
+func watchNetmap(ctx context.Context) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	if !features.IPNBus || cfg().WatchFallbackInterval <= 0 {
+		return changed
+	}
+	fire := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	go func() {
+		var last [sha256.Size]byte
+		var debounce *time.Timer
+		backoff := time.Second
+		for ctx.Err() == nil {
+			w, err := lc().WatchIPNBus(ctx, ipn.NotifyInitialNetMap|ipn.NotifyNoPrivateKeys)
+			if err != nil {
+				slog.Warn("watch tailscaled failed, retrying", logErr(err), "backoff", backoff.String())
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+				}
+				backoff = min(2*backoff, time.Minute)
+				continue
+			}
+			backoff = time.Second
+			if !watching.Swap(true) {
+				slog.Info("watching tailscaled for tailnet changes", "poll_interval", cfg().WatchFallbackInterval.String())
+			}
+			for {
+				n, err := w.Next()
+				if err != nil {
+					if ctx.Err() == nil {
+						slog.Warn("watch tailscaled failed, polling until reconnected", logErr(err), "poll_interval", cfg().SyncInterval.String())
+					}
+					break
+				}
+				if n.NetMap == nil {
+					continue
+				}
+				fp := netmapFingerprint(n.NetMap)
+				if fp == last {
+					continue
+				}
+				first := last == [sha256.Size]byte{}
+				last = fp
+				if first {
+					continue
+				}
+				metricNetmapChanges.Inc()
+				if debounce == nil {
+					debounce = time.AfterFunc(cfg().WatchDebounce, fire)
+				} else {
+					debounce.Reset(cfg().WatchDebounce)
+				}
+			}
+			w.Close()
+			watching.Store(false)
+		}
+	}()
+	return changed
+}
+
+// netmapFingerprint hashes what of nm can end up in the records, so the
+// endpoint and DERP churn of a netmap doesn't trigger syncs.
+func netmapFingerprint(nm *netmap.NetworkMap) [sha256.Size]byte {
+	h := sha256.New()
+	if nm.SelfNode.Valid() {
+		fmt.Fprintf(h, "%s %v\n", nm.SelfNode.Name(), nm.SelfNode.Addresses().AsSlice())
+	}
+	for _, p := range nm.Peers {
+		online := p.Online() != nil && *p.Online()
+		var os string
+		if p.Hostinfo().Valid() {
+			os = p.Hostinfo().OS()
+		}
+		fmt.Fprintf(h, "%s %s %d %s %v %v %t\n", p.StableID(), p.Name(), p.User(), os, p.Addresses().AsSlice(), p.Tags().AsSlice(), online)
+	}
+	var fp [sha256.Size]byte
+	h.Sum(fp[:0])
+	return fp
+}
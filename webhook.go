@@ -0,0 +1,259 @@
+//go:build !no_webhook
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// webhookMediaType is the content type of the external-dns webhook
+// protocol, version 1.
+const webhookMediaType = "application/external.dns.webhook+json;version=1"
+
+// webhookManaged are the record types the sync publishes.
+var webhookManaged = []string{"A", "AAAA", "CNAME", "SRV", "PTR"}
+
+func init() {
+	registerProvider("webhook", newWebhookProvider)
+}
+
+// webhookProvider writes through an external-dns webhook provider, the
+// sidecar external-dns itself uses for the DNS services it doesn't support
+// in tree. Like on Route53, records are sets of targets per name and type
+// and carry no comments, so every managed type under the suffix counts as
+// ours unless REGISTRY=txt says otherwise.
+type webhookProvider struct {
+	mu   sync.Mutex
+	base string
+	zone string
+}
+
+// webhookEndpoint is an endpoint of external-dns: all records of a name and
+// type.
+type webhookEndpoint struct {
+	DNSName          string            `json:"dnsName"`
+	Targets          []string          `json:"targets"`
+	RecordType       string            `json:"recordType"`
+	SetIdentifier    string            `json:"setIdentifier,omitempty"`
+	RecordTTL        int64             `json:"recordTTL,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ProviderSpecific []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"providerSpecific,omitempty"`
+}
+
+// webhookChanges is the body of POST /records.
+type webhookChanges struct {
+	Create    []*webhookEndpoint `json:"Create"`
+	UpdateOld []*webhookEndpoint `json:"UpdateOld"`
+	UpdateNew []*webhookEndpoint `json:"UpdateNew"`
+	Delete    []*webhookEndpoint `json:"Delete"`
+}
+
+func newWebhookProvider(ctx context.Context, name string) (provider, error) {
+	p := &webhookProvider{
+		base: strings.TrimSuffix(envString("WEBHOOK_URL", "http://localhost:8888"), "/"),
+		zone: name,
+	}
+	// the negotiation answers with the domains the webhook serves
+	var filter struct {
+		Include []string `json:"include"`
+		Exclude []string `json:"exclude"`
+	}
+	if err := p.call(ctx, http.MethodGet, "/", nil, &filter); err != nil {
+		return nil, fmt.Errorf("negotiate with webhook %s: %w", p.base, err)
+	}
+	if len(filter.Include) > 0 && !slices.ContainsFunc(filter.Include, func(d string) bool { return webhookUnder(name, d) }) ||
+		slices.ContainsFunc(filter.Exclude, func(d string) bool { return webhookUnder(name, d) }) {
+		return nil, fmt.Errorf("%w: %s (webhook serves %s)", errZoneNotFound, name, strings.Join(filter.Include, ", "))
+	}
+	return p, nil
+}
+
+// webhookUnder reports whether name is domain or a name under it.
+func webhookUnder(name, domain string) bool {
+	domain = strings.ToLower(strings.Trim(domain, "."))
+	return domain == "" || name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+func (p *webhookProvider) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", webhookMediaType)
+	if in != nil {
+		req.Header.Set("Content-Type", webhookMediaType)
+	}
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *webhookProvider) endpoints(ctx context.Context) ([]webhookEndpoint, error) {
+	var out []webhookEndpoint
+	if err := p.call(ctx, http.MethodGet, "/records", nil, &out); err != nil {
+		return nil, fmt.Errorf("get records: %w", err)
+	}
+	return out, nil
+}
+
+func (p *webhookProvider) List(ctx context.Context) ([]record, error) {
+	var out []record
+	err := p.ListPages(ctx, "", func(page []record) error {
+		out = append(out, page...)
+		return nil
+	})
+	return out, err
+}
+
+// ListPages passes the records under suffix to fn. The protocol has no
+// paging or filter, so it's one page of everything the webhook holds.
+func (p *webhookProvider) ListPages(ctx context.Context, suffix string, fn func([]record) error) error {
+	if suffix == "" {
+		suffix = p.zone
+	}
+	endpoints, err := p.endpoints(ctx)
+	if err != nil {
+		return err
+	}
+	var page []record
+	for _, e := range endpoints {
+		name := strings.ToLower(strings.TrimSuffix(e.DNSName, "."))
+		registry := e.RecordType == "TXT" && managedTXT()
+		if !slices.Contains(webhookManaged, e.RecordType) && !registry || e.SetIdentifier != "" ||
+			name != suffix && !strings.HasSuffix(name, "."+suffix) {
+			continue
+		}
+		for _, t := range e.Targets {
+			page = append(page, record{ID: name + " " + e.RecordType, Name: name, Type: e.RecordType,
+				Content: webhookContent(e.RecordType, t), TTL: int(e.RecordTTL)})
+		}
+	}
+	return fn(page)
+}
+
+// endpoint returns the endpoint of c holding values, adjusted by the
+// webhook the way external-dns has it done before planning.
+func (p *webhookProvider) endpoint(ctx context.Context, c change, values []string) (*webhookEndpoint, error) {
+	e := &webhookEndpoint{DNSName: c.fqdn(), RecordType: c.Type}
+	if c.TTL > 1 {
+		e.RecordTTL = int64(c.TTL)
+	}
+	for _, v := range values {
+		e.Targets = append(e.Targets, webhookTarget(c.Type, v))
+	}
+	var adjusted []*webhookEndpoint
+	if err := p.call(ctx, http.MethodPost, "/adjustendpoints", []*webhookEndpoint{e}, &adjusted); err != nil {
+		return nil, fmt.Errorf("adjust endpoint %s %s: %w", c.fqdn(), c.Type, err)
+	}
+	if len(adjusted) != 1 {
+		// the webhook dropped it, e.g. for a type it doesn't support
+		return nil, fmt.Errorf("webhook rejected the endpoint %s %s", c.fqdn(), c.Type)
+	}
+	return adjusted[0], nil
+}
+
+// webhookContent returns the content of target, without the trailing dot
+// of names and the quotes of TXT records.
+func webhookContent(typ, target string) string {
+	if typ == "TXT" {
+		return strings.Trim(target, `"`)
+	}
+	return strings.TrimSuffix(target, ".")
+}
+
+// webhookTarget returns the target for content; external-dns quotes TXT
+// targets and leaves names without the trailing dot.
+func webhookTarget(typ, content string) string {
+	if typ == "TXT" {
+		return `"` + content + `"`
+	}
+	return content
+}
+
+func (p *webhookProvider) Create(ctx context.Context, c change) (string, error) {
+	return c.fqdn() + " " + c.Type, p.edit(ctx, c)
+}
+
+func (p *webhookProvider) Update(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+func (p *webhookProvider) Delete(ctx context.Context, c change) error {
+	return p.edit(ctx, c)
+}
+
+// edit applies c to the endpoint it belongs to, replacing it as a whole.
+func (p *webhookProvider) edit(ctx context.Context, c change) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	endpoints, err := p.endpoints(ctx)
+	if err != nil {
+		return err
+	}
+	var old *webhookEndpoint
+	var values []string
+	for i, e := range endpoints {
+		if strings.EqualFold(strings.TrimSuffix(e.DNSName, "."), c.fqdn()) && e.RecordType == c.Type && e.SetIdentifier == "" {
+			old = &endpoints[i]
+			for _, t := range e.Targets {
+				values = append(values, webhookContent(c.Type, t))
+			}
+			break
+		}
+	}
+	if c.Action == actionDelete && !slices.Contains(values, c.Content) {
+		// already gone
+		return nil
+	}
+	values = editRecordSet(values, c)
+	var changes webhookChanges
+	switch {
+	case len(values) == 0:
+		changes.Delete = []*webhookEndpoint{old}
+	case old == nil:
+		e, err := p.endpoint(ctx, c, values)
+		if err != nil {
+			return err
+		}
+		changes.Create = []*webhookEndpoint{e}
+	default:
+		e, err := p.endpoint(ctx, c, values)
+		if err != nil {
+			return err
+		}
+		changes.UpdateOld, changes.UpdateNew = []*webhookEndpoint{old}, []*webhookEndpoint{e}
+	}
+	if err := p.call(ctx, http.MethodPost, "/records", changes, nil); err != nil {
+		return fmt.Errorf("%s record %s %s: %w", c.Action, c.fqdn(), c.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+//go:build !no_webui
+
+package main
+
+import (
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+//go:embed webui.html
+var webUIPage string
+
+var webUITmpl = template.Must(template.New("webui").Funcs(template.FuncMap{
+	"since": func(t time.Time) string { return time.Since(t).Round(time.Second).String() },
+}).Parse(webUIPage))
+
+// webUIData is what the dashboard is rendered with.
+type webUIData struct {
+	Status  *statusFile
+	Frozen  *freeze
+	Mode    string
+	Records []adminRecord
+	Pending []change
+	// History are the recent changes, newest first.
+	History []auditEntry
+}
+
+func init() {
+	// GET /ui shows the records by device, the last cycle, the pending
+	// changes and the recent ones. It sits behind the listener's checks
+	// like the other endpoints; with listen: tailscale and an allow list
+	// only the tailnet's operators reach it.
+	handle("/ui", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		d := webUIData{Frozen: currentFreeze(), Mode: cfg().Mode}
+		adminMu.Lock()
+		d.Status = adminStatus
+		d.Records = slices.Clone(adminRecords)
+		d.Pending = slices.Clone(adminPending)
+		d.History = slices.Clone(adminHistory)
+		adminMu.Unlock()
+		slices.Reverse(d.History)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := webUITmpl.Execute(w, d); err != nil {
+			slog.Warn("render /ui failed", logErr(err))
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerCommand("zonefile", runZoneFile)
+}
+
+// runZoneFile implements `tailscale-dns-sync zonefile`: it writes a complete
+// zone file per suffix, SOA and NS included, for BIND or NSD to load or to
+// check into git. The serial is only bumped when the records change. With
+// -watch it keeps rewriting the files every sync interval.
+func runZoneFile(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("zonefile", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file")
+	profile := fs.String("profile", os.Getenv("CONFIG_PROFILE"), "config file profile to apply")
+	out := fs.String("out", "", "zone file `path`, {zone} is replaced by the suffix, e.g. /etc/bind/db.{zone}")
+	ns := fs.String("ns", "", "name server of the zone for its SOA and NS records, e.g. ns1.example.com")
+	hostmaster := fs.String("hostmaster", "", "contact mailbox in the SOA, default hostmaster.<suffix>")
+	watch := fs.Bool("watch", false, "keep running and rewrite the files on change")
+	reload := fs.String("reload", "", "shell `command` to run after a file changed, e.g. \"rndc reload\"")
+	fs.Parse(args)
+	loadConfig(*configPath, *profile)
+	if *out == "" || *ns == "" {
+		return fmt.Errorf("-out and -ns are required")
+	}
+	if len(configuredZones()) > 1 && !strings.Contains(*out, "{zone}") {
+		return fmt.Errorf("-out needs {zone} with several zones configured")
+	}
+	for {
+		if err := writeZoneFiles(ctx, *out, *ns, *hostmaster, *reload); err != nil {
+			if !*watch {
+				return err
+			}
+			slog.Warn("write zone files failed", logErr(err))
+		}
+		if !*watch {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(syncInterval()):
+		}
+	}
+}
+
+// writeZoneFiles renders the zone file of every suffix and replaces the ones
+// whose records changed.
+func writeZoneFiles(ctx context.Context, out, ns, hostmaster, reload string) error {
+	entries, err := desiredEntries(ctx)
+	if err != nil {
+		return err
+	}
+	bySuffix := map[string][]exportEntry{}
+	for _, e := range entries {
+		bySuffix[e.Suffix] = append(bySuffix[e.Suffix], e)
+	}
+	changed := false
+	for _, z := range configuredZones() {
+		path := strings.ReplaceAll(out, "{zone}", z.Suffix)
+		old, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		serial := zoneSerial(old)
+		contact := hostmaster
+		if contact == "" {
+			contact = "hostmaster." + z.Suffix
+		}
+		render := func(serial uint32) ([]byte, error) {
+			var b bytes.Buffer
+			fmt.Fprintf(&b, "$ORIGIN %s.\n$TTL 300\n", z.Suffix)
+			fmt.Fprintf(&b, "@\tIN\tSOA\t%s. %s. %d 3600 600 1209600 300\n", strings.TrimSuffix(ns, "."), strings.TrimSuffix(strings.Replace(contact, "@", ".", 1), "."), serial)
+			fmt.Fprintf(&b, "@\tIN\tNS\t%s.\n", strings.TrimSuffix(ns, "."))
+			for _, e := range bySuffix[z.Suffix] {
+				if err := writeZoneRecord(&b, e); err != nil {
+					return nil, err
+				}
+			}
+			return b.Bytes(), nil
+		}
+		b, err := render(serial)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(b, old) {
+			continue
+		}
+		serial = nextSerial(serial, time.Now())
+		if b, err = render(serial); err != nil {
+			return err
+		}
+		if old == nil {
+			// created readable by the name server, rewrites keep the permissions
+			if err := os.WriteFile(path, nil, 0o644); err != nil {
+				return err
+			}
+		}
+		if err := writeFileAtomic(path, b); err != nil {
+			return fmt.Errorf("write %s: %w", path, err)
+		}
+		slog.Info("wrote zone file", "path", path, "serial", serial)
+		changed = true
+	}
+	if changed && reload != "" {
+		if b, err := exec.CommandContext(ctx, "sh", "-c", reload).CombinedOutput(); err != nil {
+			return fmt.Errorf("reload: %w: %s", err, bytes.TrimSpace(b))
+		}
+	}
+	return nil
+}
+
+// zoneSerial returns the SOA serial of a zone file written by
+// writeZoneFiles, or 0.
+func zoneSerial(b []byte) uint32 {
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 6 && f[0] == "@" && f[2] == "SOA" {
+			n, _ := strconv.ParseUint(f[5], 10, 32)
+			return uint32(n)
+		}
+	}
+	return 0
+}
+
+// nextSerial bumps serial in the YYYYMMDDnn convention, moving on to the
+// date of now when it is ahead.
+func nextSerial(serial uint32, now time.Time) uint32 {
+	y, m, d := now.UTC().Date()
+	today := uint32(y*1000000 + int(m)*10000 + d*100)
+	if serial < today {
+		return today
+	}
+	return serial + 1
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// zoneSettings override the global settings for one zone, keyed by the zone
+// name (the DOMAIN entry, or the suffix for SUFFIXES entries).
+type zoneSettings struct {
+	// Suffix replaces DOMAIN_SUFFIX for a DOMAIN zone; empty publishes at
+	// the zone apex.
+	Suffix *string `yaml:"suffix"`
+	// IncludeTags and ExcludeTags narrow down the hosts published in the
+	// zone, after the global INCLUDE_TAGS and EXCLUDE_TAGS.
+	IncludeTags []string `yaml:"include_tags"`
+	ExcludeTags []string `yaml:"exclude_tags"`
+	// TTL replaces the TTL of the zone's records unless they're proxied.
+	TTL int `yaml:"ttl"`
+}
+
+func (s zoneSettings) validate(c *config, zone string) []error {
+	var errs []error
+	switch {
+	case slices.Contains(c.Domains, zone):
+	case slices.Contains(c.Suffixes, zone):
+		if s.Suffix != nil {
+			errs = append(errs, fmt.Errorf("zones: %s: suffix only applies to DOMAIN zones", zone))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("zones: %s is not in domains or suffixes", zone))
+	}
+	if s.Suffix != nil && (strings.HasPrefix(*s.Suffix, ".") || strings.HasSuffix(*s.Suffix, ".")) {
+		errs = append(errs, fmt.Errorf("zones: %s: suffix %q must not start or end with a dot", zone, *s.Suffix))
+	}
+	if s.TTL != 0 && s.TTL != 1 && (s.TTL < 60 || s.TTL > 86400) {
+		errs = append(errs, fmt.Errorf("zones: %s: ttl %d must be 1 (automatic) or between 60 and 86400", zone, s.TTL))
+	}
+	for _, t := range append(slices.Clip(s.IncludeTags), s.ExcludeTags...) {
+		if !strings.HasPrefix(t, "tag:") {
+			errs = append(errs, fmt.Errorf("zones: %s: tag %q must start with tag:", zone, t))
+		}
+	}
+	return errs
+}
+
+// applyZoneSettings filters and adjusts hosts, the records of zoneName.
+func applyZoneSettings(zoneName string, hosts map[string]host) {
+	s, ok := cfg().ZoneSettings[zoneName]
+	if !ok {
+		return
+	}
+	for key, h := range hosts {
+		if !tagsMatch(h.Tags, s.IncludeTags, s.ExcludeTags) {
+			delete(hosts, key)
+			continue
+		}
+		if s.TTL != 0 && !h.Proxied {
+			h.TTL = s.TTL
+			hosts[key] = h
+		}
+	}
+}